@@ -0,0 +1,205 @@
+// Package driver is a database/sql driver which runs SQL SELECT statements
+// against elasticsearch. It is registered under the name "es":
+//
+//	db, err := sql.Open("es", "es://localhost:9200/?index=oilers")
+//	rows, err := db.Query(`SELECT name, goals FROM oilers WHERE pos = ?`, "C")
+//
+// See ParseDSN for the DSN format.
+package driver
+
+import (
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	elastigo "github.com/mattbaird/elastigo/lib"
+	"github.com/oldenbur/sql-parser/essyntax"
+	sqlparser "github.com/oldenbur/sql-parser/sql"
+)
+
+// DriverName is the name under which the driver is registered.
+const DriverName = "es"
+
+// stmtCacheSize is the number of prepared statements cached by each Driver
+// for each cluster version.
+const stmtCacheSize = 256
+
+func init() {
+	sql.Register(DriverName, NewDriver())
+}
+
+// ErrReadOnly is returned for attempts to modify data, which the driver does
+// not support.
+var ErrReadOnly = errors.New("elasticsearch driver is read-only")
+
+// Driver is a database/sql driver for elasticsearch. Statements prepared on
+// any of its connections to clusters of the same version share a cache of
+// parsed statements, since their translation depends on the version.
+type Driver struct {
+	mu     sync.Mutex
+	caches map[string]*essyntax.PreparedCache // by cluster version
+}
+
+// NewDriver returns a new Driver, for use with sql.OpenDB or for registering
+// under another name.
+func NewDriver() *Driver {
+	return &Driver{caches: make(map[string]*essyntax.PreparedCache)}
+}
+
+// cache returns the cache of statements prepared for clusters of the
+// specified version.
+func (d *Driver) cache(version string) *essyntax.PreparedCache {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.caches[version]
+	if !ok {
+		c = essyntax.NewPreparedCache(stmtCacheSize)
+		c.Translate.Version = version
+		d.caches[version] = c
+	}
+	return c
+}
+
+// Open returns a new connection to the elasticsearch cluster described by
+// the specified DSN.
+func (d *Driver) Open(dsn string) (sqldriver.Conn, error) {
+
+	cfg, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	es := elastigo.NewConn()
+	if err := es.SetFromUrl(cfg.URL); err != nil {
+		return nil, err
+	}
+	// Initialize the host pool now so the connection can be closed before
+	// it runs any request.
+	es.SetHosts([]string{net.JoinHostPort(es.Domain, es.Port)})
+
+	cache := d.cache(cfg.Version)
+	ex := essyntax.NewExecutor(es, cache)
+	ex.Decoding = cfg.Decoding
+	return &conn{cfg: cfg, cache: cache, es: es, ex: ex}, nil
+}
+
+// conn is a connection to an elasticsearch cluster.
+type conn struct {
+	cfg   *Config
+	cache *essyntax.PreparedCache
+	es    *elastigo.Conn
+	ex    *essyntax.Executor
+}
+
+// Prepare parses the specified SELECT statement and translates it into a
+// query template.
+func (c *conn) Prepare(query string) (sqldriver.Stmt, error) {
+
+	prep, err := c.cache.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range prep.Indices() {
+		if !c.cfg.allowsIndex(index) {
+			return nil, fmt.Errorf("index %s is not in the index list of the connection", index)
+		}
+	}
+
+	return &stmt{conn: c, prep: prep}, nil
+}
+
+func (c *conn) Close() error {
+	c.es.Close()
+	return nil
+}
+
+// Begin always fails, since elasticsearch does not support transactions.
+func (c *conn) Begin() (sqldriver.Tx, error) {
+	return nil, errors.New("elasticsearch driver does not support transactions")
+}
+
+// stmt is a prepared statement.
+type stmt struct {
+	conn *conn
+	prep *essyntax.Prepared
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+// NumInput returns the number of positional arguments taken by the
+// statement, or -1 if it has named parameters, which database/sql cannot
+// count.
+func (s *stmt) NumInput() int {
+
+	n := 0
+	for _, p := range sqlparser.Params(s.prep.Stmt) {
+		if len(p.Name) > 0 {
+			return -1
+		}
+		if p.Index > n {
+			n = p.Index
+		}
+	}
+	return n
+}
+
+// Exec always fails, since the driver is read-only.
+func (s *stmt) Exec(args []sqldriver.Value) (sqldriver.Result, error) {
+	return nil, ErrReadOnly
+}
+
+// Query runs the statement with args bound to its positional parameters.
+func (s *stmt) Query(args []sqldriver.Value) (sqldriver.Rows, error) {
+
+	named := make([]sqldriver.NamedValue, len(args))
+	for i, a := range args {
+		named[i] = sqldriver.NamedValue{Ordinal: i + 1, Value: a}
+	}
+	return s.QueryContext(context.Background(), named)
+}
+
+// QueryContext runs the statement with args bound to its parameters. The
+// arguments must either all be positional or all be named, as with
+// sql.Named. Pages of hits are fetched as the rows are read.
+func (s *stmt) QueryContext(ctx context.Context, args []sqldriver.NamedValue) (sqldriver.Rows, error) {
+
+	b, err := s.bind(args)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := s.conn.ex.Stream(ctx, s.prep, b)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(st), nil
+}
+
+// bind binds the specified arguments to the parameters of the statement.
+func (s *stmt) bind(args []sqldriver.NamedValue) (sqlparser.Binding, error) {
+
+	positional := make([]interface{}, 0, len(args))
+	named := make(map[string]interface{})
+	for _, a := range args {
+		if len(a.Name) > 0 {
+			named[a.Name] = a.Value
+		} else {
+			positional = append(positional, a.Value)
+		}
+	}
+
+	switch {
+	case len(named) == 0:
+		return sqlparser.NewBinding(s.prep.Stmt, positional...)
+	case len(positional) == 0:
+		return sqlparser.NewNamedBinding(s.prep.Stmt, named)
+	}
+	return nil, errors.New("cannot mix positional and named arguments")
+}
@@ -0,0 +1,200 @@
+package driver
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+// fakeES is an elasticsearch stand-in which records each request and
+// responds with a canned body, or with its version to GET /. Like
+// elasticsearch 6.0 and later, it rejects bodies without a JSON content
+// type.
+type fakeES struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+	response string
+}
+
+func newFakeES(response string) *fakeES {
+	es := &fakeES{response: response}
+	es.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		es.mu.Lock()
+		es.requests = append(es.requests, r.Method+" "+r.URL.Path+" "+string(b))
+		es.mu.Unlock()
+		if r.Method == "GET" && r.URL.Path == "/" {
+			fmt.Fprint(w, `{"version": {"number": "6.8.0"}}`)
+			return
+		}
+		if typ := r.Header.Get("Content-Type"); len(b) > 0 && typ != "application/json" {
+			w.WriteHeader(http.StatusNotAcceptable)
+			fmt.Fprintf(w, `{"error": "Content-Type header [%s] is not supported", "status": 406}`, typ)
+			return
+		}
+		if strings.Contains(r.URL.Path, "missing") {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "IndexMissingException[[missing] missing]", "status": 400}`)
+			return
+		}
+		fmt.Fprint(w, es.response)
+	}))
+	return es
+}
+
+// dsn returns a DSN for the fake server with the specified parameters.
+func (es *fakeES) dsn(params string) string {
+	return strings.Replace(es.URL, "http://", "es://", 1) + "/?" + params
+}
+
+const oilersHits = `{"hits": {"total": 3, "hits": [
+	{"_source": {"name": "Wayne Gretzky", "goals": 92, "pos": "C", "teams": ["EDM", "LAK"]}},
+	{"_source": {"name": "Jari Kurri", "goals": 45.5, "hof": true}},
+	{"_source": {"name": "Mark Messier", "pos": "C"}}]}}`
+
+func TestDriver(t *testing.T) {
+
+	defer log.Flush()
+
+	es := newFakeES(oilersHits)
+	defer es.Close()
+
+	Convey("Test querying through database/sql\n", t, func() {
+		db, err := sql.Open("es", es.dsn("index=oilers"))
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		rows, err := db.Query(`SELECT name player, goals, teams FROM oilers WHERE pos = ? LIMIT 10`, "C")
+		So(err, ShouldBeNil)
+		defer rows.Close()
+
+		cols, err := rows.Columns()
+		So(err, ShouldBeNil)
+		So(cols, ShouldResemble, []string{"player", "goals", "teams"})
+
+		types, err := rows.ColumnTypes()
+		So(err, ShouldBeNil)
+		So(types[0].DatabaseTypeName(), ShouldEqual, "STRING")
+		So(types[1].DatabaseTypeName(), ShouldEqual, "DOUBLE")
+		So(types[1].ScanType(), ShouldEqual, reflect.TypeOf(float64(0)))
+		So(types[2].DatabaseTypeName(), ShouldEqual, "ARRAY")
+		nullable, ok := types[1].Nullable()
+		So(nullable && ok, ShouldBeTrue)
+
+		var players []string
+		var goals []sql.NullFloat64
+		var teams []sql.NullString
+		for rows.Next() {
+			var p string
+			var g sql.NullFloat64
+			var t sql.NullString
+			So(rows.Scan(&p, &g, &t), ShouldBeNil)
+			players, goals, teams = append(players, p), append(goals, g), append(teams, t)
+		}
+		So(rows.Err(), ShouldBeNil)
+		So(players, ShouldResemble, []string{"Wayne Gretzky", "Jari Kurri", "Mark Messier"})
+		So(goals, ShouldResemble, []sql.NullFloat64{{Float64: 92, Valid: true}, {Float64: 45.5, Valid: true}, {}})
+		So(teams[0], ShouldResemble, sql.NullString{String: `["EDM","LAK"]`, Valid: true})
+
+		es.mu.Lock()
+		last := es.requests[len(es.requests)-1]
+		es.mu.Unlock()
+		So(last, ShouldEqual, `POST /oilers/_search {"query": {"constant_score": {"filter": {"term": {"pos": "C"}}}}, "_source": ["name", "goals", "teams"], "size": 10}`)
+
+		// A long column may hold doubles on later pages.
+		es.mu.Lock()
+		es.response = `{"hits": {"total": 1, "hits": [{"_source": {"goals": 92}}]}}`
+		es.mu.Unlock()
+		longs, err := db.Query(`SELECT goals FROM oilers LIMIT 1`)
+		So(err, ShouldBeNil)
+		types, err = longs.ColumnTypes()
+		So(err, ShouldBeNil)
+		So(types[0].DatabaseTypeName(), ShouldEqual, "LONG")
+		So(types[0].ScanType(), ShouldEqual, reflect.TypeOf(float64(0)))
+		So(longs.Close(), ShouldBeNil)
+		es.mu.Lock()
+		es.response = oilersHits
+		es.mu.Unlock()
+	})
+
+	Convey("Test translating for the cluster version\n", t, func() {
+		db, err := sql.Open("es", es.dsn("version=6.8.0"))
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		rows, err := db.Query(`SELECT HISTOGRAM(dob, INTERVAL 1 YEAR) yr, COUNT(*) FROM oilers GROUP BY yr`)
+		So(err, ShouldBeNil)
+		rows.Close()
+
+		es.mu.Lock()
+		last := es.requests[len(es.requests)-1]
+		es.mu.Unlock()
+		So(last, ShouldContainSubstring, `{"date_histogram": {"field": "dob", "interval": "1y", "min_doc_count": 1}}`)
+	})
+
+	Convey("Test prepared statements with named arguments\n", t, func() {
+		db, err := sql.Open("es", es.dsn("multivalue=join"))
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		st, err := db.Prepare(`SELECT teams FROM oilers WHERE goals >= :min`)
+		So(err, ShouldBeNil)
+		defer st.Close()
+
+		var teams string
+		So(st.QueryRow(sql.Named("min", 50)).Scan(&teams), ShouldBeNil)
+		So(teams, ShouldEqual, "EDM,LAK")
+	})
+
+	Convey("Test driver errors\n", t, func() {
+		db, err := sql.Open("es", es.dsn("index=oilers,flames"))
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		_, err = db.Query(`SELECT name FROM jets`)
+		So(err, ShouldResemble, fmt.Errorf("index jets is not in the index list of the connection"))
+		_, err = db.Query(`SELECT o.name FROM oilers o JOIN jets j ON o.team = j.team`)
+		So(err, ShouldResemble, fmt.Errorf("index jets is not in the index list of the connection"))
+		_, err = db.Query(`SELECT name FROM oilers WHERE team IN (SELECT team FROM flames WHERE pos IN (SELECT pos FROM jets))`)
+		So(err, ShouldResemble, fmt.Errorf("index jets is not in the index list of the connection"))
+
+		_, err = db.Query(`SELECT name FROM`)
+		So(err, ShouldNotBeNil)
+
+		_, err = db.Query(`SELECT name FROM oilers WHERE pos = ?`)
+		So(err.Error(), ShouldEqual, "sql: expected 1 arguments, got 0")
+
+		_, err = db.Query(`SELECT name FROM oilers WHERE pos = :pos AND goals > :goals`, sql.Named("pos", "C"))
+		So(err, ShouldResemble, fmt.Errorf("missing value for parameter :goals"))
+
+		_, err = db.Exec(`SELECT name FROM oilers`)
+		So(err, ShouldEqual, ErrReadOnly)
+
+		_, err = db.Begin()
+		So(err, ShouldResemble, fmt.Errorf("elasticsearch driver does not support transactions"))
+
+		db, err = sql.Open("es", es.dsn(""))
+		So(err, ShouldBeNil)
+		defer db.Close()
+		_, err = db.Query(`SELECT name FROM missing`)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "IndexMissingException")
+
+		bad, err := sql.Open("es", "es://localhost/?db=nhl")
+		So(err, ShouldBeNil)
+		So(bad.Ping(), ShouldResemble, fmt.Errorf(`unknown DSN parameter "db"`))
+	})
+}
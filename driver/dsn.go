@@ -0,0 +1,128 @@
+package driver
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/oldenbur/sql-parser/essyntax"
+)
+
+// DefaultPort is the elasticsearch port used for DSNs without one.
+const DefaultPort = "9200"
+
+// Config is the connection configuration parsed from a DSN.
+type Config struct {
+	// URL is the base URL of the elasticsearch HTTP API, including any user
+	// credentials.
+	URL string
+
+	// Indices restricts statements to the listed indices, which may contain
+	// wildcards. Statements may select from any index if it is empty.
+	Indices []string
+
+	Decoding essyntax.DecoderOptions
+
+	// Version is the version of the elasticsearch cluster statements are
+	// translated for, which must be set for clusters older than 7.2.
+	Version string
+}
+
+// ParseDSN parses a DSN of the form
+//
+//	es://[user[:password]@]host[:port]/[?param=value&...]
+//
+// The supported parameters are:
+//
+//	index       comma-separated list of the indices statements may select from
+//	tls         true to connect over https
+//	multivalue  array, join or expand: the policy for multi-valued fields
+//	separator   the separator of joined multi-valued fields
+//	version     the elasticsearch version of the cluster, e.g. 6.8.0
+func ParseDSN(dsn string) (*Config, error) {
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DSN: %v", err)
+	}
+	if u.Scheme != "es" {
+		return nil, fmt.Errorf("invalid DSN scheme %q, expected es", u.Scheme)
+	}
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid DSN %q: missing host", dsn)
+	}
+	if len(strings.Trim(u.Path, "/")) > 0 {
+		return nil, fmt.Errorf("invalid DSN %q: unexpected path %s", dsn, u.Path)
+	}
+
+	host := u.Host
+	if len(u.Port()) == 0 {
+		host = net.JoinHostPort(u.Hostname(), DefaultPort)
+	}
+	base := &url.URL{Scheme: "http", User: u.User, Host: host}
+
+	cfg := &Config{}
+	for param, vals := range u.Query() {
+		val := vals[len(vals)-1]
+		switch param {
+		case "index":
+			for _, index := range strings.Split(val, ",") {
+				if index = strings.TrimSpace(index); len(index) > 0 {
+					if _, err := path.Match(index, ""); err != nil {
+						return nil, fmt.Errorf("invalid index pattern %q", index)
+					}
+					cfg.Indices = append(cfg.Indices, index)
+				}
+			}
+		case "tls":
+			switch val {
+			case "true":
+				base.Scheme = "https"
+			case "false":
+			default:
+				return nil, fmt.Errorf("invalid tls value %q, expected true or false", val)
+			}
+		case "multivalue":
+			switch val {
+			case "array":
+				cfg.Decoding.MultiValue = essyntax.MultiValueArray
+			case "join":
+				cfg.Decoding.MultiValue = essyntax.MultiValueJoin
+			case "expand":
+				cfg.Decoding.MultiValue = essyntax.MultiValueExpand
+			default:
+				return nil, fmt.Errorf("invalid multivalue policy %q, expected array, join or expand", val)
+			}
+		case "separator":
+			cfg.Decoding.Separator = val
+		case "version":
+			var major, minor int
+			if _, err := fmt.Sscanf(val, "%d.%d", &major, &minor); err != nil {
+				return nil, fmt.Errorf("invalid version %q, expected e.g. 6.8.0", val)
+			}
+			cfg.Version = val
+		default:
+			return nil, fmt.Errorf("unknown DSN parameter %q", param)
+		}
+	}
+
+	cfg.URL = base.String()
+	return cfg, nil
+}
+
+// allowsIndex returns true if statements may select from the specified
+// index.
+func (c *Config) allowsIndex(index string) bool {
+
+	if len(c.Indices) == 0 {
+		return true
+	}
+	for _, pattern := range c.Indices {
+		if ok, _ := path.Match(pattern, index); ok {
+			return true
+		}
+	}
+	return false
+}
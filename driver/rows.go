@@ -0,0 +1,92 @@
+package driver
+
+import (
+	sqldriver "database/sql/driver"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/oldenbur/sql-parser/essyntax"
+)
+
+// rows iterates over the rows of a Stream, fetching pages of hits as they
+// are consumed.
+type rows struct {
+	st   *essyntax.Stream
+	cols []essyntax.Column
+}
+
+func newRows(st *essyntax.Stream) *rows {
+	return &rows{st: st, cols: st.Columns()}
+}
+
+func (r *rows) Columns() []string {
+
+	names := make([]string, len(r.cols))
+	for i, c := range r.cols {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// Close closes the underlying Stream, releasing any scroll context.
+func (r *rows) Close() error {
+	return r.st.Close()
+}
+
+// Next copies the next row into dest. Multi-valued fields decoded as arrays
+// are returned as their JSON encoding.
+func (r *rows) Next(dest []sqldriver.Value) error {
+
+	row, err := r.st.Next()
+	if err != nil {
+		return err
+	}
+
+	for i, v := range row {
+		if vals, ok := v.([]interface{}); ok {
+			b, err := json.Marshal(vals)
+			if err != nil {
+				return err
+			}
+			v = string(b)
+		}
+		dest[i] = v
+	}
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName returns the upper case name of the type of a
+// column, such as LONG, or an empty string if its type is unknown.
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return strings.ToUpper(string(r.cols[index].Type))
+}
+
+// scanTypes maps column types to the Go types of their values. Column types
+// are inferred from the first page of rows, and a later page may hold a
+// double in a long column, so that numbers are scanned as float64 alone.
+var scanTypes = map[essyntax.ColumnType]reflect.Type{
+	essyntax.TypeString:  reflect.TypeOf(""),
+	essyntax.TypeLong:    reflect.TypeOf(float64(0)),
+	essyntax.TypeDouble:  reflect.TypeOf(float64(0)),
+	essyntax.TypeBoolean: reflect.TypeOf(false),
+	essyntax.TypeDate:    reflect.TypeOf(time.Time{}),
+	essyntax.TypeArray:   reflect.TypeOf(""),
+}
+
+// ColumnTypeScanType returns the Go type into which the values of a column
+// can be scanned, or the empty interface type if its type is unknown.
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+
+	if t, ok := scanTypes[r.cols[index].Type]; ok {
+		return t
+	}
+	return reflect.TypeOf((*interface{})(nil)).Elem()
+}
+
+// ColumnTypeNullable reports that every column is nullable, since a
+// document may lack any of its fields.
+func (r *rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return true, true
+}
@@ -0,0 +1,360 @@
+package essyntax
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// termsSize is the maximum number of buckets requested for each GROUP BY
+// expression.
+const termsSize = 10000
+
+// metricAggs maps each SQL aggregate function onto the elasticsearch metric
+// aggregation computing it. COUNT(*) needs no aggregation, since it is the
+// doc_count of each bucket.
+var metricAggs = map[string]string{
+	"COUNT": "value_count",
+	"SUM":   "sum",
+	"AVG":   "avg",
+	"MIN":   "min",
+	"MAX":   "max",
+}
+
+// aggregateCall returns the aggregate function call computing the specified
+// field, or nil if the field is not an aggregate.
+func aggregateCall(f sql.Field) *sql.FuncCallExpr {
+
+	call, ok := f.Expr.(*sql.FuncCallExpr)
+	if !ok {
+		return nil
+	}
+	if _, ok := metricAggs[strings.ToUpper(call.Name)]; !ok {
+		return nil
+	}
+	return call
+}
+
+// isAggregate returns true if the statement groups documents or computes
+// aggregates over them, and so returns rows built from aggregations rather
+// than hits.
+func isAggregate(s *sql.SelectStatement) bool {
+
+	if len(s.GroupBy) > 0 {
+		return true
+	}
+	for _, f := range s.FieldList {
+		if aggregateCall(f) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isCountAll returns true for a COUNT(*) call.
+func isCountAll(call *sql.FuncCallExpr) bool {
+
+	if strings.ToUpper(call.Name) != "COUNT" || len(call.Args) != 1 {
+		return false
+	}
+	ident, ok := call.Args[0].(*sql.IdentExpr)
+	return ok && ident.Name == "*"
+}
+
+// countField returns the field counted by a statement selecting nothing but
+// an ungrouped COUNT, which the _count API answers without fetching hits or
+// computing aggregations: "*" for COUNT(*), or else the counted field.
+func countField(s *sql.SelectStatement) (string, bool) {
+
+	if len(s.FieldList) != 1 || len(s.GroupBy) > 0 || len(s.OrderBy) > 0 {
+		return "", false
+	}
+	call := aggregateCall(s.FieldList[0])
+	if call == nil || strings.ToUpper(call.Name) != "COUNT" || len(call.Args) != 1 {
+		return "", false
+	}
+	ident, ok := call.Args[0].(*sql.IdentExpr)
+	if !ok {
+		return "", false
+	}
+	return ident.Name, true
+}
+
+// genCountQuery returns the _count request body counting the documents which
+// match query and, unless field is "*", have a value for field. A field
+// within a nested field is tested by a nested query.
+func (t *translator) genCountQuery(s *sql.SelectStatement, field, query string) string {
+
+	if field != "*" {
+		exists := fmt.Sprintf(`{"exists": {"field": "%s"}}`, field)
+		if path := t.outerNested(field); len(path) > 0 {
+			exists = fmt.Sprintf(`{"nested": {"path": "%s", "query": %s}}`, path, exists)
+		}
+		if s.WhereCond == nil {
+			query = fmt.Sprintf(`{"bool": {"filter": %s}}`, exists)
+		} else {
+			query = fmt.Sprintf(`{"bool": {"must": %s, "filter": %s}}`, query, exists)
+		}
+	}
+	return fmt.Sprintf(`{"query": %s}`, query)
+}
+
+// groupAggName and metricAggName return the names of the aggregations for
+// GROUP BY expression i and select list field i, from which the Decoder
+// reads back the rows.
+func groupAggName(i int) string  { return fmt.Sprintf("group_%d", i) }
+func metricAggName(i int) string { return fmt.Sprintf("field_%d", i) }
+
+// histogramFunc is the signature of HISTOGRAM, which buckets the values of a
+// field by a number or, for dates, by an INTERVAL. It may only appear in the
+// select list or GROUP BY clause of an aggregate statement.
+var histogramFunc = sql.FuncSignature{
+	Name: "HISTOGRAM", Kind: sql.ScalarFunc, Args: []sql.ArgType{sql.FieldType, sql.AnyType}, Returns: sql.AnyType,
+}
+
+// calendarUnits maps the INTERVAL units onto the units of a date_histogram
+// calendar_interval, which buckets by a single unit.
+var calendarUnits = map[string]string{
+	"YEAR":    "y",
+	"QUARTER": "q",
+	"MONTH":   "M",
+	"WEEK":    "w",
+	"DAY":     "d",
+	"HOUR":    "h",
+	"MINUTE":  "m",
+	"SECOND":  "s",
+}
+
+// groupKey is a GROUP BY item resolved against the select list. Its name is
+// the Name of the select list fields holding its bucket keys, which are
+// values of the field, the buckets of a HISTOGRAM call, or else the values
+// computed by a call to a scalar function.
+type groupKey struct {
+	name      string
+	field     string
+	histogram *sql.FuncCallExpr
+	script    *sql.FuncCallExpr
+}
+
+// isDate returns true if the key buckets dates by an INTERVAL, and so has
+// keys which are dates.
+func (g groupKey) isDate() bool {
+
+	if g.histogram == nil {
+		return false
+	}
+	_, ok := g.histogram.Args[1].(*sql.IntervalExpr)
+	return ok
+}
+
+// groupKeys resolves the GROUP BY items of a statement, which are fields,
+// HISTOGRAM calls, aliases of select list fields or 1-based positions in the
+// select list.
+func groupKeys(s *sql.SelectStatement) ([]groupKey, error) {
+
+	keys := make([]groupKey, len(s.GroupBy))
+	for i, e := range s.GroupBy {
+		var err error
+		switch e := e.(type) {
+		case *sql.NumExpr:
+			n := int(e.Val)
+			if float64(n) != e.Val || n < 1 || n > len(s.FieldList) {
+				return nil, fmt.Errorf("GROUP BY position %s is not in the select list", sql.FormatExpr(e))
+			}
+			keys[i], err = fieldGroupKey(s.FieldList[n-1])
+		case *sql.IdentExpr:
+			keys[i] = groupKey{name: e.Name, field: e.Name}
+			for _, f := range s.FieldList {
+				if len(f.Alias) > 0 && f.Alias == e.Name {
+					keys[i], err = fieldGroupKey(f)
+				}
+			}
+		case *sql.FuncCallExpr:
+			keys[i], err = fieldGroupKey(sql.Field{Name: sql.FormatExpr(e), Expr: e})
+		default:
+			err = fmt.Errorf("unsupported GROUP BY expression %s", sql.FormatExpr(e))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// fieldGroupKey returns the groupKey for a select list field named by the
+// GROUP BY clause.
+func fieldGroupKey(f sql.Field) (groupKey, error) {
+
+	switch e := f.Expr.(type) {
+	case nil:
+		if f.Name == "*" {
+			return groupKey{}, fmt.Errorf("cannot GROUP BY *")
+		}
+		return groupKey{name: f.Name, field: f.Name}, nil
+	case *sql.FuncCallExpr:
+		if strings.ToUpper(e.Name) == histogramFunc.Name {
+			return groupKey{name: f.Name, field: e.Args[0].(*sql.IdentExpr).Name, histogram: e}, nil
+		}
+		if aggregateCall(f) != nil {
+			return groupKey{}, fmt.Errorf("cannot GROUP BY aggregate %s", f.Name)
+		}
+		return groupKey{name: f.Name, script: e}, nil
+	}
+	return groupKey{}, fmt.Errorf("unsupported GROUP BY expression %s", f.Name)
+}
+
+// genBucketAgg returns the bucket aggregation for a GROUP BY key: a terms
+// aggregation of the values of a field or of a scalar function script, or a
+// histogram or date_histogram aggregation for a HISTOGRAM call. Empty
+// histogram buckets are omitted, as they would be from the terms of a field.
+func (t *translator) genBucketAgg(g groupKey) (string, error) {
+
+	if g.script != nil {
+		script, ok, err := t.genScalarScript(g.script)
+		if !ok {
+			return "", fmt.Errorf("unsupported GROUP BY expression %s", g.name)
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(`"terms": {"script": %s, "size": %d}`, script, termsSize), nil
+	}
+	if g.histogram == nil {
+		return fmt.Sprintf(`"terms": {"field": "%s", "size": %d}`, g.field, termsSize), nil
+	}
+
+	switch interval := g.histogram.Args[1].(type) {
+	case *sql.NumExpr:
+		if interval.Val > 0 {
+			return fmt.Sprintf(`"histogram": {"field": "%s", "interval": %s, "min_doc_count": 1}`,
+				g.field, strconv.FormatFloat(interval.Val, 'f', -1, 64)), nil
+		}
+	case *sql.IntervalExpr:
+		n, _, err := intervalCount(interval)
+		if err != nil {
+			return "", err
+		}
+		if n <= 0 {
+			break
+		}
+		legacy, err := t.versionBefore(7, 2)
+		if err != nil {
+			return "", err
+		}
+		spec, err := dateHistogramInterval(n, interval.Unit, legacy)
+		if err != nil {
+			return "", err
+		}
+		agg := fmt.Sprintf(`"date_histogram": {"field": "%s", %s, "min_doc_count": 1`, g.field, spec)
+		if len(t.opts.TimeZone) > 0 {
+			agg += fmt.Sprintf(`, "time_zone": %s`, strconv.Quote(t.opts.TimeZone))
+		}
+		return agg + "}", nil
+	}
+	return "", fmt.Errorf("HISTOGRAM interval must be a positive number or INTERVAL, got %s", sql.FormatExpr(g.histogram.Args[1]))
+}
+
+// dateHistogramInterval returns the interval setting of a date_histogram
+// bucketing by n units. A single unit is a calendar_interval, following
+// daylight saving changes and months of varying length, while several units
+// must be of fixed length, and so no longer than a week. Clusters older than
+// elasticsearch 7.2, if legacy is set, take either as a plain interval.
+func dateHistogramInterval(n int64, unit string, legacy bool) (string, error) {
+
+	cal := calendarUnits[unit]
+	var setting, value string
+	switch {
+	case n == 1:
+		setting, value = "calendar_interval", "1"+cal
+	case cal == "w":
+		setting, value = "fixed_interval", fmt.Sprintf("%dd", 7*n)
+	case cal == "d" || cal == "h" || cal == "m" || cal == "s":
+		setting, value = "fixed_interval", fmt.Sprintf("%d%s", n, cal)
+	default:
+		return "", fmt.Errorf("date HISTOGRAM cannot bucket by %d %sS", n, unit)
+	}
+	if legacy {
+		setting = "interval"
+	}
+	return fmt.Sprintf(`"%s": "%s"`, setting, value), nil
+}
+
+// genAggQuery returns the search request body for an aggregate statement,
+// which nests a bucket aggregation for each GROUP BY key and computes the
+// aggregate fields within the innermost one.
+func (t *translator) genAggQuery(s *sql.SelectStatement, query string) (string, error) {
+
+	if len(s.OrderBy) > 0 {
+		return "", fmt.Errorf("ORDER BY is not supported with GROUP BY or aggregates")
+	}
+
+	keys, err := groupKeys(s)
+	if err != nil {
+		return "", err
+	}
+
+	var metrics []string
+	for i, f := range s.FieldList {
+		call := aggregateCall(f)
+		if call == nil {
+			if !groupsField(keys, f) {
+				return "", fmt.Errorf("field %s must appear in GROUP BY or be an aggregate", f.Name)
+			}
+			continue
+		}
+		if isCountAll(call) {
+			continue
+		}
+		var ident *sql.IdentExpr
+		if len(call.Args) == 1 {
+			ident, _ = call.Args[0].(*sql.IdentExpr)
+		}
+		if ident == nil || ident.Name == "*" {
+			return "", fmt.Errorf("aggregate %s expects a single field argument", f.Name)
+		}
+		metrics = append(metrics, fmt.Sprintf(`"%s": {"%s": {"field": "%s"}}`,
+			metricAggName(i), metricAggs[strings.ToUpper(call.Name)], ident.Name))
+	}
+
+	aggs := strings.Join(metrics, ", ")
+	for i := len(keys) - 1; i >= 0; i-- {
+		bucket, err := t.genBucketAgg(keys[i])
+		if err != nil {
+			return "", err
+		}
+		sub := ""
+		if len(aggs) > 0 {
+			sub = fmt.Sprintf(`, "aggs": {%s}`, aggs)
+		}
+		aggs = fmt.Sprintf(`"%s": {%s%s}`, groupAggName(i), bucket, sub)
+	}
+
+	body := fmt.Sprintf(`{"query": %s, "size": 0`, query)
+	if len(aggs) > 0 {
+		body += fmt.Sprintf(`, "aggs": {%s}`, aggs)
+	}
+	return body + "}", nil
+}
+
+// groupsField returns true if a non-aggregate select list field holds the
+// keys of a GROUP BY item.
+func groupsField(keys []groupKey, f sql.Field) bool {
+
+	for _, g := range keys {
+		if g.name == f.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
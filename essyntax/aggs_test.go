@@ -0,0 +1,221 @@
+package essyntax
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	"github.com/oldenbur/sql-parser/sql"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+// testQuery returns the elasticsearch query for the specified SQL text.
+func testQuery(q string) (string, error) {
+
+	stmt, err := sql.NewParser(strings.NewReader(q)).Parse()
+	if err != nil {
+		return "", err
+	}
+	return ElasticSearchQuery(stmt)
+}
+
+func TestAggs(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test GROUP BY queries\n", t, func() {
+		es, err := testQuery(`SELECT team, pos, COUNT(*), MAX(goals) most, avg(goals) FROM nhl WHERE goals > 10 GROUP BY team, pos`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"range": {"goals": {"gt": 10}}}}}, "size": 0, "aggs": {`+
+			`"group_0": {"terms": {"field": "team", "size": 10000}, "aggs": {`+
+			`"group_1": {"terms": {"field": "pos", "size": 10000}, "aggs": {`+
+			`"field_3": {"max": {"field": "goals"}}, "field_4": {"avg": {"field": "goals"}}}}}}}}`)
+		log.Debug(es)
+
+		es, err = testQuery(`SELECT team, COUNT(*) FROM nhl GROUP BY team LIMIT 5`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "size": 0, "aggs": {`+
+			`"group_0": {"terms": {"field": "team", "size": 10000}}}}`)
+	})
+
+	Convey("Test aggregates without GROUP BY\n", t, func() {
+		es, err := testQuery(`SELECT COUNT(*), COUNT(goals) n, SUM(goals), MIN(dob) FROM nhl`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "size": 0, "aggs": {`+
+			`"field_1": {"value_count": {"field": "goals"}}, "field_2": {"sum": {"field": "goals"}}, "field_3": {"min": {"field": "dob"}}}}`)
+
+		es, err = testQuery(`SELECT COUNT(*) FROM nhl`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}}`)
+
+		es, err = testQuery(`SELECT COUNT(*) n, COUNT(*) FROM nhl`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "size": 0}`)
+	})
+
+	Convey("Test lone COUNT queries\n", t, func() {
+		es, err := testQuery(`SELECT COUNT(*) FROM nhl`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}}`)
+
+		es, err = testQuery(`SELECT count(*) n FROM oilers WHERE pos = 'D' LIMIT 1`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"term": {"pos": "D"}}}`)
+
+		es, err = testQuery(`SELECT COUNT(goals) FROM nhl`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"bool": {"filter": {"exists": {"field": "goals"}}}}}`)
+
+		es, err = testQuery(`SELECT COUNT(goals) FROM nhl WHERE pos = 'D'`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"bool": {"must": {"term": {"pos": "D"}}, "filter": {"exists": {"field": "goals"}}}}}`)
+
+		es, err = testQuery(`SELECT COUNT(o.goals) FROM oilers o`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"bool": {"filter": {"exists": {"field": "goals"}}}}}`)
+
+		c, err := NewCatalogFromFile("testdata/mapping.json")
+		So(err, ShouldBeNil)
+		es, err = testQueryOptions(`SELECT COUNT(linemates.name) FROM oilers WHERE pos = 'C'`, TranslateOptions{Catalog: c})
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"bool": {"must": {"term": {"pos": "C"}}, `+
+			`"filter": {"nested": {"path": "linemates", "query": {"exists": {"field": "linemates.name"}}}}}}}`)
+
+		es, err = testQuery(`SELECT COUNT(goals) FROM nhl GROUP BY team`)
+		So(err, ShouldBeNil)
+		So(es, ShouldStartWith, `{"query": {"match_all": {}}, "size": 0, "aggs": `)
+	})
+
+	Convey("Test HISTOGRAM grouping\n", t, func() {
+		es, err := testQuery(`SELECT HISTOGRAM(dob, INTERVAL 1 YEAR) AS yr, COUNT(*) FROM oilers GROUP BY yr`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "size": 0, "aggs": {`+
+			`"group_0": {"date_histogram": {"field": "dob", "calendar_interval": "1y", "min_doc_count": 1}}}}`)
+		log.Debug(es)
+
+		es, err = testQuery(`SELECT pos, HISTOGRAM(goals, 10), MAX(goals) FROM oilers GROUP BY pos, HISTOGRAM(goals, 10)`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "size": 0, "aggs": {`+
+			`"group_0": {"terms": {"field": "pos", "size": 10000}, "aggs": {`+
+			`"group_1": {"histogram": {"field": "goals", "interval": 10, "min_doc_count": 1}, "aggs": {`+
+			`"field_2": {"max": {"field": "goals"}}}}}}}}`)
+
+		for _, c := range []struct{ interval, spec string }{
+			{`INTERVAL 1 QUARTER`, `"calendar_interval": "1q"`},
+			{`INTERVAL 1 MONTH`, `"calendar_interval": "1M"`},
+			{`INTERVAL 2 WEEKS`, `"fixed_interval": "14d"`},
+			{`INTERVAL 6 HOURS`, `"fixed_interval": "6h"`},
+			{`INTERVAL 90 SECOND`, `"fixed_interval": "90s"`},
+		} {
+			es, err := testQuery(`SELECT HISTOGRAM(dob, ` + c.interval + `), COUNT(*) FROM oilers GROUP BY 1`)
+			So(err, ShouldBeNil)
+			So(es, ShouldEqual, fmt.Sprintf(`{"query": {"match_all": {}}, "size": 0, "aggs": {`+
+				`"group_0": {"date_histogram": {"field": "dob", %s, "min_doc_count": 1}}}}`, c.spec))
+		}
+
+		for _, c := range []struct{ version, interval, spec string }{
+			{"7.1.1", `INTERVAL 1 MONTH`, `"interval": "1M"`},
+			{"6.8.0", `INTERVAL 2 WEEKS`, `"interval": "14d"`},
+			{"7.2.0", `INTERVAL 1 MONTH`, `"calendar_interval": "1M"`},
+		} {
+			es, err := testQueryOptions(`SELECT HISTOGRAM(dob, `+c.interval+`), COUNT(*) FROM oilers GROUP BY 1`,
+				TranslateOptions{Version: c.version})
+			So(err, ShouldBeNil)
+			So(es, ShouldEqual, fmt.Sprintf(`{"query": {"match_all": {}}, "size": 0, "aggs": {`+
+				`"group_0": {"date_histogram": {"field": "dob", %s, "min_doc_count": 1}}}}`, c.spec))
+		}
+		_, err = testQueryOptions(`SELECT HISTOGRAM(dob, INTERVAL 1 DAY) d FROM oilers GROUP BY d`, TranslateOptions{Version: "latest"})
+		So(err, ShouldResemble, fmt.Errorf(`invalid elasticsearch version "latest"`))
+
+		es, err = testQueryOptions(`SELECT HISTOGRAM(dob, INTERVAL 1 DAY) d FROM oilers GROUP BY d`, TranslateOptions{TimeZone: "Europe/Paris"})
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "size": 0, "aggs": {"group_0": {"date_histogram": `+
+			`{"field": "dob", "calendar_interval": "1d", "min_doc_count": 1, "time_zone": "Europe/Paris"}}}}`)
+	})
+
+	Convey("Test GROUP BY aliases and positions\n", t, func() {
+		es, err := testQuery(`SELECT team t, pos, COUNT(*) FROM nhl GROUP BY t, 2`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "size": 0, "aggs": {`+
+			`"group_0": {"terms": {"field": "team", "size": 10000}, "aggs": {`+
+			`"group_1": {"terms": {"field": "pos", "size": 10000}}}}}}`)
+
+		es, err = testQuery(`SELECT COUNT(*), team FROM nhl GROUP BY team`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "size": 0, "aggs": {`+
+			`"group_0": {"terms": {"field": "team", "size": 10000}}}}`)
+
+		es, err = testQuery(`SELECT o.pos, MAX(o.goals) FROM oilers o GROUP BY o.pos`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "size": 0, "aggs": {`+
+			`"group_0": {"terms": {"field": "pos", "size": 10000}, "aggs": {`+
+			`"field_1": {"max": {"field": "goals"}}}}}}`)
+	})
+
+	Convey("Test invalid aggregate queries\n", t, func() {
+		_, err := testQuery(`SELECT team, name, COUNT(*) FROM nhl GROUP BY team`)
+		So(err, ShouldResemble, fmt.Errorf("field name must appear in GROUP BY or be an aggregate"))
+
+		_, err = testQuery(`SELECT *, COUNT(*) FROM nhl`)
+		So(err, ShouldResemble, fmt.Errorf("field * must appear in GROUP BY or be an aggregate"))
+
+		_, err = testQuery(`SELECT SCORE(), COUNT(*) FROM nhl`)
+		So(err, ShouldResemble, fmt.Errorf("field SCORE() must appear in GROUP BY or be an aggregate"))
+
+		_, err = testQuery(`SELECT MAX(goals, assists) FROM nhl`)
+		So(err.Error(), ShouldEqual, "MAX expects 1 argument, got 2")
+
+		_, err = testQuery(`SELECT MAX() FROM nhl`)
+		So(err.Error(), ShouldEqual, "MAX expects 1 argument, got 0")
+
+		_, err = testQuery(`SELECT SUM(*) FROM nhl`)
+		So(err.Error(), ShouldEqual, "argument 1 of SUM must be a field, got *")
+
+		_, err = testQuery(`SELECT team FROM nhl GROUP BY F(team)`)
+		So(err.Error(), ShouldEqual, "unknown function F")
+
+		fs := NewFunctionSet()
+		fs.Registry.Register(sql.FuncSignature{Name: "F", Args: []sql.ArgType{sql.FieldType}, Returns: sql.NumberType})
+		stmt, err := sql.NewParser(strings.NewReader(`SELECT F(team), COUNT(*) FROM nhl GROUP BY F(team)`)).Parse()
+		So(err, ShouldBeNil)
+		_, err = ElasticSearchQueryOptions(stmt, TranslateOptions{Functions: fs})
+		So(err, ShouldResemble, fmt.Errorf("unsupported GROUP BY expression F(team)"))
+
+		fs.RegisterScalar(sql.FuncSignature{Name: "F", Args: []sql.ArgType{sql.FieldType}, Returns: sql.StringType},
+			func(call *sql.FuncCallExpr, value func(sql.Expr) (string, error)) (string, error) {
+				return fmt.Sprintf(`{"source": "doc['%s'].value.toLowerCase()"}`, sql.FormatExpr(call.Args[0])), nil
+			})
+		es, err := ElasticSearchQueryOptions(stmt, TranslateOptions{Functions: fs})
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "size": 0, "aggs": {`+
+			`"group_0": {"terms": {"script": {"source": "doc['team'].value.toLowerCase()"}, "size": 10000}}}}`)
+
+		_, err = testQuery(`SELECT team FROM nhl GROUP BY team ORDER BY team`)
+		So(err, ShouldResemble, fmt.Errorf("ORDER BY is not supported with GROUP BY or aggregates"))
+
+		for _, c := range []struct{ q, err string }{
+			{`SELECT team, COUNT(*) FROM nhl GROUP BY 3`, "GROUP BY position 3 is not in the select list"},
+			{`SELECT team, COUNT(*) FROM nhl GROUP BY 1.5`, "GROUP BY position 1.5 is not in the select list"},
+			{`SELECT team, COUNT(*) FROM nhl GROUP BY 2`, "cannot GROUP BY aggregate COUNT(*)"},
+			{`SELECT * FROM nhl GROUP BY 1`, "cannot GROUP BY *"},
+			{`SELECT SCORE() s FROM nhl GROUP BY s`, "unsupported GROUP BY expression SCORE()"},
+			{`SELECT team FROM nhl GROUP BY 'team'`, "unsupported GROUP BY expression 'team'"},
+			{`SELECT HISTOGRAM(dob, INTERVAL 1 DAY) FROM nhl GROUP BY HISTOGRAM(dob, INTERVAL 2 DAY)`,
+				"field HISTOGRAM(dob, INTERVAL 1 DAY) must appear in GROUP BY or be an aggregate"},
+			{`SELECT COUNT(*) FROM nhl GROUP BY HISTOGRAM(goals, 0)`, "HISTOGRAM interval must be a positive number or INTERVAL, got 0"},
+			{`SELECT COUNT(*) FROM nhl GROUP BY HISTOGRAM(goals, 'ten')`, "HISTOGRAM interval must be a positive number or INTERVAL, got 'ten'"},
+			{`SELECT COUNT(*) FROM nhl GROUP BY HISTOGRAM(dob, INTERVAL -1 DAY)`, "HISTOGRAM interval must be a positive number or INTERVAL, got INTERVAL -1 DAY"},
+			{`SELECT COUNT(*) FROM nhl GROUP BY HISTOGRAM(dob, INTERVAL 2 YEARS)`, "date HISTOGRAM cannot bucket by 2 YEARS"},
+			{`SELECT COUNT(*) FROM nhl GROUP BY HISTOGRAM(dob, INTERVAL 1.5 DAY)`, "INTERVAL count must be an integer, got INTERVAL 1.5 DAY"},
+			{`SELECT COUNT(*) FROM nhl GROUP BY HISTOGRAM('dob', 10)`, "argument 1 of HISTOGRAM must be a field, got 'dob'"},
+		} {
+			_, err := testQuery(c.q)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, c.err)
+		}
+	})
+}
@@ -0,0 +1,382 @@
+package essyntax
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// Prepared is a parsed statement together with its elasticsearch query
+// template. It is immutable and may be executed concurrently with different
+// arguments, each execution only binding the parameters into the template.
+type Prepared struct {
+	Stmt *sql.SelectStatement
+
+	// parts holds the template text surrounding the parameter slots, so that
+	// slot i is rendered between parts[i] and parts[i+1].
+	parts []string
+	slots []paramSlot
+
+	// staged is set for statements with joins or IN subqueries, and for
+	// statements with unions whose branches cannot be sent in a single
+	// _msearch request, which have no template since an Executor runs them
+	// in stages.
+	staged bool
+}
+
+// Prepare parses the specified SQL text and translates it into a query
+// template.
+func Prepare(sqlText string) (*Prepared, error) {
+	return PrepareOptions(sqlText, TranslateOptions{})
+}
+
+// PrepareOptions parses the specified SQL text and translates it into a
+// query template with the specified options. A statement with joins or IN
+// subqueries has no single query, and is only checked to be executable by
+// an Executor. The template of a statement with unions is an _msearch
+// request body, if its branches can be sent in a single request.
+func PrepareOptions(sqlText string, opts TranslateOptions) (*Prepared, error) {
+
+	stmt, err := sql.NewParser(strings.NewReader(sqlText)).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkUnion(stmt); err != nil {
+		return nil, err
+	}
+	if stagedError(stmt) != nil {
+		branches := []*sql.SelectStatement{stmt}
+		if len(stmt.Unions) > 0 {
+			branches = unionBranches(stmt)
+		}
+		for _, b := range branches {
+			if err := checkSubqueries(b, nil); err != nil {
+				return nil, err
+			}
+			if len(b.Joins) > 0 {
+				if _, err := planJoin(b); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return &Prepared{Stmt: stmt, staged: true}, nil
+	}
+
+	t := &translator{template: true, opts: opts}
+	tmpl, err := t.genQuery(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Prepared{Stmt: stmt, slots: t.slots}
+	for i := range t.slots {
+		marker := slotMarker(i)
+		idx := strings.Index(tmpl, marker)
+		if idx < 0 {
+			return nil, fmt.Errorf("query template is missing parameter %s", t.slots[i].param)
+		}
+		p.parts = append(p.parts, tmpl[:idx])
+		tmpl = tmpl[idx+len(marker):]
+	}
+	p.parts = append(p.parts, tmpl)
+
+	return p, nil
+}
+
+// Query returns the elasticsearch query for the statement with args bound to
+// its positional and numbered parameters.
+func (p *Prepared) Query(args ...interface{}) (string, error) {
+
+	b, err := sql.NewBinding(p.Stmt, args...)
+	if err != nil {
+		return "", err
+	}
+	return p.render(b)
+}
+
+// QueryNamed returns the elasticsearch query for the statement with args
+// bound to its named parameters.
+func (p *Prepared) QueryNamed(args map[string]interface{}) (string, error) {
+
+	b, err := sql.NewNamedBinding(p.Stmt, args)
+	if err != nil {
+		return "", err
+	}
+	return p.render(b)
+}
+
+// render fills each parameter slot of the template with its bound value.
+func (p *Prepared) render(b sql.Binding) (string, error) {
+
+	if p.staged {
+		return "", stagedError(p.Stmt)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(p.parts[0])
+	for i, slot := range p.slots {
+		val, err := slot.render(b[slot.param.Key()])
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(val)
+		buf.WriteString(p.parts[i+1])
+	}
+
+	return buf.String(), nil
+}
+
+// Indices returns the names of the indices the statement selects from,
+// including those of its joins, unions and IN subqueries, in the order they
+// first appear.
+func (p *Prepared) Indices() []string {
+
+	var names []string
+	sql.Inspect(p.Stmt, func(n sql.Node) bool {
+		switch n := n.(type) {
+		case *sql.SelectStatement:
+			for _, t := range n.Tables() {
+				if !containsString(names, t.Name) {
+					names = append(names, t.Name)
+				}
+			}
+		case *sql.CondExists:
+			// The table of an EXISTS subquery is a nested field.
+			return false
+		}
+		return true
+	})
+	return names
+}
+
+// readsIndex returns true if the statement selects from the specified
+// concrete index: by its name, through a wildcard pattern matching it, or
+// through an alias or pattern which the Catalog, if not nil, resolves to it.
+func (p *Prepared) readsIndex(index string, c *Catalog) bool {
+
+	for _, name := range p.Indices() {
+		if name == index || matchIndex(name, index) {
+			return true
+		}
+		if c != nil {
+			if names, ok := c.Resolve(name); ok && containsString(names, index) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CacheStats reports the activity of a PreparedCache.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+// PreparedCache is a concurrency-safe cache of Prepared statements keyed by
+// normalized SQL text. Once it holds capacity entries, adding another evicts
+// the least recently used one.
+type PreparedCache struct {
+	// Translate controls the translation of statements prepared on a cache
+	// miss, and must not be changed once the cache is in use.
+	Translate TranslateOptions
+
+	mu       sync.Mutex
+	capacity int
+	lru      *list.List // of *cacheEntry, most recently used first
+	entries  map[string]*list.Element
+	stats    CacheStats
+
+	// generation counts the invalidations, so that a statement translated
+	// while one ran, possibly against the mapping it invalidated, is not
+	// cached.
+	generation uint64
+}
+
+type cacheEntry struct {
+	key  string
+	prep *Prepared
+}
+
+// NewPreparedCache returns an empty cache holding at most capacity statements.
+func NewPreparedCache(capacity int) *PreparedCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &PreparedCache{
+		capacity: capacity,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Prepare returns the cached Prepared statement for the specified SQL text,
+// parsing and translating it on a cache miss. Statements that fail to parse
+// or translate are not cached, nor are those translated while the cache was
+// invalidated or purged.
+func (c *PreparedCache) Prepare(sqlText string) (*Prepared, error) {
+
+	key := normalizeSQL(sqlText)
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+		c.stats.Hits += 1
+		c.mu.Unlock()
+		return el.Value.(*cacheEntry).prep, nil
+	}
+	c.stats.Misses += 1
+	generation := c.generation
+	c.mu.Unlock()
+
+	prep, err := PrepareOptions(sqlText, c.Translate)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return prep, nil
+	}
+
+	// Another caller may have prepared the same statement in the meantime.
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+		return el.Value.(*cacheEntry).prep, nil
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, prep: prep})
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+		c.stats.Evictions += 1
+	}
+
+	return prep, nil
+}
+
+// Query prepares the specified SQL text through the cache and returns its
+// elasticsearch query with args bound to the positional parameters.
+func (c *PreparedCache) Query(sqlText string, args ...interface{}) (string, error) {
+
+	prep, err := c.Prepare(sqlText)
+	if err != nil {
+		return "", err
+	}
+	return prep.Query(args...)
+}
+
+// Invalidate drops every cached statement that selects from the specified
+// index, e.g. after its mapping has changed, and returns the number dropped.
+// Statements selecting from it through a wildcard pattern are dropped too,
+// as are those selecting from it through an alias resolved by the Catalog of
+// the Translate options.
+func (c *PreparedCache) Invalidate(index string) int {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation += 1
+	n := 0
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*cacheEntry).prep.readsIndex(index, c.Translate.Catalog) {
+			c.remove(el)
+			n += 1
+		}
+		el = next
+	}
+	return n
+}
+
+// Refresh reloads the mappings of the specified indices, or of every index,
+// in the Catalog of the Translate options, and drops the cached statements
+// translated against their old mappings: those selecting from any of the
+// indices, or every statement if none are specified.
+func (c *PreparedCache) Refresh(indices ...string) error {
+
+	if c.Translate.Catalog != nil {
+		if err := c.Translate.Catalog.Refresh(indices...); err != nil {
+			return err
+		}
+	}
+	if len(indices) == 0 {
+		c.Purge()
+	}
+	for _, index := range indices {
+		c.Invalidate(index)
+	}
+	return nil
+}
+
+// Purge drops every cached statement.
+func (c *PreparedCache) Purge() {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation += 1
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// Stats returns a snapshot of the cache activity.
+func (c *PreparedCache) Stats() CacheStats {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	return stats
+}
+
+// remove drops the specified element, which must be held by the cache.
+func (c *PreparedCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+// normalizeSQL returns the specified SQL text with leading and trailing
+// whitespace removed and every other run of whitespace outside of string
+// literals collapsed into a single space.
+func normalizeSQL(sqlText string) string {
+
+	var buf bytes.Buffer
+	var quote rune
+	space := false
+	escaped := false
+
+	for _, ch := range strings.TrimSpace(sqlText) {
+		switch {
+		case quote != 0:
+			if escaped {
+				escaped = false
+			} else if ch == '\\' {
+				escaped = true
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			space = true
+			continue
+		case ch == '\'' || ch == '"':
+			quote = ch
+		}
+
+		if space {
+			buf.WriteRune(' ')
+			space = false
+		}
+		buf.WriteRune(ch)
+	}
+
+	return buf.String()
+}
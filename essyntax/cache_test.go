@@ -0,0 +1,200 @@
+package essyntax
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	elastigo "github.com/mattbaird/elastigo/lib"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+func TestPreparedCache(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test normalizing SQL text\n", t, func() {
+		So(normalizeSQL("  SELECT name\n\tFROM  oilers  "), ShouldEqual, "SELECT name FROM oilers")
+		So(normalizeSQL(`SELECT name FROM oilers WHERE quote = 'two  spaces'`), ShouldEqual,
+			`SELECT name FROM oilers WHERE quote = 'two  spaces'`)
+		So(normalizeSQL(`SELECT a FROM b WHERE q = "esc\"  aped"   AND  r = 1`), ShouldEqual,
+			`SELECT a FROM b WHERE q = "esc\"  aped" AND r = 1`)
+	})
+
+	Convey("Test preparing and binding a statement\n", t, func() {
+		p, err := Prepare(`SELECT name FROM oilers WHERE pos = ? AND goals >= $2`)
+		So(err, ShouldBeNil)
+
+		q, err := p.Query("C", 50)
+		So(err, ShouldBeNil)
+		So(q, ShouldEqual, `{"query": {"constant_score": {"filter": {"bool": {"must": [{"term": {"pos": "C"}}, {"range": {"goals": {"gte": 50}}}]}}}}, "_source": ["name"]}`)
+		log.Debug(q)
+
+		q, err = p.Query(`"D"`, 7.5)
+		So(err, ShouldBeNil)
+		So(q, ShouldEqual, `{"query": {"constant_score": {"filter": {"bool": {"must": [{"term": {"pos": "\"D\""}}, {"range": {"goals": {"gte": 7.5}}}]}}}}, "_source": ["name"]}`)
+
+		_, err = p.Query("C")
+		So(err, ShouldResemble, fmt.Errorf("missing value for parameter $2"))
+
+		_, err = p.Query("C", "lots")
+		So(err, ShouldResemble, fmt.Errorf("unexpected comparison token generating string comparison: GE"))
+
+		p, err = Prepare(`SELECT name FROM oilers WHERE name = :name`)
+		So(err, ShouldBeNil)
+		q, err = p.QueryNamed(map[string]interface{}{"name": "Wayne Gretzky"})
+		So(err, ShouldBeNil)
+		So(q, ShouldEqual, `{"query": {"constant_score": {"filter": {"term": {"name": "Wayne Gretzky"}}}}, "_source": ["name"]}`)
+
+		p, err = Prepare(`SELECT name FROM oilers`)
+		So(err, ShouldBeNil)
+		q, err = p.Query()
+		So(err, ShouldBeNil)
+		So(q, ShouldEqual, `{"query": {"match_all": {}}, "_source": ["name"]}`)
+
+		p, err = Prepare(`SELECT name, goals g FROM oilers ORDER BY g DESC, name LIMIT ?`)
+		So(err, ShouldBeNil)
+		q, err = p.Query(5)
+		So(err, ShouldBeNil)
+		So(q, ShouldEqual, `{"query": {"match_all": {}}, "_source": ["name", "goals"], `+
+			`"sort": [{"goals": {"order": "desc"}}, {"name": {"order": "asc"}}], "size": 5}`)
+
+		_, err = p.Query(2.5)
+		So(err, ShouldResemble, fmt.Errorf("LIMIT must be a non-negative integer, got 2.500000"))
+	})
+
+	Convey("Test cache hits, misses and eviction\n", t, func() {
+		c := NewPreparedCache(2)
+
+		p1, err := c.Prepare(`SELECT name FROM oilers WHERE pos = ?`)
+		So(err, ShouldBeNil)
+		p2, err := c.Prepare("SELECT name\n  FROM oilers   WHERE pos = ?")
+		So(err, ShouldBeNil)
+		So(p2, ShouldEqual, p1)
+		So(c.Stats(), ShouldResemble, CacheStats{Hits: 1, Misses: 1, Size: 1})
+
+		_, err = c.Prepare(`SELECT name FROM flames WHERE pos = ?`)
+		So(err, ShouldBeNil)
+		_, err = c.Prepare(`SELECT name FROM oilers WHERE pos = ?`)
+		So(err, ShouldBeNil)
+		_, err = c.Prepare(`SELECT name FROM jets`)
+		So(err, ShouldBeNil)
+		So(c.Stats(), ShouldResemble, CacheStats{Hits: 2, Misses: 3, Evictions: 1, Size: 2})
+
+		// flames was least recently used, so it was evicted
+		_, err = c.Prepare(`SELECT name FROM flames WHERE pos = ?`)
+		So(err, ShouldBeNil)
+		So(c.Stats().Misses, ShouldEqual, 4)
+
+		_, err = c.Prepare(`SELECT name FROM`)
+		So(err, ShouldNotBeNil)
+		So(c.Stats(), ShouldResemble, CacheStats{Hits: 2, Misses: 5, Evictions: 2, Size: 2})
+
+		q, err := c.Query(`SELECT name FROM flames WHERE pos = ?`, "G")
+		So(err, ShouldBeNil)
+		So(q, ShouldEqual, `{"query": {"constant_score": {"filter": {"term": {"pos": "G"}}}}, "_source": ["name"]}`)
+
+		c.Purge()
+		So(c.Stats().Size, ShouldEqual, 0)
+	})
+
+	Convey("Test cache invalidation by index\n", t, func() {
+		c := NewPreparedCache(10)
+		_, err := c.Prepare(`SELECT name FROM oilers WHERE pos = ?`)
+		So(err, ShouldBeNil)
+		_, err = c.Prepare(`SELECT name FROM oilers, flames`)
+		So(err, ShouldBeNil)
+		_, err = c.Prepare(`SELECT name FROM flames`)
+		So(err, ShouldBeNil)
+
+		So(c.Invalidate("oilers"), ShouldEqual, 2)
+		So(c.Stats().Size, ShouldEqual, 1)
+		So(c.Invalidate("oilers"), ShouldEqual, 0)
+
+		_, err = c.Prepare(`SELECT name FROM oil*`)
+		So(err, ShouldBeNil)
+		So(c.Invalidate("oilers"), ShouldEqual, 1)
+	})
+
+	Convey("Test cache invalidation through index aliases\n", t, func() {
+		mapping, err := ioutil.ReadFile("testdata/mapping.json")
+		So(err, ShouldBeNil)
+		es := newFakeES(string(mapping))
+		defer es.Close()
+
+		c := NewPreparedCache(10)
+		c.Translate.Catalog = NewCatalog(es.conn())
+		_, err = c.Prepare(`SELECT name FROM nhl WHERE pos = ?`)
+		So(err, ShouldBeNil)
+		_, err = c.Prepare(`SELECT name FROM nhl WHERE goals > ?`)
+		So(err, ShouldBeNil)
+		So(c.Invalidate("kings"), ShouldEqual, 0)
+		So(c.Invalidate("jets"), ShouldEqual, 2)
+
+		_, err = c.Prepare(`SELECT name FROM nhl WHERE pos = ?`)
+		So(err, ShouldBeNil)
+		So(c.Refresh("nhl"), ShouldBeNil)
+		So(es.paths, ShouldResemble, []string{"GET /nhl/_mapping", "GET /nhl/_mapping"})
+		So(c.Stats().Size, ShouldEqual, 0)
+
+		_, err = c.Prepare(`SELECT name FROM nhl WHERE pos = ?`)
+		So(err, ShouldBeNil)
+		So(c.Refresh(), ShouldBeNil)
+		So(c.Stats().Size, ShouldEqual, 0)
+	})
+
+	Convey("Test invalidating the cache while a statement is translated\n", t, func() {
+		mapping, err := ioutil.ReadFile("testdata/mapping.json")
+		So(err, ShouldBeNil)
+
+		// The mapping of the index is invalidated while it is loaded for the
+		// translation of the statement.
+		c := NewPreparedCache(10)
+		es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.Invalidate("oilers")
+			w.Write(mapping)
+		}))
+		defer es.Close()
+		conn := elastigo.NewConn()
+		conn.SetFromUrl(es.URL)
+		c.Translate.Catalog = NewCatalog(conn)
+
+		_, err = c.Prepare(`SELECT name FROM oilers`)
+		So(err, ShouldBeNil)
+		So(c.Stats().Size, ShouldEqual, 0)
+		_, err = c.Prepare(`SELECT name FROM oilers`)
+		So(err, ShouldBeNil)
+		So(c.Stats().Size, ShouldEqual, 1)
+	})
+
+	Convey("Test concurrent use of the cache\n", t, func() {
+		c := NewPreparedCache(4)
+		queries := make([]string, 64)
+		errs := make([]error, 64)
+
+		var wg sync.WaitGroup
+		for i := range queries {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				queries[i], errs[i] = c.Query(`SELECT name FROM oilers WHERE jersey = ?`, i)
+			}(i)
+		}
+		wg.Wait()
+
+		for i := range queries {
+			So(errs[i], ShouldBeNil)
+			So(queries[i], ShouldEqual, fmt.Sprintf(`{"query": {"constant_score": {"filter": {"term": {"jersey": %d}}}}, "_source": ["name"]}`, i))
+		}
+		stats := c.Stats()
+		So(stats.Hits+stats.Misses, ShouldEqual, 64)
+		So(stats.Size, ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,368 @@
+package essyntax
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	elastigo "github.com/mattbaird/elastigo/lib"
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// defaultDateFormat is the format of date fields mapped without one.
+const defaultDateFormat = "strict_date_optional_time||epoch_millis"
+
+// FieldInfo describes a field of an index mapping.
+type FieldInfo struct {
+	// Path is the dotted path of the field, including the name of the
+	// multi-field for fields such as name.keyword.
+	Path string
+
+	// Type is the elasticsearch type of the field, such as keyword, text,
+	// long, date, geo_point, nested or object. Strings of legacy mappings are
+	// reported as keyword if not analyzed and as text otherwise.
+	Type string
+
+	// Format is the format of a date field.
+	Format string
+
+	// Nested is the path of the innermost nested field enclosing the field,
+	// if any.
+	Nested string
+}
+
+// Catalog caches the fields mapped by indices, keyed by the index names used
+// in FROM clauses. Mappings are loaded as statements name new indices, and
+// reloaded on demand by Refresh.
+type Catalog struct {
+	conn *elastigo.Conn
+
+	mu       sync.RWMutex
+	indices  map[string]map[string]FieldInfo
+	resolved map[string][]string // the concrete indices of each name
+}
+
+// NewCatalog returns an empty Catalog which loads mappings over the
+// specified connection.
+func NewCatalog(conn *elastigo.Conn) *Catalog {
+	return &Catalog{conn: conn, indices: make(map[string]map[string]FieldInfo), resolved: make(map[string][]string)}
+}
+
+// NewCatalogFromFile returns an offline Catalog holding the mappings in the
+// specified file, which has the format of a _mapping response. It cannot
+// load the mappings of any other indices.
+func NewCatalogFromFile(filename string) (*Catalog, error) {
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	mappings, err := parseMappings(b)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing mapping file %s: %v", filename, err)
+	}
+	c := &Catalog{indices: mappings, resolved: make(map[string][]string)}
+	for index := range mappings {
+		c.resolved[index] = []string{index}
+	}
+	return c, nil
+}
+
+// Load loads the mappings of any of the specified tables which are not yet
+// in the Catalog. A table naming an alias or wildcard pattern is given the
+// fields of every index it resolves to, the first index in name order
+// winning any conflict.
+func (c *Catalog) Load(tables sql.Fields) error {
+
+	for _, t := range tables {
+		c.mu.RLock()
+		_, ok := c.indices[t.Name]
+		c.mu.RUnlock()
+		if ok {
+			continue
+		}
+		if err := c.load(t.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Refresh reloads the mappings of the specified indices, or of every index
+// in the Catalog if none are specified. Queries already translated against
+// the old mappings are not affected: PreparedCache.Refresh also drops the
+// cached statements reading the indices. An offline Catalog has no mappings
+// to reload, so refreshing it does nothing.
+func (c *Catalog) Refresh(indices ...string) error {
+
+	if c.conn == nil {
+		return nil
+	}
+	if len(indices) == 0 {
+		indices = c.Indices()
+	}
+	for _, index := range indices {
+		if err := c.load(index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load fetches the mapping for the specified index name into the Catalog.
+// An offline Catalog resolves a wildcard pattern against the indices it
+// holds instead.
+func (c *Catalog) load(index string) error {
+
+	if c.conn == nil {
+		return c.loadPattern(index)
+	}
+
+	body, err := c.conn.DoCommand("GET", fmt.Sprintf("/%s/_mapping", index), nil, nil)
+	if err != nil {
+		return err
+	}
+	mappings, err := parseMappings(body)
+	if err != nil {
+		return fmt.Errorf("error parsing mapping for index %s: %v", index, err)
+	}
+	if len(mappings) == 0 {
+		return fmt.Errorf("no mapping for index %s", index)
+	}
+	c.add(index, mappings)
+	return nil
+}
+
+// loadPattern resolves a wildcard pattern against the indices of an offline
+// Catalog.
+func (c *Catalog) loadPattern(pattern string) error {
+
+	mappings := make(map[string]map[string]FieldInfo)
+	if strings.Contains(pattern, "*") {
+		c.mu.RLock()
+		for name, names := range c.resolved {
+			if len(names) == 1 && names[0] == name && matchIndex(pattern, name) {
+				mappings[name] = c.indices[name]
+			}
+		}
+		c.mu.RUnlock()
+	}
+	if len(mappings) == 0 {
+		return fmt.Errorf("no mapping for index %s in offline catalog", pattern)
+	}
+	c.add(pattern, mappings)
+	return nil
+}
+
+// add records the mappings of the indices a name resolves to, merging their
+// fields under the name.
+func (c *Catalog) add(index string, mappings map[string]map[string]FieldInfo) {
+
+	var names []string
+	for name := range mappings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make(map[string]FieldInfo)
+	for _, name := range names {
+		for path, f := range mappings[name] {
+			if _, ok := fields[path]; !ok {
+				fields[path] = f
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.indices[index] = fields
+	c.resolved[index] = names
+	c.mu.Unlock()
+}
+
+// matchIndex returns true if an index name matches a pattern in which each
+// * matches any run of characters.
+func matchIndex(pattern, name string) bool {
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 {
+			return strings.HasSuffix(name, part)
+		}
+		j := strings.Index(name, part)
+		if j < 0 {
+			return false
+		}
+		name = name[j+len(part):]
+	}
+	return len(name) == 0
+}
+
+// Indices returns the names of the indices in the Catalog, in name order.
+func (c *Catalog) Indices() []string {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var names []string
+	for name := range c.indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve returns the names of the concrete indices an index name in the
+// Catalog resolves to, in name order: the index itself, or the indices of an
+// alias or wildcard pattern.
+func (c *Catalog) Resolve(index string) ([]string, bool) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names, ok := c.resolved[index]
+	return names, ok
+}
+
+// Field returns the field with the specified path in the mapping of an
+// index, and false if the index is not in the Catalog or lacks the field.
+// Every index has the _index pseudo-column, a keyword.
+func (c *Catalog) Field(index, path string) (FieldInfo, bool) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	fields, ok := c.indices[index]
+	if ok && path == indexField {
+		return indexFieldInfo, true
+	}
+	f, ok := fields[path]
+	return f, ok
+}
+
+// Fields returns the fields of the mapping of an index, in path order.
+func (c *Catalog) Fields(index string) []FieldInfo {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var fields []FieldInfo
+	for _, f := range c.indices[index] {
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Path < fields[j].Path })
+	return fields
+}
+
+// mappingProp is a field of a mapping, as returned by the _mapping API.
+type mappingProp struct {
+	Type       string                 `json:"type"`
+	Format     string                 `json:"format"`
+	Index      json.RawMessage        `json:"index"`
+	Properties map[string]mappingProp `json:"properties"`
+	Fields     map[string]mappingProp `json:"fields"`
+}
+
+// parseMappings returns the fields of each index in a _mapping response.
+// The mappings of each index are either typeless, or keyed by document type,
+// in which case the fields of every type are merged.
+func parseMappings(b []byte) (map[string]map[string]FieldInfo, error) {
+
+	var resp map[string]struct {
+		Mappings map[string]json.RawMessage `json:"mappings"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, err
+	}
+
+	indices := make(map[string]map[string]FieldInfo)
+	for index, m := range resp {
+		if m.Mappings == nil {
+			return nil, fmt.Errorf("missing mappings for index %s", index)
+		}
+
+		var types []string
+		if _, ok := m.Mappings["properties"]; ok {
+			types = []string{""}
+		} else {
+			for name := range m.Mappings {
+				types = append(types, name)
+			}
+			sort.Strings(types)
+		}
+
+		fields := make(map[string]FieldInfo)
+		for _, name := range types {
+			var props map[string]mappingProp
+			raw := m.Mappings["properties"]
+			if len(name) > 0 {
+				var typeMapping struct {
+					Properties json.RawMessage `json:"properties"`
+				}
+				if err := json.Unmarshal(m.Mappings[name], &typeMapping); err != nil {
+					return nil, err
+				}
+				raw = typeMapping.Properties
+			}
+			if len(raw) > 0 {
+				if err := json.Unmarshal(raw, &props); err != nil {
+					return nil, err
+				}
+			}
+			addMappingFields("", "", props, fields)
+		}
+		indices[index] = fields
+	}
+
+	return indices, nil
+}
+
+// addMappingFields adds the specified properties, found at prefix within the
+// nested field with path nested, and their multi-fields and sub-properties
+// to fields. Fields already present are kept.
+func addMappingFields(prefix, nested string, props map[string]mappingProp, fields map[string]FieldInfo) {
+
+	for name, p := range props {
+		path := prefix + name
+		f := FieldInfo{Path: path, Type: mappingType(p), Format: p.Format, Nested: nested}
+		if f.Type == "date" && len(f.Format) == 0 {
+			f.Format = defaultDateFormat
+		}
+		if _, ok := fields[path]; !ok {
+			fields[path] = f
+		}
+
+		for sub, sp := range p.Fields {
+			subPath := path + "." + sub
+			if _, ok := fields[subPath]; !ok {
+				fields[subPath] = FieldInfo{Path: subPath, Type: mappingType(sp), Format: sp.Format, Nested: nested}
+			}
+		}
+
+		inner := nested
+		if f.Type == "nested" {
+			inner = path
+		}
+		addMappingFields(path+".", inner, p.Properties, fields)
+	}
+}
+
+// mappingType returns the type of a mapped field, which is object for fields
+// with properties but no type, and keyword or text for legacy strings.
+func mappingType(p mappingProp) string {
+
+	switch {
+	case len(p.Type) == 0 && p.Properties != nil:
+		return "object"
+	case p.Type == "string" && string(p.Index) == `"not_analyzed"`:
+		return "keyword"
+	case p.Type == "string":
+		return "text"
+	}
+	return p.Type
+}
@@ -0,0 +1,120 @@
+package essyntax
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	"github.com/oldenbur/sql-parser/sql"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+// testTables returns the tables of the specified SQL text.
+func testTables(q string) sql.Fields {
+	stmt, err := sql.NewParser(strings.NewReader(q)).Parse()
+	if err != nil {
+		panic(err)
+	}
+	return stmt.TableList
+}
+
+func TestCatalog(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test an offline catalog\n", t, func() {
+		c, err := NewCatalogFromFile("testdata/mapping.json")
+		So(err, ShouldBeNil)
+		So(c.Indices(), ShouldResemble, []string{"flames", "jets", "oilers"})
+
+		f, ok := c.Field("oilers", "name")
+		So(ok, ShouldBeTrue)
+		So(f, ShouldResemble, FieldInfo{Path: "name", Type: "text"})
+		f, _ = c.Field("oilers", "name.keyword")
+		So(f, ShouldResemble, FieldInfo{Path: "name.keyword", Type: "keyword"})
+		f, _ = c.Field("oilers", "dob")
+		So(f, ShouldResemble, FieldInfo{Path: "dob", Type: "date", Format: "basic_date"})
+		f, _ = c.Field("oilers", "hometown")
+		So(f.Type, ShouldEqual, "geo_point")
+		f, _ = c.Field("oilers", "stats.ppg")
+		So(f, ShouldResemble, FieldInfo{Path: "stats.ppg", Type: "double"})
+		f, _ = c.Field("oilers", "linemates.seasons.year")
+		So(f, ShouldResemble, FieldInfo{Path: "linemates.seasons.year", Type: "long", Nested: "linemates.seasons"})
+		f, _ = c.Field("oilers", "linemates.seasons")
+		So(f, ShouldResemble, FieldInfo{Path: "linemates.seasons", Type: "nested", Nested: "linemates"})
+
+		f, _ = c.Field("flames", "drafted")
+		So(f, ShouldResemble, FieldInfo{Path: "drafted", Type: "date", Format: defaultDateFormat})
+		f, _ = c.Field("jets", "pos")
+		So(f.Type, ShouldEqual, "keyword")
+		f, _ = c.Field("jets", "name")
+		So(f.Type, ShouldEqual, "text")
+
+		_, ok = c.Field("oilers", "missing")
+		So(ok, ShouldBeFalse)
+		_, ok = c.Field("kings", "name")
+		So(ok, ShouldBeFalse)
+
+		var paths []string
+		for _, f := range c.Fields("oilers") {
+			paths = append(paths, f.Path)
+		}
+		So(paths, ShouldResemble, []string{"GAA", "dob", "goals", "hometown", "jersey",
+			"linemates", "linemates.name", "linemates.seasons", "linemates.seasons.year",
+			"name", "name.keyword", "pos", "quote", "stats", "stats.plusminus", "stats.ppg", "teams"})
+
+		So(c.Load(testTables(`SELECT name FROM oilers, flames`)), ShouldBeNil)
+		So(c.Load(testTables(`SELECT name FROM kings`)), ShouldResemble, fmt.Errorf("no mapping for index kings in offline catalog"))
+		So(c.Refresh("oilers"), ShouldBeNil)
+	})
+
+	Convey("Test loading mappings\n", t, func() {
+		mapping, err := ioutil.ReadFile("testdata/mapping.json")
+		So(err, ShouldBeNil)
+		es := newFakeES(string(mapping))
+		defer es.Close()
+
+		c := NewCatalog(es.conn())
+		So(c.Load(testTables(`SELECT name FROM nhl*`)), ShouldBeNil)
+		So(es.paths, ShouldResemble, []string{"GET /nhl*/_mapping"})
+		So(c.Indices(), ShouldResemble, []string{"nhl*"})
+		f, _ := c.Field("nhl*", "name")
+		So(f.Type, ShouldEqual, "text")
+		f, _ = c.Field("nhl*", "drafted")
+		So(f.Type, ShouldEqual, "date")
+
+		So(c.Load(testTables(`SELECT name FROM nhl*`)), ShouldBeNil)
+		So(es.paths, ShouldHaveLength, 1)
+
+		es.response = `{"nhl": {"mappings": {"properties": {"name": {"type": "keyword"}}}}}`
+		So(c.Refresh(), ShouldBeNil)
+		So(es.paths, ShouldResemble, []string{"GET /nhl*/_mapping", "GET /nhl*/_mapping"})
+		f, _ = c.Field("nhl*", "name")
+		So(f.Type, ShouldEqual, "keyword")
+		_, ok := c.Field("nhl*", "drafted")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Test catalog errors\n", t, func() {
+		_, err := NewCatalogFromFile("testdata/missing.json")
+		So(err, ShouldNotBeNil)
+
+		es := newFakeES(`{}`)
+		defer es.Close()
+		c := NewCatalog(es.conn())
+		So(c.Load(testTables(`SELECT name FROM kings`)), ShouldResemble, fmt.Errorf("no mapping for index kings"))
+
+		es.response = `{"kings": {}}`
+		So(c.Refresh("kings"), ShouldResemble, fmt.Errorf("error parsing mapping for index kings: missing mappings for index kings"))
+
+		es.response = `{"error": "IndexMissingException[[kings] missing]", "status": 400}`
+		es.status = 400
+		So(c.Refresh("kings"), ShouldNotBeNil)
+		So(c.Indices(), ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,375 @@
+package essyntax
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// dateFuncs lists the signatures of the date functions. NOW() is the time of
+// the search and CURRENT_DATE() the start of its day, DATE_TRUNC rounds a
+// date down to the start of a unit such as 'month', and DATE_ADD and DATE_SUB
+// add an interval to a date and subtract one from it, as do + and -.
+var dateFuncs = []sql.FuncSignature{
+	{Name: "NOW", Kind: sql.ScalarFunc, Returns: sql.DateType},
+	{Name: "CURRENT_DATE", Kind: sql.ScalarFunc, Returns: sql.DateType},
+	{Name: "DATE_TRUNC", Kind: sql.ScalarFunc, Returns: sql.DateType,
+		Args: []sql.ArgType{sql.StringType, sql.DateType}},
+	{Name: "DATE_ADD", Kind: sql.ScalarFunc, Returns: sql.DateType,
+		Args: []sql.ArgType{sql.DateType, sql.IntervalType}},
+	{Name: "DATE_SUB", Kind: sql.ScalarFunc, Returns: sql.DateType,
+		Args: []sql.ArgType{sql.DateType, sql.IntervalType}},
+}
+
+// dateUnit describes how a unit of DATE_TRUNC or INTERVAL is expressed in
+// elasticsearch date math and in painless scripts.
+type dateUnit struct {
+	round string // the date math rounding unit, empty if there is none
+	add   string // the date math unit of an interval
+	scale int64  // the number of add units in the unit
+	trunc string // painless truncating the date %s to the start of the unit
+	plus  string // the painless ZonedDateTime method suffix adding the unit
+}
+
+// dateUnits maps the units of DATE_TRUNC and INTERVAL to their dateUnit.
+// Date math cannot round to the start of a quarter, which is only computed by
+// scripts.
+var dateUnits = map[string]dateUnit{
+	"YEAR":    {"y", "y", 1, "%s.truncatedTo(ChronoUnit.DAYS).withDayOfYear(1)", "Years"},
+	"QUARTER": {"", "M", 3, "%s.truncatedTo(ChronoUnit.DAYS).with(IsoFields.DAY_OF_QUARTER, 1)", "Months"},
+	"MONTH":   {"M", "M", 1, "%s.truncatedTo(ChronoUnit.DAYS).withDayOfMonth(1)", "Months"},
+	"WEEK":    {"w", "w", 1, "%s.truncatedTo(ChronoUnit.DAYS).with(ChronoField.DAY_OF_WEEK, 1)", "Weeks"},
+	"DAY":     {"d", "d", 1, "%s.truncatedTo(ChronoUnit.DAYS)", "Days"},
+	"HOUR":    {"h", "h", 1, "%s.truncatedTo(ChronoUnit.HOURS)", "Hours"},
+	"MINUTE":  {"m", "m", 1, "%s.truncatedTo(ChronoUnit.MINUTES)", "Minutes"},
+	"SECOND":  {"s", "s", 1, "%s.truncatedTo(ChronoUnit.SECONDS)", "Seconds"},
+}
+
+// isDateExpr returns true for an expression computing a date: a + or - of an
+// interval, or a call to a function returning a date.
+func (fs *FunctionSet) isDateExpr(e sql.Expr) bool {
+
+	switch e := e.(type) {
+	case *sql.BinaryExpr:
+		_, lhs := e.LHS.(*sql.IntervalExpr)
+		_, rhs := e.RHS.(*sql.IntervalExpr)
+		return lhs || rhs
+	case *sql.FuncCallExpr:
+		sig, ok := fs.Registry.Lookup(e.Name)
+		return ok && sig.Returns == sql.DateType
+	}
+	return false
+}
+
+// dateMath is a date expression folded into elasticsearch date math: an
+// anchor, which is now or a date literal, followed by operations such as -30y,
+// which subtracts 30 years, or /d, which rounds down to the start of a day.
+type dateMath struct {
+	anchor string
+	ops    string
+}
+
+func (d dateMath) String() string {
+	if d.anchor == "now" || len(d.ops) == 0 {
+		return d.anchor + d.ops
+	}
+	return d.anchor + "||" + d.ops
+}
+
+// rounded returns true if the date math rounds to the start of a unit.
+func (d dateMath) rounded() bool {
+	return strings.Contains(d.ops, "/")
+}
+
+// foldDate returns the date math computing the specified date expression.
+func foldDate(e sql.Expr) (dateMath, error) {
+
+	switch e := e.(type) {
+	case *sql.StringExpr:
+		return dateMath{anchor: e.Unquoted()}, nil
+
+	case *sql.BinaryExpr:
+		date, interval := e.LHS, e.RHS
+		if _, ok := date.(*sql.IntervalExpr); ok {
+			date, interval = interval, date
+		}
+		return foldInterval(date, interval, e.Op == sql.MINUS)
+
+	case *sql.FuncCallExpr:
+		switch strings.ToUpper(e.Name) {
+		case "NOW":
+			return dateMath{anchor: "now"}, nil
+		case "CURRENT_DATE":
+			return dateMath{anchor: "now", ops: "/d"}, nil
+		case "DATE_ADD", "DATE_SUB":
+			return foldInterval(e.Args[0], e.Args[1], strings.ToUpper(e.Name) == "DATE_SUB")
+		case "DATE_TRUNC":
+			unit, err := truncUnit(e)
+			if err != nil {
+				return dateMath{}, err
+			}
+			if len(unit.round) == 0 {
+				break
+			}
+			d, err := foldDate(e.Args[1])
+			if err != nil {
+				return dateMath{}, err
+			}
+			d.ops += "/" + unit.round
+			return d, nil
+		}
+
+	case *sql.ParamExpr:
+		return dateMath{}, fmt.Errorf("parameter %s cannot be used in a date expression", e)
+	}
+
+	return dateMath{}, fmt.Errorf("cannot translate %s to date math", e)
+}
+
+// foldInterval returns the date math adding the specified interval to a
+// date, or subtracting it if subtract is set.
+func foldInterval(date, interval sql.Expr, subtract bool) (dateMath, error) {
+
+	d, err := foldDate(date)
+	if err != nil {
+		return dateMath{}, err
+	}
+	n, unit, err := intervalCount(interval)
+	if err != nil {
+		return dateMath{}, err
+	}
+
+	n *= unit.scale
+	if subtract {
+		n = -n
+	}
+	sign := "+"
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	d.ops += fmt.Sprintf("%s%d%s", sign, n, unit.add)
+	return d, nil
+}
+
+// intervalCount returns the count and unit of an interval, which must be a
+// whole number of units.
+func intervalCount(e sql.Expr) (int64, dateUnit, error) {
+
+	i, ok := e.(*sql.IntervalExpr)
+	if !ok {
+		return 0, dateUnit{}, fmt.Errorf("expected an interval, got %s", e)
+	}
+	if i.Val != math.Trunc(i.Val) {
+		return 0, dateUnit{}, fmt.Errorf("INTERVAL count must be an integer, got %s", i)
+	}
+	return int64(i.Val), dateUnits[i.Unit], nil
+}
+
+// truncUnit returns the unit named by the first argument of a DATE_TRUNC
+// call.
+func truncUnit(call *sql.FuncCallExpr) (dateUnit, error) {
+
+	str, ok := call.Args[0].(*sql.StringExpr)
+	if !ok {
+		return dateUnit{}, fmt.Errorf("DATE_TRUNC unit must be a string literal, got %s", call.Args[0])
+	}
+	unit, ok := dateUnits[strings.ToUpper(str.Unquoted())]
+	if !ok {
+		return dateUnit{}, fmt.Errorf("unknown DATE_TRUNC unit %s", str)
+	}
+	return unit, nil
+}
+
+// genDateClause returns the range query comparing a date field with a date
+// expression. A range query rounds the upper bound of lte and the lower bound
+// of gt up to the end of the unit, rather than down to its start as SQL does,
+// so a rounded date is only compared with gte and lt, against the date or
+// the following millisecond.
+func (t *translator) genDateClause(field string, op sql.Token, val sql.Expr) (string, error) {
+
+	d, err := foldDate(val)
+	if err != nil {
+		return "", err
+	}
+	date := d.String()
+	next := date
+	if d.rounded() {
+		next += "+1ms"
+	}
+
+	var bounds [][2]string
+	switch {
+	case d.rounded() && op == sql.GT:
+		bounds = [][2]string{{"gte", next}}
+	case d.rounded() && op == sql.LE:
+		bounds = [][2]string{{"lt", next}}
+	case op == sql.EQ || op == sql.NE:
+		bounds = [][2]string{{"gte", date}, {"lte", date}}
+		if d.rounded() {
+			bounds[1] = [2]string{"lt", next}
+		}
+	case genRangeOp(op) != "":
+		bounds = [][2]string{{genRangeOp(op), date}}
+	default:
+		return "", fmt.Errorf("unexpected comparison token generating date comparison: %v", op)
+	}
+
+	members := make([]string, len(bounds))
+	for i, b := range bounds {
+		v, err := json.Marshal(b[1])
+		if err != nil {
+			return "", err
+		}
+		members[i] = fmt.Sprintf(`"%s": %s`, b[0], v)
+	}
+	if len(t.opts.TimeZone) > 0 {
+		members = append(members, fmt.Sprintf(`"time_zone": "%s"`, t.opts.TimeZone))
+	}
+
+	clause := fmt.Sprintf(`{"range": {"%s": {%s}}}`, field, strings.Join(members, ", "))
+	if op == sql.NE {
+		return fmt.Sprintf(`{"bool": {"must_not": %s}}`, clause), nil
+	}
+	return clause, nil
+}
+
+// genScriptFields returns the script_fields computing the selected fields
+// which are not read from the documents, other than SCORE(), or an empty
+// string if there are none. Each is named by its alias or SQL text.
+func (t *translator) genScriptFields(fields sql.Fields) (string, error) {
+
+	var scripts []string
+	for _, f := range fields {
+		if f.Expr == nil || isScoreCall(f.Expr) {
+			continue
+		}
+		script, ok, err := t.genScalarScript(f.Expr)
+		if !ok {
+			script, err = t.genDateScript(f.Expr)
+		}
+		if err != nil {
+			return "", err
+		}
+		name, err := json.Marshal(scriptFieldName(f))
+		if err != nil {
+			return "", err
+		}
+		scripts = append(scripts, fmt.Sprintf(`%s: {"script": %s}`, name, script))
+	}
+	if len(scripts) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf(`{%s}`, strings.Join(scripts, ", ")), nil
+}
+
+// scriptFieldName returns the name of the script field computing a selected
+// field.
+func scriptFieldName(f sql.Field) string {
+	if len(f.Alias) > 0 {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// genDateScript returns the painless script computing a date expression from
+// the fields of each document, as an ISO 8601 string, or null if any of the
+// fields is missing. Dates are computed in the time zone of the translation.
+func (t *translator) genDateScript(e sql.Expr) (string, error) {
+
+	if !t.functions().isDateExpr(e) {
+		return "", fmt.Errorf("cannot compute %s in a script field", e)
+	}
+	// Dates are only java.time values in scripts from elasticsearch 7.0.
+	if old, err := t.versionBefore(7, 0); err != nil || old {
+		if err == nil {
+			err = fmt.Errorf("computing %s in a script field requires elasticsearch 7.0 or later", sql.FormatExpr(e))
+		}
+		return "", err
+	}
+
+	var fields []string
+	expr, err := t.painless(e, &fields)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for _, f := range fields {
+		fmt.Fprintf(&buf, "if (doc['%s'].size() == 0) { return null; } ", f)
+	}
+	fmt.Fprintf(&buf, "return %s.format(DateTimeFormatter.ISO_OFFSET_DATE_TIME);", expr)
+
+	source, err := json.Marshal(buf.String())
+	if err != nil {
+		return "", err
+	}
+	if len(t.opts.TimeZone) > 0 {
+		return fmt.Sprintf(`{"lang": "painless", "source": %s, "params": {"time_zone": "%s"}}`, source, t.opts.TimeZone), nil
+	}
+	return fmt.Sprintf(`{"lang": "painless", "source": %s}`, source), nil
+}
+
+// painless returns the painless expression computing a date expression,
+// adding the fields it reads to fields.
+func (t *translator) painless(e sql.Expr, fields *[]string) (string, error) {
+
+	switch e := e.(type) {
+	case *sql.IdentExpr:
+		if !containsString(*fields, e.Name) {
+			*fields = append(*fields, e.Name)
+		}
+		if len(t.opts.TimeZone) > 0 {
+			return fmt.Sprintf("doc['%s'].value.withZoneSameInstant(ZoneId.of(params.time_zone))", e.Name), nil
+		}
+		return fmt.Sprintf("doc['%s'].value", e.Name), nil
+
+	case *sql.BinaryExpr:
+		date, interval := e.LHS, e.RHS
+		if _, ok := date.(*sql.IntervalExpr); ok {
+			date, interval = interval, date
+		}
+		return t.painlessInterval(date, interval, e.Op == sql.MINUS, fields)
+
+	case *sql.FuncCallExpr:
+		switch strings.ToUpper(e.Name) {
+		case "DATE_ADD", "DATE_SUB":
+			return t.painlessInterval(e.Args[0], e.Args[1], strings.ToUpper(e.Name) == "DATE_SUB", fields)
+		case "DATE_TRUNC":
+			unit, err := truncUnit(e)
+			if err != nil {
+				return "", err
+			}
+			date, err := t.painless(e.Args[1], fields)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf(unit.trunc, date), nil
+		}
+	}
+
+	return "", fmt.Errorf("cannot compute %s in a script field", e)
+}
+
+// painlessInterval returns the painless expression adding an interval to a
+// date, or subtracting it if subtract is set.
+func (t *translator) painlessInterval(date, interval sql.Expr, subtract bool, fields *[]string) (string, error) {
+
+	d, err := t.painless(date, fields)
+	if err != nil {
+		return "", err
+	}
+	n, unit, err := intervalCount(interval)
+	if err != nil {
+		return "", err
+	}
+
+	method := "plus"
+	if subtract {
+		method = "minus"
+	}
+	return fmt.Sprintf("%s.%s%s(%d)", d, method, unit.plus, n*unit.scale), nil
+}
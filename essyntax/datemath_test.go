@@ -0,0 +1,144 @@
+package essyntax
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	"github.com/oldenbur/sql-parser/sql"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+// testQueryOptions returns the elasticsearch query for the specified SQL
+// text, translated with the specified options.
+func testQueryOptions(q string, opts TranslateOptions) (string, error) {
+
+	stmt, err := sql.NewParser(strings.NewReader(q)).Parse()
+	if err != nil {
+		return "", err
+	}
+	return ElasticSearchQueryOptions(stmt, opts)
+}
+
+func TestDateMath(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test folding date expressions into date math\n", t, func() {
+		es, err := testQuery(`SELECT name FROM oilers WHERE dob > NOW() - INTERVAL 30 YEAR`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"range": {"dob": {"gt": "now-30y"}}}}}, "_source": ["name"]}`)
+		log.Debug(es)
+
+		for _, c := range []struct{ cond, clause string }{
+			{`dob <= now() + interval 2 weeks`, `{"range": {"dob": {"lte": "now+2w"}}}`},
+			{`dob = DATE_SUB(NOW(), INTERVAL 90 MINUTE)`, `{"range": {"dob": {"gte": "now-90m", "lte": "now-90m"}}}`},
+			{`dob < DATE_ADD('1961-01-26', INTERVAL 1 QUARTER)`, `{"range": {"dob": {"lt": "1961-01-26||+3M"}}}`},
+			{`dob >= INTERVAL 1 DAY + '1961-01-26' - INTERVAL -12 HOUR`, `{"range": {"dob": {"gte": "1961-01-26||+1d+12h"}}}`},
+			{`dob != DATE_SUB(NOW(), INTERVAL 1 SECOND)`, `{"bool": {"must_not": {"range": {"dob": {"gte": "now-1s", "lte": "now-1s"}}}}}`},
+		} {
+			es, err := testQuery(`SELECT * FROM oilers WHERE ` + c.cond)
+			So(err, ShouldBeNil)
+			So(es, ShouldEqual, fmt.Sprintf(`{"query": {"constant_score": {"filter": %s}}}`, c.clause))
+		}
+	})
+
+	Convey("Test comparing with rounded dates\n", t, func() {
+		for _, c := range []struct{ cond, clause string }{
+			{`dob >= DATE_TRUNC('month', NOW())`, `{"range": {"dob": {"gte": "now/M"}}}`},
+			{`dob < CURRENT_DATE()`, `{"range": {"dob": {"lt": "now/d"}}}`},
+			{`dob > DATE_TRUNC('day', NOW() - INTERVAL 30 YEAR)`, `{"range": {"dob": {"gte": "now-30y/d+1ms"}}}`},
+			{`dob <= CURRENT_DATE() - INTERVAL 1 DAY`, `{"range": {"dob": {"lt": "now/d-1d+1ms"}}}`},
+			{`dob = DATE_TRUNC('YEAR', '1961-01-26')`, `{"range": {"dob": {"gte": "1961-01-26||/y", "lt": "1961-01-26||/y+1ms"}}}`},
+			{`dob != DATE_TRUNC('week', NOW())`, `{"bool": {"must_not": {"range": {"dob": {"gte": "now/w", "lt": "now/w+1ms"}}}}}`},
+			{`dob IN (CURRENT_DATE(), '1961-01-26')`, `{"bool": {"should": [` +
+				`{"range": {"dob": {"gte": "now/d", "lt": "now/d+1ms"}}}, {"range": {"dob": {"gte": "1961-01-26", "lte": "1961-01-26"}}}]}}`},
+			{`dob NOT IN (DATE_TRUNC('hour', NOW()))`, `{"bool": {"must_not": {"bool": {"should": [` +
+				`{"range": {"dob": {"gte": "now/h", "lt": "now/h+1ms"}}}]}}}}`},
+		} {
+			es, err := testQuery(`SELECT * FROM oilers WHERE ` + c.cond)
+			So(err, ShouldBeNil)
+			So(es, ShouldEqual, fmt.Sprintf(`{"query": {"constant_score": {"filter": %s}}}`, c.clause))
+		}
+	})
+
+	Convey("Test date math in a time zone\n", t, func() {
+		es, err := testQueryOptions(`SELECT COUNT(*) FROM oilers WHERE dob >= CURRENT_DATE() - INTERVAL 30 YEAR AND pos = 'C'`,
+			TranslateOptions{TimeZone: "Europe/Paris"})
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"bool": {"must": [`+
+			`{"range": {"dob": {"gte": "now/d-30y", "time_zone": "Europe/Paris"}}}, {"term": {"pos": "C"}}]}}}`)
+	})
+
+	Convey("Test computing dates in script fields\n", t, func() {
+		es, err := testQuery(`SELECT name, DATE_TRUNC('month', dob) m, DATE_ADD(DATE_TRUNC('quarter', dob), INTERVAL 1 YEAR) FROM oilers`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "_source": ["name"], "script_fields": {`+
+			`"m": {"script": {"lang": "painless", "source": "if (doc['dob'].size() == 0) { return null; } `+
+			`return doc['dob'].value.truncatedTo(ChronoUnit.DAYS).withDayOfMonth(1).format(DateTimeFormatter.ISO_OFFSET_DATE_TIME);"}}, `+
+			`"DATE_ADD(DATE_TRUNC('quarter', dob), INTERVAL 1 YEAR)": {"script": {"lang": "painless", "source": "if (doc['dob'].size() == 0) { return null; } `+
+			`return doc['dob'].value.truncatedTo(ChronoUnit.DAYS).with(IsoFields.DAY_OF_QUARTER, 1).plusYears(1).format(DateTimeFormatter.ISO_OFFSET_DATE_TIME);"}}}}`)
+
+		es, err = testQueryOptions(`SELECT DATE_TRUNC('hour', dob - INTERVAL 2 QUARTERS) h FROM oilers`, TranslateOptions{TimeZone: "+01:00"})
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "_source": false, "script_fields": {`+
+			`"h": {"script": {"lang": "painless", "source": "if (doc['dob'].size() == 0) { return null; } `+
+			`return doc['dob'].value.withZoneSameInstant(ZoneId.of(params.time_zone)).minusMonths(6).truncatedTo(ChronoUnit.HOURS)`+
+			`.format(DateTimeFormatter.ISO_OFFSET_DATE_TIME);", "params": {"time_zone": "+01:00"}}}}}`)
+
+		fake := newFakeES(`{"hits": {"total": 2, "hits": [
+			{"_source": {"name": "Wayne Gretzky"}, "fields": {"m": ["1961-01-01T00:00:00Z"]}},
+			{"_source": {"name": "Mark Messier"}}]}}`)
+		defer fake.Close()
+		rs, err := NewExecutor(fake.conn(), nil).Query(`SELECT name, DATE_TRUNC('month', dob) m FROM oilers`)
+		So(err, ShouldBeNil)
+		So(rs.Columns, ShouldResemble, []Column{
+			{Name: "name", Field: "name", Type: TypeString},
+			{Name: "m", Field: "m", Type: TypeString}})
+		So(rs.Rows, ShouldResemble, [][]interface{}{{"Wayne Gretzky", "1961-01-01T00:00:00Z"}, {"Mark Messier", nil}})
+	})
+
+	Convey("Test date expression errors\n", t, func() {
+		for _, c := range []struct{ q, err string }{
+			{`SELECT * FROM oilers WHERE dob > DATE_TRUNC('quarter', NOW())`, "cannot translate DATE_TRUNC('quarter', NOW()) to date math"},
+			{`SELECT * FROM oilers WHERE dob > DATE_TRUNC('fortnight', NOW())`, "unknown DATE_TRUNC unit 'fortnight'"},
+			{`SELECT * FROM oilers WHERE dob > NOW() - INTERVAL 1.5 DAY`, "INTERVAL count must be an integer, got INTERVAL 1.5 DAY"},
+			{`SELECT * FROM oilers WHERE dob > drafted + INTERVAL 1 DAY`, "cannot translate drafted to date math"},
+			{`SELECT * FROM oilers WHERE dob LIKE NOW()`, "LIKE cannot be applied to date expression NOW()"},
+			{`SELECT * FROM oilers WHERE dob > NOW() - 1`, "cannot apply - to NOW() and 1"},
+			{`SELECT * FROM oilers WHERE dob > DATE_ADD(NOW(), 1)`, "argument 2 of DATE_ADD must be an interval, got 1.000000"},
+			{`SELECT NOW() FROM oilers`, "cannot compute NOW() in a script field"},
+			{`SELECT DATE_TRUNC('month', dob) m FROM oilers ORDER BY m`, "cannot sort by computed field m"},
+		} {
+			_, err := testQuery(c.q)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, c.err)
+		}
+
+		_, err := Prepare(`SELECT * FROM oilers WHERE dob > DATE_ADD(?, INTERVAL 1 DAY)`)
+		So(err, ShouldResemble, fmt.Errorf("parameter ? cannot be used in a date expression"))
+
+		_, err = testQueryOptions(`SELECT DATE_TRUNC('month', dob) m FROM oilers`, TranslateOptions{Version: "6.8.0"})
+		So(err, ShouldResemble, fmt.Errorf("computing DATE_TRUNC('month', dob) in a script field requires elasticsearch 7.0 or later"))
+		_, err = testQueryOptions(`SELECT DATE_TRUNC('month', dob) m FROM oilers`, TranslateOptions{Version: "7.0.0"})
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Test validating date comparisons\n", t, func() {
+		So(testValidate(`SELECT name, DATE_TRUNC('month', dob) FROM oilers WHERE dob > NOW() - INTERVAL 30 YEAR`), ShouldBeNil)
+
+		So(validationMessages(testValidate(`SELECT name FROM oilers WHERE goals > 10 + 5`)), ShouldResemble, []string{
+			"1:42: cannot apply + to 10 and 5",
+		})
+
+		err := testValidate(`SELECT DATE_TRUNC('month', dobb) FROM oilers WHERE goals > NOW() - INTERVAL 1 DAY`)
+		So(validationMessages(err), ShouldResemble, []string{
+			"1:28: unknown column dobb (did you mean dob?)",
+			"1:52: date NOW() - INTERVAL 1 DAY compared with long field goals",
+		})
+	})
+}
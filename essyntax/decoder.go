@@ -0,0 +1,533 @@
+package essyntax
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	elastigo "github.com/mattbaird/elastigo/lib"
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// MultiValuePolicy selects how a Decoder represents a field holding several
+// values in one document, such as an array of team names.
+type MultiValuePolicy int
+
+const (
+	// MultiValueArray returns the values as a []interface{}.
+	MultiValueArray MultiValuePolicy = iota
+
+	// MultiValueJoin joins the values into a string, separated by the
+	// Separator of the DecoderOptions.
+	MultiValueJoin
+
+	// MultiValueExpand returns a row for each value, repeating the values of
+	// the other columns. A document with several multi-valued columns
+	// expands into the cross product of their values.
+	MultiValueExpand
+)
+
+// DecoderOptions control the decoding of documents into rows. The zero
+// value returns multiple values as arrays.
+type DecoderOptions struct {
+	MultiValue MultiValuePolicy
+
+	// Separator joins the values of a multi-valued field under
+	// MultiValueJoin, and defaults to ",".
+	Separator string
+}
+
+// Decoder converts elasticsearch search responses into the rows selected by
+// a statement. Rows are built from the _source and fields of each hit or,
+// for aggregate statements, from the nested aggregation buckets. Objects are
+// flattened, so that selecting an object field yields a column for each of
+// its leaf fields, named by its dotted path.
+//
+// The columns are resolved from the first response passed to Decode, and
+// the rows of each response are returned by Next. Later responses are
+// decoded into the same columns, since rows may already have been returned:
+// a field first found in a later response gets no column from * or an
+// object field, and a value of another type than its column, such as a
+// double in a long column, is returned as decoded. A ResultSet infers its
+// column types anew from all of its rows.
+type Decoder struct {
+	stmt     *sql.SelectStatement
+	opts     DecoderOptions
+	count    bool // whether the statement is answered by the _count API
+	limit    int  // maximum number of rows to return, or -1
+	cols     []Column
+	resolved bool
+	rows     [][]interface{}
+	n        int // number of rows returned by Next
+}
+
+// NewDecoder returns a Decoder for the rows selected by the specified
+// statement, which must not contain any unbound parameters.
+func NewDecoder(s *sql.SelectStatement, opts DecoderOptions) (*Decoder, error) {
+
+	s = unqualifyStatement(s)
+	d := &Decoder{stmt: s, opts: opts, limit: -1}
+	_, d.count = countField(s)
+	if len(d.opts.Separator) == 0 {
+		d.opts.Separator = ","
+	}
+
+	if s.Limit != nil {
+		n, ok := s.Limit.(*sql.NumExpr)
+		if !ok {
+			return nil, fmt.Errorf("statement has unbound LIMIT %s", s.Limit)
+		}
+		d.limit = int(n.Val)
+	}
+
+	return d, nil
+}
+
+// Columns returns the columns of the decoded rows, which are known once the
+// first response has been decoded.
+func (d *Decoder) Columns() []Column {
+	return d.cols
+}
+
+// Decode decodes the rows of a search response, to be returned by Next.
+func (d *Decoder) Decode(res *elastigo.SearchResult) error {
+
+	var rows [][]interface{}
+	var err error
+	if isAggregate(d.stmt) {
+		rows, err = d.decodeAggs(res)
+	} else {
+		rows, err = d.decodeHits(res.Hits.Hits)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !d.resolved {
+		inferTypes(d.cols, rows)
+		d.resolved = true
+	}
+	for _, row := range rows {
+		for i, col := range d.cols {
+			if n, ok := row[i].(int64); ok && col.Type == TypeDouble {
+				row[i] = float64(n)
+			}
+		}
+	}
+
+	d.rows = append(d.rows, rows...)
+	return nil
+}
+
+// Next returns the next decoded row, or io.EOF once every decoded row, or
+// the number of rows limited by the statement, has been returned.
+func (d *Decoder) Next() ([]interface{}, error) {
+
+	if len(d.rows) == 0 || d.limitReached() {
+		return nil, io.EOF
+	}
+
+	row := d.rows[0]
+	d.rows = d.rows[1:]
+	d.n += 1
+	return row, nil
+}
+
+// limitReached returns true if Next has returned the number of rows limited
+// by the statement.
+func (d *Decoder) limitReached() bool {
+	return d.limit >= 0 && d.n >= d.limit
+}
+
+// decodeHits returns the rows for the specified hits.
+func (d *Decoder) decodeHits(hits []elastigo.Hit) ([][]interface{}, error) {
+
+	docs := make([]map[string][]interface{}, len(hits))
+	for i, hit := range hits {
+		var err error
+		if docs[i], err = flattenHit(hit); err != nil {
+			return nil, err
+		}
+	}
+
+	if !d.resolved {
+		d.cols = hitColumns(d.stmt.FieldList, docs)
+	}
+	if selectsScore(d.stmt.FieldList) {
+		for i, hit := range hits {
+			docs[i][scoreField] = []interface{}{hitScore(hit)}
+		}
+	}
+	if selectsIndex(d.stmt.FieldList) {
+		for i, hit := range hits {
+			docs[i][indexField] = []interface{}{hit.Index}
+		}
+	}
+
+	var rows [][]interface{}
+	for _, doc := range docs {
+		rows = append(rows, d.hitRows(doc)...)
+	}
+	return rows, nil
+}
+
+// hitRows returns the row for a flattened document, or a row for each
+// combination of values of its multi-valued columns under MultiValueExpand.
+func (d *Decoder) hitRows(doc map[string][]interface{}) [][]interface{} {
+
+	row := make([]interface{}, len(d.cols))
+	var expand []int
+	for i, col := range d.cols {
+		vals := doc[col.Field]
+		switch {
+		case len(vals) == 0:
+		case len(vals) == 1:
+			row[i] = vals[0]
+		case d.opts.MultiValue == MultiValueJoin:
+			strs := make([]string, len(vals))
+			for j, v := range vals {
+				strs[j] = fmt.Sprint(v)
+			}
+			row[i] = strings.Join(strs, d.opts.Separator)
+		case d.opts.MultiValue == MultiValueExpand:
+			row[i] = vals
+			expand = append(expand, i)
+		default:
+			row[i] = vals
+		}
+	}
+
+	rows := [][]interface{}{row}
+	for _, i := range expand {
+		var expanded [][]interface{}
+		for _, r := range rows {
+			for _, v := range r[i].([]interface{}) {
+				e := make([]interface{}, len(r))
+				copy(e, r)
+				e[i] = v
+				expanded = append(expanded, e)
+			}
+		}
+		rows = expanded
+	}
+	return rows
+}
+
+// hitColumns returns the columns for the specified select list. A * field
+// expands to every leaf field of the documents, and an object field to each
+// of its leaf fields, in path order.
+func hitColumns(fields sql.Fields, docs []map[string][]interface{}) []Column {
+
+	var cols []Column
+	for _, f := range fields {
+
+		if f.Name == "*" {
+			for _, path := range leafPaths(docs, "") {
+				cols = append(cols, Column{Name: path, Field: path})
+			}
+			continue
+		}
+
+		name := f.Name
+		if len(f.Alias) > 0 {
+			name = f.Alias
+		}
+
+		if isScoreCall(f.Expr) {
+			cols = append(cols, Column{Name: name, Field: scoreField, Type: TypeDouble})
+			continue
+		}
+		if f.Expr != nil {
+			cols = append(cols, Column{Name: name, Field: scriptFieldName(f)})
+			continue
+		}
+		if f.Name == indexField {
+			cols = append(cols, Column{Name: name, Field: indexField, Type: TypeString})
+			continue
+		}
+
+		paths := leafPaths(docs, f.Name+".")
+		if len(paths) == 0 || isLeaf(docs, f.Name) {
+			cols = append(cols, Column{Name: name, Field: f.Name})
+			continue
+		}
+		for _, path := range paths {
+			cols = append(cols, Column{Name: name + path[len(f.Name):], Field: path})
+		}
+	}
+	return cols
+}
+
+// leafPaths returns the sorted paths of the leaf fields with the specified
+// prefix found in any of the flattened documents.
+func leafPaths(docs []map[string][]interface{}, prefix string) []string {
+
+	seen := make(map[string]bool)
+	var paths []string
+	for _, doc := range docs {
+		for path := range doc {
+			if strings.HasPrefix(path, prefix) && !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// isLeaf returns true if any of the flattened documents has a leaf field
+// with the specified path.
+func isLeaf(docs []map[string][]interface{}, path string) bool {
+	for _, doc := range docs {
+		if _, ok := doc[path]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hitScore returns the relevance score of a hit, which is decoded as a
+// float32 and so is converted through its shortest decimal representation.
+func hitScore(hit elastigo.Hit) float64 {
+	f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(hit.Score), 'g', -1, 32), 64)
+	return f
+}
+
+// flattenHit returns the values of each leaf field of a hit, keyed by its
+// dotted path, taken from its _source and then from its fields.
+func flattenHit(hit elastigo.Hit) (map[string][]interface{}, error) {
+
+	flat := make(map[string][]interface{})
+
+	if hit.Source != nil {
+		var source interface{}
+		if err := decodeJSON(*hit.Source, &source); err != nil {
+			return nil, err
+		}
+		flatten("", source, flat)
+	}
+
+	if hit.Fields != nil {
+		var fields map[string]interface{}
+		if err := decodeJSON(*hit.Fields, &fields); err != nil {
+			return nil, err
+		}
+		for path, v := range fields {
+			if _, ok := flat[path]; !ok {
+				flatten(path, v, flat)
+			}
+		}
+	}
+
+	return flat, nil
+}
+
+// flatten adds the leaf values of v, found at the specified path, to flat.
+// Array elements are values of the same field, and null values are omitted
+// although their field is recorded.
+func flatten(path string, v interface{}, flat map[string][]interface{}) {
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if len(path) > 0 {
+				k = path + "." + k
+			}
+			flatten(k, e, flat)
+		}
+	case []interface{}:
+		flat[path] = flat[path]
+		for _, e := range v {
+			flatten(path, e, flat)
+		}
+	case nil:
+		flat[path] = flat[path]
+	default:
+		flat[path] = append(flat[path], typedValue(v))
+	}
+}
+
+// decodeAggs returns the rows for an aggregate statement: one for each
+// innermost GROUP BY bucket, or a single row if there is no GROUP BY.
+func (d *Decoder) decodeAggs(res *elastigo.SearchResult) ([][]interface{}, error) {
+
+	groups, err := groupKeys(d.stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	if !d.resolved {
+		d.cols = make([]Column, len(d.stmt.FieldList))
+		for i, f := range d.stmt.FieldList {
+			d.cols[i] = Column{Name: f.Name, Field: f.Name}
+			if len(f.Alias) > 0 {
+				d.cols[i].Name = f.Alias
+			}
+		}
+	}
+
+	aggs := make(map[string]interface{})
+	if len(res.Aggregations) > 0 {
+		if err := decodeJSON(res.Aggregations, &aggs); err != nil {
+			return nil, err
+		}
+	}
+	aggs["doc_count"] = int64(res.Hits.Total)
+
+	var rows [][]interface{}
+	var walk func(level int, bucket map[string]interface{}, keys []interface{})
+	walk = func(level int, bucket map[string]interface{}, keys []interface{}) {
+
+		if level == len(groups) {
+			rows = append(rows, d.aggRow(groups, bucket, keys))
+			return
+		}
+
+		agg, _ := bucket[groupAggName(level)].(map[string]interface{})
+		buckets, _ := agg["buckets"].([]interface{})
+		for _, b := range buckets {
+			if b, ok := b.(map[string]interface{}); ok {
+				key := typedValue(b["key"])
+				if groups[level].isDate() {
+					key = bucketTime(key)
+				}
+				walk(level+1, b, append(keys[:level:level], key))
+			}
+		}
+	}
+	walk(0, aggs, nil)
+
+	return rows, nil
+}
+
+// aggRow returns the row for an innermost bucket reached through buckets
+// with the specified keys.
+func (d *Decoder) aggRow(groups []groupKey, bucket map[string]interface{}, keys []interface{}) []interface{} {
+
+	row := make([]interface{}, len(d.stmt.FieldList))
+	for i, f := range d.stmt.FieldList {
+		call := aggregateCall(f)
+		switch {
+		case call == nil:
+			for j, g := range groups {
+				if g.name == f.Name {
+					row[i] = keys[j]
+				}
+			}
+		case isCountAll(call), d.count:
+			row[i] = typedValue(bucket["doc_count"])
+		default:
+			if metric, ok := bucket[metricAggName(i)].(map[string]interface{}); ok {
+				row[i] = typedValue(metric["value"])
+			}
+		}
+	}
+	return row
+}
+
+// bucketTime converts the key of a date_histogram bucket, the start of the
+// bucket in milliseconds since the epoch, to a UTC time.
+func bucketTime(key interface{}) interface{} {
+
+	switch ms := key.(type) {
+	case int64:
+		return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+	case float64:
+		return time.Unix(0, int64(ms*float64(time.Millisecond))).UTC()
+	}
+	return key
+}
+
+// decodeJSON unmarshals b into v, keeping numbers as json.Number so that
+// integers and floating point values can be told apart.
+func decodeJSON(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// typedValue converts a decoded JSON number to int64 if it is an integer and
+// to float64 otherwise.
+func typedValue(v interface{}) interface{} {
+
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+// valueType returns the column type of a value returned by typedValue.
+func valueType(v interface{}) ColumnType {
+
+	switch v.(type) {
+	case string:
+		return TypeString
+	case int64:
+		return TypeLong
+	case float64:
+		return TypeDouble
+	case bool:
+		return TypeBoolean
+	case time.Time:
+		return TypeDate
+	case []interface{}:
+		return TypeArray
+	}
+	return TypeUnknown
+}
+
+// retypeRows infers the type of each column anew from all of the specified
+// rows, decoded from several responses, and converts the long values of
+// double columns to doubles.
+func retypeRows(cols []Column, rows [][]interface{}) {
+
+	for i := range cols {
+		cols[i].Type = TypeUnknown
+	}
+	inferTypes(cols, rows)
+	for _, row := range rows {
+		for i, col := range cols {
+			if n, ok := row[i].(int64); ok && col.Type == TypeDouble {
+				row[i] = float64(n)
+			}
+		}
+	}
+}
+
+// inferTypes sets the type of each column from the values it holds in the
+// specified rows. A column holding both long and double values is a double
+// column. A column holding values of other differing types, or only nil
+// values, has an unknown type.
+func inferTypes(cols []Column, rows [][]interface{}) {
+
+	for i := range cols {
+
+		types := make(map[ColumnType]bool)
+		for _, row := range rows {
+			if row[i] != nil {
+				types[valueType(row[i])] = true
+			}
+		}
+
+		if len(types) == 2 && types[TypeLong] && types[TypeDouble] {
+			delete(types, TypeLong)
+		}
+
+		if len(types) == 1 {
+			for t := range types {
+				cols[i].Type = t
+			}
+		}
+	}
+}
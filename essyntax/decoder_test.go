@@ -0,0 +1,173 @@
+package essyntax
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/cihub/seelog"
+	elastigo "github.com/mattbaird/elastigo/lib"
+	"github.com/oldenbur/sql-parser/sql"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+// testDecode decodes the specified search responses for a query and returns
+// the columns and every row.
+func testDecode(q string, opts DecoderOptions, responses ...string) ([]Column, [][]interface{}, error) {
+
+	stmt, err := sql.NewParser(strings.NewReader(q)).Parse()
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := NewDecoder(stmt, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, r := range responses {
+		var res elastigo.SearchResult
+		if err := json.Unmarshal([]byte(r), &res); err != nil {
+			return nil, nil, err
+		}
+		if err := d.Decode(&res); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var rows [][]interface{}
+	for {
+		row, err := d.Next()
+		if err == io.EOF {
+			return d.Columns(), rows, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+const playerHits = `{"hits": {"total": 2, "hits": [
+	{"_source": {"name": "Wayne Gretzky", "teams": ["EDM", "LAK", "STL", "NYR"], "stats": {"gp": 1487, "career": {"pts": 2857}}}},
+	{"_source": {"name": "Jari Kurri", "teams": ["EDM", "LAK"], "stats": {"gp": 1251, "ppg": 1.07}, "retired": null},
+	 "fields": {"name": ["ignored"], "hof_year": [2001]}}]}}`
+
+func TestDecoder(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test flattening objects into dotted columns\n", t, func() {
+		cols, rows, err := testDecode(`SELECT name player, stats s FROM nhl`, DecoderOptions{}, playerHits)
+		So(err, ShouldBeNil)
+		So(cols, ShouldResemble, []Column{
+			{Name: "player", Field: "name", Type: TypeString},
+			{Name: "s.career.pts", Field: "stats.career.pts", Type: TypeLong},
+			{Name: "s.gp", Field: "stats.gp", Type: TypeLong},
+			{Name: "s.ppg", Field: "stats.ppg", Type: TypeDouble}})
+		So(rows, ShouldResemble, [][]interface{}{
+			{"Wayne Gretzky", int64(2857), int64(1487), nil},
+			{"Jari Kurri", nil, int64(1251), 1.07}})
+
+		cols, rows, err = testDecode(`SELECT * FROM nhl`, DecoderOptions{}, playerHits)
+		So(err, ShouldBeNil)
+		names := make([]string, len(cols))
+		for i, c := range cols {
+			names[i] = c.Name
+		}
+		So(names, ShouldResemble, []string{"hof_year", "name", "retired", "stats.career.pts", "stats.gp", "stats.ppg", "teams"})
+		So(rows[1], ShouldResemble, []interface{}{
+			int64(2001), "Jari Kurri", nil, nil, int64(1251), 1.07, []interface{}{"EDM", "LAK"}})
+	})
+
+	Convey("Test multi-valued field policies\n", t, func() {
+		cols, rows, err := testDecode(`SELECT name, teams FROM nhl`, DecoderOptions{}, playerHits)
+		So(err, ShouldBeNil)
+		So(cols[1].Type, ShouldEqual, TypeArray)
+		So(rows[0][1], ShouldResemble, []interface{}{"EDM", "LAK", "STL", "NYR"})
+
+		cols, rows, err = testDecode(`SELECT name, teams FROM nhl`, DecoderOptions{MultiValue: MultiValueJoin, Separator: "|"}, playerHits)
+		So(err, ShouldBeNil)
+		So(cols[1].Type, ShouldEqual, TypeString)
+		So(rows, ShouldResemble, [][]interface{}{{"Wayne Gretzky", "EDM|LAK|STL|NYR"}, {"Jari Kurri", "EDM|LAK"}})
+
+		_, rows, err = testDecode(`SELECT name, teams FROM nhl`, DecoderOptions{MultiValue: MultiValueJoin}, playerHits)
+		So(err, ShouldBeNil)
+		So(rows[1][1], ShouldEqual, "EDM,LAK")
+
+		cols, rows, err = testDecode(`SELECT name, teams FROM nhl`, DecoderOptions{MultiValue: MultiValueExpand}, playerHits)
+		So(err, ShouldBeNil)
+		So(cols[1].Type, ShouldEqual, TypeString)
+		So(rows, ShouldResemble, [][]interface{}{
+			{"Wayne Gretzky", "EDM"}, {"Wayne Gretzky", "LAK"}, {"Wayne Gretzky", "STL"}, {"Wayne Gretzky", "NYR"},
+			{"Jari Kurri", "EDM"}, {"Jari Kurri", "LAK"}})
+
+		_, rows, err = testDecode(`SELECT teams a, teams b FROM nhl LIMIT 5`, DecoderOptions{MultiValue: MultiValueExpand},
+			`{"hits": {"hits": [{"_source": {"teams": ["EDM", "LAK"]}}]}}`)
+		So(err, ShouldBeNil)
+		So(rows, ShouldResemble, [][]interface{}{{"EDM", "EDM"}, {"EDM", "LAK"}, {"LAK", "EDM"}, {"LAK", "LAK"}})
+	})
+
+	Convey("Test decoding several responses\n", t, func() {
+		cols, rows, err := testDecode(`SELECT name, goals FROM nhl LIMIT 3`, DecoderOptions{},
+			`{"hits": {"hits": [{"_source": {"name": "a", "goals": 1.5}}, {"_source": {"name": "b", "goals": 2}}]}}`,
+			`{"hits": {"hits": [{"_source": {"name": "c", "goals": 3}}, {"_source": {"name": "d", "goals": 4}}]}}`)
+		So(err, ShouldBeNil)
+		So(cols[1].Type, ShouldEqual, TypeDouble)
+		So(rows, ShouldResemble, [][]interface{}{{"a", 1.5}, {"b", float64(2)}, {"c", float64(3)}})
+	})
+
+	Convey("Test decoding aggregation buckets\n", t, func() {
+		cols, rows, err := testDecode(`SELECT team, pos p, COUNT(*) n, MAX(goals) most FROM nhl GROUP BY team, pos`, DecoderOptions{},
+			`{"hits": {"total": 9}, "aggregations": {"group_0": {"buckets": [
+				{"key": "EDM", "doc_count": 5, "group_1": {"buckets": [
+					{"key": "C", "doc_count": 3, "field_3": {"value": 92.0}},
+					{"key": "RW", "doc_count": 2, "field_3": {"value": 71.0}}]}},
+				{"key": "LAK", "doc_count": 4, "group_1": {"buckets": [
+					{"key": "C", "doc_count": 4, "field_3": {"value": null}}]}}]}}}`)
+		So(err, ShouldBeNil)
+		So(cols, ShouldResemble, []Column{
+			{Name: "team", Field: "team", Type: TypeString},
+			{Name: "p", Field: "pos", Type: TypeString},
+			{Name: "n", Field: "COUNT(*)", Type: TypeLong},
+			{Name: "most", Field: "MAX(goals)", Type: TypeDouble}})
+		So(rows, ShouldResemble, [][]interface{}{
+			{"EDM", "C", int64(3), float64(92)},
+			{"EDM", "RW", int64(2), float64(71)},
+			{"LAK", "C", int64(4), nil}})
+
+		cols, rows, err = testDecode(`SELECT COUNT(*), COUNT(goals), AVG(goals) FROM nhl`, DecoderOptions{},
+			`{"hits": {"total": 9}, "aggregations": {"field_1": {"value": 7}, "field_2": {"value": 40.5}}}`)
+		So(err, ShouldBeNil)
+		So(cols[0], ShouldResemble, Column{Name: "COUNT(*)", Field: "COUNT(*)", Type: TypeLong})
+		So(rows, ShouldResemble, [][]interface{}{{int64(9), int64(7), 40.5}})
+	})
+
+	Convey("Test decoding histogram buckets\n", t, func() {
+		cols, rows, err := testDecode(`SELECT HISTOGRAM(dob, INTERVAL 1 YEAR) yr, HISTOGRAM(goals, 10), COUNT(*) FROM nhl GROUP BY 1, 2`, DecoderOptions{},
+			`{"hits": {"total": 3}, "aggregations": {"group_0": {"buckets": [
+				{"key_as_string": "1961-01-01T00:00:00.000Z", "key": -283996800000, "doc_count": 2, "group_1": {"buckets": [
+					{"key": 40.0, "doc_count": 1}, {"key": 90.0, "doc_count": 1}]}},
+				{"key_as_string": "1970-01-01T00:00:00.000Z", "key": 0, "doc_count": 1, "group_1": {"buckets": [
+					{"key": 2.5, "doc_count": 1}]}}]}}}`)
+		So(err, ShouldBeNil)
+		So(cols, ShouldResemble, []Column{
+			{Name: "yr", Field: "HISTOGRAM(dob, INTERVAL 1 YEAR)", Type: TypeDate},
+			{Name: "HISTOGRAM(goals, 10)", Field: "HISTOGRAM(goals, 10)", Type: TypeDouble},
+			{Name: "COUNT(*)", Field: "COUNT(*)", Type: TypeLong}})
+		y1961, y1970 := time.Date(1961, 1, 1, 0, 0, 0, 0, time.UTC), time.Unix(0, 0).UTC()
+		So(rows, ShouldResemble, [][]interface{}{
+			{y1961, float64(40), int64(1)},
+			{y1961, float64(90), int64(1)},
+			{y1970, 2.5, int64(1)}})
+	})
+
+	Convey("Test decoder errors\n", t, func() {
+		_, _, err := testDecode(`SELECT a FROM nhl LIMIT ?`, DecoderOptions{})
+		So(err, ShouldResemble, fmt.Errorf("statement has unbound LIMIT ?"))
+	})
+}
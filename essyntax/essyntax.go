@@ -1,33 +1,336 @@
 package essyntax
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"math"
+	"strings"
 
 	"github.com/oldenbur/sql-parser/sql"
 )
 
+// ElasticSearchQuery returns the elasticsearch query DSL for the specified
+// statement, which must not contain any unbound parameters.
 func ElasticSearchQuery(s *sql.SelectStatement) (string, error) {
 
+	if params := sql.Params(s); len(params) > 0 {
+		return "", fmt.Errorf("statement has unbound parameter %s", params[0])
+	}
+
+	return new(translator).genQuery(s)
+}
+
+// ElasticSearchQueryOptions returns the elasticsearch query DSL for the
+// specified statement, translated with the specified options.
+func ElasticSearchQueryOptions(s *sql.SelectStatement, opts TranslateOptions) (string, error) {
+
+	if params := sql.Params(s); len(params) > 0 {
+		return "", fmt.Errorf("statement has unbound parameter %s", params[0])
+	}
+
+	return (&translator{opts: opts}).genQuery(s)
+}
+
+// TextEquality selects how equality on an analyzed text field without a
+// keyword subfield is translated.
+type TextEquality int
+
+const (
+	// TextMatchPhrase translates equality into a match_phrase query.
+	TextMatchPhrase TextEquality = iota
+
+	// TextError rejects equality on such fields.
+	TextError
+)
+
+// TranslateOptions control the translation of statements. The zero value
+// translates every equality into a term query.
+type TranslateOptions struct {
+	// Catalog, if not nil, supplies the mappings of the indices named by
+	// statements, loading any it lacks. Equality on a text field then targets
+	// its keyword subfield, or is handled according to TextEquality if it
+	// has none.
+	Catalog *Catalog
+
+	TextEquality TextEquality
+
+	// Functions, if not nil, holds the functions which statements may call,
+	// and otherwise defaults to DefaultFunctions.
+	Functions *FunctionSet
+
+	// TimeZone, if not empty, is the time zone, e.g. "+01:00" or
+	// "Europe/Paris", in which date expressions are computed and rounded and
+	// date literals without an offset are read. Otherwise UTC is used.
+	TimeZone string
+
+	// Version, if not empty, is the version of the elasticsearch cluster
+	// statements are translated for, e.g. "6.8.0", and otherwise the latest
+	// is assumed. Date histograms for clusters older than 7.2 bucket by the
+	// legacy interval setting, and dates cannot be computed in script fields
+	// for clusters older than 7.0. It must be set for older clusters, since
+	// statements are translated before an Executor reaches the cluster.
+	Version string
+}
+
+// translator generates elasticsearch query DSL from a parsed statement. In
+// template mode, parameters are rendered as placeholders and recorded in
+// slots so that the result can be reused for different argument values.
+type translator struct {
+	template bool
+	slots    []paramSlot
+	opts     TranslateOptions
+	indices  []string // the indices named by the statement, for opts.Catalog
+	scope    nestedScope
+}
+
+// versionBefore returns true if the elasticsearch version statements are
+// translated for is older than major.minor.
+func (t *translator) versionBefore(major, minor int) (bool, error) {
+
+	if len(t.opts.Version) == 0 {
+		return false, nil
+	}
+	m, n, err := parseVersion(t.opts.Version)
+	if err != nil {
+		return false, err
+	}
+	return m < major || m == major && n < minor, nil
+}
+
+// paramSlot records a parameter placeholder in a query template along with
+// the function that renders its bound value in place of the placeholder.
+type paramSlot struct {
+	param  *sql.ParamExpr
+	render func(sql.Expr) (string, error)
+}
+
+// slotMarker returns the placeholder for slot i in a query template. The NUL
+// delimiters cannot collide with generated JSON, which escapes them.
+func slotMarker(i int) string {
+	return fmt.Sprintf("\x00%d\x00", i)
+}
+
+// genQuery returns the elasticsearch search request body for the specified
+// statement: the query along with the projected source fields, sort order
+// and number of hits, or the aggregations computed by an aggregate statement.
+// A statement selecting only a COUNT yields a _count request body instead.
+func (t *translator) genQuery(s *sql.SelectStatement) (string, error) {
+
+	if err := stagedError(s); err != nil {
+		return "", err
+	}
+	if len(s.Unions) > 0 {
+		return t.genMsearch(s)
+	}
+
+	if errs := t.functions().Registry.Check(s); len(errs) > 0 {
+		return "", errs[0]
+	}
+
+	if t.opts.Catalog != nil {
+		if err := t.opts.Catalog.Load(s.TableList); err != nil {
+			return "", err
+		}
+		for _, table := range s.TableList {
+			t.indices = append(t.indices, table.Name)
+		}
+	}
+	s = unqualifyStatement(s)
+	t.scope = newNestedScope(s.TableList)
+	where := s.WhereCond
+
+	if field, ok := countField(s); ok {
+		query := `{"match_all": {}}`
+		if where != nil {
+			var err error
+			if query, err = t.genCondClause(where); err != nil {
+				return "", err
+			}
+		}
+		return t.genCountQuery(s, field, query), nil
+	}
+
+	query, err := t.genWhereQuery(where)
+	if err != nil {
+		return "", err
+	}
+	if isAggregate(s) {
+		return t.genAggQuery(s, query)
+	}
+
+	body := []string{fmt.Sprintf(`"query": %s`, query)}
+
+	if source := genSource(s.FieldList); len(source) > 0 {
+		body = append(body, fmt.Sprintf(`"_source": %s`, source))
+	}
+
+	scripts, err := t.genScriptFields(s.FieldList)
+	if err != nil {
+		return "", err
+	}
+	if len(scripts) > 0 {
+		body = append(body, fmt.Sprintf(`"script_fields": %s`, scripts))
+	}
+
+	if len(s.OrderBy) > 0 {
+		sort, err := t.genSort(s)
+		if err != nil {
+			return "", err
+		}
+		body = append(body, fmt.Sprintf(`"sort": %s`, sort))
+
+		// Hits sorted by anything but relevance are only scored on request.
+		if selectsScore(s.FieldList) {
+			body = append(body, `"track_scores": true`)
+		}
+	}
+
+	if s.Limit != nil {
+		size, err := t.genValue(s.Limit, genLimitValue)
+		if err != nil {
+			return "", err
+		}
+		body = append(body, fmt.Sprintf(`"size": %s`, size))
+	}
+
+	return fmt.Sprintf(`{%s}`, strings.Join(body, ", ")), nil
+}
+
+// genSource returns the _source filter listing the selected fields, or an
+// empty string if every field is selected. Fields computed by a function,
+// such as SCORE(), are not source fields, nor is the _index of each hit, so
+// that selecting nothing else disables the _source.
+func genSource(fields sql.Fields) string {
+
+	var names []string
+	for _, f := range fields {
+		if f.Name == "*" {
+			return ""
+		}
+		if f.Expr != nil || f.Name == indexField {
+			continue
+		}
+		names = append(names, fmt.Sprintf(`"%s"`, f.Name))
+	}
+	if len(names) == 0 {
+		return "false"
+	}
+	return fmt.Sprintf(`[%s]`, strings.Join(names, ", "))
+}
+
+// genSort returns the sort clause for the ORDER BY items of the specified
+// statement, resolving any that name a field alias to the aliased field.
+func (t *translator) genSort(s *sql.SelectStatement) (string, error) {
+
+	sorts := make([]string, len(s.OrderBy))
+	for i, o := range s.OrderBy {
+		order := "asc"
+		if o.Desc {
+			order = "desc"
+		}
+		if isDistanceCall(o.Expr) {
+			var err error
+			if sorts[i], err = t.genDistanceSort(o.Expr.(*sql.FuncCallExpr), order); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		// A field computed by a script is sorted by the same script.
+		expr := o.Expr
+		if ident, ok := o.Expr.(*sql.IdentExpr); ok && fieldExpr(s.FieldList, ident.Name) != nil {
+			expr = fieldExpr(s.FieldList, ident.Name)
+		}
+		if sort, ok, err := t.genScriptSort(expr, order); ok {
+			if err != nil {
+				return "", err
+			}
+			sorts[i] = sort
+			continue
+		}
+
+		var field string
+		if ident, ok := o.Expr.(*sql.IdentExpr); ok {
+			field = fieldName(s.FieldList, ident.Name)
+			if e := fieldExpr(s.FieldList, ident.Name); isScoreCall(e) {
+				field = scoreField
+			} else if e != nil {
+				return "", fmt.Errorf("cannot sort by computed field %s", ident.Name)
+			}
+		} else if isScoreCall(o.Expr) {
+			field = scoreField
+		} else {
+			return "", fmt.Errorf("unsupported ORDER BY expression %s", o.Expr)
+		}
+		sorts[i] = fmt.Sprintf(`{"%s": {"order": "%s"}}`, field, order)
+	}
+	return fmt.Sprintf(`[%s]`, strings.Join(sorts, ", ")), nil
+}
+
+// fieldName returns the name of the selected field with the specified alias,
+// or the specified name itself if no field has that alias.
+func fieldName(fields sql.Fields, name string) string {
+	for _, f := range fields {
+		if f.Alias == name {
+			return f.Name
+		}
+	}
+	return name
+}
 
+// fieldExpr returns the expression computing the selected field with the
+// specified alias, or nil if there is none.
+func fieldExpr(fields sql.Fields, alias string) sql.Expr {
+	for _, f := range fields {
+		if f.Alias == alias {
+			return f.Expr
+		}
+	}
+	return nil
+}
 
-	return "", nil
+// genLimitValue returns the number of hits requested by a LIMIT value.
+func genLimitValue(val sql.Expr) (string, error) {
+
+	n, ok := val.(*sql.NumExpr)
+	if !ok || n.Val < 0 || n.Val != math.Trunc(n.Val) {
+		return "", fmt.Errorf("LIMIT must be a non-negative integer, got %s", val)
+	}
+	return fmt.Sprintf("%d", int64(n.Val)), nil
 }
 
 // genCondClause returns an elasticsearch query clause generated from the specified clause,
 // which can either be a conjuction or a comparison
-func genCondClause(where sql.Cond) (string, error) {
+func (t *translator) genCondClause(where sql.Cond) (string, error) {
+
+	if path := t.nestedPath(where); len(path) > 0 {
+		return t.genNestedClause(path, where, t.genCondClause)
+	}
 
 	switch where := where.(type) {
 	case *sql.CondComp:
-		return genCompClause(where)
+		return t.genCompClause(where)
+
+	case *sql.CondIn:
+		return t.genInClause(where)
+
+	case *sql.CondFunc:
+		if where.CondOp != sql.ILLEGAL {
+			return t.genDistanceClause(where)
+		}
+		return t.genFuncClause(where.Call)
+
+	case *sql.CondExists:
+		return t.genExistsClause(where)
 
 	case *sql.CondConj:
 		if where.Left != nil && where.Right != nil {
-			return genConjClause(where)
+			return t.genConjClause(where)
 		} else if where.Left != nil {
-			return genCondClause(where.Left)
+			return t.genCondClause(where.Left)
 		} else if where.Right != nil {
-			return genCondClause(where.Right)
+			return t.genCondClause(where.Right)
 		} else {
 			return "", fmt.Errorf("unexpected emtpy logical conjunction")
 		}
@@ -40,14 +343,20 @@ func genCondClause(where sql.Cond) (string, error) {
 
 // genConjClause returns an elasticsearch bool should or must clause generated from the
 // specified conjunction clause
-func genConjClause(conj *sql.CondConj) (string, error) {
+func (t *translator) genConjClause(conj *sql.CondConj) (string, error) {
+
+	if conj.Op == sql.AND {
+		if clause, ok, err := t.genNestedConj(conj); ok {
+			return clause, err
+		}
+	}
 
-	leftClause, err := genCondClause(conj.Left)
+	leftClause, err := t.genCondClause(conj.Left)
 	if err != nil {
 		return "", err
 	}
 
-	rightClause, err := genCondClause(conj.Right)
+	rightClause, err := t.genCondClause(conj.Right)
 	if err != nil {
 		return "", err
 	}
@@ -63,29 +372,190 @@ func genConjClause(conj *sql.CondConj) (string, error) {
 }
 
 // genCompClause creates an elasticsearch term or range clause for the specified comparison
-func genCompClause(comp *sql.CondComp) (string, error) {
+func (t *translator) genCompClause(comp *sql.CondComp) (string, error) {
+
+	if t.functions().isDateExpr(comp.Val) {
+		if comp.CondOp == sql.LIKE {
+			return "", fmt.Errorf("LIKE cannot be applied to date expression %s", comp.Val)
+		}
+		return t.genDateClause(comp.Ident, comp.CondOp, comp.Val)
+	}
+
+	val, err := t.genCompValue(comp.Ident, comp.CondOp, comp.Val)
+	if err != nil {
+		return "", err
+	}
+
+	switch op := comp.CondOp; op {
+	case sql.LT, sql.LE, sql.GT, sql.GE:
+		return fmt.Sprintf(`{"range": {"%s": {"%s": %s}}}`, comp.Ident, genRangeOp(op), val), nil
+	case sql.LIKE:
+		// Analyzed text is matched against its keyword subfield, as for equality.
+		field, analyzed, err := t.eqField(comp.Ident)
+		if err != nil || analyzed {
+			return "", fmt.Errorf("LIKE cannot be applied to text field %s, which has no keyword subfield", comp.Ident)
+		}
+		return fmt.Sprintf(`{"wildcard": {"%s": %s}}`, field, val), nil
+	}
+
+	eq, err := t.genEqClause(comp.Ident, val)
+	if err != nil {
+		return "", err
+	}
+	if comp.CondOp == sql.NE {
+		return fmt.Sprintf(`{"bool": {"must_not": %s}}`, eq), nil
+	}
+	return eq, nil
+}
 
-	switch val := comp.Val.(type) {
+// genEqClause returns the clause matching documents in which the specified
+// field equals the rendered value: a term query, unless the mapping of the
+// field calls for its keyword subfield or a match_phrase query.
+func (t *translator) genEqClause(ident, val string) (string, error) {
+
+	field, phrase, err := t.eqField(ident)
+	if err != nil {
+		return "", err
+	}
+	if phrase {
+		return fmt.Sprintf(`{"match_phrase": {"%s": %s}}`, field, val), nil
+	}
+	return fmt.Sprintf(`{"term": {"%s": %s}}`, field, val), nil
+}
+
+// eqField returns the field targeted by an equality on ident, and whether
+// the equality requires a match_phrase query. Only analyzed text fields in
+// the catalog are treated specially; any other field is returned as is.
+func (t *translator) eqField(ident string) (string, bool, error) {
+
+	f, ok := t.field(ident)
+	if !ok || f.Type != "text" {
+		return ident, false, nil
+	}
+	if k, ok := t.field(ident + ".keyword"); ok && k.Type == "keyword" {
+		return k.Path, false, nil
+	}
+	if t.opts.TextEquality == TextError {
+		return "", false, fmt.Errorf("equality on text field %s, which has no keyword subfield", ident)
+	}
+	return ident, true, nil
+}
+
+// field returns the mapping of the specified field in the first index of
+// the statement mapping it, if translating with a catalog.
+func (t *translator) field(path string) (FieldInfo, bool) {
+
+	for _, index := range t.indices {
+		if f, ok := t.opts.Catalog.Field(index, path); ok {
+			return f, true
+		}
+	}
+	return FieldInfo{}, false
+}
+
+// genInClause creates an elasticsearch terms clause for the specified list membership test
+func (t *translator) genInClause(in *sql.CondIn) (string, error) {
+
+	for _, v := range in.Vals {
+		if t.functions().isDateExpr(v) {
+			return t.genDateInClause(in)
+		}
+	}
+
+	vals := make([]string, len(in.Vals))
+	for i, v := range in.Vals {
+		val, err := t.genCompValue(in.Ident, sql.EQ, v)
+		if err != nil {
+			return "", err
+		}
+		vals[i] = val
+	}
+
+	field, phrase, err := t.eqField(in.Ident)
+	if err != nil {
+		return "", err
+	}
+	var terms string
+	switch {
+	case len(vals) == 0:
+		// An empty list, which an IN subquery may return, matches nothing,
+		// whereas an empty bool query would match every document.
+		terms = `{"match_none": {}}`
+	case phrase:
+		phrases := make([]string, len(vals))
+		for i, val := range vals {
+			phrases[i] = fmt.Sprintf(`{"match_phrase": {"%s": %s}}`, field, val)
+		}
+		terms = fmt.Sprintf(`{"bool": {"should": [%s]}}`, strings.Join(phrases, ", "))
+	default:
+		terms = fmt.Sprintf(`{"terms": {"%s": [%s]}}`, field, strings.Join(vals, ", "))
+	}
+	if in.Not {
+		return fmt.Sprintf(`{"bool": {"must_not": %s}}`, terms), nil
+	}
+	return terms, nil
+}
+
+// genDateInClause returns the clause matching documents whose date field
+// equals any of the values of a list membership test, which include a date
+// expression.
+func (t *translator) genDateInClause(in *sql.CondIn) (string, error) {
+
+	clauses := make([]string, len(in.Vals))
+	for i, v := range in.Vals {
+		var err error
+		if clauses[i], err = t.genDateClause(in.Ident, sql.EQ, v); err != nil {
+			return "", err
+		}
+	}
+	should := fmt.Sprintf(`{"bool": {"should": [%s]}}`, strings.Join(clauses, ", "))
+	if in.Not {
+		return fmt.Sprintf(`{"bool": {"must_not": %s}}`, should), nil
+	}
+	return should, nil
+}
+
+// genValue returns the value rendered by the specified function or, for a
+// parameter in template mode, a placeholder for a slot that renders the
+// value bound to it.
+func (t *translator) genValue(val sql.Expr, render func(sql.Expr) (string, error)) (string, error) {
+
+	if p, ok := val.(*sql.ParamExpr); ok && t.template {
+		t.slots = append(t.slots, paramSlot{param: p, render: render})
+		return slotMarker(len(t.slots) - 1), nil
+	}
+	return render(val)
+}
+
+// genCompValue returns the JSON encoding of the value compared against ident,
+// verifying that the comparison operator is applicable to the value's type.
+// In template mode a parameter yields a placeholder for its slot.
+func (t *translator) genCompValue(ident string, op sql.Token, val sql.Expr) (string, error) {
+	return t.genValue(val, func(v sql.Expr) (string, error) {
+		return genCompValue(ident, op, v)
+	})
+}
+
+// genCompValue renders a comparison value for translator.genCompValue.
+func genCompValue(ident string, op sql.Token, val sql.Expr) (string, error) {
+
+	switch val := val.(type) {
 	case *sql.NumExpr:
 
-		op := comp.CondOp
-		if op == sql.LT || op == sql.LE || op == sql.GT || op == sql.GE {
-			return fmt.Sprintf(`{"range": {"%s": {"%s": %v}}}`, comp.Ident, genRangeOp(op), val.Val), nil
-		} else if op == sql.EQ {
-			return fmt.Sprintf(`{"term": {"%s": %v}}`, comp.Ident, val.Val), nil
-		} else if op == sql.NE {
-			return fmt.Sprintf(`{"bool": {"must_not": {"term": {"%s": %v}}}}`, comp.Ident, val.Val), nil
+		if op == sql.LT || op == sql.LE || op == sql.GT || op == sql.GE || op == sql.EQ || op == sql.NE {
+			return fmt.Sprintf("%v", val.Val), nil
 		} else {
 			return "", fmt.Errorf("unexpected comparison token generating number comparison: %v", op)
 		}
 
 	case *sql.StringExpr:
 
-		op := comp.CondOp
-		if op == sql.EQ {
-			return fmt.Sprintf(`{"term": {"%s": %v}}`, comp.Ident, val.Val), nil
-		} else if op == sql.NE {
-			return fmt.Sprintf(`{"bool": {"must_not": {"term": {"%s": %v}}}}`, comp.Ident, val.Val), nil
+		if op == sql.EQ || op == sql.NE {
+			b, err := json.Marshal(val.Unquoted())
+			return string(b), err
+		} else if op == sql.LIKE {
+			b, err := json.Marshal(wildcardPattern(val.Unquoted()))
+			return string(b), err
 		} else {
 			return "", fmt.Errorf("unexpected comparison token generating string comparison: %v", op)
 		}
@@ -93,11 +563,39 @@ func genCompClause(comp *sql.CondComp) (string, error) {
 	case *sql.FuncCallExpr:
 		return "", fmt.Errorf("function call comparisons not yet supported for: %s", val.Name)
 
+	case *sql.ParamExpr:
+		return "", fmt.Errorf("unbound parameter %s in comparison on %s", val, ident)
+
+	case *sql.IdentExpr:
+		return "", fmt.Errorf("field comparisons not supported: %s with %s", ident, val.Name)
+
 	default:
 		return "", fmt.Errorf("unexpected expression type in comparison: %t", val)
 	}
 }
 
+// wildcardPattern converts a LIKE pattern into an elasticsearch wildcard
+// pattern, in which * and ? take the place of % and _, escaping any * and ?
+// that are to be matched literally.
+func wildcardPattern(like string) string {
+
+	var buf bytes.Buffer
+	for _, ch := range like {
+		switch ch {
+		case '%':
+			buf.WriteRune('*')
+		case '_':
+			buf.WriteRune('?')
+		case '*', '?', '\\':
+			buf.WriteRune('\\')
+			buf.WriteRune(ch)
+		default:
+			buf.WriteRune(ch)
+		}
+	}
+	return buf.String()
+}
+
 // genRangeOp expects a comparison token (LT, LE, GT, GE) and returns the
 // elasticsearch range operator equivalent, otherwise an empty string.
 func genRangeOp(tok sql.Token) string {
@@ -2,6 +2,7 @@ package essyntax
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -16,6 +17,8 @@ func TestQuery(t *testing.T) {
 
 	defer log.Flush()
 
+	tr := &translator{}
+
 	Convey("Test getRangeOp\n", t, func() {
 		So(genRangeOp(LT), ShouldEqual, "lt")
 		So(genRangeOp(LE), ShouldEqual, "lte")
@@ -25,55 +28,188 @@ func TestQuery(t *testing.T) {
 
 	Convey("Test ES comparisons\n", t, func() {
 
-		es, err := genCompClause(&CondComp{Ident:"numLT", CondOp: LT, Val: &NumExpr{Val: 12.3}})
+		es, err := tr.genCompClause(&CondComp{Ident:"numLT", CondOp: LT, Val: &NumExpr{Val: 12.3}})
 		So(err, ShouldBeNil)
 		So(es, ShouldEqual, `{"range": {"numLT": {"lt": 12.3}}}`)
 		log.Debug(es)
 
-		es, err = genCompClause(&CondComp{Ident:"strEQ", CondOp: EQ, Val: &NumExpr{Val: 23.4}})
+		es, err = tr.genCompClause(&CondComp{Ident:"strEQ", CondOp: EQ, Val: &NumExpr{Val: 23.4}})
 		So(err, ShouldBeNil)
 		So(es, ShouldEqual, `{"term": {"strEQ": 23.4}}`)
 		log.Debug(es)
 
-		es, err = genCompClause(&CondComp{Ident:"strNE", CondOp: NE, Val: &NumExpr{Val: 34.5}})
+		es, err = tr.genCompClause(&CondComp{Ident:"strNE", CondOp: NE, Val: &NumExpr{Val: 34.5}})
 		So(err, ShouldBeNil)
 		So(es, ShouldEqual, `{"bool": {"must_not": {"term": {"strNE": 34.5}}}}`)
 		log.Debug(es)
 
-		_, err = genCompClause(&CondComp{Ident:"strP", CondOp: PAREN_R, Val: &NumExpr{Val: 45.6}})
+		_, err = tr.genCompClause(&CondComp{Ident:"strP", CondOp: PAREN_R, Val: &NumExpr{Val: 45.6}})
 		So(err, ShouldResemble, fmt.Errorf("unexpected comparison token generating number comparison: PAREN_R"))
 
-		es, err = genCompClause(&CondComp{Ident:"strEQ", CondOp: EQ, Val: &StringExpr{Val: `"strEQval"`}})
+		es, err = tr.genCompClause(&CondComp{Ident:"strEQ", CondOp: EQ, Val: &StringExpr{Val: `"strEQval"`}})
 		So(err, ShouldBeNil)
 		So(es, ShouldEqual, `{"term": {"strEQ": "strEQval"}}`)
 		log.Debug(es)
 
-		es, err = genCompClause(&CondComp{Ident:"strNE", CondOp: NE, Val: &StringExpr{Val: `"strNEval"`}})
+		es, err = tr.genCompClause(&CondComp{Ident:"strNE", CondOp: NE, Val: &StringExpr{Val: `"strNEval"`}})
 		So(err, ShouldBeNil)
 		So(es, ShouldEqual, `{"bool": {"must_not": {"term": {"strNE": "strNEval"}}}}`)
 		log.Debug(es)
 
-		_, err = genCompClause(&CondComp{Ident:"strGT", CondOp: GT, Val: &StringExpr{Val: `"strGTval"`}})
+		_, err = tr.genCompClause(&CondComp{Ident:"strGT", CondOp: GT, Val: &StringExpr{Val: `"strGTval"`}})
 		So(err, ShouldResemble, fmt.Errorf("unexpected comparison token generating string comparison: GT"))
 
+		es, err = tr.genCompClause(&CondComp{Ident:"name", CondOp: LIKE, Val: &StringExpr{Val: `"Gr_tz%*?"`}})
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"wildcard": {"name": "Gr?tz*\\*\\?"}}`)
+		log.Debug(es)
+
+		_, err = tr.genCompClause(&CondComp{Ident:"jersey", CondOp: LIKE, Val: &NumExpr{Val: 99}})
+		So(err, ShouldResemble, fmt.Errorf("unexpected comparison token generating number comparison: LIKE"))
+
+		_, err = tr.genCompClause(&CondComp{Ident:"param", CondOp: EQ, Val: &ParamExpr{Lit: "?", Index: 1}})
+		So(err, ShouldResemble, fmt.Errorf("unbound parameter ? in comparison on param"))
+
+	})
+
+	Convey("Test ES terms\n", t, func() {
+		es, err := tr.genCondClause(&CondIn{Ident: "pos", Vals: []Expr{&StringExpr{Val: `'C'`}, &StringExpr{Val: `"LW"`}}})
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"terms": {"pos": ["C", "LW"]}}`)
+		log.Debug(es)
+
+		es, err = tr.genCondClause(&CondIn{Ident: "jersey", Not: true, Vals: []Expr{&NumExpr{Val: 99}, &NumExpr{Val: 11}}})
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"bool": {"must_not": {"terms": {"jersey": [99, 11]}}}}`)
+		log.Debug(es)
+	})
+
+	Convey("Test unbound parameters\n", t, func() {
+		stmt, err := NewParser(strings.NewReader(`SELECT name FROM oilers WHERE pos = :pos`)).Parse()
+		So(err, ShouldBeNil)
+		_, err = ElasticSearchQuery(stmt)
+		So(err, ShouldResemble, fmt.Errorf("statement has unbound parameter :pos"))
+	})
+
+	Convey("Test ES search bodies\n", t, func() {
+		stmt, err := NewParser(strings.NewReader(`SELECT * FROM oilers WHERE pos = 'C' ORDER BY goals DESC LIMIT 3`)).Parse()
+		So(err, ShouldBeNil)
+		es, err := ElasticSearchQuery(stmt)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"term": {"pos": "C"}}}}, "sort": [{"goals": {"order": "desc"}}], "size": 3}`)
+		log.Debug(es)
+
+		stmt, err = NewParser(strings.NewReader(`SELECT name FROM oilers ORDER BY F(goals)`)).Parse()
+		So(err, ShouldBeNil)
+		_, err = ElasticSearchQuery(stmt)
+		So(err.Error(), ShouldEqual, "unknown function F")
+		fs := NewFunctionSet()
+		fs.Registry.Register(FuncSignature{Name: "F", Args: []ArgType{FieldType}, Returns: NumberType})
+		_, err = ElasticSearchQueryOptions(stmt, TranslateOptions{Functions: fs})
+		So(err, ShouldResemble, fmt.Errorf("unsupported ORDER BY expression F(goals)"))
+
+		double := func(call *FuncCallExpr, value func(Expr) (string, error)) (string, error) {
+			factor, err := value(call.Args[1])
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf(`{"source": "doc['%s'].value * params.factor", "params": {"factor": %s}}`, FormatExpr(call.Args[0]), factor), nil
+		}
+		So(fs.RegisterScalar(FuncSignature{Name: "TIMES", Args: []ArgType{FieldType, NumberType}, Returns: NumberType}, double), ShouldBeNil)
+		stmt, err = NewParser(strings.NewReader(`SELECT name, TIMES(goals, 2) g FROM oilers ORDER BY g DESC, TIMES(goals, ?)`)).Parse()
+		So(err, ShouldBeNil)
+		stmt, err = Bind(stmt, 3)
+		So(err, ShouldBeNil)
+		es, err = ElasticSearchQueryOptions(stmt, TranslateOptions{Functions: fs})
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "_source": ["name"], "script_fields": {`+
+			`"g": {"script": {"source": "doc['goals'].value * params.factor", "params": {"factor": 2}}}}, "sort": [`+
+			`{"_script": {"type": "number", "script": {"source": "doc['goals'].value * params.factor", "params": {"factor": 2}}, "order": "desc"}}, `+
+			`{"_script": {"type": "number", "script": {"source": "doc['goals'].value * params.factor", "params": {"factor": 3}}, "order": "asc"}}]}`)
+
+		So(fs.RegisterScalar(FuncSignature{Name: "WHEN", Kind: PredicateFunc}, double), ShouldResemble, fmt.Errorf("function WHEN is not a scalar function"))
+		So(fs.RegisterScalar(FuncSignature{Name: "BORN", Args: []ArgType{FieldType}, Returns: DateType}, double), ShouldBeNil)
+		stmt, err = NewParser(strings.NewReader(`SELECT name FROM oilers ORDER BY BORN(dob)`)).Parse()
+		So(err, ShouldBeNil)
+		_, err = ElasticSearchQueryOptions(stmt, TranslateOptions{Functions: fs})
+		So(err, ShouldResemble, fmt.Errorf("cannot sort by BORN(dob), which returns a date"))
+
+		stmt, err = NewParser(strings.NewReader(`SELECT name FROM oilers WHERE goals = assists`)).Parse()
+		So(err, ShouldBeNil)
+		_, err = ElasticSearchQuery(stmt)
+		So(err, ShouldResemble, fmt.Errorf("field comparisons not supported: goals with assists"))
+	})
+
+	Convey("Test mapping-aware equality\n", t, func() {
+		c, err := NewCatalogFromFile("testdata/mapping.json")
+		So(err, ShouldBeNil)
+		opts := TranslateOptions{Catalog: c}
+
+		query := func(q string, opts TranslateOptions) (string, error) {
+			stmt, err := NewParser(strings.NewReader(q)).Parse()
+			So(err, ShouldBeNil)
+			return ElasticSearchQueryOptions(stmt, opts)
+		}
+
+		es, err := query(`SELECT * FROM oilers WHERE name = 'Wayne Gretzky' AND pos != 'G'`, opts)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"bool": {"must": [{"term": {"name.keyword": "Wayne Gretzky"}}, `+
+			`{"bool": {"must_not": {"term": {"pos": "G"}}}}]}}}}}`)
+		log.Debug(es)
+
+		es, err = query(`SELECT * FROM oilers WHERE quote = 'great one' OR teams IN ('LAK', 'STL')`, opts)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"bool": {"should": [{"match_phrase": {"quote": "great one"}}, `+
+			`{"terms": {"teams": ["LAK", "STL"]}}]}}}}}`)
+
+		es, err = query(`SELECT * FROM jets WHERE name IN ('Hawerchuk', 'Selanne') AND pos = 'C'`, opts)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"bool": {"must": [{"bool": {"should": [`+
+			`{"match_phrase": {"name": "Hawerchuk"}}, {"match_phrase": {"name": "Selanne"}}]}}, {"term": {"pos": "C"}}]}}}}}`)
+
+		es, err = query(`SELECT * FROM oilers WHERE name LIKE 'Wayne%'`, opts)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"wildcard": {"name.keyword": "Wayne*"}}}}}`)
+		_, err = query(`SELECT * FROM oilers WHERE quote LIKE '%great%'`, opts)
+		So(err, ShouldResemble, fmt.Errorf("LIKE cannot be applied to text field quote, which has no keyword subfield"))
+
+		es, err = query(`SELECT * FROM oilers WHERE quote = 'great one'`, TranslateOptions{})
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"term": {"quote": "great one"}}}}}`)
+
+		opts.TextEquality = TextError
+		_, err = query(`SELECT * FROM oilers WHERE quote != 'great one'`, opts)
+		So(err, ShouldResemble, fmt.Errorf("equality on text field quote, which has no keyword subfield"))
+		es, err = query(`SELECT * FROM oilers WHERE name = 'Wayne Gretzky'`, opts)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"term": {"name.keyword": "Wayne Gretzky"}}}}}`)
+
+		_, err = query(`SELECT * FROM kings WHERE name = 'Dionne'`, opts)
+		So(err, ShouldResemble, fmt.Errorf("no mapping for index kings in offline catalog"))
+
+		p, err := PrepareOptions(`SELECT * FROM oilers WHERE name = ?`, opts)
+		So(err, ShouldBeNil)
+		es, err = p.Query("Mark Messier")
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"term": {"name.keyword": "Mark Messier"}}}}}`)
 	})
 
 	Convey("Test ES conjuctions\n", t, func() {
-		es, err := genCondClause(&CondConj{
+		es, err := tr.genCondClause(&CondConj{
 			Left: &CondComp{Ident:"condAnd1", CondOp: EQ, Val: &StringExpr{Val: `"condAndVal"`}}, Op: AND,
 			Right: &CondComp{Ident:"condAnd2", CondOp: EQ, Val: &NumExpr{Val: -9}}})
 		So(err, ShouldBeNil)
 		So(es, ShouldEqual, `{"bool": {"must": [{"term": {"condAnd1": "condAndVal"}}, {"term": {"condAnd2": -9}}]}}`)
 		log.Debug(es)
 
-		es, err = genCondClause(&CondConj{
+		es, err = tr.genCondClause(&CondConj{
 			Left: &CondComp{Ident:"condOr1", CondOp: EQ, Val: &StringExpr{Val: `"condOrVal"`}}, Op: OR,
 			Right: &CondComp{Ident:"condOr2", CondOp: EQ, Val: &NumExpr{Val: 23}}})
 		So(err, ShouldBeNil)
 		So(es, ShouldEqual, `{"bool": {"should": [{"term": {"condOr1": "condOrVal"}}, {"term": {"condOr2": 23}}]}}`)
 		log.Debug(es)
 
-		es, err = genCondClause(&CondConj{
+		es, err = tr.genCondClause(&CondConj{
 			Left: &CondConj{
 				Left: &CondComp{Ident:"c1", CondOp: NE, Val: &StringExpr{Val: `"c1val"`}},
 				Op: AND,
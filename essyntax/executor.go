@@ -0,0 +1,266 @@
+package essyntax
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	elastigo "github.com/mattbaird/elastigo/lib"
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// ColumnType is the type of the values in a ResultSet column.
+type ColumnType string
+
+const (
+	TypeUnknown ColumnType = ""
+	TypeString  ColumnType = "string"
+	TypeLong    ColumnType = "long"
+	TypeDouble  ColumnType = "double"
+	TypeBoolean ColumnType = "boolean"
+	TypeDate    ColumnType = "date"
+	TypeArray   ColumnType = "array"
+)
+
+// Column describes a column of a ResultSet. Name is the alias of the
+// selected field, if any, and Field the path of the field in each document.
+type Column struct {
+	Name  string
+	Field string
+	Type  ColumnType
+}
+
+// ResultSet holds the rows returned by a statement, decoded by a Decoder.
+// Values are nil for fields missing from a document, and otherwise are of the
+// Go type corresponding to their column type: string, int64, float64, bool,
+// time.Time or []interface{}. Only the keys of date histogram buckets are
+// decoded as dates; other dates are strings as they appear in the documents.
+// Total is the number of matching documents, which may
+// exceed the number of rows.
+type ResultSet struct {
+	Columns []Column
+	Rows    [][]interface{}
+	Total   int
+}
+
+// Executor runs statements against an elasticsearch cluster.
+type Executor struct {
+	// Decoding controls how documents are decoded into rows.
+	Decoding DecoderOptions
+
+	// Paging controls how the hits of statements are paged through.
+	Paging PagingOptions
+
+	// Translate controls the translation of statements, unless they are
+	// prepared through a cache, which has its own options.
+	Translate TranslateOptions
+
+	// Join, Subquery and Union control the execution of the statements
+	// which are run in stages, whose intermediate rows are held in memory.
+	// Each bounds those rows, by 10000 unless set, and fails a statement
+	// exceeding its limit rather than return a partial result.
+	Join     JoinOptions
+	Subquery SubqueryOptions
+	Union    UnionOptions
+
+	conn  *elastigo.Conn
+	cache *PreparedCache
+
+	mu     sync.Mutex
+	probed bool // whether the cluster version has been probed
+	scroll bool // whether the cluster lacks search_after
+}
+
+// defaultMemoryLimit is the limit on the rows or values held in memory while
+// running a statement in stages, where its options leave it unset.
+const defaultMemoryLimit = 10000
+
+// memoryLimit returns the limit set by an option, or defaultMemoryLimit if n
+// is not positive.
+func memoryLimit(n int) int {
+	if n <= 0 {
+		return defaultMemoryLimit
+	}
+	return n
+}
+
+// NewExecutor returns an Executor which sends queries over the specified
+// connection. Statements are prepared through cache, or each time they are
+// run if cache is nil.
+func NewExecutor(conn *elastigo.Conn, cache *PreparedCache) *Executor {
+	return &Executor{conn: conn, cache: cache}
+}
+
+// Query runs the specified SQL text with args bound to its positional and
+// numbered parameters.
+func (e *Executor) Query(sqlText string, args ...interface{}) (*ResultSet, error) {
+
+	prep, err := e.prepare(sqlText)
+	if err != nil {
+		return nil, err
+	}
+	b, err := sql.NewBinding(prep.Stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	return e.Run(prep, b)
+}
+
+// QueryNamed runs the specified SQL text with args bound to its named
+// parameters.
+func (e *Executor) QueryNamed(sqlText string, args map[string]interface{}) (*ResultSet, error) {
+
+	prep, err := e.prepare(sqlText)
+	if err != nil {
+		return nil, err
+	}
+	b, err := sql.NewNamedBinding(prep.Stmt, args)
+	if err != nil {
+		return nil, err
+	}
+	return e.Run(prep, b)
+}
+
+func (e *Executor) prepare(sqlText string) (*Prepared, error) {
+	if e.cache != nil {
+		return e.cache.Prepare(sqlText)
+	}
+	return PrepareOptions(sqlText, e.Translate)
+}
+
+// translateOptions returns the options with which statements are prepared.
+func (e *Executor) translateOptions() TranslateOptions {
+	if e.cache != nil {
+		return e.cache.Translate
+	}
+	return e.Translate
+}
+
+// Run executes a prepared statement with the specified parameter values,
+// paging through every hit it selects.
+func (e *Executor) Run(prep *Prepared, b sql.Binding) (*ResultSet, error) {
+
+	st, err := e.Stream(context.Background(), prep, b)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+
+	rs := &ResultSet{Total: st.Total}
+	for {
+		row, err := st.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rs.Rows = append(rs.Rows, row)
+	}
+
+	// The columns were typed by the first page of rows alone.
+	rs.Columns = append([]Column{}, st.Columns()...)
+	if len(rs.Rows) > 0 {
+		retypeRows(rs.Columns, rs.Rows)
+	}
+	return rs, nil
+}
+
+// indexList returns the comma-separated names of the specified tables.
+func indexList(tables sql.Fields) string {
+
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = t.Name
+	}
+	return strings.Join(names, ",")
+}
+
+// jsonContent and ndjsonContent are the content types of request bodies,
+// which elasticsearch 6.0 and later require.
+const (
+	jsonContent   = "application/json"
+	ndjsonContent = "application/x-ndjson"
+)
+
+// doRequest sends a request with the specified body, of the specified
+// content type, and returns the body of the response. Unlike DoCommand,
+// which sends string bodies without a content type, it can be used with
+// elasticsearch 6.0 and later.
+func doRequest(conn *elastigo.Conn, method, path string, args map[string]interface{}, body, contentType string) ([]byte, error) {
+
+	query, err := elastigo.Escape(args)
+	if err != nil {
+		return nil, err
+	}
+	req, err := conn.NewRequest(method, path, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > 0 {
+		req.SetBodyString(body)
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	// Do decodes the response into response if the request failed.
+	var response map[string]interface{}
+	status, res, err := req.Do(&response)
+	if err != nil || status <= 304 {
+		return res, err
+	}
+	if resErr, ok := response["error"]; ok {
+		return res, elastigo.ESError{When: time.Now(), What: fmt.Sprintf("Error [%s] Status [%v]", resErr, response["status"]), Code: status}
+	}
+	return res, nil
+}
+
+// doSearch sends a search request with the specified JSON body, and decodes
+// the response.
+func doSearch(conn *elastigo.Conn, path string, args map[string]interface{}, body string) (elastigo.SearchResult, error) {
+
+	res, err := doRequest(conn, "POST", path, args, body, jsonContent)
+	if err != nil {
+		return elastigo.SearchResult{}, err
+	}
+	return decodeSearchResult(res)
+}
+
+// decodeSearchResult decodes a search response. Its hits.total is the number
+// of hits before elasticsearch 7.0, and since an object holding the number
+// as its value, which elastigo cannot decode.
+func decodeSearchResult(body []byte) (elastigo.SearchResult, error) {
+
+	var r struct {
+		elastigo.SearchResult
+		Hits struct {
+			Total json.RawMessage `json:"total"`
+			Hits  []elastigo.Hit  `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return elastigo.SearchResult{}, err
+	}
+
+	res := r.SearchResult
+	res.RawJSON = body
+	res.Hits.Hits = r.Hits.Hits
+	total := r.Hits.Total
+	if len(total) == 0 || string(total) == "null" {
+		return res, nil
+	}
+	if total[0] != '{' {
+		return res, json.Unmarshal(total, &res.Hits.Total)
+	}
+	var obj struct {
+		Value int `json:"value"`
+	}
+	if err := json.Unmarshal(total, &obj); err != nil {
+		return res, err
+	}
+	res.Hits.Total = obj.Value
+	return res, nil
+}
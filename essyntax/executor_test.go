@@ -0,0 +1,224 @@
+package essyntax
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	elastigo "github.com/mattbaird/elastigo/lib"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+// fakeES is an elasticsearch stand-in which records each request and
+// responds with a canned body, or with its version to GET /. Any queued
+// responses are sent in turn before the canned body. Like elasticsearch 6.0
+// and later, it rejects request bodies without a JSON content type, or an
+// ndjson one for _msearch.
+type fakeES struct {
+	*httptest.Server
+	paths     []string
+	bodies    []string
+	types     []string // the Content-Type of each request
+	status    int
+	responses []string
+	response  string
+	version   string
+
+	mu sync.Mutex // serializes the requests of concurrent UNION branches
+}
+
+func newFakeES(response string) *fakeES {
+	es := &fakeES{response: response, version: "6.8.0"}
+	es.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		es.mu.Lock()
+		defer es.mu.Unlock()
+		es.paths = append(es.paths, r.Method+" "+r.URL.Path)
+		es.bodies = append(es.bodies, string(b))
+		es.types = append(es.types, r.Header.Get("Content-Type"))
+		if r.Method == "GET" && r.URL.Path == "/" {
+			fmt.Fprintf(w, `{"version": {"number": "%s"}}`, es.version)
+			return
+		}
+		want := "application/json"
+		if r.URL.Path == "/_msearch" {
+			want = "application/x-ndjson"
+		}
+		if typ := r.Header.Get("Content-Type"); len(b) > 0 && typ != want {
+			w.WriteHeader(http.StatusNotAcceptable)
+			fmt.Fprintf(w, `{"error": "Content-Type header [%s] is not supported", "status": 406}`, typ)
+			return
+		}
+		if es.status != 0 {
+			w.WriteHeader(es.status)
+		}
+		if len(es.responses) > 0 {
+			fmt.Fprint(w, es.responses[0])
+			es.responses = es.responses[1:]
+			return
+		}
+		fmt.Fprint(w, es.response)
+	}))
+	return es
+}
+
+// conn returns an elastigo connection to the fake server.
+func (es *fakeES) conn() *elastigo.Conn {
+	c := elastigo.NewConn()
+	c.SetFromUrl(es.URL)
+	return c
+}
+
+const oilersHits = `{"took": 1, "hits": {"total": 7, "hits": [
+	{"_index": "oilers", "_id": "1", "_source": {"name": "Wayne Gretzky", "goals": 92, "pos": "C", "stats": {"ppg": 2.6}}},
+	{"_index": "oilers", "_id": "2", "_source": {"name": "Jari Kurri", "goals": 45.5, "hof": true}},
+	{"_index": "oilers", "_id": "3", "_source": {"name": "Mark Messier", "pos": ["C", "LW"]}}]}}`
+
+func TestExecutor(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test executing a statement\n", t, func() {
+		es := newFakeES(oilersHits)
+		defer es.Close()
+
+		ex := NewExecutor(es.conn(), nil)
+		rs, err := ex.Query(`SELECT name n, goals, stats.ppg, missing FROM oilers WHERE pos = ? ORDER BY n LIMIT 3`, "C")
+		So(err, ShouldBeNil)
+		So(es.paths, ShouldResemble, []string{"POST /oilers/_search"})
+		So(es.bodies[0], ShouldEqual, `{"query": {"constant_score": {"filter": {"term": {"pos": "C"}}}}, "_source": ["name", "goals", "stats.ppg", "missing"], `+
+			`"sort": [{"name": {"order": "asc"}}], "size": 3}`)
+
+		So(rs.Total, ShouldEqual, 7)
+		So(rs.Columns, ShouldResemble, []Column{
+			{Name: "n", Field: "name", Type: TypeString},
+			{Name: "goals", Field: "goals", Type: TypeDouble},
+			{Name: "stats.ppg", Field: "stats.ppg", Type: TypeDouble},
+			{Name: "missing", Field: "missing", Type: TypeUnknown}})
+		So(rs.Rows, ShouldResemble, [][]interface{}{
+			{"Wayne Gretzky", float64(92), 2.6, nil},
+			{"Jari Kurri", 45.5, nil, nil},
+			{"Mark Messier", nil, nil, nil}})
+	})
+
+	Convey("Test hit totals of elasticsearch 7 and later\n", t, func() {
+		es := newFakeES(`{"hits": {"total": {"value": 7, "relation": "eq"}, "hits": [{"_source": {"name": "Wayne Gretzky"}}]}}`)
+		es.version = "7.10.2"
+		defer es.Close()
+
+		rs, err := NewExecutor(es.conn(), nil).Query(`SELECT name FROM oilers LIMIT 1`)
+		So(err, ShouldBeNil)
+		So(rs.Total, ShouldEqual, 7)
+		So(rs.Rows, ShouldResemble, [][]interface{}{{"Wayne Gretzky"}})
+
+		es.response = `{"hits": {"total": {"value": 10000, "relation": "gte"}, "hits": []}, "aggregations": {}}`
+		rs, err = NewExecutor(es.conn(), nil).Query(`SELECT MAX(goals) FROM oilers`)
+		So(err, ShouldBeNil)
+		So(rs.Total, ShouldEqual, 10000)
+	})
+
+	Convey("Test executing a select all statement\n", t, func() {
+		es := newFakeES(oilersHits)
+		defer es.Close()
+
+		ex := NewExecutor(es.conn(), NewPreparedCache(4))
+		rs, err := ex.QueryNamed(`SELECT * FROM oilers, flames WHERE goals > :goals`, map[string]interface{}{"goals": 40})
+		So(err, ShouldBeNil)
+		So(es.paths, ShouldResemble, []string{"GET /", "POST /oilers,flames/_search"})
+		So(es.bodies[1], ShouldEqual, `{"query": {"constant_score": {"filter": {"range": {"goals": {"gt": 40}}}}}, `+
+			`"sort": [{"_score": {"order": "desc"}}, {"_id": {"order": "asc"}}], "size": 1000}`)
+
+		So(rs.Columns, ShouldResemble, []Column{
+			{Name: "goals", Field: "goals", Type: TypeDouble},
+			{Name: "hof", Field: "hof", Type: TypeBoolean},
+			{Name: "name", Field: "name", Type: TypeString},
+			{Name: "pos", Field: "pos", Type: TypeUnknown},
+			{Name: "stats.ppg", Field: "stats.ppg", Type: TypeDouble}})
+		So(rs.Rows[0], ShouldResemble, []interface{}{float64(92), nil, "Wayne Gretzky", "C", 2.6})
+		So(rs.Rows[2][3], ShouldResemble, []interface{}{"C", "LW"})
+	})
+
+	Convey("Test typed values\n", t, func() {
+		es := newFakeES(`{"hits": {"total": 2, "hits": [
+			{"_source": {"jersey": 99, "dotted.name": "x"}}, {"_source": {"jersey": 11}}]}}`)
+		defer es.Close()
+
+		rs, err := NewExecutor(es.conn(), nil).Query(`SELECT jersey, dotted.name FROM oilers`)
+		So(err, ShouldBeNil)
+		So(rs.Columns[0].Type, ShouldEqual, TypeLong)
+		So(rs.Rows, ShouldResemble, [][]interface{}{{int64(99), "x"}, {int64(11), nil}})
+	})
+
+	Convey("Test executing an aggregate statement\n", t, func() {
+		es := newFakeES(`{"hits": {"total": 9, "hits": []}, "aggregations": {"group_0": {"buckets": [
+			{"key": "EDM", "doc_count": 5}, {"key": "LAK", "doc_count": 4}]}}}`)
+		defer es.Close()
+
+		rs, err := NewExecutor(es.conn(), nil).Query(`SELECT team, COUNT(*) n FROM nhl GROUP BY team LIMIT ?`, 1)
+		So(err, ShouldBeNil)
+		So(es.bodies[0], ShouldEqual, `{"query": {"match_all": {}}, "size": 0, "aggs": {`+
+			`"group_0": {"terms": {"field": "team", "size": 10000}}}}`)
+		So(rs.Columns, ShouldResemble, []Column{
+			{Name: "team", Field: "team", Type: TypeString},
+			{Name: "n", Field: "COUNT(*)", Type: TypeLong}})
+		So(rs.Rows, ShouldResemble, [][]interface{}{{"EDM", int64(5)}})
+	})
+
+	Convey("Test executing a lone COUNT statement\n", t, func() {
+		es := newFakeES(`{"count": 42, "_shards": {"total": 5, "successful": 5, "failed": 0}}`)
+		defer es.Close()
+
+		rs, err := NewExecutor(es.conn(), nil).Query(`SELECT COUNT(goals) n FROM oilers WHERE pos = ?`, "D")
+		So(err, ShouldBeNil)
+		So(es.paths, ShouldResemble, []string{"GET /oilers/_count"})
+		So(es.bodies[0], ShouldEqual, `{"query": {"bool": {"must": {"term": {"pos": "D"}}, "filter": {"exists": {"field": "goals"}}}}}`)
+		So(es.types[0], ShouldEqual, "application/json")
+		So(rs.Total, ShouldEqual, 42)
+		So(rs.Columns, ShouldResemble, []Column{{Name: "n", Field: "COUNT(goals)", Type: TypeLong}})
+		So(rs.Rows, ShouldResemble, [][]interface{}{{int64(42)}})
+
+		rs, err = NewExecutor(es.conn(), nil).Query(`SELECT COUNT(*) FROM oilers LIMIT 0`)
+		So(err, ShouldBeNil)
+		So(es.bodies[1], ShouldEqual, `{"query": {"match_all": {}}}`)
+		So(rs.Rows, ShouldBeEmpty)
+	})
+
+	Convey("Test executing with decoder options\n", t, func() {
+		es := newFakeES(oilersHits)
+		defer es.Close()
+
+		ex := NewExecutor(es.conn(), nil)
+		ex.Decoding = DecoderOptions{MultiValue: MultiValueJoin, Separator: "/"}
+		rs, err := ex.Query(`SELECT pos FROM oilers`)
+		So(err, ShouldBeNil)
+		So(rs.Columns[0].Type, ShouldEqual, TypeString)
+		So(rs.Rows, ShouldResemble, [][]interface{}{{"C"}, {nil}, {"C/LW"}})
+	})
+
+	Convey("Test execution errors\n", t, func() {
+		es := newFakeES(`{"error": "IndexMissingException[[nhl] missing]", "status": 400}`)
+		defer es.Close()
+
+		ex := NewExecutor(es.conn(), nil)
+		_, err := ex.Query(`SELECT name FROM`)
+		So(err, ShouldNotBeNil)
+		So(es.paths, ShouldBeEmpty)
+
+		_, err = ex.Query(`SELECT name FROM oilers WHERE pos = ?`)
+		So(err, ShouldResemble, fmt.Errorf("missing value for parameter ?"))
+		So(es.paths, ShouldBeEmpty)
+
+		es.status = http.StatusBadRequest
+		_, err = ex.Query(`SELECT name FROM nhl`)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "IndexMissingException")
+		So(es.paths, ShouldResemble, []string{"GET /", "POST /nhl/_search"})
+	})
+}
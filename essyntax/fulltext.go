@@ -0,0 +1,207 @@
+package essyntax
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// scoreField is the sort field and hit member holding the relevance score
+// selected by SCORE().
+const scoreField = "_score"
+
+// fullTextFuncs lists the signatures of the full-text predicates, each of
+// which translates to the elasticsearch query of the same name in lower
+// case, and accepts the query parameters listed in its Options.
+var fullTextFuncs = []sql.FuncSignature{
+	{Name: "MATCH", Kind: sql.PredicateFunc, Returns: sql.BoolType,
+		Args:    []sql.ArgType{sql.FieldType, sql.StringType},
+		Options: []string{"operator", "fuzziness", "boost", "analyzer", "minimum_should_match"}},
+	{Name: "MATCH_PHRASE", Kind: sql.PredicateFunc, Returns: sql.BoolType,
+		Args:    []sql.ArgType{sql.FieldType, sql.StringType},
+		Options: []string{"slop", "boost", "analyzer"}},
+	{Name: "MULTI_MATCH", Kind: sql.PredicateFunc, Returns: sql.BoolType,
+		Args: []sql.ArgType{sql.StringType, sql.FieldType}, Variadic: true,
+		Options: []string{"type", "operator", "fuzziness", "boost", "analyzer", "minimum_should_match"}},
+	{Name: "QUERY_STRING", Kind: sql.PredicateFunc, Returns: sql.BoolType,
+		Args:    []sql.ArgType{sql.StringType},
+		Options: []string{"default_field", "default_operator", "fuzziness", "boost", "analyzer"}},
+}
+
+// genFullText is the PredicateTranslator of the full-text predicates. A
+// predicate whose first argument is a field, such as MATCH(quote, 'puck'),
+// queries that field, while the fields following the query string of one
+// such as MULTI_MATCH('puck', quote, name) are listed in the query.
+func genFullText(call *sql.FuncCallExpr, value func(sql.Expr) (string, error)) (string, error) {
+
+	var field, query string
+	var fields, opts []string
+	for i, a := range call.Args {
+		switch a := a.(type) {
+		case *sql.IdentExpr:
+			if i == 0 {
+				field = a.Name
+			} else {
+				fields = append(fields, fmt.Sprintf(`"%s"`, a.Name))
+			}
+		case *sql.NamedArgExpr:
+			val, err := value(a.Val)
+			if err != nil {
+				return "", err
+			}
+			opts = append(opts, fmt.Sprintf(`"%s": %s`, a.Name, val))
+		default:
+			var err error
+			if query, err = value(a); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	members := []string{fmt.Sprintf(`"query": %s`, query)}
+	if len(fields) > 0 {
+		members = append(members, fmt.Sprintf(`"fields": [%s]`, strings.Join(fields, ", ")))
+	}
+	body := fmt.Sprintf(`{%s}`, strings.Join(append(members, opts...), ", "))
+
+	name := strings.ToLower(call.Name)
+	if len(field) > 0 {
+		return fmt.Sprintf(`{"%s": {"%s": %s}}`, name, field, body), nil
+	}
+	return fmt.Sprintf(`{"%s": %s}`, name, body), nil
+}
+
+// genFuncValue returns the JSON encoding of a literal argument to the named
+// predicate function.
+func genFuncValue(name string, val sql.Expr) (string, error) {
+
+	switch val := val.(type) {
+	case *sql.StringExpr:
+		b, err := json.Marshal(val.Unquoted())
+		return string(b), err
+	case *sql.NumExpr:
+		return fmt.Sprintf("%v", val.Val), nil
+	case *sql.ParamExpr:
+		return "", fmt.Errorf("unbound parameter %s in %s", val, name)
+	default:
+		return "", fmt.Errorf("%s expects a string or number, got %s", name, val)
+	}
+}
+
+// isScoreCall returns true for a call to SCORE(), which selects or sorts by
+// the relevance score of each hit.
+func isScoreCall(e sql.Expr) bool {
+	call, ok := e.(*sql.FuncCallExpr)
+	return ok && strings.ToUpper(call.Name) == "SCORE" && len(call.Args) == 0
+}
+
+// selectsScore returns true if the select list includes SCORE().
+func selectsScore(fields sql.Fields) bool {
+	for _, f := range fields {
+		if isScoreCall(f.Expr) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFullText returns true if the condition contains a full-text predicate.
+// Geo predicates and comparisons of function calls only filter documents.
+func hasFullText(cond sql.Cond) bool {
+
+	switch c := cond.(type) {
+	case *sql.CondFunc:
+		return c.CondOp == sql.ILLEGAL && !isGeoCall(c.Call)
+	case *sql.CondConj:
+		return hasFullText(c.Left) || hasFullText(c.Right)
+	}
+	return false
+}
+
+// genWhereQuery returns the query for the specified WHERE condition, which
+// is nil if the statement has none. Only full-text predicates contribute to
+// the relevance score: every other predicate is placed in filter context,
+// which is cached and unscored, and a condition without any full-text
+// predicate is wrapped in a constant_score query.
+func (t *translator) genWhereQuery(where sql.Cond) (string, error) {
+
+	if where == nil {
+		return `{"match_all": {}}`, nil
+	}
+	if hasFullText(where) {
+		return t.genScoredClause(where)
+	}
+	filter, err := t.genCondClause(where)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`{"constant_score": {"filter": %s}}`, filter), nil
+}
+
+// genScoredClause returns the query clause for a condition containing a
+// full-text predicate. The operands of an AND are split between must and
+// filter, and those of an OR without a full-text predicate are wrapped in a
+// bool filter so that they match without scoring.
+func (t *translator) genScoredClause(cond sql.Cond) (string, error) {
+
+	if path := t.nestedPath(cond); len(path) > 0 {
+		return t.genNestedClause(path, cond, t.genScoredClause)
+	}
+
+	conj, ok := cond.(*sql.CondConj)
+	if !ok || conj.Left == nil || conj.Right == nil {
+		return t.genCondClause(cond)
+	}
+	operands := []sql.Cond{conj.Left, conj.Right}
+
+	switch conj.Op {
+	case sql.AND:
+		// The clauses are generated in the order they appear in the query,
+		// which template mode requires of parameter slots.
+		var must, filter []string
+		for _, scored := range []bool{true, false} {
+			for _, c := range operands {
+				if hasFullText(c) != scored {
+					continue
+				}
+				if scored {
+					clause, err := t.genScoredClause(c)
+					if err != nil {
+						return "", err
+					}
+					must = append(must, clause)
+				} else {
+					clause, err := t.genCondClause(c)
+					if err != nil {
+						return "", err
+					}
+					filter = append(filter, clause)
+				}
+			}
+		}
+		members := []string{fmt.Sprintf(`"must": [%s]`, strings.Join(must, ", "))}
+		if len(filter) > 0 {
+			members = append(members, fmt.Sprintf(`"filter": [%s]`, strings.Join(filter, ", ")))
+		}
+		return fmt.Sprintf(`{"bool": {%s}}`, strings.Join(members, ", ")), nil
+
+	case sql.OR:
+		should := make([]string, len(operands))
+		for i, c := range operands {
+			var err error
+			if hasFullText(c) {
+				should[i], err = t.genScoredClause(c)
+			} else if should[i], err = t.genCondClause(c); err == nil {
+				should[i] = fmt.Sprintf(`{"bool": {"filter": %s}}`, should[i])
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+		return fmt.Sprintf(`{"bool": {"should": [%s]}}`, strings.Join(should, ", ")), nil
+	}
+
+	return "", fmt.Errorf("unexpected operator generating conjuction: %v", conj.Op)
+}
@@ -0,0 +1,153 @@
+package essyntax
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	"github.com/oldenbur/sql-parser/sql"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+func TestFullText(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test full-text predicates\n", t, func() {
+		es, err := testQuery(`SELECT name FROM oilers WHERE MATCH(quote, 'puck')`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match": {"quote": {"query": "puck"}}}, "_source": ["name"]}`)
+		log.Debug(es)
+
+		es, err = testQuery(`SELECT * FROM oilers WHERE match(quote, 'pukc', operator = 'and', fuzziness = 'AUTO', boost = 2) AND pos = 'C'`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"bool": {"must": [`+
+			`{"match": {"quote": {"query": "pukc", "operator": "and", "fuzziness": "AUTO", "boost": 2}}}], `+
+			`"filter": [{"term": {"pos": "C"}}]}}}`)
+
+		es, err = testQuery(`SELECT * FROM oilers WHERE MATCH_PHRASE(quote, 'the puck', slop = 1)`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_phrase": {"quote": {"query": "the puck", "slop": 1}}}}`)
+
+		es, err = testQuery(`SELECT * FROM oilers WHERE MULTI_MATCH('team', quote, name, type = 'best_fields')`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"multi_match": {"query": "team", "fields": ["quote", "name"], "type": "best_fields"}}}`)
+
+		es, err = testQuery(`SELECT * FROM oilers WHERE QUERY_STRING('puck AND team', default_field = 'quote') OR goals > 50`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"bool": {"should": [`+
+			`{"query_string": {"query": "puck AND team", "default_field": "quote"}}, `+
+			`{"bool": {"filter": {"range": {"goals": {"gt": 50}}}}}]}}}`)
+	})
+
+	Convey("Test query and filter context\n", t, func() {
+		es, err := testQuery(`SELECT * FROM oilers WHERE pos = 'C' AND (MATCH(quote, 'puck') OR MATCH(name, 'wayne')) AND goals > 50`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"bool": {"must": [{"bool": {"must": [`+
+			`{"bool": {"should": [{"match": {"quote": {"query": "puck"}}}, {"match": {"name": {"query": "wayne"}}}]}}], `+
+			`"filter": [{"range": {"goals": {"gt": 50}}}]}}], `+
+			`"filter": [{"term": {"pos": "C"}}]}}}`)
+
+		es, err = testQuery(`SELECT * FROM oilers WHERE MATCH(quote, 'puck') AND MATCH_PHRASE(quote, 'the team')`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"bool": {"must": [`+
+			`{"match": {"quote": {"query": "puck"}}}, {"match_phrase": {"quote": {"query": "the team"}}}]}}}`)
+
+		p, err := Prepare(`SELECT * FROM oilers WHERE pos = ? AND MATCH(quote, ?)`)
+		So(err, ShouldBeNil)
+		es, err = p.Query("C", "puck")
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"bool": {"must": [{"match": {"quote": {"query": "puck"}}}], "filter": [{"term": {"pos": "C"}}]}}}`)
+	})
+
+	Convey("Test selecting and sorting by SCORE()\n", t, func() {
+		es, err := testQuery(`SELECT name, SCORE() FROM oilers WHERE MATCH(quote, 'puck') ORDER BY SCORE() DESC`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match": {"quote": {"query": "puck"}}}, "_source": ["name"], `+
+			`"sort": [{"_score": {"order": "desc"}}], "track_scores": true}`)
+
+		es, err = testQuery(`SELECT score() s FROM oilers WHERE MATCH(quote, 'puck') ORDER BY s, name`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match": {"quote": {"query": "puck"}}}, "_source": false, `+
+			`"sort": [{"_score": {"order": "asc"}}, {"name": {"order": "asc"}}], "track_scores": true}`)
+
+		es, err = testQuery(`SELECT name FROM oilers WHERE MATCH(quote, 'puck') ORDER BY SCORE()`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match": {"quote": {"query": "puck"}}}, "_source": ["name"], "sort": [{"_score": {"order": "asc"}}]}`)
+
+		fake := newFakeES(`{"hits": {"total": 2, "hits": [
+			{"_score": 1.3862944, "_source": {"name": "Wayne Gretzky"}},
+			{"_score": 0.2876821, "_source": {"name": "Mark Messier"}}]}}`)
+		defer fake.Close()
+		rs, err := NewExecutor(fake.conn(), nil).Query(`SELECT name, SCORE() relevance FROM oilers WHERE MATCH(quote, 'puck') LIMIT 2`)
+		So(err, ShouldBeNil)
+		So(rs.Columns, ShouldResemble, []Column{
+			{Name: "name", Field: "name", Type: TypeString},
+			{Name: "relevance", Field: "_score", Type: TypeDouble}})
+		So(rs.Rows, ShouldResemble, [][]interface{}{{"Wayne Gretzky", 1.3862944}, {"Mark Messier", 0.2876821}})
+	})
+
+	Convey("Test full-text predicates with parameters\n", t, func() {
+		p, err := Prepare(`SELECT * FROM oilers WHERE MATCH(quote, :text, boost = :boost)`)
+		So(err, ShouldBeNil)
+		es, err := p.QueryNamed(map[string]interface{}{"text": `the "puck"`, "boost": 1.5})
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match": {"quote": {"query": "the \"puck\"", "boost": 1.5}}}}`)
+
+		stmt, err := sql.NewParser(strings.NewReader(`SELECT * FROM oilers WHERE QUERY_STRING(?)`)).Parse()
+		So(err, ShouldBeNil)
+		_, err = new(translator).genQuery(stmt)
+		So(err, ShouldResemble, fmt.Errorf("unbound parameter ? in QUERY_STRING"))
+	})
+
+	Convey("Test registering a predicate function\n", t, func() {
+		fs := NewFunctionSet()
+		err := fs.RegisterPredicate(sql.FuncSignature{
+			Name: "PREFIX", Kind: sql.PredicateFunc, Args: []sql.ArgType{sql.FieldType, sql.StringType}, Returns: sql.BoolType,
+		}, func(call *sql.FuncCallExpr, value func(sql.Expr) (string, error)) (string, error) {
+			prefix, err := value(call.Args[1])
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf(`{"prefix": {"%s": %s}}`, call.Args[0], prefix), nil
+		})
+		So(err, ShouldBeNil)
+
+		stmt, err := sql.NewParser(strings.NewReader(`SELECT name FROM oilers WHERE prefix(name, 'Gr') AND pos = 'C'`)).Parse()
+		So(err, ShouldBeNil)
+		es, err := ElasticSearchQueryOptions(stmt, TranslateOptions{Functions: fs})
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"bool": {"must": [{"prefix": {"name": "Gr"}}], "filter": [{"term": {"pos": "C"}}]}}, "_source": ["name"]}`)
+
+		_, err = ElasticSearchQuery(stmt)
+		So(err.Error(), ShouldEqual, "unknown function prefix")
+
+		err = fs.RegisterPredicate(sql.FuncSignature{Name: "F", Kind: sql.ScalarFunc}, genFullText)
+		So(err, ShouldResemble, fmt.Errorf("function F is not a predicate"))
+	})
+
+	Convey("Test full-text predicate errors\n", t, func() {
+		for _, c := range []struct{ q, err string }{
+			{`FOO(quote, 'puck')`, "unknown function FOO"},
+			{`MATCH('puck')`, "MATCH expects 2 arguments, got 1"},
+			{`MATCH('quote', 'puck')`, "argument 1 of MATCH must be a field, got 'quote'"},
+			{`MULTI_MATCH('puck', 'quote')`, "argument 2 of MULTI_MATCH must be a field, got 'quote'"},
+			{`MULTI_MATCH('puck')`, "MULTI_MATCH expects at least 2 arguments, got 1"},
+			{`QUERY_STRING('puck', quote)`, "QUERY_STRING expects 1 argument, got 2"},
+			{`MATCH(quote, boost = 2, 'puck')`, "positional argument 'puck' follows named arguments in MATCH"},
+			{`MATCH_PHRASE(quote, 'puck', fuzziness = 1)`, "unexpected argument fuzziness to MATCH_PHRASE, expected one of slop, boost, analyzer"},
+			{`MATCH(quote, name)`, "argument 2 of MATCH must be a string, got name"},
+			{`MATCH(quote, 'puck', boost = name)`, "MATCH expects a string or number, got name"},
+			{`SCORE()`, "SCORE is not a predicate"},
+			{`goals = MATCH(quote, 'puck')`, "predicate MATCH cannot be used as a value"},
+		} {
+			_, err := testQuery(`SELECT * FROM oilers WHERE ` + c.q)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, c.err)
+		}
+	})
+}
@@ -0,0 +1,187 @@
+package essyntax
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// PredicateTranslator returns the elasticsearch query clause for a call to
+// a predicate function, whose arguments have already been checked against
+// its signature. value returns the JSON encoding of a literal or parameter
+// argument, or a placeholder for a parameter when preparing a template.
+type PredicateTranslator func(call *sql.FuncCallExpr, value func(sql.Expr) (string, error)) (string, error)
+
+// ScalarTranslator returns the elasticsearch script computing a call to a
+// scalar function for each document, e.g. {"source": "doc['goals'].value *
+// 2"}, whose arguments have already been checked against its signature. It
+// is used for calls in the select list, ORDER BY and GROUP BY. value is as
+// for a PredicateTranslator.
+type ScalarTranslator func(call *sql.FuncCallExpr, value func(sql.Expr) (string, error)) (string, error)
+
+// FunctionSet holds the functions understood by the translator: the
+// signatures of every function in a sql.FunctionRegistry, against which
+// statements are checked, along with the translators of the predicates and
+// of any scalar functions computed by scripts.
+type FunctionSet struct {
+	Registry *sql.FunctionRegistry
+
+	mu         sync.RWMutex
+	predicates map[string]PredicateTranslator
+	scalars    map[string]ScalarTranslator
+}
+
+// DefaultFunctions is the FunctionSet of translations which specify none.
+// Functions registered with it are available to every such translation.
+var DefaultFunctions = NewFunctionSet()
+
+// NewFunctionSet returns a FunctionSet holding the built in functions: the
+// standard aggregates, SCORE(), the date functions and the full-text
+// predicates.
+func NewFunctionSet() *FunctionSet {
+
+	fs := &FunctionSet{
+		Registry:   sql.NewFunctionRegistry(),
+		predicates: make(map[string]PredicateTranslator),
+		scalars:    make(map[string]ScalarTranslator),
+	}
+	fs.Registry.Register(sql.FuncSignature{Name: "SCORE", Kind: sql.ScalarFunc, Returns: sql.NumberType})
+	fs.Registry.Register(histogramFunc)
+	for _, sig := range dateFuncs {
+		fs.Registry.Register(sig)
+	}
+	for _, sig := range fullTextFuncs {
+		fs.RegisterPredicate(sig, genFullText)
+	}
+	for _, sig := range geoFuncs {
+		fs.Registry.Register(sig)
+	}
+	fs.RegisterPredicate(withinFunc, genWithin)
+	return fs
+}
+
+// RegisterPredicate adds a predicate function to the FunctionSet, replacing
+// any function of the same name.
+func (fs *FunctionSet) RegisterPredicate(sig sql.FuncSignature, tr PredicateTranslator) error {
+
+	if sig.Kind != sql.PredicateFunc {
+		return fmt.Errorf("function %s is not a predicate", sig.Name)
+	}
+	if err := fs.Registry.Register(sig); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.predicates[strings.ToUpper(sig.Name)] = tr
+	return nil
+}
+
+// predicate returns the translator of the named predicate function.
+func (fs *FunctionSet) predicate(name string) (PredicateTranslator, bool) {
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	tr, ok := fs.predicates[strings.ToUpper(name)]
+	return tr, ok
+}
+
+// RegisterScalar adds a scalar function computed by a script to the
+// FunctionSet, replacing any function of the same name.
+func (fs *FunctionSet) RegisterScalar(sig sql.FuncSignature, tr ScalarTranslator) error {
+
+	if sig.Kind != sql.ScalarFunc {
+		return fmt.Errorf("function %s is not a scalar function", sig.Name)
+	}
+	if err := fs.Registry.Register(sig); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.scalars[strings.ToUpper(sig.Name)] = tr
+	return nil
+}
+
+// scalar returns the translator of the named scalar function.
+func (fs *FunctionSet) scalar(name string) (ScalarTranslator, bool) {
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	tr, ok := fs.scalars[strings.ToUpper(name)]
+	return tr, ok
+}
+
+// functions returns the FunctionSet of the translation.
+func (t *translator) functions() *FunctionSet {
+	if t.opts.Functions != nil {
+		return t.opts.Functions
+	}
+	return DefaultFunctions
+}
+
+// genFuncClause creates the elasticsearch query clause for a predicate
+// function call, e.g. MATCH(quote, 'puck', operator = 'and').
+func (t *translator) genFuncClause(call *sql.FuncCallExpr) (string, error) {
+
+	tr, ok := t.functions().predicate(call.Name)
+	if !ok {
+		return "", fmt.Errorf("unsupported predicate function %s", call.Name)
+	}
+	return tr(call, func(v sql.Expr) (string, error) {
+		return t.genValue(v, func(v sql.Expr) (string, error) {
+			return genFuncValue(call.Name, v)
+		})
+	})
+}
+
+// genScalarScript returns the script computing an expression which calls a
+// scalar function with a translator, and false for any other expression.
+func (t *translator) genScalarScript(e sql.Expr) (string, bool, error) {
+
+	call, ok := e.(*sql.FuncCallExpr)
+	if !ok {
+		return "", false, nil
+	}
+	tr, ok := t.functions().scalar(call.Name)
+	if !ok {
+		return "", false, nil
+	}
+	script, err := tr(call, func(v sql.Expr) (string, error) {
+		return t.genValue(v, func(v sql.Expr) (string, error) {
+			return genFuncValue(call.Name, v)
+		})
+	})
+	return script, true, err
+}
+
+// genScriptSort returns the sort ordering hits by the script computing a
+// call to a scalar function with a translator, which must return a number
+// or a string, and false for any other expression.
+func (t *translator) genScriptSort(e sql.Expr, order string) (string, bool, error) {
+
+	call, ok := e.(*sql.FuncCallExpr)
+	if !ok {
+		return "", false, nil
+	}
+	if _, ok := t.functions().scalar(call.Name); !ok {
+		return "", false, nil
+	}
+	sig, _ := t.functions().Registry.Lookup(call.Name)
+	var typ string
+	switch sig.Returns {
+	case sql.NumberType:
+		typ = "number"
+	case sql.StringType:
+		typ = "string"
+	default:
+		return "", true, fmt.Errorf("cannot sort by %s, which returns a %s", sql.FormatExpr(call), sig.Returns)
+	}
+	script, _, err := t.genScalarScript(call)
+	if err != nil {
+		return "", true, err
+	}
+	return fmt.Sprintf(`{"_script": {"type": "%s", "script": %s, "order": "%s"}}`, typ, script, order), true, nil
+}
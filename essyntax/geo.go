@@ -0,0 +1,170 @@
+package essyntax
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// geoFuncs lists the signatures of the geo functions other than the
+// ST_WITHIN predicate. POINT(lon, lat) and BBOX(top_left, bottom_right) are
+// geo_point literals, and ST_DISTANCE(field, point) is the distance of a
+// geo_point field from a point, which may be compared with a distance in a
+// WHERE condition or sorted by.
+var geoFuncs = []sql.FuncSignature{
+	{Name: "POINT", Kind: sql.ScalarFunc, Args: []sql.ArgType{sql.NumberType, sql.NumberType}, Returns: sql.PointType},
+	{Name: "BBOX", Kind: sql.ScalarFunc, Args: []sql.ArgType{sql.PointType, sql.PointType}, Returns: sql.BoxType},
+	{Name: "ST_DISTANCE", Kind: sql.ScalarFunc, Args: []sql.ArgType{sql.FieldType, sql.PointType}, Returns: sql.NumberType},
+}
+
+// withinFunc is the signature of ST_WITHIN(field, box), which matches
+// documents whose geo_point field lies within a bounding box.
+var withinFunc = sql.FuncSignature{
+	Name: "ST_WITHIN", Kind: sql.PredicateFunc, Args: []sql.ArgType{sql.FieldType, sql.BoxType}, Returns: sql.BoolType,
+}
+
+// distancePattern matches the distances accepted by elasticsearch: a number
+// followed by an optional unit, which defaults to meters.
+var distancePattern = regexp.MustCompile(`^\d+(\.\d+)?(mi|miles|yd|yards|ft|feet|in|inch|km|kilometers|m|meters|cm|centimeters|mm|millimeters|NM|nmi|nauticalmiles)?$`)
+
+// isGeoCall returns true for a call to a function taking a geo_point field
+// as its first argument.
+func isGeoCall(call *sql.FuncCallExpr) bool {
+	name := strings.ToUpper(call.Name)
+	return name == "ST_DISTANCE" || name == withinFunc.Name
+}
+
+// isDistanceCall returns true for a call to ST_DISTANCE.
+func isDistanceCall(e sql.Expr) bool {
+	call, ok := e.(*sql.FuncCallExpr)
+	return ok && strings.ToUpper(call.Name) == "ST_DISTANCE"
+}
+
+// genWithin is the PredicateTranslator of ST_WITHIN, which translates to a
+// geo_bounding_box query.
+func genWithin(call *sql.FuncCallExpr, value func(sql.Expr) (string, error)) (string, error) {
+
+	box, ok := call.Args[1].(*sql.FuncCallExpr)
+	if !ok || strings.ToUpper(box.Name) != "BBOX" {
+		return "", fmt.Errorf("ST_WITHIN expects a BBOX, got %s", call.Args[1])
+	}
+	topLeft, err := genPoint(box.Args[0], value)
+	if err != nil {
+		return "", err
+	}
+	bottomRight, err := genPoint(box.Args[1], value)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`{"geo_bounding_box": {"%s": {"top_left": %s, "bottom_right": %s}}}`,
+		call.Args[0], topLeft, bottomRight), nil
+}
+
+// genPoint returns the geo_point object for a call to POINT, rendering its
+// coordinates with value. Literal coordinates are checked to be in range.
+func genPoint(e sql.Expr, value func(sql.Expr) (string, error)) (string, error) {
+
+	call, ok := e.(*sql.FuncCallExpr)
+	if !ok || strings.ToUpper(call.Name) != "POINT" {
+		return "", fmt.Errorf("expected a POINT, got %s", e)
+	}
+
+	coords := make([]string, 2)
+	for i, c := range []struct {
+		name  string
+		bound float64
+	}{{"longitude", 180}, {"latitude", 90}} {
+		if n, ok := call.Args[i].(*sql.NumExpr); ok && (n.Val < -c.bound || n.Val > c.bound) {
+			return "", fmt.Errorf("POINT %s must be between %v and %v, got %s", c.name, -c.bound, c.bound, sql.FormatExpr(n))
+		}
+		var err error
+		if coords[i], err = value(call.Args[i]); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf(`{"lon": %s, "lat": %s}`, coords[0], coords[1]), nil
+}
+
+// genPointValue returns the point for a call to POINT outside of a
+// predicate, whose coordinates may be bound parameters.
+func (t *translator) genPointValue(e sql.Expr) (string, error) {
+	return genPoint(e, func(v sql.Expr) (string, error) {
+		return t.genValue(v, func(v sql.Expr) (string, error) {
+			return genFuncValue("POINT", v)
+		})
+	})
+}
+
+// genDistanceClause returns the geo_distance query for a comparison of
+// ST_DISTANCE with a distance. A geo_distance query matches the points
+// within the distance, including those at exactly that distance, so that
+// < and <= are not told apart, nor are > and >=. A point beyond the distance
+// is matched by negating the query, and documents without a point, whose
+// distance is null, are excluded by an exists filter.
+func (t *translator) genDistanceClause(c *sql.CondFunc) (string, error) {
+
+	if !isDistanceCall(c.Call) {
+		return "", fmt.Errorf("unsupported comparison of %s", c.Call)
+	}
+
+	var outside bool
+	switch c.CondOp {
+	case sql.LT, sql.LE:
+	case sql.GT, sql.GE:
+		outside = true
+	default:
+		return "", fmt.Errorf("ST_DISTANCE can only be compared with <, <=, > or >=, got %s", c.CondOp.Text())
+	}
+
+	distance, err := t.genValue(c.Val, genDistance)
+	if err != nil {
+		return "", err
+	}
+	point, err := t.genPointValue(c.Call.Args[1])
+	if err != nil {
+		return "", err
+	}
+
+	field := c.Call.Args[0]
+	clause := fmt.Sprintf(`{"geo_distance": {"distance": %s, "%s": %s}}`, distance, field, point)
+	if outside {
+		return fmt.Sprintf(`{"bool": {"filter": {"exists": {"field": "%s"}}, "must_not": %s}}`, field, clause), nil
+	}
+	return clause, nil
+}
+
+// genDistance renders the distance ST_DISTANCE is compared with: a string
+// such as '10km', or a number of meters.
+func genDistance(val sql.Expr) (string, error) {
+
+	switch val := val.(type) {
+	case *sql.StringExpr:
+		if !distancePattern.MatchString(val.Unquoted()) {
+			return "", fmt.Errorf("invalid distance %s", val)
+		}
+		b, err := json.Marshal(val.Unquoted())
+		return string(b), err
+	case *sql.NumExpr:
+		if val.Val < 0 {
+			return "", fmt.Errorf("invalid distance %s", sql.FormatExpr(val))
+		}
+		return fmt.Sprintf(`"%sm"`, strconv.FormatFloat(val.Val, 'f', -1, 64)), nil
+	case *sql.ParamExpr:
+		return "", fmt.Errorf("unbound parameter %s in ST_DISTANCE", val)
+	}
+	return "", fmt.Errorf("ST_DISTANCE must be compared with a distance, got %s", val)
+}
+
+// genDistanceSort returns the _geo_distance sort by an ST_DISTANCE call.
+func (t *translator) genDistanceSort(call *sql.FuncCallExpr, order string) (string, error) {
+
+	point, err := t.genPointValue(call.Args[1])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`{"_geo_distance": {"%s": %s, "order": "%s", "unit": "m"}}`, call.Args[0], point, order), nil
+}
@@ -0,0 +1,102 @@
+package essyntax
+
+import (
+	"fmt"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+func TestGeo(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test geo distance predicates\n", t, func() {
+		es, err := testQuery(`SELECT name FROM oilers WHERE ST_DISTANCE(location, POINT(-113.49, 53.54)) < '10km'`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": `+
+			`{"geo_distance": {"distance": "10km", "location": {"lon": -113.49, "lat": 53.54}}}}}, "_source": ["name"]}`)
+		log.Debug(es)
+
+		for _, c := range []struct{ cond, clause string }{
+			{`st_distance(location, point(0, 0)) <= 1500.5`, `{"geo_distance": {"distance": "1500.5m", "location": {"lon": 0, "lat": 0}}}`},
+			{`ST_DISTANCE(location, POINT(1, 2)) < 1000000`, `{"geo_distance": {"distance": "1000000m", "location": {"lon": 1, "lat": 2}}}`},
+			{`ST_DISTANCE(location, POINT(180, -90)) > '5mi'`,
+				`{"bool": {"filter": {"exists": {"field": "location"}}, ` +
+					`"must_not": {"geo_distance": {"distance": "5mi", "location": {"lon": 180, "lat": -90}}}}}`},
+		} {
+			es, err := testQuery(`SELECT * FROM oilers WHERE ` + c.cond)
+			So(err, ShouldBeNil)
+			So(es, ShouldEqual, fmt.Sprintf(`{"query": {"constant_score": {"filter": %s}}}`, c.clause))
+		}
+
+		p, err := Prepare(`SELECT * FROM oilers WHERE ST_DISTANCE(location, POINT(?, ?)) < ?`)
+		So(err, ShouldBeNil)
+		es, err = p.Query(-113.49, 53.54, "2km")
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": `+
+			`{"geo_distance": {"distance": "2km", "location": {"lon": -113.49, "lat": 53.54}}}}}}`)
+	})
+
+	Convey("Test bounding box predicates\n", t, func() {
+		es, err := testQuery(`SELECT * FROM oilers WHERE ST_WITHIN(location, BBOX(POINT(-114, 54), POINT(-113, 53)))`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"geo_bounding_box": {"location": `+
+			`{"top_left": {"lon": -114, "lat": 54}, "bottom_right": {"lon": -113, "lat": 53}}}}}}}`)
+
+		es, err = testQuery(`SELECT * FROM oilers WHERE MATCH(quote, 'puck') AND ST_WITHIN(location, BBOX(POINT(-114, 54), POINT(-113, 53)))`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"bool": {"must": [{"match": {"quote": {"query": "puck"}}}], "filter": [`+
+			`{"geo_bounding_box": {"location": {"top_left": {"lon": -114, "lat": 54}, "bottom_right": {"lon": -113, "lat": 53}}}}]}}}`)
+	})
+
+	Convey("Test sorting by geo distance\n", t, func() {
+		es, err := testQuery(`SELECT name FROM oilers ORDER BY ST_DISTANCE(location, POINT(-113.49, 53.54)), name DESC`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "_source": ["name"], "sort": [`+
+			`{"_geo_distance": {"location": {"lon": -113.49, "lat": 53.54}, "order": "asc", "unit": "m"}}, `+
+			`{"name": {"order": "desc"}}]}`)
+
+		p, err := Prepare(`SELECT name FROM oilers WHERE pos = ? ORDER BY ST_DISTANCE(location, POINT(?, 53.54)) DESC`)
+		So(err, ShouldBeNil)
+		es, err = p.Query("C", 1.5)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"term": {"pos": "C"}}}}, "_source": ["name"], "sort": [`+
+			`{"_geo_distance": {"location": {"lon": 1.5, "lat": 53.54}, "order": "desc", "unit": "m"}}]}`)
+	})
+
+	Convey("Test geo errors\n", t, func() {
+		for _, c := range []struct{ q, err string }{
+			{`SELECT * FROM oilers WHERE ST_DISTANCE(location, POINT(-113.49, 53.54)) = '10km'`,
+				"ST_DISTANCE can only be compared with <, <=, > or >=, got ="},
+			{`SELECT * FROM oilers WHERE ST_DISTANCE(location, POINT(0, 0)) < '10 parsecs'`, "invalid distance '10 parsecs'"},
+			{`SELECT * FROM oilers WHERE ST_DISTANCE(location, POINT(0, 0)) < -1`, "invalid distance -1"},
+			{`SELECT * FROM oilers WHERE ST_DISTANCE(location, POINT(0, 0)) < location`, "ST_DISTANCE must be compared with a distance, got location"},
+			{`SELECT * FROM oilers WHERE ST_DISTANCE(location, POINT(0, 91)) < 1`, "POINT latitude must be between -90 and 90, got 91"},
+			{`SELECT * FROM oilers WHERE ST_WITHIN(location, BBOX(POINT(-181, 0), POINT(0, 0)))`, "POINT longitude must be between -180 and 180, got -181"},
+			{`SELECT * FROM oilers WHERE ST_DISTANCE(location, 'edmonton') < 1`, "argument 2 of ST_DISTANCE must be a point, got 'edmonton'"},
+			{`SELECT * FROM oilers WHERE ST_WITHIN(location, POINT(0, 0))`, "argument 2 of ST_WITHIN must be a bounding box, got POINT(0.000000, 0.000000)"},
+			{`SELECT * FROM oilers WHERE ST_DISTANCE(location, POINT(0, 0))`, "ST_DISTANCE is not a predicate"},
+			{`SELECT * FROM oilers WHERE SCORE() > 1`, "unsupported comparison of SCORE()"},
+			{`SELECT ST_DISTANCE(location, POINT(0, 0)) FROM oilers`, "cannot compute ST_DISTANCE(location, POINT(0.000000, 0.000000)) in a script field"},
+		} {
+			_, err := testQuery(c.q)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, c.err)
+		}
+	})
+
+	Convey("Test validating geo fields\n", t, func() {
+		So(testValidate(`SELECT name FROM oilers WHERE ST_DISTANCE(hometown, POINT(-80.26, 43.14)) < '100km' ORDER BY ST_DISTANCE(hometown, POINT(0, 0))`), ShouldBeNil)
+
+		err := testValidate(`SELECT name FROM oilers WHERE ST_WITHIN(goals, BBOX(POINT(0, 1), POINT(1, 0))) ORDER BY ST_DISTANCE(hometwon, POINT(0, 0))`)
+		So(validationMessages(err), ShouldResemble, []string{
+			"1:41: ST_WITHIN of long field goals, which is not a geo_point",
+			"1:101: unknown column hometwon (did you mean hometown?)",
+		})
+	})
+}
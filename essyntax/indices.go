@@ -0,0 +1,149 @@
+package essyntax
+
+import (
+	"strings"
+
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// indexField is the pseudo-column holding the name of the index of each hit.
+// It may be selected, and compared in WHERE conditions as a keyword field.
+const indexField = "_index"
+
+// indexFieldInfo describes indexField as a field of every index.
+var indexFieldInfo = FieldInfo{Path: indexField, Type: "keyword"}
+
+// selectsIndex returns true if the select list includes indexField.
+func selectsIndex(fields sql.Fields) bool {
+	for _, f := range fields {
+		if f.Expr == nil && f.Name == indexField {
+			return true
+		}
+	}
+	return false
+}
+
+// unqualifyStatement returns a copy of the specified statement in which
+// fields qualified by the alias of one of its tables are named by their path
+// alone, in the select list, WHERE, GROUP BY and ORDER BY alike. A plain
+// field so renamed keeps its qualified name as its alias, so that the column
+// is still named as selected.
+func unqualifyStatement(s *sql.SelectStatement) *sql.SelectStatement {
+
+	if !hasTableAlias(s.TableList) {
+		return s
+	}
+	rename := func(name string) string { return unqualifyName(name, s.TableList) }
+
+	u := *s
+	u.FieldList = make(sql.Fields, len(s.FieldList))
+	for i, f := range s.FieldList {
+		if f.Expr != nil {
+			f.Expr = mapExprFields(f.Expr, rename)
+		} else if name := rename(f.Name); name != f.Name {
+			if len(f.Alias) == 0 && name != "*" {
+				f.Alias = f.Name
+			}
+			f.Name = name
+		}
+		u.FieldList[i] = f
+	}
+	u.WhereCond = unqualifyCond(s.WhereCond, s.TableList)
+	u.GroupBy = make([]sql.Expr, len(s.GroupBy))
+	for i, e := range s.GroupBy {
+		u.GroupBy[i] = mapExprFields(e, rename)
+	}
+	u.OrderBy = make([]sql.OrderItem, len(s.OrderBy))
+	for i, o := range s.OrderBy {
+		u.OrderBy[i] = sql.OrderItem{Expr: mapExprFields(o.Expr, rename), Desc: o.Desc}
+	}
+	return &u
+}
+
+// unqualifyCond returns a copy of the specified condition in which fields
+// qualified by the alias of one of the tables, as in o.goals for FROM oilers
+// o, are named by their path alone.
+func unqualifyCond(cond sql.Cond, tables sql.Fields) sql.Cond {
+
+	if hasTableAlias(tables) {
+		return mapCondFields(cond, func(name string) string { return unqualifyName(name, tables) })
+	}
+	return cond
+}
+
+// hasTableAlias returns true if any of the tables has an alias.
+func hasTableAlias(tables sql.Fields) bool {
+	for _, t := range tables {
+		if len(t.Alias) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// unqualifyName returns the path of a field, stripping any qualifier naming
+// the alias of one of the tables. An alias thus hides any object field of
+// the same name.
+func unqualifyName(name string, tables sql.Fields) string {
+
+	for _, t := range tables {
+		if len(t.Alias) > 0 && strings.HasPrefix(name, t.Alias+".") {
+			return name[len(t.Alias)+1:]
+		}
+	}
+	return name
+}
+
+// mapCondFields returns a copy of the specified condition in which the name
+// of each field is replaced by the result of rename. The conditions of any
+// EXISTS or IN subqueries within it are left as they are, since their fields
+// are named relative to the tables of those subqueries.
+func mapCondFields(cond sql.Cond, rename func(string) string) sql.Cond {
+
+	expr := func(e sql.Expr) sql.Expr { return mapExprFields(e, rename) }
+
+	switch c := cond.(type) {
+	case *sql.CondConj:
+		conj := *c
+		if c.Left != nil {
+			conj.Left = mapCondFields(c.Left, rename)
+		}
+		if c.Right != nil {
+			conj.Right = mapCondFields(c.Right, rename)
+		}
+		return &conj
+	case *sql.CondComp:
+		comp := *c
+		comp.Ident, comp.Val = rename(c.Ident), expr(c.Val)
+		return &comp
+	case *sql.CondIn:
+		in := *c
+		in.Ident = rename(c.Ident)
+		in.Vals = make([]sql.Expr, len(c.Vals))
+		for i, v := range c.Vals {
+			in.Vals[i] = expr(v)
+		}
+		return &in
+	case *sql.CondFunc:
+		f := *c
+		f.Call, f.Val = expr(c.Call).(*sql.FuncCallExpr), expr(c.Val)
+		return &f
+	}
+	return cond
+}
+
+// mapExprFields returns a copy of the specified expression in which the name
+// of each field is replaced by the result of rename. A subquery is left as
+// it is, as by mapCondFields.
+func mapExprFields(e sql.Expr, rename func(string) string) sql.Expr {
+
+	if _, ok := e.(*sql.SubqueryExpr); ok || e == nil {
+		return e
+	}
+	return sql.Rewrite(e, func(n sql.Node) sql.Node {
+		if ident, ok := n.(*sql.IdentExpr); ok && ident.Name != "*" {
+			return &sql.IdentExpr{Name: rename(ident.Name), Pos: ident.Pos}
+		}
+		return n
+	}).(sql.Expr)
+}
@@ -0,0 +1,120 @@
+package essyntax
+
+import (
+	"fmt"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	"github.com/oldenbur/sql-parser/sql"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+func TestIndices(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test searching several indices\n", t, func() {
+		fake := newFakeES(`{"hits": {"total": 2, "hits": [
+			{"_index": "logs-2024.01", "_source": {"name": "Wayne Gretzky"}},
+			{"_index": "oilers", "_source": {"name": "Mark Messier"}}]}}`)
+		defer fake.Close()
+
+		rs, err := NewExecutor(fake.conn(), nil).Query(`SELECT _index, name FROM logs-2024.*, oilers WHERE _index != 'flames'`)
+		So(err, ShouldBeNil)
+		So(fake.paths, ShouldContain, "POST /logs-2024.*,oilers/_search")
+		So(fake.bodies[len(fake.bodies)-1], ShouldContainSubstring,
+			`{"query": {"constant_score": {"filter": {"bool": {"must_not": {"term": {"_index": "flames"}}}}}}, "_source": ["name"]`)
+		So(rs.Columns, ShouldResemble, []Column{
+			{Name: "_index", Field: "_index", Type: TypeString},
+			{Name: "name", Field: "name", Type: TypeString}})
+		So(rs.Rows, ShouldResemble, [][]interface{}{{"logs-2024.01", "Wayne Gretzky"}, {"oilers", "Mark Messier"}})
+		log.Debug(rs.Rows)
+
+		es, err := testQuery(`SELECT _index FROM oilers`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "_source": false}`)
+	})
+
+	Convey("Test qualifying fields by table aliases\n", t, func() {
+		es, err := testQuery(`SELECT name FROM oilers o, flames f WHERE o.goals > 50 AND f.pos IN ('C', 'LW') AND MATCH(o.quote, 'puck')`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"bool": {"must": [{"bool": {"must": [{"match": {"quote": {"query": "puck"}}}], `+
+			`"filter": [{"terms": {"pos": ["C", "LW"]}}]}}], "filter": [{"range": {"goals": {"gt": 50}}}]}}, "_source": ["name"]}`)
+
+		es, err = testQuery(`SELECT * FROM oilers WHERE oilers.stats.ppg > 1`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"range": {"oilers.stats.ppg": {"gt": 1}}}}}}`)
+
+		es, err = testQuery(`SELECT * FROM oilers o WHERE EXISTS (SELECT * FROM o.linemates l WHERE l.name = 'Jari Kurri')`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": `+
+			`{"nested": {"path": "linemates", "query": {"term": {"linemates.name": "Jari Kurri"}}}}}}}`)
+
+		es, err = testQuery(`SELECT o.name, o.goals g FROM oilers o ORDER BY o.goals DESC`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"match_all": {}}, "_source": ["name", "goals"], "sort": [{"goals": {"order": "desc"}}]}`)
+
+		fake := newFakeES(oilersHits)
+		defer fake.Close()
+		rs, err := NewExecutor(fake.conn(), nil).Query(`SELECT o.name FROM oilers o`)
+		So(err, ShouldBeNil)
+		So(rs.Columns, ShouldResemble, []Column{{Name: "o.name", Field: "name", Type: TypeString}})
+		So(rs.Rows[0], ShouldResemble, []interface{}{"Wayne Gretzky"})
+	})
+
+	Convey("Test resolving index patterns in an offline catalog\n", t, func() {
+		c, err := NewCatalogFromFile("testdata/mapping.json")
+		So(err, ShouldBeNil)
+
+		So(c.Load(sql.Fields{{Name: "*s"}, {Name: "f*"}}), ShouldBeNil)
+		names, ok := c.Resolve("*s")
+		So(ok, ShouldBeTrue)
+		So(names, ShouldResemble, []string{"flames", "jets", "oilers"})
+		names, _ = c.Resolve("f*")
+		So(names, ShouldResemble, []string{"flames"})
+		names, _ = c.Resolve("oilers")
+		So(names, ShouldResemble, []string{"oilers"})
+		_, ok = c.Resolve("kings")
+		So(ok, ShouldBeFalse)
+
+		f, ok := c.Field("f*", "drafted")
+		So(ok, ShouldBeTrue)
+		So(f.Type, ShouldEqual, "date")
+		f, ok = c.Field("oilers", "_index")
+		So(ok, ShouldBeTrue)
+		So(f, ShouldResemble, FieldInfo{Path: "_index", Type: "keyword"})
+		_, ok = c.Field("kings", "_index")
+		So(ok, ShouldBeFalse)
+
+		So(c.Load(testTables(`SELECT * FROM k*`)), ShouldResemble, fmt.Errorf("no mapping for index k* in offline catalog"))
+
+		es, err := testQueryOptions(`SELECT * FROM oil* WHERE _index = 'oilers' AND name = 'Wayne Gretzky'`, TranslateOptions{Catalog: c})
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"bool": {"must": [`+
+			`{"term": {"_index": "oilers"}}, {"term": {"name.keyword": "Wayne Gretzky"}}]}}}}}`)
+
+		for _, c := range []struct{ pattern, name string }{
+			{"*", "oilers"}, {"oil*", "oilers"}, {"*ers", "oilers"}, {"o*l*s", "oilers"}, {"oilers", "oilers"},
+		} {
+			So(matchIndex(c.pattern, c.name), ShouldBeTrue)
+		}
+		for _, c := range []struct{ pattern, name string }{
+			{"oil", "oilers"}, {"*x*", "oilers"}, {"o*e", "oilers"}, {"oilers*s", "oilers"},
+		} {
+			So(matchIndex(c.pattern, c.name), ShouldBeFalse)
+		}
+	})
+
+	Convey("Test validating _index and qualified fields\n", t, func() {
+		So(testValidate(`SELECT _index, name FROM oilers o WHERE _index IN ('oilers') AND o.goals > 50 AND _index LIKE 'oil%'`), ShouldBeNil)
+
+		err := testValidate(`SELECT name FROM oilers o WHERE _index = 'oiler' AND o.gaols > 50`)
+		So(validationMessages(err), ShouldResemble, []string{
+			"1:33: index oiler is not selected by FROM (did you mean oilers?)",
+			"1:54: unknown column gaols (did you mean goals?)",
+		})
+	})
+}
@@ -0,0 +1,617 @@
+package essyntax
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// JoinOptions control the execution of statements with joins, which
+// elasticsearch cannot run itself. The rows of each table are fetched with
+// the predicates on that table pushed into its query, and joined in memory.
+type JoinOptions struct {
+	// MaxRows bounds both the rows fetched from each table and the joined
+	// rows built from them.
+	MaxRows int
+}
+
+// joinTable is a table of a join, with the part of the statement which is
+// pushed into its query.
+type joinTable struct {
+	name      string
+	qualifier string // the alias of the table, or its name
+	left      bool   // whether it is joined by a LEFT JOIN
+	keys      []joinKey
+	conds     []sql.Cond // predicates on the table alone
+	fields    []string   // paths of the fields to fetch
+	all       bool       // whether to fetch every field
+}
+
+// joinKey is an equality of the ON condition of a join, between a field of
+// the joined table and a field of an earlier table.
+type joinKey struct {
+	field string
+	table int
+	other string
+}
+
+// joinColumn is a field of the select list or ORDER BY clause of a join,
+// qualified by its table.
+type joinColumn struct {
+	table int
+	path  string
+}
+
+// joinPlan is a statement with joins, split into the queries of its tables
+// and the work done in memory.
+type joinPlan struct {
+	stmt   *sql.SelectStatement
+	tables []joinTable
+}
+
+// planJoin splits a statement with joins into the queries of its tables.
+// Every field must be qualified by the alias or name of a table, and the
+// ON condition of each join must be an AND of equalities between a field of
+// the joined table and one of an earlier table, and of predicates on the
+// joined table alone. The WHERE condition must be an AND of predicates on a
+// single table each. A WHERE predicate on a LEFT-joined table drops its
+// unmatched rows, so that the join becomes an INNER JOIN.
+func planJoin(s *sql.SelectStatement) (*joinPlan, error) {
+
+	if len(s.GroupBy) > 0 || isAggregate(s) {
+		return nil, fmt.Errorf("JOIN cannot be combined with GROUP BY or aggregate functions")
+	}
+
+	p := &joinPlan{stmt: s}
+	tables := s.Tables()
+	for i, t := range tables {
+		q := t.Alias
+		if len(q) == 0 {
+			q = t.Name
+		}
+		for _, other := range p.tables {
+			if other.qualifier == q {
+				return nil, fmt.Errorf("table %s is named more than once in the JOIN, and needs an alias", q)
+			}
+		}
+		jt := joinTable{name: t.Name, qualifier: q}
+		if i > 0 {
+			jt.left = s.Joins[i-1].Left
+		}
+		p.tables = append(p.tables, jt)
+	}
+
+	for _, f := range s.FieldList {
+		if f.Expr != nil {
+			return nil, fmt.Errorf("JOIN cannot select computed column %s", sql.FormatExpr(f.Expr))
+		}
+		if f.Name == "*" {
+			for i := range p.tables {
+				p.tables[i].all = true
+			}
+			continue
+		}
+		if i, ok := p.qualifierTable(f.Name); ok {
+			p.tables[i].all = true
+			continue
+		}
+		if _, err := p.column(f.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, o := range s.OrderBy {
+		ident, ok := o.Expr.(*sql.IdentExpr)
+		if !ok {
+			return nil, fmt.Errorf("JOIN can only be ordered by columns, not %s", sql.FormatExpr(o.Expr))
+		}
+		if _, err := p.column(fieldName(s.FieldList, ident.Name)); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, j := range s.Joins {
+		jt := &p.tables[i+1]
+		for _, c := range andOperands(j.On) {
+			if key, ok := p.equality(c, i+1); ok {
+				jt.keys = append(jt.keys, key)
+				p.fetch(i+1, key.field)
+				p.fetch(key.table, key.other)
+				continue
+			}
+			refs, err := p.condTables(c)
+			if err != nil {
+				return nil, err
+			}
+			if len(refs) != 1 || !refs[i+1] {
+				return nil, fmt.Errorf("ON condition %s must compare %s with an earlier table, or test %s alone", sql.FormatCond(c), jt.qualifier, jt.qualifier)
+			}
+			jt.conds = append(jt.conds, c)
+		}
+		if len(jt.keys) == 0 {
+			return nil, fmt.Errorf("JOIN %s has no equality between its fields and those of an earlier table", jt.qualifier)
+		}
+	}
+
+	for _, c := range andOperands(s.WhereCond) {
+		refs, err := p.condTables(c)
+		if err != nil {
+			return nil, err
+		}
+		if len(refs) > 1 {
+			return nil, fmt.Errorf("WHERE condition %s tests several tables of the JOIN", sql.FormatCond(c))
+		}
+		i := 0
+		for t := range refs {
+			i = t
+		}
+		p.tables[i].conds = append(p.tables[i].conds, c)
+		p.tables[i].left = false
+	}
+
+	return p, nil
+}
+
+// andOperands returns the operands of a chain of ANDed conditions.
+func andOperands(cond sql.Cond) []sql.Cond {
+
+	if and, ok := cond.(*sql.CondConj); ok && and.Op == sql.AND && and.Left != nil && and.Right != nil {
+		return append(andOperands(and.Left), andOperands(and.Right)...)
+	}
+	if cond == nil {
+		return nil
+	}
+	return []sql.Cond{cond}
+}
+
+// qualifierTable returns the table qualifying a name of the form q.*.
+func (p *joinPlan) qualifierTable(name string) (int, bool) {
+
+	if !strings.HasSuffix(name, ".*") {
+		return 0, false
+	}
+	for i, t := range p.tables {
+		if t.qualifier == name[:len(name)-2] {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// column resolves a qualified field name to its table and path, marking the
+// field to be fetched.
+func (p *joinPlan) column(name string) (joinColumn, error) {
+
+	for i, t := range p.tables {
+		if strings.HasPrefix(name, t.qualifier+".") {
+			col := joinColumn{table: i, path: name[len(t.qualifier)+1:]}
+			p.fetch(i, col.path)
+			return col, nil
+		}
+	}
+	return joinColumn{}, fmt.Errorf("column %s must be qualified by a table of the JOIN", name)
+}
+
+// fetch adds a field to those fetched from a table.
+func (p *joinPlan) fetch(table int, path string) {
+
+	t := &p.tables[table]
+	for _, f := range t.fields {
+		if f == path {
+			return
+		}
+	}
+	t.fields = append(t.fields, path)
+}
+
+// equality returns the key of an equality between a field of the joined
+// table and a field of an earlier table.
+func (p *joinPlan) equality(cond sql.Cond, joined int) (joinKey, bool) {
+
+	comp, ok := cond.(*sql.CondComp)
+	if !ok || comp.CondOp != sql.EQ {
+		return joinKey{}, false
+	}
+	ident, ok := comp.Val.(*sql.IdentExpr)
+	if !ok {
+		return joinKey{}, false
+	}
+	left, err := p.column(comp.Ident)
+	if err != nil {
+		return joinKey{}, false
+	}
+	right, err := p.column(ident.Name)
+	if err != nil {
+		return joinKey{}, false
+	}
+	switch {
+	case left.table == joined && right.table < joined:
+		return joinKey{field: left.path, table: right.table, other: right.path}, true
+	case right.table == joined && left.table < joined:
+		return joinKey{field: right.path, table: left.table, other: left.path}, true
+	}
+	return joinKey{}, false
+}
+
+// condTables returns the tables whose fields are tested by a condition,
+// excluding the fields of its IN subqueries, which are run separately.
+func (p *joinPlan) condTables(cond sql.Cond) (map[int]bool, error) {
+
+	refs := make(map[int]bool)
+	var err error
+	ref := func(name string) {
+		for i, t := range p.tables {
+			if name == t.qualifier || strings.HasPrefix(name, t.qualifier+".") {
+				refs[i] = true
+				return
+			}
+		}
+		if err == nil {
+			err = fmt.Errorf("column %s must be qualified by a table of the JOIN", name)
+		}
+	}
+	sql.Inspect(cond, func(n sql.Node) bool {
+		switch n := n.(type) {
+		case *sql.CondComp:
+			ref(n.Ident)
+		case *sql.CondIn:
+			ref(n.Ident)
+		case *sql.IdentExpr:
+			if n.Name != "*" {
+				ref(n.Name)
+			}
+		case *sql.CondExists:
+			for _, t := range n.Select.TableList {
+				ref(t.Name)
+			}
+			return false
+		case *sql.SubqueryExpr:
+			return false
+		}
+		return true
+	})
+	return refs, err
+}
+
+// sideQuery returns the statement fetching the rows of a table, which is
+// qualified by its own alias so that its fields are named by their paths.
+// Fields within an object field which is fetched are fetched with it.
+func (p *joinPlan) sideQuery(i int, limit int) *sql.SelectStatement {
+
+	t := p.tables[i]
+	s := &sql.SelectStatement{
+		TableList: sql.Fields{{Name: t.name, Alias: t.qualifier}},
+		Limit:     &sql.NumExpr{Val: float64(limit)},
+	}
+	if t.all {
+		s.FieldList = sql.Fields{{Name: "*"}}
+	} else {
+	fields:
+		for _, f := range t.fields {
+			for _, other := range t.fields {
+				if strings.HasPrefix(f, other+".") {
+					continue fields
+				}
+			}
+			s.FieldList = append(s.FieldList, sql.Field{Name: f})
+		}
+	}
+	for j := len(t.conds) - 1; j >= 0; j-- {
+		if s.WhereCond == nil {
+			s.WhereCond = t.conds[j]
+		} else {
+			s.WhereCond = &sql.CondConj{Op: sql.AND, Left: t.conds[j], Right: s.WhereCond}
+		}
+	}
+	return s
+}
+
+// joinSide holds the rows fetched from a table, each keyed by field path.
+type joinSide struct {
+	cols []Column
+	rows []map[string]interface{}
+}
+
+// streamJoin executes a statement with joins, whose parameters must be
+// bound, and returns a Stream of the joined rows.
+func (e *Executor) streamJoin(ctx context.Context, s *sql.SelectStatement) (*Stream, error) {
+
+	p, err := planJoin(s)
+	if err != nil {
+		return nil, err
+	}
+	max := memoryLimit(e.Join.MaxRows)
+	translate := e.translateOptions()
+
+	sides := make([]joinSide, len(p.tables))
+	for i, t := range p.tables {
+		side := p.sideQuery(i, max+1)
+		query, err := (&translator{opts: translate}).genQuery(side)
+		if err != nil {
+			return nil, err
+		}
+		if sides[i], err = e.fetchSide(ctx, side, query); err != nil {
+			return nil, err
+		}
+		if len(sides[i].rows) > max {
+			return nil, fmt.Errorf("JOIN table %s has more than %d matching rows", t.qualifier, max)
+		}
+	}
+
+	joined, err := p.hashJoin(sides, max)
+	if err != nil {
+		return nil, err
+	}
+	cols, rows, err := p.output(sides, joined)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.rowStream(ctx, s, cols, rows, len(rows))
+}
+
+// rowStream returns a Stream of rows computed in memory for a statement,
+// limited by its LIMIT.
+func (e *Executor) rowStream(ctx context.Context, s *sql.SelectStatement, cols []Column, rows [][]interface{}, total int) (*Stream, error) {
+
+	dec := &Decoder{stmt: s, opts: e.Decoding, limit: -1, cols: cols, resolved: true, rows: rows}
+	if s.Limit != nil {
+		n, ok := s.Limit.(*sql.NumExpr)
+		if !ok {
+			return nil, fmt.Errorf("statement has unbound LIMIT %s", sql.FormatExpr(s.Limit))
+		}
+		dec.limit = int(n.Val)
+	}
+	st := &Stream{Total: total, ctx: ctx, dec: dec, last: true, done: make(chan struct{})}
+	st.release()
+	return st, nil
+}
+
+// fetchSide returns every row returned by the query of a table of a join.
+func (e *Executor) fetchSide(ctx context.Context, s *sql.SelectStatement, query string) (joinSide, error) {
+
+	var side joinSide
+	st, err := e.stream(ctx, s, query)
+	if err != nil {
+		return side, err
+	}
+	defer st.Close()
+
+	for {
+		row, err := st.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return side, err
+		}
+		m := make(map[string]interface{}, len(row))
+		for i, col := range st.Columns() {
+			m[col.Field] = row[i]
+		}
+		side.rows = append(side.rows, m)
+	}
+	side.cols = st.Columns()
+	return side, nil
+}
+
+// hashJoin joins the rows of the tables, returning for each joined row the
+// index of its row in each table, or -1 for the missing row of a LEFT JOIN.
+// The rows of each joined table are hashed by their key fields, and probed
+// with the keys of the rows joined so far.
+func (p *joinPlan) hashJoin(sides []joinSide, maxRows int) ([][]int, error) {
+
+	joined := make([][]int, len(sides[0].rows))
+	for r := range sides[0].rows {
+		joined[r] = []int{r}
+	}
+
+	for i := 1; i < len(p.tables); i++ {
+		t := p.tables[i]
+
+		hash := make(map[string][]int)
+		for r, row := range sides[i].rows {
+			vals := make([]interface{}, len(t.keys))
+			for k, key := range t.keys {
+				vals[k] = row[key.field]
+			}
+			if h, ok := joinHash(vals); ok {
+				hash[h] = append(hash[h], r)
+			}
+		}
+
+		var next [][]int
+		for _, jr := range joined {
+			vals := make([]interface{}, len(t.keys))
+			for k, key := range t.keys {
+				if r := jr[key.table]; r >= 0 {
+					vals[k] = sides[key.table].rows[r][key.other]
+				}
+			}
+			var matches []int
+			if h, ok := joinHash(vals); ok {
+				matches = hash[h]
+			}
+			if len(matches) == 0 && t.left {
+				matches = []int{-1}
+			}
+			for _, r := range matches {
+				next = append(next, append(jr[:i:i], r))
+			}
+			if len(next) > maxRows {
+				return nil, fmt.Errorf("JOIN produces more than %d rows", maxRows)
+			}
+		}
+		joined = next
+	}
+
+	return joined, nil
+}
+
+// joinHash returns the hash key of the key values of a row, and false if
+// any of them is missing or multi-valued, which matches no other row.
+// Integers are hashed as doubles, so that 1 matches 1.0.
+func joinHash(vals []interface{}) (string, bool) {
+
+	parts := make([]string, len(vals))
+	for i, v := range vals {
+		switch n := v.(type) {
+		case nil, []interface{}:
+			return "", false
+		case int64:
+			v = float64(n)
+		}
+		parts[i] = fmt.Sprintf("%T:%v", v, v)
+	}
+	return strings.Join(parts, "\x00"), true
+}
+
+// output returns the columns of the select list and the joined rows, in
+// ORDER BY order. A * field expands to every fetched field of each table,
+// q.* to those of table q, and an object field to each of its leaf fields,
+// each named by its qualified path unless aliased.
+func (p *joinPlan) output(sides []joinSide, joined [][]int) ([]Column, [][]interface{}, error) {
+
+	var cols []Column
+	var from []joinColumn
+	expand := func(table int, prefix, name string) {
+		for _, c := range sides[table].cols {
+			if len(prefix) == 0 || c.Field == prefix || strings.HasPrefix(c.Field, prefix+".") {
+				cols = append(cols, Column{Name: name + c.Field[len(prefix):], Field: p.tables[table].qualifier + "." + c.Field})
+				from = append(from, joinColumn{table: table, path: c.Field})
+			}
+		}
+	}
+
+	for _, f := range p.stmt.FieldList {
+		if f.Name == "*" {
+			for i, t := range p.tables {
+				expand(i, "", t.qualifier+".")
+			}
+			continue
+		}
+		if i, ok := p.qualifierTable(f.Name); ok {
+			expand(i, "", p.tables[i].qualifier+".")
+			continue
+		}
+		col, err := p.column(f.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		name := f.Name
+		if len(f.Alias) > 0 {
+			name = f.Alias
+		}
+		n := len(cols)
+		if !hasField(sides[col.table].cols, col.path) {
+			expand(col.table, col.path, name)
+		}
+		if len(cols) == n {
+			cols = append(cols, Column{Name: name, Field: f.Name})
+			from = append(from, col)
+		}
+	}
+
+	value := func(jr []int, c joinColumn) interface{} {
+		if r := jr[c.table]; r >= 0 {
+			return sides[c.table].rows[r][c.path]
+		}
+		return nil
+	}
+
+	var order []joinColumn
+	for _, o := range p.stmt.OrderBy {
+		col, err := p.column(fieldName(p.stmt.FieldList, o.Expr.(*sql.IdentExpr).Name))
+		if err != nil {
+			return nil, nil, err
+		}
+		order = append(order, col)
+	}
+	sort.SliceStable(joined, func(a, b int) bool {
+		for k, o := range p.stmt.OrderBy {
+			c := compareValues(value(joined[a], order[k]), value(joined[b], order[k]))
+			if c != 0 {
+				return (c < 0) != o.Desc
+			}
+		}
+		return false
+	})
+
+	rows := make([][]interface{}, len(joined))
+	for r, jr := range joined {
+		rows[r] = make([]interface{}, len(cols))
+		for i, c := range from {
+			rows[r][i] = value(jr, c)
+		}
+	}
+	inferTypes(cols, rows)
+
+	return cols, rows, nil
+}
+
+// hasField returns true if one of the columns holds the field with the
+// specified path as a leaf.
+func hasField(cols []Column, path string) bool {
+	for _, c := range cols {
+		if c.Field == path {
+			return true
+		}
+	}
+	return false
+}
+
+// compareValues orders the values of a column: nil first, then numbers,
+// strings, booleans and dates, each in their natural order. Values of other
+// types compare equal.
+func compareValues(a, b interface{}) int {
+
+	rank := func(v interface{}) (int, float64) {
+		switch v := v.(type) {
+		case nil:
+			return 0, 0
+		case int64:
+			return 1, float64(v)
+		case float64:
+			return 1, v
+		case string:
+			return 2, 0
+		case bool:
+			return 3, 0
+		case time.Time:
+			return 4, 0
+		}
+		return 5, 0
+	}
+
+	ra, fa := rank(a)
+	rb, fb := rank(b)
+	switch {
+	case ra != rb:
+		return ra - rb
+	case ra == 1 && fa != fb:
+		if fa < fb {
+			return -1
+		}
+		return 1
+	case ra == 2:
+		return strings.Compare(a.(string), b.(string))
+	case ra == 3 && a != b:
+		if !a.(bool) {
+			return -1
+		}
+		return 1
+	case ra == 4:
+		ta, tb := a.(time.Time), b.(time.Time)
+		if ta.Before(tb) {
+			return -1
+		} else if tb.Before(ta) {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,143 @@
+package essyntax
+
+import (
+	"fmt"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+const joinPlayers = `{"hits": {"total": 4, "hits": [
+	{"_source": {"name": "Wayne Gretzky", "team": "EDM", "goals": 92}},
+	{"_source": {"name": "Lanny McDonald", "team": "CGY", "goals": 66}},
+	{"_source": {"name": "Dale Hawerchuk", "team": "WPG", "goals": 53}},
+	{"_source": {"name": "Mike Bossy", "goals": 64}}]}}`
+
+const joinTeams = `{"hits": {"total": 3, "hits": [
+	{"_source": {"code": "EDM", "city": "Edmonton", "arena": {"name": "Northlands", "seats": 17498}}},
+	{"_source": {"code": "CGY", "city": "Calgary", "arena": {"name": "Saddledome", "seats": 19289}}},
+	{"_source": {"code": "EDM", "city": "Oklahoma City"}}]}}`
+
+func TestJoin(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test executing an INNER JOIN\n", t, func() {
+		fake := newFakeES("")
+		fake.responses = []string{joinPlayers, joinTeams}
+		defer fake.Close()
+
+		rs, err := NewExecutor(fake.conn(), nil).Query(`SELECT p.name, t.city c FROM oilers p JOIN teams t `+
+			`ON p.team = t.code AND t.city != 'Winnipeg' WHERE p.goals > ? ORDER BY c DESC, p.name`, 50)
+		So(err, ShouldBeNil)
+		So(fake.paths, ShouldContain, "POST /oilers/_search")
+		So(fake.paths, ShouldContain, "POST /teams/_search")
+		So(fake.bodies[len(fake.bodies)-2], ShouldContainSubstring,
+			`{"query": {"constant_score": {"filter": {"range": {"goals": {"gt": 50}}}}}, "_source": ["name", "team"]`)
+		So(fake.bodies[len(fake.bodies)-1], ShouldContainSubstring,
+			`{"query": {"constant_score": {"filter": {"bool": {"must_not": {"term": {"city": "Winnipeg"}}}}}}, "_source": ["city", "code"]`)
+		So(rs.Columns, ShouldResemble, []Column{
+			{Name: "p.name", Field: "p.name", Type: TypeString},
+			{Name: "c", Field: "t.city", Type: TypeString}})
+		So(rs.Rows, ShouldResemble, [][]interface{}{
+			{"Wayne Gretzky", "Oklahoma City"}, {"Wayne Gretzky", "Edmonton"}, {"Lanny McDonald", "Calgary"}})
+		So(rs.Total, ShouldEqual, 3)
+		log.Debug(rs.Rows)
+	})
+
+	Convey("Test executing a LEFT JOIN\n", t, func() {
+		fake := newFakeES("")
+		fake.responses = []string{joinPlayers, joinTeams}
+		defer fake.Close()
+
+		rs, err := NewExecutor(fake.conn(), nil).Query(`SELECT p.name, t.arena FROM oilers p LEFT JOIN teams t ` +
+			`ON t.code = p.team ORDER BY t.arena.seats DESC, p.name LIMIT 4`)
+		So(err, ShouldBeNil)
+		So(rs.Columns, ShouldResemble, []Column{
+			{Name: "p.name", Field: "p.name", Type: TypeString},
+			{Name: "t.arena.name", Field: "t.arena.name", Type: TypeString},
+			{Name: "t.arena.seats", Field: "t.arena.seats", Type: TypeLong}})
+		So(rs.Rows, ShouldResemble, [][]interface{}{
+			{"Lanny McDonald", "Saddledome", int64(19289)}, {"Wayne Gretzky", "Northlands", int64(17498)},
+			{"Dale Hawerchuk", nil, nil}, {"Mike Bossy", nil, nil}})
+		So(rs.Total, ShouldEqual, 5)
+
+		fake.responses = []string{joinPlayers, `{"hits": {"total": 1, "hits": [{"_source": {"code": "EDM"}}]}}`}
+		rs, err = NewExecutor(fake.conn(), nil).Query(`SELECT p.name FROM oilers p LEFT JOIN teams t ` +
+			`ON t.code = p.team WHERE t.city = 'Edmonton'`)
+		So(err, ShouldBeNil)
+		So(fake.bodies[len(fake.bodies)-1], ShouldContainSubstring, `{"term": {"city": "Edmonton"}}`)
+		So(rs.Rows, ShouldResemble, [][]interface{}{{"Wayne Gretzky"}})
+	})
+
+	Convey("Test the JOIN row cap\n", t, func() {
+		fake := newFakeES("")
+		fake.responses = []string{joinPlayers, joinTeams}
+		defer fake.Close()
+
+		ex := NewExecutor(fake.conn(), nil)
+		ex.Join.MaxRows = 3
+		_, err := ex.Query(`SELECT * FROM oilers p JOIN teams t ON p.team = t.code`)
+		So(err, ShouldResemble, fmt.Errorf("JOIN table p has more than 3 matching rows"))
+		So(fake.bodies[len(fake.bodies)-1], ShouldContainSubstring, `"size": 4`)
+
+		fake.responses = []string{joinTeams, joinTeams}
+		ex.Join.MaxRows = 4
+		_, err = ex.Query(`SELECT a.city FROM teams a JOIN teams b ON a.code = b.code`)
+		So(err, ShouldResemble, fmt.Errorf("JOIN produces more than 4 rows"))
+
+		h1, _ := joinHash([]interface{}{int64(1), "EDM"})
+		h2, ok := joinHash([]interface{}{1.0, "EDM"})
+		So(ok, ShouldBeTrue)
+		So(h1, ShouldEqual, h2)
+		_, ok = joinHash([]interface{}{nil})
+		So(ok, ShouldBeFalse)
+		_, ok = joinHash([]interface{}{[]interface{}{"EDM"}})
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Test JOIN planning errors\n", t, func() {
+		for _, c := range []struct{ q, err string }{
+			{`SELECT name FROM oilers p JOIN teams t ON p.team = t.code`,
+				"column name must be qualified by a table of the JOIN"},
+			{`SELECT * FROM oilers JOIN oilers ON oilers.team = oilers.team`,
+				"table oilers is named more than once in the JOIN, and needs an alias"},
+			{`SELECT * FROM oilers p JOIN teams t ON p.team = t.code WHERE p.goals > 50 OR t.city = 'Edmonton'`,
+				"WHERE condition p.goals > 50 OR t.city = 'Edmonton' tests several tables of the JOIN"},
+			{`SELECT * FROM oilers p JOIN teams t ON p.goals > 50`,
+				"ON condition p.goals > 50 must compare t with an earlier table, or test t alone"},
+			{`SELECT * FROM oilers p JOIN teams t ON t.city = 'Edmonton'`,
+				"JOIN t has no equality between its fields and those of an earlier table"},
+			{`SELECT p.team, COUNT(*) FROM oilers p JOIN teams t ON p.team = t.code GROUP BY p.team`,
+				"JOIN cannot be combined with GROUP BY or aggregate functions"},
+			{`SELECT p.name, SCORE() FROM oilers p JOIN teams t ON p.team = t.code`,
+				"JOIN cannot select computed column SCORE()"},
+			{`SELECT * FROM oilers p JOIN teams t ON p.team = t.code ORDER BY p.dob - INTERVAL 1 DAY`,
+				"JOIN can only be ordered by columns, not p.dob - INTERVAL 1 DAY"},
+		} {
+			_, err := Prepare(c.q)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, c.err)
+		}
+
+		p, err := Prepare(`SELECT * FROM oilers p JOIN teams t ON p.team = t.code`)
+		So(err, ShouldBeNil)
+		So(p.readsIndex("teams", nil), ShouldBeTrue)
+		_, err = p.Query()
+		So(err, ShouldResemble, fmt.Errorf("statement with JOIN has no single elasticsearch query"))
+	})
+
+	Convey("Test validating JOINs\n", t, func() {
+		So(testValidate(`SELECT o.name, f.pos FROM oilers o JOIN flames f ON o.pos = f.pos WHERE f.goals > 50 ORDER BY f.pos`), ShouldBeNil)
+
+		err := testValidate(`SELECT o.nmae FROM oilers o LEFT JOIN flames f ON o.dob = f.drafetd`)
+		So(validationMessages(err), ShouldResemble, []string{
+			"1:8: unknown column nmae (did you mean name?)",
+			"1:59: unknown column drafetd (did you mean drafted?)",
+		})
+	})
+}
@@ -0,0 +1,275 @@
+package essyntax
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// nestedScope describes the statement whose conditions are being translated
+// or validated: the top-level statement, or an EXISTS subquery matching the
+// objects of a nested field.
+type nestedScope struct {
+	// path is the nested path of the objects matched, "" at the top level.
+	path string
+
+	// tables maps the names and aliases of the tables in scope, including
+	// those of enclosing statements, to their nested path.
+	tables map[string]string
+}
+
+// newNestedScope returns the scope of a top-level statement selecting from
+// the specified tables.
+func newNestedScope(tables sql.Fields) nestedScope {
+
+	sc := nestedScope{tables: make(map[string]string)}
+	for _, t := range tables {
+		sc.tables[t.Name] = ""
+		if len(t.Alias) > 0 {
+			sc.tables[t.Alias] = ""
+		}
+	}
+	return sc
+}
+
+// enter returns the scope of an EXISTS subquery within sc. The subquery
+// selects from a single table naming a nested path below a table in scope,
+// e.g. oilers.linemates, or o.linemates for an index selected as oilers o.
+func (sc nestedScope) enter(s *sql.SelectStatement) (nestedScope, error) {
+
+	if len(s.TableList) != 1 {
+		return sc, fmt.Errorf("EXISTS subquery must select from a single nested path")
+	}
+	if len(s.GroupBy) > 0 || len(s.OrderBy) > 0 || s.Limit != nil {
+		return sc, fmt.Errorf("EXISTS subquery cannot have GROUP BY, ORDER BY or LIMIT")
+	}
+
+	table := s.TableList[0]
+	var outer string
+	for name := range sc.tables {
+		if strings.HasPrefix(table.Name, name+".") && len(name) > len(outer) {
+			outer = name
+		}
+	}
+	if len(outer) == 0 {
+		return sc, fmt.Errorf("EXISTS subquery table %s is not a nested path of a FROM table", table.Name)
+	}
+
+	path := table.Name[len(outer)+1:]
+	if prefix := sc.tables[outer]; len(prefix) > 0 {
+		path = prefix + "." + path
+	}
+
+	sub := nestedScope{path: path, tables: map[string]string{table.Name: path}}
+	for name, p := range sc.tables {
+		sub.tables[name] = p
+	}
+	if len(table.Alias) > 0 {
+		sub.tables[table.Alias] = path
+	}
+	return sub, nil
+}
+
+// qualifyCond returns a copy of the condition of an EXISTS subquery within
+// sc in which the fields, named relative to the nested path or qualified by
+// the name or alias of the subquery table, are given their full path. A field
+// qualified by a table of sc instead would make the subquery correlated,
+// which a nested query cannot express. The conditions of any EXISTS
+// subqueries within it are left as they are, since they are relative to the
+// tables of those subqueries.
+func (sc nestedScope) qualifyCond(cond sql.Cond, table sql.Field, path string) (sql.Cond, error) {
+
+	var err error
+	qualified := mapCondFields(cond, func(name string) string {
+		for _, q := range []string{table.Alias, table.Name} {
+			if len(q) > 0 && strings.HasPrefix(name, q+".") {
+				return path + "." + name[len(q)+1:]
+			}
+		}
+		var outer string
+		for q := range sc.tables {
+			if strings.HasPrefix(name, q+".") && len(q) > len(outer) {
+				outer = q
+			}
+		}
+		if len(outer) > 0 && err == nil {
+			err = fmt.Errorf("EXISTS subquery of %s is correlated: %s refers to the outer table %s", table.Name, name, outer)
+		}
+		return path + "." + name
+	})
+	return qualified, err
+}
+
+// genExistsClause returns the nested query for an EXISTS subquery, which
+// matches documents with an object of the nested path meeting every
+// condition of the subquery.
+func (t *translator) genExistsClause(e *sql.CondExists) (string, error) {
+
+	sub, err := t.scope.enter(e.Select)
+	if err != nil {
+		return "", err
+	}
+	if f, ok := t.field(sub.path); ok && f.Type != "nested" {
+		return "", fmt.Errorf("EXISTS subquery table %s is %s field %s, which is not nested", e.Select.TableList[0].Name, f.Type, f.Path)
+	}
+
+	query := `{"match_all": {}}`
+	if e.Select.WhereCond != nil {
+		cond, err := t.scope.qualifyCond(e.Select.WhereCond, e.Select.TableList[0], sub.path)
+		if err != nil {
+			return "", err
+		}
+		outer := t.scope
+		t.scope = sub
+		query, err = t.genCondClause(cond)
+		t.scope = outer
+		if err != nil {
+			return "", err
+		}
+	}
+
+	clause := fmt.Sprintf(`{"nested": {"path": "%s", "query": %s}}`, sub.path, query)
+	if e.Not {
+		return fmt.Sprintf(`{"bool": {"must_not": %s}}`, clause), nil
+	}
+	return clause, nil
+}
+
+// genNestedClause returns the nested query matching documents with an object
+// of the specified nested path which meets the condition, whose query is
+// generated by gen.
+func (t *translator) genNestedClause(path string, cond sql.Cond, gen func(sql.Cond) (string, error)) (string, error) {
+
+	outer := t.scope
+	t.scope.path = path
+	query, err := gen(cond)
+	t.scope = outer
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`{"nested": {"path": "%s", "query": %s}}`, path, query), nil
+}
+
+// genNestedConj returns the query for an AND chain in which conditions on
+// fields of the same nested path are grouped into one nested query, so that
+// they must be met by the same nested object. It returns false if no two
+// conditions of the chain share a nested path.
+func (t *translator) genNestedConj(conj *sql.CondConj) (string, bool, error) {
+
+	var operands []sql.Cond
+	var flatten func(c sql.Cond)
+	flatten = func(c sql.Cond) {
+		if and, ok := c.(*sql.CondConj); ok && and.Op == sql.AND && and.Left != nil && and.Right != nil {
+			flatten(and.Left)
+			flatten(and.Right)
+		} else if c != nil {
+			operands = append(operands, c)
+		}
+	}
+	flatten(conj)
+
+	// The groups are kept in the order of their first condition, and the
+	// clauses generated in that order, as template mode requires.
+	var paths []string
+	groups := make(map[string][]sql.Cond)
+	shared := false
+	for i, c := range operands {
+		path := t.nestedPath(c)
+		key := path
+		if len(path) == 0 {
+			key = fmt.Sprintf("\x00%d", i)
+		}
+		if _, ok := groups[key]; !ok {
+			paths = append(paths, key)
+		} else {
+			shared = true
+		}
+		groups[key] = append(groups[key], c)
+	}
+	if !shared {
+		return "", false, nil
+	}
+
+	clauses := make([]string, len(paths))
+	for i, key := range paths {
+		conds := groups[key]
+		cond := conds[len(conds)-1]
+		for j := len(conds) - 2; j >= 0; j-- {
+			cond = &sql.CondConj{Op: sql.AND, Left: conds[j], Right: cond}
+		}
+		var err error
+		if clauses[i], err = t.genCondClause(cond); err != nil {
+			return "", true, err
+		}
+	}
+	return fmt.Sprintf(`{"bool": {"must": [%s]}}`, strings.Join(clauses, ", ")), true, nil
+}
+
+// nestedPath returns the outermost nested path below the current scope which
+// encloses every field tested by the condition, according to the catalog,
+// or "" if there is none. Conditions containing an EXISTS subquery are never
+// within a nested path, since the subquery determines its own.
+func (t *translator) nestedPath(cond sql.Cond) string {
+
+	var fields []string
+	var exists bool
+	var collect func(c sql.Cond)
+	collect = func(c sql.Cond) {
+		switch c := c.(type) {
+		case *sql.CondConj:
+			if c.Left != nil {
+				collect(c.Left)
+			}
+			if c.Right != nil {
+				collect(c.Right)
+			}
+		case *sql.CondComp:
+			fields = append(fields, c.Ident)
+		case *sql.CondIn:
+			fields = append(fields, c.Ident)
+		case *sql.CondFunc:
+			for _, a := range c.Call.Args {
+				if ident, ok := a.(*sql.IdentExpr); ok {
+					fields = append(fields, ident.Name)
+				}
+			}
+		case *sql.CondExists:
+			exists = true
+		}
+	}
+	collect(cond)
+	if exists || len(fields) == 0 || t.opts.Catalog == nil {
+		return ""
+	}
+
+	path := t.outerNested(fields[0])
+	for _, f := range fields[1:] {
+		if t.outerNested(f) != path {
+			return ""
+		}
+	}
+	return path
+}
+
+// outerNested returns the outermost nested field enclosing a field below the
+// current scope, or "" if the field is not nested below it.
+func (t *translator) outerNested(field string) string {
+
+	f, ok := t.field(field)
+	if !ok || len(f.Nested) == 0 || f.Nested == t.scope.path {
+		return ""
+	}
+	if len(t.scope.path) > 0 && !strings.HasPrefix(f.Nested, t.scope.path+".") {
+		return ""
+	}
+
+	path := f.Nested
+	for {
+		parent, ok := t.field(path)
+		if !ok || len(parent.Nested) == 0 || parent.Nested == t.scope.path {
+			return path
+		}
+		path = parent.Nested
+	}
+}
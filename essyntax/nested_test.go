@@ -0,0 +1,126 @@
+package essyntax
+
+import (
+	"fmt"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+func TestNested(t *testing.T) {
+
+	defer log.Flush()
+
+	c, err := NewCatalogFromFile("testdata/mapping.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := TranslateOptions{Catalog: c}
+
+	Convey("Test wrapping conditions on nested fields in nested queries\n", t, func() {
+		es, err := testQueryOptions(`SELECT name FROM oilers WHERE linemates.name = 'Jari Kurri'`, opts)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": `+
+			`{"nested": {"path": "linemates", "query": {"term": {"linemates.name": "Jari Kurri"}}}}}}, "_source": ["name"]}`)
+		log.Debug(es)
+
+		for _, c := range []struct{ cond, clause string }{
+			{`linemates.seasons.year > 1985`, `{"nested": {"path": "linemates", "query": ` +
+				`{"nested": {"path": "linemates.seasons", "query": {"range": {"linemates.seasons.year": {"gt": 1985}}}}}}}`},
+			{`linemates.name = 'Jari Kurri' AND linemates.seasons.year = 1985 AND pos = 'C'`, `{"bool": {"must": [` +
+				`{"nested": {"path": "linemates", "query": {"bool": {"must": [{"term": {"linemates.name": "Jari Kurri"}}, ` +
+				`{"nested": {"path": "linemates.seasons", "query": {"term": {"linemates.seasons.year": 1985}}}}]}}}}, ` +
+				`{"term": {"pos": "C"}}]}}`},
+			{`linemates.name = 'Jari Kurri' AND pos = 'C' AND linemates.name != 'Esa Tikkanen'`, `{"bool": {"must": [` +
+				`{"nested": {"path": "linemates", "query": {"bool": {"must": [{"term": {"linemates.name": "Jari Kurri"}}, ` +
+				`{"bool": {"must_not": {"term": {"linemates.name": "Esa Tikkanen"}}}}]}}}}, {"term": {"pos": "C"}}]}}`},
+			{`linemates.name = 'Jari Kurri' OR pos = 'C'`, `{"bool": {"should": [` +
+				`{"nested": {"path": "linemates", "query": {"term": {"linemates.name": "Jari Kurri"}}}}, {"term": {"pos": "C"}}]}}`},
+			{`pos = 'C' AND goals > 50`, `{"bool": {"must": [{"term": {"pos": "C"}}, {"range": {"goals": {"gt": 50}}}]}}`},
+		} {
+			es, err := testQueryOptions(`SELECT * FROM oilers WHERE `+c.cond, opts)
+			So(err, ShouldBeNil)
+			So(es, ShouldEqual, fmt.Sprintf(`{"query": {"constant_score": {"filter": %s}}}`, c.clause))
+		}
+
+		es, err = testQuery(`SELECT * FROM oilers WHERE linemates.name = 'Jari Kurri'`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"term": {"linemates.name": "Jari Kurri"}}}}}`)
+	})
+
+	Convey("Test EXISTS subqueries on nested paths\n", t, func() {
+		es, err := testQuery(`SELECT name FROM oilers WHERE EXISTS (SELECT * FROM oilers.linemates WHERE name = 'Jari Kurri' AND games > 70)`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"nested": {"path": "linemates", "query": `+
+			`{"bool": {"must": [{"term": {"linemates.name": "Jari Kurri"}}, {"range": {"linemates.games": {"gt": 70}}}]}}}}}}, "_source": ["name"]}`)
+		log.Debug(es)
+
+		for _, c := range []struct{ cond, clause string }{
+			{`NOT EXISTS (SELECT * FROM o.linemates)`,
+				`{"bool": {"must_not": {"nested": {"path": "linemates", "query": {"match_all": {}}}}}}`},
+			{`EXISTS (SELECT * FROM o.linemates l WHERE EXISTS (SELECT * FROM l.seasons WHERE year IN (1984, 1985)))`,
+				`{"nested": {"path": "linemates", "query": {"nested": {"path": "linemates.seasons", "query": ` +
+					`{"terms": {"linemates.seasons.year": [1984, 1985]}}}}}}`},
+		} {
+			es, err := testQueryOptions(`SELECT * FROM oilers o WHERE `+c.cond, opts)
+			So(err, ShouldBeNil)
+			So(es, ShouldEqual, fmt.Sprintf(`{"query": {"constant_score": {"filter": %s}}}`, c.clause))
+		}
+
+		es, err = testQuery(`SELECT * FROM oilers WHERE MATCH(quote, 'puck') AND EXISTS (SELECT * FROM oilers.linemates WHERE MATCH(name, 'kurri'))`)
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"bool": {"must": [{"match": {"quote": {"query": "puck"}}}], "filter": [`+
+			`{"nested": {"path": "linemates", "query": {"match": {"linemates.name": {"query": "kurri"}}}}}]}}}`)
+
+		p, err := Prepare(`SELECT * FROM oilers WHERE pos = ? AND EXISTS (SELECT * FROM oilers.linemates WHERE name = ?)`)
+		So(err, ShouldBeNil)
+		es, err = p.Query("C", "Jari Kurri")
+		So(err, ShouldBeNil)
+		So(es, ShouldEqual, `{"query": {"constant_score": {"filter": {"bool": {"must": [{"term": {"pos": "C"}}, `+
+			`{"nested": {"path": "linemates", "query": {"term": {"linemates.name": "Jari Kurri"}}}}]}}}}}`)
+	})
+
+	Convey("Test nested query errors\n", t, func() {
+		for _, c := range []struct{ q, err string }{
+			{`SELECT * FROM oilers WHERE EXISTS (SELECT * FROM flames.linemates)`,
+				"EXISTS subquery table flames.linemates is not a nested path of a FROM table"},
+			{`SELECT * FROM oilers WHERE EXISTS (SELECT * FROM oilers.linemates, oilers.stats)`,
+				"EXISTS subquery must select from a single nested path"},
+			{`SELECT * FROM oilers WHERE EXISTS (SELECT * FROM oilers.linemates LIMIT 1)`,
+				"EXISTS subquery cannot have GROUP BY, ORDER BY or LIMIT"},
+			{`SELECT * FROM oilers WHERE EXISTS (SELECT * FROM oilers.linemates WHERE oilers.pos = 'C')`,
+				"EXISTS subquery of oilers.linemates is correlated: oilers.pos refers to the outer table oilers"},
+			{`SELECT * FROM oilers o WHERE EXISTS (SELECT * FROM o.linemates l WHERE l.name = 'Jari Kurri' AND o.goals > 50)`,
+				"EXISTS subquery of o.linemates is correlated: o.goals refers to the outer table o"},
+		} {
+			_, err := testQuery(c.q)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, c.err)
+		}
+
+		_, err := testQueryOptions(`SELECT * FROM oilers WHERE EXISTS (SELECT * FROM oilers.stats WHERE ppg > 1)`, opts)
+		So(err, ShouldResemble, fmt.Errorf("EXISTS subquery table oilers.stats is object field stats, which is not nested"))
+	})
+
+	Convey("Test validating EXISTS subqueries\n", t, func() {
+		So(testValidate(`SELECT name FROM oilers o WHERE EXISTS (SELECT * FROM o.linemates l WHERE name = 'Jari Kurri' `+
+			`AND EXISTS (SELECT * FROM l.seasons WHERE year > 1985))`), ShouldBeNil)
+
+		err := testValidate(`SELECT name FROM oilers WHERE EXISTS (SELECT * FROM oilers.stats) AND ` +
+			`EXISTS (SELECT * FROM oilers.linemates WHERE nmae = 'Jari Kurri') AND EXISTS (SELECT * FROM flames.roster)`)
+		So(validationMessages(err), ShouldResemble, []string{
+			"1:53: EXISTS subquery table oilers.stats is object field stats, which is not nested",
+			"1:116: unknown column linemates.nmae (did you mean linemates.name?)",
+			"1:141: EXISTS subquery table flames.roster is not a nested path of a FROM table",
+		})
+
+		err = testValidate(`SELECT name FROM oilers WHERE EXISTS (SELECT * FROM oilers.linemates WHERE oilers.pos = 'C')`)
+		So(validationMessages(err), ShouldResemble, []string{
+			"1:31: EXISTS subquery of oilers.linemates is correlated: oilers.pos refers to the outer table oilers",
+		})
+	})
+}
@@ -0,0 +1,518 @@
+package essyntax
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	elastigo "github.com/mattbaird/elastigo/lib"
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// PagingMode selects how an Executor pages through the hits of a statement.
+type PagingMode int
+
+const (
+	// PagingAuto pages with search_after, or with the scroll API on clusters
+	// older than elasticsearch 5, which lack search_after. The scroll API is
+	// also used from elasticsearch 8, which rejects sorting on _id by
+	// default, unless a TieBreaker is specified.
+	PagingAuto PagingMode = iota
+
+	// PagingSearchAfter pages with search_after, ordering hits by the sort
+	// order of the statement followed by the TieBreaker field.
+	PagingSearchAfter
+
+	// PagingScroll pages with the scroll API.
+	PagingScroll
+)
+
+// searchAfterVersion is the first major elasticsearch version supporting
+// search_after, and idSortVersion the first which disables sorting on _id
+// unless indices.id_field_data.enabled is set.
+const (
+	searchAfterVersion = 5
+	idSortVersion      = 8
+)
+
+// PagingOptions control how an Executor pages through the hits of
+// statements without a LIMIT, or with a LIMIT larger than a page. The zero
+// value pages through 1000 hits at a time.
+type PagingOptions struct {
+	Mode PagingMode
+
+	// PageSize is the number of hits fetched by each request, and defaults
+	// to 1000.
+	PageSize int
+
+	// TieBreaker is a field holding a unique value for each document, which
+	// makes the sort order deterministic under search_after. It defaults to
+	// _id, which elasticsearch 8 only sorts on if the cluster enables
+	// indices.id_field_data.enabled.
+	TieBreaker string
+
+	// KeepAlive is how long elasticsearch keeps a scroll context between
+	// requests, and defaults to 1m.
+	KeepAlive string
+}
+
+// withDefaults returns the options with defaults for any unset fields.
+func (o PagingOptions) withDefaults() PagingOptions {
+	if o.PageSize <= 0 {
+		o.PageSize = 1000
+	}
+	if len(o.TieBreaker) == 0 {
+		o.TieBreaker = "_id"
+	}
+	if len(o.KeepAlive) == 0 {
+		o.KeepAlive = "1m"
+	}
+	return o
+}
+
+// Stream iterates over the rows returned by a statement, fetching the next
+// page of hits only once the rows of the previous page have been consumed.
+// A Stream must be closed to release its scroll context, which is also
+// released once every page has been fetched or its context is cancelled.
+type Stream struct {
+	// Total is the number of matching documents, which may exceed the
+	// number of rows.
+	Total int
+
+	ctx   context.Context
+	conn  *elastigo.Conn
+	opts  PagingOptions
+	index string
+	dec   *Decoder
+
+	query  string            // the query, sent as is unless paged
+	count  bool              // whether to send the query to the _count API
+	body   map[string]string // the members of the query, if paged
+	scroll bool              // whether to page with the scroll API
+	after  string            // sort values of the last hit, for search_after
+
+	mu       sync.Mutex
+	scrollID string
+	pages    int   // number of pages fetched
+	hits     int   // number of hits fetched
+	last     bool  // whether the last page has been fetched
+	err      error // error ending the stream
+	released bool
+	done     chan struct{} // closed once released
+}
+
+// Stream executes a prepared statement with the specified parameter values
+// and returns a Stream of its rows. The first page is fetched before Stream
+// returns, so that the columns are known. Cancelling ctx ends the Stream.
+// The IN subqueries of a statement are run before Stream returns, as are
+// the queries of the tables of a statement with joins, whose rows are joined
+// in memory, and the branches of a statement with unions, whose rows are
+// merged in memory.
+func (e *Executor) Stream(ctx context.Context, prep *Prepared, b sql.Binding) (*Stream, error) {
+
+	if prep.staged {
+		s, err := e.resolveSubqueries(ctx, b.Bind(prep.Stmt))
+		if err != nil {
+			return nil, err
+		}
+		return e.streamStatement(ctx, s)
+	}
+	query, err := prep.render(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(prep.Stmt.Unions) > 0 {
+		return e.streamUnion(ctx, b.Bind(prep.Stmt), query)
+	}
+	return e.stream(ctx, b.Bind(prep.Stmt), query)
+}
+
+// streamStatement translates a statement whose parameters are bound and
+// whose IN subqueries have been resolved, and returns a Stream of its rows.
+// The branches of a statement with unions are sent in a single _msearch
+// request if they can be, and are otherwise run concurrently.
+func (e *Executor) streamStatement(ctx context.Context, s *sql.SelectStatement) (*Stream, error) {
+
+	if len(s.Unions) > 0 && stagedError(s) != nil {
+		return e.streamUnion(ctx, s, "")
+	}
+	if len(s.Joins) > 0 {
+		return e.streamJoin(ctx, s)
+	}
+	query, err := (&translator{opts: e.translateOptions()}).genQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Unions) > 0 {
+		return e.streamUnion(ctx, s, query)
+	}
+	return e.stream(ctx, s, query)
+}
+
+// stream returns a Stream of the rows returned by the query for the
+// specified statement.
+func (e *Executor) stream(ctx context.Context, s *sql.SelectStatement, query string) (*Stream, error) {
+
+	dec, err := NewDecoder(s, e.Decoding)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &Stream{
+		ctx:   ctx,
+		conn:  e.conn,
+		opts:  e.Paging.withDefaults(),
+		index: indexList(s.TableList),
+		dec:   dec,
+		query: query,
+		done:  make(chan struct{}),
+	}
+	_, st.count = countField(s)
+
+	if !isAggregate(s) && (dec.limit < 0 || dec.limit > st.opts.PageSize) {
+		if st.scroll, err = e.useScroll(); err != nil {
+			return nil, err
+		}
+		if st.body, err = pagingBody(query, st.scroll, st.opts.TieBreaker); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := st.fetch(); err != nil {
+		st.release()
+		return nil, err
+	}
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				st.Close()
+			case <-st.done:
+			}
+		}()
+	}
+
+	return st, nil
+}
+
+// useScroll returns true if statements should be paged with the scroll API,
+// which under PagingAuto depends on the version of the cluster.
+func (e *Executor) useScroll() (bool, error) {
+
+	switch e.Paging.Mode {
+	case PagingSearchAfter:
+		return false, nil
+	case PagingScroll:
+		return true, nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.probed {
+		return e.scroll, nil
+	}
+
+	body, err := e.conn.DoCommand("GET", "/", nil, nil)
+	if err != nil {
+		return false, err
+	}
+	var info struct {
+		Version struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return false, err
+	}
+	major, _, err := parseVersion(info.Version.Number)
+	if err != nil {
+		return false, err
+	}
+
+	e.probed = true
+	e.scroll = major < searchAfterVersion || major >= idSortVersion && len(e.Paging.TieBreaker) == 0
+	return e.scroll, nil
+}
+
+// parseVersion returns the major and minor numbers of an elasticsearch
+// version number such as 7.10.2.
+func parseVersion(number string) (int, int, error) {
+
+	var major, minor int
+	if _, err := fmt.Sscanf(number, "%d.%d", &major, &minor); err != nil {
+		return 0, 0, fmt.Errorf("invalid elasticsearch version %q", number)
+	}
+	return major, minor, nil
+}
+
+// Columns returns the columns of the rows.
+func (s *Stream) Columns() []Column {
+	return s.dec.Columns()
+}
+
+// Next returns the next row, fetching the next page of hits if the rows of
+// the previous page have been consumed, or io.EOF after the last row.
+func (s *Stream) Next() ([]interface{}, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if err := s.ctx.Err(); err != nil {
+			s.fail(err)
+		}
+		if s.err != nil {
+			return nil, s.err
+		}
+
+		row, err := s.dec.Next()
+		if err != io.EOF {
+			return row, err
+		}
+		if s.last || s.dec.limitReached() {
+			s.release()
+			return nil, io.EOF
+		}
+		if err := s.fetch(); err != nil {
+			s.fail(err)
+		}
+	}
+}
+
+// Close ends the Stream and releases its scroll context, if any.
+func (s *Stream) Close() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err == nil {
+		s.err = io.EOF
+	}
+	return s.release()
+}
+
+// fail ends the Stream with the specified error.
+func (s *Stream) fail(err error) {
+	s.err = err
+	s.release()
+}
+
+// release clears the scroll context of the Stream, if any, and stops
+// watching its context.
+func (s *Stream) release() error {
+
+	if s.released {
+		return nil
+	}
+	s.released = true
+	close(s.done)
+
+	if len(s.scrollID) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(map[string][]string{"scroll_id": {s.scrollID}})
+	if err != nil {
+		return err
+	}
+	s.scrollID = ""
+	_, err = doRequest(s.conn, "DELETE", "/_search/scroll", nil, string(body), jsonContent)
+	return err
+}
+
+// fetch requests and decodes the next page of hits.
+func (s *Stream) fetch() error {
+
+	var res elastigo.SearchResult
+	var err error
+	size := s.pageSize()
+	scroll := map[string]interface{}{"scroll": s.opts.KeepAlive}
+	switch {
+	case s.count:
+		res, err = s.countHits()
+	case s.body == nil:
+		res, err = doSearch(s.conn, "/"+s.index+"/_search", nil, s.query)
+	case s.scroll && s.pages > 0:
+		res, err = s.scrollPage()
+	case s.scroll:
+		s.body["size"] = fmt.Sprint(size)
+		res, err = doSearch(s.conn, "/"+s.index+"/_search", scroll, formatBody(s.body))
+	default:
+		s.body["size"] = fmt.Sprint(size)
+		if len(s.after) > 0 {
+			s.body["search_after"] = s.after
+		}
+		res, err = doSearch(s.conn, "/"+s.index+"/_search", nil, formatBody(s.body))
+	}
+	if err != nil {
+		return err
+	}
+
+	if s.pages == 0 {
+		s.Total = res.Hits.Total
+	}
+	s.pages += 1
+	s.hits += len(res.Hits.Hits)
+	if s.scroll {
+		s.scrollID = res.ScrollId
+	}
+	if err := s.dec.Decode(&res); err != nil {
+		return err
+	}
+
+	if s.body == nil || len(res.Hits.Hits) < size || (s.dec.limit >= 0 && s.hits >= s.dec.limit) {
+		s.last = true
+		return s.release()
+	}
+	if !s.scroll {
+		if s.after, err = lastSortValues(res.RawJSON); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scrollPage requests the next page of a scroll. Elasticsearch 7.0 and later
+// only accept the scroll id within a JSON body.
+func (s *Stream) scrollPage() (elastigo.SearchResult, error) {
+
+	body, err := json.Marshal(map[string]string{"scroll": s.opts.KeepAlive, "scroll_id": s.scrollID})
+	if err != nil {
+		return elastigo.SearchResult{}, err
+	}
+	return doSearch(s.conn, "/_search/scroll", nil, string(body))
+}
+
+// countHits requests the number of matching documents from the _count API,
+// returned as the total of a search result without hits.
+func (s *Stream) countHits() (elastigo.SearchResult, error) {
+
+	var res elastigo.SearchResult
+	body, err := doRequest(s.conn, "GET", "/"+s.index+"/_count", nil, s.query, jsonContent)
+	if err != nil {
+		return res, err
+	}
+	var count elastigo.CountResponse
+	if err := json.Unmarshal(body, &count); err != nil {
+		return res, err
+	}
+	res.Hits.Total = count.Count
+	return res, nil
+}
+
+// pageSize returns the number of hits to request for the next page.
+func (s *Stream) pageSize() int {
+	if s.dec.limit >= 0 && s.dec.limit-s.hits < s.opts.PageSize {
+		return s.dec.limit - s.hits
+	}
+	return s.opts.PageSize
+}
+
+// bodyKeys orders the members of a query formatted by formatBody.
+var bodyKeys = []string{"query", "_source", "sort", "size", "search_after"}
+
+// pagingBody splits the specified query into its members, in preparation
+// for paging. For search_after, the tie breaker field is appended to the
+// sort order, which is by relevance if the query is unsorted.
+func pagingBody(query string, scroll bool, tieBreaker string) (map[string]string, error) {
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(query), &members); err != nil {
+		return nil, err
+	}
+	body := make(map[string]string)
+	for k, v := range members {
+		body[k] = string(v)
+	}
+	if scroll {
+		return body, nil
+	}
+
+	var keys []json.RawMessage
+	if s, ok := members["sort"]; ok {
+		if err := json.Unmarshal(s, &keys); err != nil {
+			return nil, err
+		}
+	} else {
+		keys = append(keys, json.RawMessage(`{"_score": {"order": "desc"}}`))
+	}
+
+	for _, k := range keys {
+		var key interface{}
+		if err := json.Unmarshal(k, &key); err != nil {
+			return nil, err
+		}
+		switch key := key.(type) {
+		case string:
+			if key == tieBreaker {
+				return body, nil
+			}
+		case map[string]interface{}:
+			if _, ok := key[tieBreaker]; ok {
+				return body, nil
+			}
+		}
+	}
+	keys = append(keys, json.RawMessage(fmt.Sprintf(`{"%s": {"order": "asc"}}`, tieBreaker)))
+
+	strs := make([]string, len(keys))
+	for i, k := range keys {
+		strs[i] = string(k)
+	}
+	body["sort"] = "[" + strings.Join(strs, ", ") + "]"
+	return body, nil
+}
+
+// formatBody returns the query with the specified members, in the order of
+// bodyKeys followed by any others in name order.
+func formatBody(body map[string]string) string {
+
+	var names []string
+	for k := range body {
+		if !containsString(bodyKeys, k) {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var members []string
+	for _, k := range append(bodyKeys, names...) {
+		if v, ok := body[k]; ok {
+			members = append(members, fmt.Sprintf(`"%s": %s`, k, v))
+		}
+	}
+	return "{" + strings.Join(members, ", ") + "}"
+}
+
+// lastSortValues returns the sort values of the last hit of a raw search
+// response, for use as search_after.
+func lastSortValues(raw []byte) (string, error) {
+
+	var res struct {
+		Hits struct {
+			Hits []struct {
+				Sort []json.RawMessage `json:"sort"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return "", err
+	}
+
+	hits := res.Hits.Hits
+	if len(hits) == 0 || len(hits[len(hits)-1].Sort) == 0 {
+		return "", fmt.Errorf("search response has no sort values for search_after")
+	}
+	vals := make([]string, len(hits[len(hits)-1].Sort))
+	for i, v := range hits[len(hits)-1].Sort {
+		vals[i] = string(v)
+	}
+	return "[" + strings.Join(vals, ", ") + "]", nil
+}
@@ -0,0 +1,236 @@
+package essyntax
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	"github.com/oldenbur/sql-parser/sql"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+// testStream returns a Stream of the rows of the specified SQL text.
+func testStream(ctx context.Context, ex *Executor, q string, args ...interface{}) (*Stream, error) {
+
+	prep, err := Prepare(q)
+	if err != nil {
+		return nil, err
+	}
+	b, err := sql.NewBinding(prep.Stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	return ex.Stream(ctx, prep, b)
+}
+
+// readAll returns the remaining rows of a Stream.
+func readAll(st *Stream) ([][]interface{}, error) {
+
+	var rows [][]interface{}
+	for {
+		row, err := st.Next()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return rows, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+const (
+	page1 = `{"hits": {"total": 3, "hits": [
+		{"_id": "1", "_source": {"name": "Gretzky"}, "sort": ["Gretzky", "1"]},
+		{"_id": "2", "_source": {"name": "Kurri"}, "sort": ["Kurri", "2"]}]}, "_scroll_id": "s1"}`
+	page2 = `{"hits": {"total": 3, "hits": [
+		{"_id": "3", "_source": {"name": "Messier"}, "sort": ["Messier", "3"]}]}, "_scroll_id": "s2"}`
+)
+
+func TestStream(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test paging with search_after\n", t, func() {
+		es := newFakeES(`{"hits": {"total": 3, "hits": []}}`)
+		es.responses = []string{page1, page2}
+		defer es.Close()
+
+		ex := NewExecutor(es.conn(), nil)
+		ex.Paging.PageSize = 2
+		st, err := testStream(context.Background(), ex, `SELECT name FROM oilers ORDER BY name`)
+		So(err, ShouldBeNil)
+		defer st.Close()
+		So(st.Total, ShouldEqual, 3)
+		So(st.Columns(), ShouldResemble, []Column{{Name: "name", Field: "name", Type: TypeString}})
+		So(es.paths, ShouldResemble, []string{"GET /", "POST /oilers/_search"})
+		So(es.bodies[1], ShouldEqual, `{"query": {"match_all": {}}, "_source": ["name"], `+
+			`"sort": [{"name": {"order": "asc"}}, {"_id": {"order": "asc"}}], "size": 2}`)
+
+		row, err := st.Next()
+		So(err, ShouldBeNil)
+		So(row, ShouldResemble, []interface{}{"Gretzky"})
+		row, err = st.Next()
+		So(err, ShouldBeNil)
+		So(row, ShouldResemble, []interface{}{"Kurri"})
+		So(es.paths, ShouldHaveLength, 2)
+
+		rows, err := readAll(st)
+		So(err, ShouldBeNil)
+		So(rows, ShouldResemble, [][]interface{}{{"Messier"}})
+		So(es.paths, ShouldHaveLength, 3)
+		So(es.bodies[2], ShouldEqual, `{"query": {"match_all": {}}, "_source": ["name"], `+
+			`"sort": [{"name": {"order": "asc"}}, {"_id": {"order": "asc"}}], "size": 2, "search_after": ["Kurri", "2"]}`)
+	})
+
+	Convey("Test paging up to a LIMIT\n", t, func() {
+		es := newFakeES(`{"hits": {"total": 3, "hits": []}}`)
+		es.responses = []string{page1, page2}
+		defer es.Close()
+
+		ex := NewExecutor(es.conn(), nil)
+		ex.Paging = PagingOptions{Mode: PagingSearchAfter, PageSize: 2, TieBreaker: "seq"}
+		rs, err := ex.Query(`SELECT name FROM oilers LIMIT ?`, 3)
+		So(err, ShouldBeNil)
+		So(rs.Rows, ShouldResemble, [][]interface{}{{"Gretzky"}, {"Kurri"}, {"Messier"}})
+		So(es.paths, ShouldResemble, []string{"POST /oilers/_search", "POST /oilers/_search"})
+		So(es.bodies[0], ShouldEqual, `{"query": {"match_all": {}}, "_source": ["name"], `+
+			`"sort": [{"_score": {"order": "desc"}}, {"seq": {"order": "asc"}}], "size": 2}`)
+		So(es.bodies[1], ShouldEqual, `{"query": {"match_all": {}}, "_source": ["name"], `+
+			`"sort": [{"_score": {"order": "desc"}}, {"seq": {"order": "asc"}}], "size": 1, "search_after": ["Kurri", "2"]}`)
+
+		es = newFakeES(page1)
+		defer es.Close()
+		ex = NewExecutor(es.conn(), nil)
+		ex.Paging = PagingOptions{Mode: PagingSearchAfter, PageSize: 1, TieBreaker: "seq"}
+		_, err = ex.Query(`SELECT name FROM oilers ORDER BY seq DESC LIMIT 2`)
+		So(err, ShouldBeNil)
+		So(es.bodies[0], ShouldEqual, `{"query": {"match_all": {}}, "_source": ["name"], "sort": [{"seq": {"order": "desc"}}], "size": 1}`)
+	})
+
+	Convey("Test paging with scroll on older clusters\n", t, func() {
+		es := newFakeES(`{"hits": {"total": 3, "hits": []}, "_scroll_id": "s3"}`)
+		es.responses = []string{page1, page1}
+		es.version = "2.4.1"
+		defer es.Close()
+
+		ex := NewExecutor(es.conn(), nil)
+		ex.Paging.PageSize = 2
+		rs, err := ex.Query(`SELECT name FROM oilers`)
+		So(err, ShouldBeNil)
+		So(rs.Rows, ShouldHaveLength, 4)
+		So(es.paths, ShouldResemble, []string{"GET /", "POST /oilers/_search",
+			"POST /_search/scroll", "POST /_search/scroll", "DELETE /_search/scroll"})
+		So(es.bodies[1], ShouldEqual, `{"query": {"match_all": {}}, "_source": ["name"], "size": 2}`)
+		So(es.bodies[2:], ShouldResemble, []string{`{"scroll":"1m","scroll_id":"s1"}`, `{"scroll":"1m","scroll_id":"s1"}`,
+			`{"scroll_id":["s3"]}`})
+
+		es.paths, es.bodies = nil, nil
+		es.responses = []string{page1}
+		st, err := testStream(context.Background(), ex, `SELECT name FROM oilers`)
+		So(err, ShouldBeNil)
+		_, err = st.Next()
+		So(err, ShouldBeNil)
+		So(st.Close(), ShouldBeNil)
+		So(es.paths, ShouldResemble, []string{"POST /oilers/_search", "DELETE /_search/scroll"})
+		So(es.bodies[1], ShouldEqual, `{"scroll_id":["s1"]}`)
+		_, err = st.Next()
+		So(err, ShouldEqual, io.EOF)
+		So(st.Close(), ShouldBeNil)
+		So(es.paths, ShouldHaveLength, 2)
+	})
+
+	Convey("Test paging on clusters which cannot sort on _id\n", t, func() {
+		es := newFakeES(`{"hits": {"total": 3, "hits": []}, "_scroll_id": "s3"}`)
+		es.responses = []string{page1, page2}
+		es.version = "8.11.1"
+		defer es.Close()
+
+		ex := NewExecutor(es.conn(), nil)
+		ex.Paging.PageSize = 2
+		rs, err := ex.Query(`SELECT name FROM oilers`)
+		So(err, ShouldBeNil)
+		So(rs.Rows, ShouldResemble, [][]interface{}{{"Gretzky"}, {"Kurri"}, {"Messier"}})
+		So(es.paths, ShouldResemble, []string{"GET /", "POST /oilers/_search", "POST /_search/scroll", "DELETE /_search/scroll"})
+		So(es.bodies[2:], ShouldResemble, []string{`{"scroll":"1m","scroll_id":"s1"}`, `{"scroll_id":["s2"]}`})
+
+		es.paths, es.bodies = nil, nil
+		es.responses = []string{page1, page2}
+		ex = NewExecutor(es.conn(), nil)
+		ex.Paging = PagingOptions{PageSize: 2, TieBreaker: "seq"}
+		_, err = ex.Query(`SELECT name FROM oilers`)
+		So(err, ShouldBeNil)
+		So(es.paths, ShouldResemble, []string{"GET /", "POST /oilers/_search", "POST /oilers/_search"})
+		So(es.bodies[2], ShouldContainSubstring, `"search_after": ["Kurri", "2"]`)
+	})
+
+	Convey("Test decoding pages of differing documents\n", t, func() {
+		pages := []string{`{"hits": {"total": 3, "hits": [
+			{"_source": {"name": "Gretzky", "goals": 92}, "sort": [1]},
+			{"_source": {"name": "Kurri", "goals": 71}, "sort": [2]}]}}`,
+			`{"hits": {"total": 3, "hits": [{"_source": {"name": "Messier", "goals": 45.5, "pos": "C"}, "sort": [3]}]}}`}
+		es := newFakeES(`{"hits": {"total": 3, "hits": []}}`)
+		es.responses = append([]string{}, pages...)
+		defer es.Close()
+
+		ex := NewExecutor(es.conn(), nil)
+		ex.Paging = PagingOptions{Mode: PagingSearchAfter, PageSize: 2}
+		st, err := testStream(context.Background(), ex, `SELECT * FROM oilers`)
+		So(err, ShouldBeNil)
+		defer st.Close()
+		rows, err := readAll(st)
+		So(err, ShouldBeNil)
+		So(st.Columns(), ShouldResemble, []Column{
+			{Name: "goals", Field: "goals", Type: TypeLong},
+			{Name: "name", Field: "name", Type: TypeString}})
+		So(rows, ShouldResemble, [][]interface{}{{int64(92), "Gretzky"}, {int64(71), "Kurri"}, {45.5, "Messier"}})
+
+		es.responses = append([]string{}, pages...)
+		rs, err := ex.Query(`SELECT * FROM oilers`)
+		So(err, ShouldBeNil)
+		So(rs.Columns, ShouldResemble, []Column{
+			{Name: "goals", Field: "goals", Type: TypeDouble},
+			{Name: "name", Field: "name", Type: TypeString}})
+		So(rs.Rows, ShouldResemble, [][]interface{}{{float64(92), "Gretzky"}, {float64(71), "Kurri"}, {45.5, "Messier"}})
+	})
+
+	Convey("Test cancelling a stream\n", t, func() {
+		es := newFakeES(page1)
+		defer es.Close()
+
+		ex := NewExecutor(es.conn(), nil)
+		ex.Paging = PagingOptions{Mode: PagingScroll, PageSize: 2}
+		ctx, cancel := context.WithCancel(context.Background())
+		st, err := testStream(ctx, ex, `SELECT name FROM oilers`)
+		So(err, ShouldBeNil)
+		defer st.Close()
+
+		cancel()
+		_, err = st.Next()
+		So(err, ShouldEqual, context.Canceled)
+		So(es.paths, ShouldResemble, []string{"POST /oilers/_search", "DELETE /_search/scroll"})
+
+		_, err = testStream(ctx, ex, `SELECT name FROM oilers`)
+		So(err, ShouldEqual, context.Canceled)
+		So(es.paths, ShouldHaveLength, 2)
+	})
+
+	Convey("Test stream errors\n", t, func() {
+		es := newFakeES(`{"hits": {"total": 3, "hits": [{"_source": {"name": "Gretzky"}}]}}`)
+		defer es.Close()
+
+		ex := NewExecutor(es.conn(), nil)
+		ex.Paging.PageSize = 1
+		_, err := ex.Query(`SELECT name FROM oilers`)
+		So(err, ShouldResemble, fmt.Errorf("search response has no sort values for search_after"))
+
+		es.version = "x"
+		_, err = NewExecutor(es.conn(), nil).Query(`SELECT name FROM oilers`)
+		So(err, ShouldResemble, fmt.Errorf(`invalid elasticsearch version "x"`))
+	})
+}
@@ -0,0 +1,203 @@
+package essyntax
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// SubqueryOptions control the execution of IN subqueries, which are run as
+// semi-joins: the subquery is executed first, and the distinct values of
+// its column substituted into the IN list of the outer statement.
+type SubqueryOptions struct {
+	// MaxValues bounds the distinct values substituted for each subquery,
+	// which elasticsearch must also accept as the terms of a single query.
+	MaxValues int
+}
+
+// inSubquery returns the subquery supplying the values of an IN list, if
+// any.
+func inSubquery(in *sql.CondIn) (*sql.SubqueryExpr, bool) {
+	if len(in.Vals) != 1 {
+		return nil, false
+	}
+	sub, ok := in.Vals[0].(*sql.SubqueryExpr)
+	return sub, ok
+}
+
+// hasSubqueries returns true if the statement has an IN subquery.
+func hasSubqueries(s *sql.SelectStatement) bool {
+
+	found := false
+	sql.Inspect(s, func(n sql.Node) bool {
+		if _, ok := n.(*sql.SubqueryExpr); ok {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// stagedError returns an error if the statement cannot be translated into a
+// single query because an Executor runs it in stages: statements with joins
+// or IN subqueries, and statements with unions whose branches cannot be sent
+// in a single _msearch request.
+func stagedError(s *sql.SelectStatement) error {
+
+	switch {
+	case len(s.Joins) > 0:
+		return fmt.Errorf("statement with JOIN has no single elasticsearch query")
+	case hasSubqueries(s):
+		return fmt.Errorf("statement with IN subquery has no single elasticsearch query")
+	case len(s.Unions) > 0:
+		return msearchError(unionBranches(s))
+	}
+	return nil
+}
+
+// tableQualifiers returns the names by which the fields of the tables of a
+// statement are qualified: the alias of each table, or its name.
+func tableQualifiers(s *sql.SelectStatement) []string {
+
+	var qs []string
+	for _, t := range s.Tables() {
+		if len(t.Alias) > 0 {
+			qs = append(qs, t.Alias)
+		} else {
+			qs = append(qs, t.Name)
+		}
+	}
+	return qs
+}
+
+// checkSubqueries checks that each IN subquery of a statement selects a
+// single column and is not correlated, i.e. does not refer to the tables of
+// the statements enclosing it, whose qualifiers are outer.
+func checkSubqueries(s *sql.SelectStatement, outer []string) error {
+
+	outer = append(outer[:len(outer):len(outer)], tableQualifiers(s)...)
+	var err error
+	sql.Inspect(s, func(n sql.Node) bool {
+		in, ok := n.(*sql.CondIn)
+		if !ok || err != nil {
+			return err == nil
+		}
+		sub, ok := inSubquery(in)
+		if !ok {
+			return true
+		}
+		err = checkSubquery(in.Ident, sub.Select, outer)
+		return false
+	})
+	return err
+}
+
+// checkSubquery checks the subquery supplying the values tested against a
+// field.
+func checkSubquery(ident string, s *sql.SelectStatement, outer []string) error {
+
+	if len(s.FieldList) != 1 || strings.HasSuffix(s.FieldList[0].Name, "*") {
+		return fmt.Errorf("IN subquery of %s must select a single column", ident)
+	}
+
+	inner := tableQualifiers(s)
+	var names []string
+	for _, f := range s.FieldList {
+		if f.Expr == nil {
+			names = append(names, f.Name)
+		}
+	}
+	sql.Inspect(s, func(n sql.Node) bool {
+		switch n := n.(type) {
+		case *sql.CondComp:
+			names = append(names, n.Ident)
+		case *sql.CondIn:
+			names = append(names, n.Ident)
+		case *sql.IdentExpr:
+			names = append(names, n.Name)
+		case *sql.SubqueryExpr, *sql.CondExists:
+			return false
+		}
+		return true
+	})
+	for _, name := range names {
+		for _, q := range outer {
+			if strings.HasPrefix(name, q+".") && !containsString(inner, q) {
+				return fmt.Errorf("IN subquery of %s is correlated: %s refers to the outer table %s", ident, name, q)
+			}
+		}
+	}
+
+	return checkSubqueries(s, outer)
+}
+
+// resolveSubqueries returns a copy of a statement, whose parameters must be
+// bound, in which the IN list of each subquery holds the distinct values it
+// returns. Subqueries within subqueries are run first.
+func (e *Executor) resolveSubqueries(ctx context.Context, s *sql.SelectStatement) (*sql.SelectStatement, error) {
+
+	max := memoryLimit(e.Subquery.MaxValues)
+	var err error
+	resolved := sql.Rewrite(s, func(n sql.Node) sql.Node {
+		in, ok := n.(*sql.CondIn)
+		if !ok || err != nil {
+			return n
+		}
+		sub, ok := inSubquery(in)
+		if !ok {
+			return n
+		}
+		c := *in
+		c.Vals, err = e.subqueryValues(ctx, in.Ident, sub.Select, max)
+		return &c
+	}).(*sql.SelectStatement)
+	return resolved, err
+}
+
+// subqueryValues runs the subquery supplying the values tested against a
+// field, and returns the distinct non-null values of its column in the order
+// they are first returned. The values of multi-valued fields are each
+// included.
+func (e *Executor) subqueryValues(ctx context.Context, ident string, s *sql.SelectStatement, max int) ([]sql.Expr, error) {
+
+	st, err := e.streamStatement(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("error running IN subquery of %s: %v", ident, err)
+	}
+	defer st.Close()
+
+	vals := []sql.Expr{}
+	seen := make(map[string]bool)
+	for {
+		row, err := st.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error running IN subquery of %s: %v", ident, err)
+		}
+		values := []interface{}{row[0]}
+		if multi, ok := row[0].([]interface{}); ok {
+			values = multi
+		}
+		for _, v := range values {
+			key, ok := joinHash([]interface{}{v})
+			if !ok || seen[key] {
+				continue
+			}
+			seen[key] = true
+			if len(vals) == max {
+				return nil, fmt.Errorf("IN subquery of %s returns more than %d distinct values", ident, max)
+			}
+			lit, err := sql.LiteralExpr(v)
+			if err != nil {
+				lit = &sql.StringExpr{Val: sql.QuoteString(fmt.Sprint(v))}
+			}
+			vals = append(vals, lit)
+		}
+	}
+	return vals, nil
+}
@@ -0,0 +1,138 @@
+package essyntax
+
+import (
+	"fmt"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+const westTeams = `{"hits": {"total": 4, "hits": [
+	{"_source": {"code": "EDM"}}, {"_source": {"code": "CGY"}}, {"_source": {"code": "EDM"}},
+	{"_source": {"code": ["VAN", "WPG"]}}, {"_source": {}}]}}`
+
+func TestSubquery(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test executing an IN subquery as a semi-join\n", t, func() {
+		fake := newFakeES(oilersHits)
+		fake.responses = []string{westTeams}
+		defer fake.Close()
+
+		rs, err := NewExecutor(fake.conn(), nil).Query(`SELECT name FROM oilers WHERE team IN `+
+			`(SELECT code FROM teams WHERE conf = ?) AND pos = 'C' LIMIT 2`, "West")
+		So(err, ShouldBeNil)
+		So(fake.paths, ShouldContain, "POST /teams/_search")
+		So(fake.paths[len(fake.paths)-1], ShouldEqual, "POST /oilers/_search")
+		So(fake.bodies, ShouldContain, `{"query": {"constant_score": {"filter": {"term": {"conf": "West"}}}}, `+
+			`"_source": ["code"], "sort": [{"_score": {"order": "desc"}}, {"_id": {"order": "asc"}}], "size": 1000}`)
+		So(fake.bodies[len(fake.bodies)-1], ShouldEqual, `{"query": {"constant_score": {"filter": {"bool": {"must": [`+
+			`{"terms": {"team": ["EDM", "CGY", "VAN", "WPG"]}}, {"term": {"pos": "C"}}]}}}}, "_source": ["name"], "size": 2}`)
+		So(rs.Rows, ShouldResemble, [][]interface{}{{"Wayne Gretzky"}, {"Jari Kurri"}})
+		log.Debug(fake.bodies)
+
+		fake.responses = []string{`{"hits": {"total": 0, "hits": []}}`}
+		_, err = NewExecutor(fake.conn(), nil).Query(`SELECT name FROM oilers WHERE team NOT IN (SELECT code FROM teams LIMIT 5)`)
+		So(err, ShouldBeNil)
+		So(fake.bodies[len(fake.bodies)-1], ShouldContainSubstring, `{"bool": {"must_not": {"match_none": {}}}}`)
+	})
+
+	Convey("Test an IN subquery returning no values\n", t, func() {
+		c, err := NewCatalogFromFile("testdata/mapping.json")
+		So(err, ShouldBeNil)
+		fake := newFakeES(oilersHits)
+		fake.responses = []string{`{"hits": {"total": 0, "hits": []}}`}
+		defer fake.Close()
+
+		ex := NewExecutor(fake.conn(), nil)
+		ex.Translate.Catalog = c
+		_, err = ex.Query(`SELECT name FROM oilers WHERE quote IN (SELECT line FROM flames LIMIT 5) LIMIT 5`)
+		So(err, ShouldBeNil)
+		So(fake.bodies[len(fake.bodies)-1], ShouldEqual,
+			`{"query": {"constant_score": {"filter": {"match_none": {}}}}, "_source": ["name"], "size": 5}`)
+
+		fake.responses = []string{`{"hits": {"total": 0, "hits": []}}`}
+		_, err = ex.Query(`SELECT name FROM oilers WHERE quote NOT IN (SELECT line FROM flames LIMIT 5) LIMIT 5`)
+		So(err, ShouldBeNil)
+		So(fake.bodies[len(fake.bodies)-1], ShouldEqual,
+			`{"query": {"constant_score": {"filter": {"bool": {"must_not": {"match_none": {}}}}}}, "_source": ["name"], "size": 5}`)
+	})
+
+	Convey("Test resolving nested IN subqueries\n", t, func() {
+		fake := newFakeES(oilersHits)
+		fake.responses = []string{`{"hits": {"total": 1, "hits": [{"_source": {"city": "Edmonton"}}]}}`,
+			`{"hits": {"total": 1, "hits": [{"_source": {"code": 1}}, {"_source": {"code": 1.0}}, {"_source": {"code": 2.5}}]}}`}
+		defer fake.Close()
+
+		_, err := NewExecutor(fake.conn(), nil).Query(`SELECT name FROM oilers WHERE team IN ` +
+			`(SELECT code FROM teams WHERE city IN (SELECT city FROM arenas LIMIT 10) LIMIT 10) LIMIT 10`)
+		So(err, ShouldBeNil)
+		So(fake.bodies[0], ShouldEqual, `{"query": {"match_all": {}}, "_source": ["city"], "size": 10}`)
+		So(fake.bodies[1], ShouldEqual, `{"query": {"constant_score": {"filter": {"terms": {"city": ["Edmonton"]}}}}, "_source": ["code"], "size": 10}`)
+		So(fake.bodies[2], ShouldEqual, `{"query": {"constant_score": {"filter": {"terms": {"team": [1, 2.5]}}}}, "_source": ["name"], "size": 10}`)
+	})
+
+	Convey("Test the IN subquery value cap\n", t, func() {
+		fake := newFakeES("")
+		fake.responses = []string{westTeams}
+		defer fake.Close()
+
+		ex := NewExecutor(fake.conn(), nil)
+		ex.Subquery.MaxValues = 3
+		_, err := ex.Query(`SELECT name FROM oilers WHERE team IN (SELECT code FROM teams)`)
+		So(err, ShouldResemble, fmt.Errorf("IN subquery of team returns more than 3 distinct values"))
+	})
+
+	Convey("Test IN subquery errors\n", t, func() {
+		for _, c := range []struct{ q, err string }{
+			{`SELECT name FROM oilers o WHERE team IN (SELECT code FROM teams t WHERE t.city = o.city)`,
+				"IN subquery of team is correlated: o.city refers to the outer table o"},
+			{`SELECT name FROM oilers WHERE team IN (SELECT code FROM teams WHERE city IN (SELECT city FROM arenas WHERE owner = oilers.owner))`,
+				"IN subquery of city is correlated: oilers.owner refers to the outer table oilers"},
+			{`SELECT name FROM oilers WHERE team IN (SELECT code, city FROM teams)`,
+				"IN subquery of team must select a single column"},
+			{`SELECT name FROM oilers WHERE team IN (SELECT * FROM teams)`,
+				"IN subquery of team must select a single column"},
+		} {
+			_, err := Prepare(c.q)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, c.err)
+		}
+
+		p, err := Prepare(`SELECT name FROM oilers o WHERE team IN (SELECT o.code FROM teams o)`)
+		So(err, ShouldBeNil)
+		So(p.Indices(), ShouldResemble, []string{"oilers", "teams"})
+		_, err = p.Query()
+		So(err, ShouldResemble, fmt.Errorf("statement with IN subquery has no single elasticsearch query"))
+		_, err = testQuery(`SELECT name FROM oilers WHERE team IN (SELECT code FROM teams)`)
+		So(err, ShouldResemble, fmt.Errorf("statement with IN subquery has no single elasticsearch query"))
+	})
+
+	Convey("Test IN subqueries in JOINs\n", t, func() {
+		fake := newFakeES("")
+		fake.responses = []string{`{"hits": {"total": 1, "hits": [{"_source": {"code": "EDM"}}]}}`, joinPlayers, joinTeams}
+		defer fake.Close()
+
+		rs, err := NewExecutor(fake.conn(), nil).Query(`SELECT p.name, t.city FROM oilers p JOIN teams t ` +
+			`ON p.team = t.code WHERE t.code IN (SELECT code FROM champions) ORDER BY t.city`)
+		So(err, ShouldBeNil)
+		So(fake.bodies[len(fake.bodies)-1], ShouldContainSubstring, `{"terms": {"code": ["EDM"]}}`)
+		So(rs.Rows, ShouldResemble, [][]interface{}{
+			{"Lanny McDonald", "Calgary"}, {"Wayne Gretzky", "Edmonton"}, {"Wayne Gretzky", "Oklahoma City"}})
+	})
+
+	Convey("Test validating IN subqueries\n", t, func() {
+		So(testValidate(`SELECT name FROM oilers WHERE pos IN (SELECT pos FROM flames WHERE drafted > '1980-01-01')`), ShouldBeNil)
+
+		err := testValidate(`SELECT name FROM oilers WHERE pos IN (SELECT pso FROM flames WHERE nmae = 'Lanny McDonald')`)
+		So(validationMessages(err), ShouldResemble, []string{
+			"1:46: unknown column pso",
+			"1:68: unknown column nmae (did you mean name?)",
+		})
+	})
+}
@@ -0,0 +1,403 @@
+package essyntax
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// UnionOptions control the execution of statements with unions whose
+// branches cannot all be sent in a single _msearch request. Such branches
+// are run concurrently and their rows merged in memory.
+type UnionOptions struct {
+	// MaxRows bounds the rows of each branch without a LIMIT of its own.
+	MaxRows int
+}
+
+// unionBranches returns the statements whose rows a statement with unions
+// combines: the statement itself without its unions, ORDER BY and LIMIT,
+// followed by the Select of each union. If every union is a UNION ALL, the
+// LIMIT is pushed into each branch along with the ORDER BY, provided that
+// each of its keys is a plain field selected at the same position by every
+// branch.
+func unionBranches(s *sql.SelectStatement) []*sql.SelectStatement {
+
+	first := *s
+	first.Unions, first.OrderBy, first.Limit = nil, nil, nil
+	branches := []*sql.SelectStatement{&first}
+	all := true
+	for _, u := range s.Unions {
+		branches = append(branches, u.Select)
+		all = all && u.All
+	}
+	if !all || s.Limit == nil {
+		return branches
+	}
+
+	orders := make([][]sql.OrderItem, len(branches))
+	for _, o := range s.OrderBy {
+		k, ok := unionOrderField(s.FieldList, o)
+		if !ok {
+			return branches
+		}
+		for i, b := range branches {
+			if k >= len(b.FieldList) || b.FieldList[k].Expr != nil || strings.HasSuffix(b.FieldList[k].Name, "*") {
+				return branches
+			}
+			orders[i] = append(orders[i], sql.OrderItem{Expr: &sql.IdentExpr{Name: b.FieldList[k].Name}, Desc: o.Desc})
+		}
+	}
+	for i, b := range branches {
+		pushed := *b
+		pushed.OrderBy, pushed.Limit = orders[i], s.Limit
+		branches[i] = &pushed
+	}
+	return branches
+}
+
+// unionOrderField returns the position of the field of a select list named
+// by an ORDER BY key, through its alias if it has one.
+func unionOrderField(fields sql.Fields, o sql.OrderItem) (int, bool) {
+
+	ident, ok := o.Expr.(*sql.IdentExpr)
+	if !ok {
+		return 0, false
+	}
+	for k, f := range fields {
+		if f.Alias == ident.Name || len(f.Alias) == 0 && f.Name == ident.Name {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+// selectArity returns the number of columns a statement selects, which is
+// unknown until its rows are returned if it selects *.
+func selectArity(s *sql.SelectStatement) (int, bool) {
+
+	for _, f := range s.FieldList {
+		if f.Expr == nil && (f.Name == "*" || strings.HasSuffix(f.Name, ".*")) {
+			return 0, false
+		}
+	}
+	return len(s.FieldList), true
+}
+
+// unionArityError returns an error if a branch of a statement with unions
+// selects a different number of columns than the first. Branches selecting
+// * are checked once their rows are returned.
+func unionArityError(s *sql.SelectStatement, branch int, b *sql.SelectStatement) error {
+
+	want, ok := selectArity(s)
+	if !ok {
+		return nil
+	}
+	if n, ok := selectArity(b); ok && n != want {
+		return fmt.Errorf("UNION branch %d has %d columns, expected %d", branch, n, want)
+	}
+	return nil
+}
+
+// checkUnion checks that each branch of a statement with unions selects as
+// many columns as the first, and that each ORDER BY key names a column,
+// which is all the combined rows can be sorted by.
+func checkUnion(s *sql.SelectStatement) error {
+
+	if len(s.Unions) == 0 {
+		return nil
+	}
+	for i, u := range s.Unions {
+		if err := unionArityError(s, i+2, u.Select); err != nil {
+			return err
+		}
+	}
+	for _, o := range s.OrderBy {
+		if _, ok := o.Expr.(*sql.IdentExpr); !ok {
+			return fmt.Errorf("ORDER BY %s is not a column of the UNION", sql.FormatExpr(o.Expr))
+		}
+	}
+	return nil
+}
+
+// msearchError returns an error if the branches of a statement with unions
+// cannot be sent in a single _msearch request, whose searches are not paged:
+// each branch must have a single query, and either be aggregated or have a
+// LIMIT.
+func msearchError(branches []*sql.SelectStatement) error {
+
+	for i, b := range branches {
+		if err := stagedError(b); err != nil {
+			return err
+		}
+		if _, ok := countField(b); ok {
+			return fmt.Errorf("statement with UNION has no single elasticsearch query: branch %d is answered by the _count API", i+1)
+		}
+		if b.Limit == nil && !isAggregate(b) {
+			return fmt.Errorf("statement with UNION has no single elasticsearch query: branch %d has no LIMIT", i+1)
+		}
+	}
+	return nil
+}
+
+// genMsearch returns the _msearch request body for a statement with unions:
+// for each branch, a header naming its indices followed by its search
+// request body, each on a line of its own.
+func (t *translator) genMsearch(s *sql.SelectStatement) (string, error) {
+
+	var buf bytes.Buffer
+	for _, b := range unionBranches(s) {
+		t.indices = nil
+		query, err := t.genQuery(b)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, "{\"index\": \"%s\"}\n%s\n", indexList(b.TableList), query)
+	}
+	return buf.String(), nil
+}
+
+// unionBranch holds the rows returned by a branch of a statement with
+// unions.
+type unionBranch struct {
+	cols  []Column
+	rows  [][]interface{}
+	total int
+}
+
+// streamUnion executes a statement with unions, whose parameters must be
+// bound, and returns a Stream of the combined rows. The branches are sent
+// as the specified _msearch request body, or run concurrently if it is
+// empty.
+func (e *Executor) streamUnion(ctx context.Context, s *sql.SelectStatement, query string) (*Stream, error) {
+
+	branches := unionBranches(s)
+	var results []unionBranch
+	var err error
+	if len(query) > 0 {
+		results, err = e.msearch(ctx, branches, query)
+	} else {
+		results, err = e.runBranches(ctx, branches)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cols, rows, total, err := mergeUnion(s, results)
+	if err != nil {
+		return nil, err
+	}
+	return e.rowStream(ctx, s, cols, rows, total)
+}
+
+// msearch sends the searches of the branches in a single _msearch request,
+// and decodes the rows of each.
+func (e *Executor) msearch(ctx context.Context, branches []*sql.SelectStatement, query string) ([]unionBranch, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	body, err := doRequest(e.conn, "POST", "/_msearch", nil, query, ndjsonContent)
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Responses []json.RawMessage `json:"responses"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Responses) != len(branches) {
+		return nil, fmt.Errorf("_msearch returned %d responses for %d UNION branches", len(res.Responses), len(branches))
+	}
+
+	results := make([]unionBranch, len(branches))
+	for i, raw := range res.Responses {
+		var failed struct {
+			Error json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(raw, &failed); err != nil {
+			return nil, err
+		}
+		if len(failed.Error) > 0 {
+			return nil, fmt.Errorf("error running UNION branch %d: %s", i+1, failed.Error)
+		}
+
+		sr, err := decodeSearchResult(raw)
+		if err != nil {
+			return nil, err
+		}
+		dec, err := NewDecoder(branches[i], e.Decoding)
+		if err != nil {
+			return nil, err
+		}
+		if err := dec.Decode(&sr); err != nil {
+			return nil, fmt.Errorf("error running UNION branch %d: %v", i+1, err)
+		}
+		results[i].total = sr.Hits.Total
+		for {
+			row, err := dec.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error running UNION branch %d: %v", i+1, err)
+			}
+			results[i].rows = append(results[i].rows, row)
+		}
+		results[i].cols = dec.Columns()
+	}
+	return results, nil
+}
+
+// runBranches runs the branches concurrently, each paged through by a
+// Stream of its own. The first branch to fail cancels the others.
+func (e *Executor) runBranches(ctx context.Context, branches []*sql.SelectStatement) ([]unionBranch, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	max := memoryLimit(e.Union.MaxRows)
+
+	results := make([]unionBranch, len(branches))
+	var wg sync.WaitGroup
+	var once sync.Once
+	var first error
+	for i, b := range branches {
+		wg.Add(1)
+		go func(i int, b *sql.SelectStatement) {
+			defer wg.Done()
+			var err error
+			if results[i], err = e.runBranch(ctx, i, b, max); err != nil {
+				once.Do(func() {
+					first = err
+					cancel()
+				})
+			}
+		}(i, b)
+	}
+	wg.Wait()
+	return results, first
+}
+
+// runBranch returns every row of a branch. A branch without a LIMIT which
+// is not aggregated may return at most max rows.
+func (e *Executor) runBranch(ctx context.Context, i int, b *sql.SelectStatement, max int) (unionBranch, error) {
+
+	var res unionBranch
+	capped := b.Limit == nil && !isAggregate(b)
+	if capped {
+		limited := *b
+		limited.Limit = &sql.NumExpr{Val: float64(max + 1)}
+		b = &limited
+	}
+
+	st, err := e.streamStatement(ctx, b)
+	if err != nil {
+		return res, fmt.Errorf("error running UNION branch %d: %v", i+1, err)
+	}
+	defer st.Close()
+
+	for {
+		row, err := st.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, fmt.Errorf("error running UNION branch %d: %v", i+1, err)
+		}
+		res.rows = append(res.rows, row)
+	}
+	if capped && len(res.rows) > max {
+		return res, fmt.Errorf("UNION branch %d has more than %d rows", i+1, max)
+	}
+	res.cols, res.total = st.Columns(), st.Total
+	return res, nil
+}
+
+// mergeUnion combines the rows of the branches of a statement with unions,
+// named by the columns of the first branch, in ORDER BY order. Each UNION
+// drops the duplicates among the rows combined so far, while a UNION ALL
+// keeps them. The column types are inferred anew from the combined rows,
+// and the total is that of every branch.
+func mergeUnion(s *sql.SelectStatement, branches []unionBranch) ([]Column, [][]interface{}, int, error) {
+
+	cols := append([]Column{}, branches[0].cols...)
+	var rows [][]interface{}
+	total := 0
+	for i, b := range branches {
+		if len(b.cols) != len(cols) {
+			return nil, nil, 0, fmt.Errorf("UNION branch %d has %d columns, expected %d", i+1, len(b.cols), len(cols))
+		}
+		rows = append(rows, b.rows...)
+		total += b.total
+		if i > 0 && !s.Unions[i-1].All {
+			rows = distinctRows(rows)
+		}
+	}
+
+	retypeRows(cols, rows)
+
+	order := make([]int, len(s.OrderBy))
+	for k, o := range s.OrderBy {
+		name := o.Expr.(*sql.IdentExpr).Name
+		order[k] = -1
+		for i, c := range cols {
+			if c.Name == name {
+				order[k] = i
+				break
+			}
+		}
+		if order[k] < 0 {
+			return nil, nil, 0, fmt.Errorf("ORDER BY %s is not a column of the UNION", name)
+		}
+	}
+	sort.SliceStable(rows, func(a, b int) bool {
+		for k, o := range s.OrderBy {
+			c := compareValues(rows[a][order[k]], rows[b][order[k]])
+			if c != 0 {
+				return (c < 0) != o.Desc
+			}
+		}
+		return false
+	})
+
+	return cols, rows, total, nil
+}
+
+// distinctRows returns the rows without duplicates, keeping the first of
+// each.
+func distinctRows(rows [][]interface{}) [][]interface{} {
+
+	seen := make(map[string]bool, len(rows))
+	distinct := rows[:0]
+	for _, row := range rows {
+		key := rowKey(row)
+		if !seen[key] {
+			seen[key] = true
+			distinct = append(distinct, row)
+		}
+	}
+	return distinct
+}
+
+// rowKey returns a key under which rows with equal values collide. Unlike
+// joinHash, missing and multi-valued values are keyed too, since a UNION
+// treats equal nulls and equal arrays as duplicates. Integers are keyed as
+// doubles, so that 1 equals 1.0.
+func rowKey(row []interface{}) string {
+
+	parts := make([]string, len(row))
+	for i, v := range row {
+		if n, ok := v.(int64); ok {
+			v = float64(n)
+		}
+		parts[i] = fmt.Sprintf("%T:%v", v, v)
+	}
+	return strings.Join(parts, "\x00")
+}
@@ -0,0 +1,176 @@
+package essyntax
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	"github.com/oldenbur/sql-parser/sql"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+const flamesHits = `{"hits": {"total": 2, "hits": [
+	{"_source": {"name": "Lanny McDonald", "goals": 66}},
+	{"_source": {"name": "Kent Nilsson", "goals": 49}}]}}`
+
+func TestUnion(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test sending UNION ALL branches as one _msearch request\n", t, func() {
+		fake := newFakeES(`{"responses": [` + oilersHits + `, ` + flamesHits + `]}`)
+		defer fake.Close()
+
+		q := `SELECT name, goals FROM oilers WHERE pos = ? UNION ALL SELECT name, goals FROM flames WHERE pos = ? ` +
+			`ORDER BY goals DESC LIMIT 3`
+		p, err := Prepare(q)
+		So(err, ShouldBeNil)
+		query, err := p.Query("C", "C")
+		So(err, ShouldBeNil)
+		So(query, ShouldEqual, strings.Join([]string{
+			`{"index": "oilers"}`,
+			`{"query": {"constant_score": {"filter": {"term": {"pos": "C"}}}}, "_source": ["name", "goals"], ` +
+				`"sort": [{"goals": {"order": "desc"}}], "size": 3}`,
+			`{"index": "flames"}`,
+			`{"query": {"constant_score": {"filter": {"term": {"pos": "C"}}}}, "_source": ["name", "goals"], ` +
+				`"sort": [{"goals": {"order": "desc"}}], "size": 3}`,
+			``}, "\n"))
+
+		rs, err := NewExecutor(fake.conn(), nil).Query(q, "C", "C")
+		So(err, ShouldBeNil)
+		So(fake.paths, ShouldResemble, []string{"POST /_msearch"})
+		So(fake.bodies[0], ShouldEqual, query)
+		So(rs.Columns, ShouldResemble, []Column{
+			{Name: "name", Field: "name", Type: TypeString},
+			{Name: "goals", Field: "goals", Type: TypeDouble}})
+		So(rs.Rows, ShouldResemble, [][]interface{}{
+			{"Wayne Gretzky", float64(92)}, {"Lanny McDonald", float64(66)}, {"Kent Nilsson", float64(49)}})
+		So(rs.Total, ShouldEqual, 9)
+		log.Debug(rs.Rows)
+
+		fake.response = `{"responses": [` + strings.Replace(oilersHits, `"total": 7`, `"total": {"value": 7, "relation": "eq"}`, 1) +
+			`, ` + strings.Replace(flamesHits, `"total": 2`, `"total": {"value": 2, "relation": "eq"}`, 1) + `]}`
+		fake.version = "7.10.2"
+		rs, err = NewExecutor(fake.conn(), nil).Query(q, "C", "C")
+		So(err, ShouldBeNil)
+		So(rs.Rows, ShouldHaveLength, 3)
+		So(rs.Total, ShouldEqual, 9)
+	})
+
+	Convey("Test running UNION branches concurrently\n", t, func() {
+		fake := newFakeES(oilersHits)
+		defer fake.Close()
+
+		rs, err := NewExecutor(fake.conn(), nil).Query(`SELECT name FROM oilers WHERE pos = 'C' ` +
+			`UNION SELECT name FROM flames WHERE pos = 'C' ORDER BY name`)
+		So(err, ShouldBeNil)
+		So(fake.paths, ShouldContain, "POST /oilers/_search")
+		So(fake.paths, ShouldContain, "POST /flames/_search")
+		So(rs.Rows, ShouldResemble, [][]interface{}{{"Jari Kurri"}, {"Mark Messier"}, {"Wayne Gretzky"}})
+		So(rs.Total, ShouldEqual, 14)
+
+		rs, err = NewExecutor(fake.conn(), nil).Query(`SELECT name FROM oilers UNION ALL SELECT name FROM flames ` +
+			`UNION SELECT name FROM jets`)
+		So(err, ShouldBeNil)
+		So(rs.Rows, ShouldResemble, [][]interface{}{{"Wayne Gretzky"}, {"Jari Kurri"}, {"Mark Messier"}})
+
+		rs, err = NewExecutor(fake.conn(), nil).Query(`SELECT name FROM oilers UNION SELECT name FROM flames ` +
+			`UNION ALL SELECT name FROM jets LIMIT 5`)
+		So(err, ShouldBeNil)
+		So(rs.Rows, ShouldResemble, [][]interface{}{
+			{"Wayne Gretzky"}, {"Jari Kurri"}, {"Mark Messier"}, {"Wayne Gretzky"}, {"Jari Kurri"}})
+	})
+
+	Convey("Test the UNION row cap\n", t, func() {
+		fake := newFakeES(oilersHits)
+		defer fake.Close()
+
+		ex := NewExecutor(fake.conn(), nil)
+		ex.Union.MaxRows = 2
+		_, err := ex.Query(`SELECT name FROM oilers UNION SELECT pos FROM flames GROUP BY pos`)
+		So(err, ShouldResemble, fmt.Errorf("UNION branch 1 has more than 2 rows"))
+	})
+
+	Convey("Test UNION result errors\n", t, func() {
+		fake := newFakeES(`{"responses": [` + oilersHits + `, ` + flamesHits + `]}`)
+		defer fake.Close()
+
+		_, err := NewExecutor(fake.conn(), nil).Query(`SELECT name FROM oilers UNION ALL SELECT name, goals FROM flames LIMIT 5`)
+		So(err, ShouldResemble, fmt.Errorf("UNION branch 2 has 2 columns, expected 1"))
+		So(fake.paths, ShouldBeEmpty)
+
+		_, err = NewExecutor(fake.conn(), nil).Query(`SELECT name FROM oilers UNION ALL SELECT name FROM flames ORDER BY goals LIMIT 5`)
+		So(err, ShouldResemble, fmt.Errorf("ORDER BY goals is not a column of the UNION"))
+
+		fake.response = `{"responses": [` + oilersHits + `, {"error": {"type": "index_not_found_exception"}, "status": 404}]}`
+		_, err = NewExecutor(fake.conn(), nil).Query(`SELECT name FROM oilers UNION ALL SELECT name FROM flames LIMIT 5`)
+		So(err, ShouldResemble, fmt.Errorf(`error running UNION branch 2: {"type": "index_not_found_exception"}`))
+	})
+
+	Convey("Test pushing ORDER BY and LIMIT into UNION ALL branches\n", t, func() {
+		branches := func(q string) []*sql.SelectStatement {
+			stmt, err := sql.NewParser(strings.NewReader(q)).Parse()
+			So(err, ShouldBeNil)
+			return unionBranches(stmt)
+		}
+
+		b := branches(`SELECT name n FROM oilers UNION ALL SELECT player FROM flames ORDER BY n DESC LIMIT 2`)
+		So(b, ShouldHaveLength, 2)
+		So(b[0].String(), ShouldEqual, `SELECT name n FROM oilers ORDER BY name DESC LIMIT 2.000000`)
+		So(b[1].String(), ShouldEqual, `SELECT player FROM flames ORDER BY player DESC LIMIT 2.000000`)
+
+		for _, q := range []string{
+			`SELECT name FROM oilers UNION SELECT name FROM flames ORDER BY name LIMIT 2`,
+			`SELECT name FROM oilers UNION ALL SELECT * FROM flames ORDER BY name LIMIT 2`,
+			`SELECT name FROM oilers UNION ALL SELECT name FROM flames ORDER BY name`,
+		} {
+			for _, s := range branches(q) {
+				So(s.OrderBy, ShouldBeNil)
+				So(s.Limit, ShouldBeNil)
+			}
+		}
+	})
+
+	Convey("Test preparing UNIONs\n", t, func() {
+		for _, c := range []struct{ q, err string }{
+			{`SELECT name FROM oilers UNION SELECT name FROM flames ORDER BY UPPER(name)`,
+				"ORDER BY UPPER(name) is not a column of the UNION"},
+			{`SELECT name FROM oilers UNION SELECT name FROM flames p JOIN teams t ON p.team = t.code`,
+				"column name must be qualified by a table of the JOIN"},
+			{`SELECT name FROM oilers UNION SELECT name FROM flames f WHERE team IN (SELECT code FROM teams WHERE city = f.city)`,
+				"IN subquery of team is correlated: f.city refers to the outer table f"},
+		} {
+			_, err := Prepare(c.q)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, c.err)
+		}
+
+		p, err := Prepare(`SELECT name FROM oilers UNION ALL SELECT name FROM flames WHERE team IN (SELECT code FROM teams)`)
+		So(err, ShouldBeNil)
+		So(p.Indices(), ShouldResemble, []string{"oilers", "flames", "teams"})
+		So(p.readsIndex("teams", nil), ShouldBeTrue)
+		_, err = p.Query()
+		So(err, ShouldResemble, fmt.Errorf("statement with IN subquery has no single elasticsearch query"))
+
+		_, err = testQuery(`SELECT name FROM oilers UNION SELECT name FROM flames LIMIT 3`)
+		So(err, ShouldResemble, fmt.Errorf("statement with UNION has no single elasticsearch query: branch 1 has no LIMIT"))
+		_, err = testQuery(`SELECT COUNT(*) FROM oilers UNION ALL SELECT COUNT(*) FROM flames`)
+		So(err, ShouldResemble, fmt.Errorf("statement with UNION has no single elasticsearch query: branch 1 is answered by the _count API"))
+		_, err = testQuery(`SELECT pos, COUNT(*) FROM oilers GROUP BY pos UNION SELECT pos, COUNT(*) FROM flames GROUP BY pos`)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Test validating UNIONs\n", t, func() {
+		So(testValidate(`SELECT pos FROM oilers UNION SELECT pos FROM flames WHERE drafted > '1980-01-01' ORDER BY pos`), ShouldBeNil)
+
+		err := testValidate(`SELECT name FROM oilers UNION ALL SELECT nmae FROM flames UNION SELECT name FROM kings`)
+		So(validationMessages(err), ShouldResemble, []string{
+			"1:42: unknown column nmae (did you mean name?)",
+			"1:82: unknown index kings",
+		})
+	})
+}
@@ -0,0 +1,406 @@
+package essyntax
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/oldenbur/sql-parser/sql"
+)
+
+// numericTypes lists the elasticsearch field types holding numbers.
+var numericTypes = []string{
+	"long", "integer", "short", "byte", "double", "float", "half_float", "scaled_float", "unsigned_long",
+}
+
+// ValidationError describes a problem found by Validate at a position in the
+// SQL text, which is only valid if the statement was parsed with
+// sql.RecordPositions. Suggestion, if not empty, proposes a fix.
+type ValidationError struct {
+	Pos        sql.Pos
+	Msg        string
+	Suggestion string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Suggestion) == 0 {
+		return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s (%s)", e.Pos, e.Msg, e.Suggestion)
+}
+
+// ValidationErrors holds every problem found by Validate, in the order they
+// appear in the SQL text.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e[0], len(e)-1)
+}
+
+// Validate checks the specified statement against the mappings in the
+// Catalog, first loading those of any indices named by the statement which
+// it lacks, and returns ValidationErrors listing any unknown indices or columns, any calls
+// which do not match the signatures of DefaultFunctions, and any comparisons,
+// aggregates or sorts unsuited to the types of their fields.
+func Validate(s *sql.SelectStatement, c *Catalog) error {
+	return ValidateFunctions(s, c, DefaultFunctions)
+}
+
+// ValidateFunctions is like Validate, but checks calls against the
+// signatures of the specified FunctionSet.
+func ValidateFunctions(s *sql.SelectStatement, c *Catalog, fs *FunctionSet) error {
+
+	v := &validator{s: s, c: c, fs: fs}
+	v.validate()
+	if len(v.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.errs, func(i, j int) bool { return v.errs[i].Pos.Offset < v.errs[j].Pos.Offset })
+	return v.errs
+}
+
+// validator accumulates the problems found in a statement.
+type validator struct {
+	s       *sql.SelectStatement
+	c       *Catalog
+	fs      *FunctionSet
+	indices []string // the known indices named by the statement
+	scope   nestedScope
+	errs    ValidationErrors
+
+	// subquery is set for the statement of an IN subquery or of a union,
+	// whose calls are checked along with those of the enclosing statement.
+	subquery bool
+}
+
+func (v *validator) errorf(pos sql.Pos, suggestion, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{Pos: pos, Msg: fmt.Sprintf(format, args...), Suggestion: suggestion})
+}
+
+func (v *validator) validate() {
+
+	if !v.subquery {
+		for _, e := range v.fs.Registry.Check(v.s) {
+			v.errorf(e.Pos, "", "%s", e.Msg)
+		}
+	}
+
+	for i, u := range v.s.Unions {
+		if err := unionArityError(v.s, i+2, u.Select); err != nil {
+			v.errorf(u.Pos, "", "%s", err)
+		}
+		inner := &validator{s: u.Select, c: v.c, fs: v.fs, subquery: true}
+		inner.validate()
+		v.errs = append(v.errs, inner.errs...)
+	}
+
+	tables := v.s.Tables()
+	for _, t := range tables {
+		// An index whose mapping cannot be loaded is reported as unknown.
+		v.c.Load(sql.Fields{t})
+	}
+	known := v.c.Indices()
+	for _, t := range tables {
+		if containsString(known, t.Name) {
+			v.indices = append(v.indices, t.Name)
+			continue
+		}
+		v.errorf(t.Pos, didYouMean(t.Name, known), "unknown index %s", t.Name)
+	}
+	if len(v.indices) == 0 {
+		return
+	}
+
+	for _, f := range v.s.FieldList {
+		if call, ok := f.Expr.(*sql.FuncCallExpr); ok {
+			v.validateCall(call)
+		} else if name := unqualifyName(f.Name, tables); name != "*" {
+			v.field(name, f.Pos)
+		}
+	}
+
+	v.scope = newNestedScope(tables)
+	for _, j := range v.s.Joins {
+		on := unqualifyCond(j.On, tables)
+		v.validateCond(on)
+		for _, c := range andOperands(on) {
+			if comp, ok := c.(*sql.CondComp); ok {
+				if ident, ok := comp.Val.(*sql.IdentExpr); ok {
+					v.field(ident.Name, ident.Pos)
+				}
+			}
+		}
+	}
+	if v.s.WhereCond != nil {
+		v.validateCond(unqualifyCond(v.s.WhereCond, tables))
+	}
+
+	for _, e := range v.s.GroupBy {
+		switch e := e.(type) {
+		case *sql.IdentExpr:
+			if isComputedAlias(v.s.FieldList, e.Name) {
+				continue
+			}
+			if f, ok := v.field(fieldName(v.s.FieldList, e.Name), e.Pos); ok && f.Type == "text" {
+				v.errorf(e.Pos, v.keywordSuggestion(f), "cannot group by text field %s", f.Path)
+			}
+		case *sql.FuncCallExpr:
+			v.validateCall(e)
+		}
+	}
+
+	for _, o := range v.s.OrderBy {
+		if call, ok := o.Expr.(*sql.FuncCallExpr); ok {
+			v.validateCall(call)
+			continue
+		}
+		ident, ok := o.Expr.(*sql.IdentExpr)
+		if !ok || isComputedAlias(v.s.FieldList, ident.Name) {
+			continue
+		}
+		if f, ok := v.field(unqualifyName(fieldName(v.s.FieldList, ident.Name), tables), ident.Pos); ok && f.Type == "text" {
+			v.errorf(ident.Pos, v.keywordSuggestion(f), "cannot sort by text field %s", f.Path)
+		}
+	}
+}
+
+// validateCall checks the field arguments of a function call, which must not
+// be text fields if the function is an aggregate or HISTOGRAM, and must be
+// geo_point fields if it is a geo function.
+func (v *validator) validateCall(call *sql.FuncCallExpr) {
+
+	_, agg := metricAggs[strings.ToUpper(call.Name)]
+	agg = agg || strings.ToUpper(call.Name) == histogramFunc.Name
+	for _, arg := range call.Args {
+		ident, ok := arg.(*sql.IdentExpr)
+		if !ok || ident.Name == "*" {
+			continue
+		}
+		f, ok := v.field(ident.Name, ident.Pos)
+		switch {
+		case !ok:
+		case agg && f.Type == "text":
+			v.errorf(ident.Pos, v.keywordSuggestion(f), "cannot aggregate over text field %s", f.Path)
+		case isGeoCall(call) && f.Type != "geo_point":
+			v.errorf(ident.Pos, "", "%s of %s field %s, which is not a geo_point", call.Name, f.Type, f.Path)
+		}
+	}
+}
+
+// validateCond checks the fields of a condition tree and the values they are
+// compared with.
+func (v *validator) validateCond(cond sql.Cond) {
+
+	switch c := cond.(type) {
+	case *sql.CondConj:
+		v.validateCond(c.Left)
+		v.validateCond(c.Right)
+
+	case *sql.CondComp:
+		f, ok := v.field(c.Ident, c.Pos)
+		if !ok {
+			return
+		}
+		switch {
+		case c.CondOp == sql.LIKE && isNumeric(f):
+			v.errorf(c.Pos, "use a comparison or IN", "LIKE on %s field %s", f.Type, f.Path)
+		case c.CondOp == sql.LIKE && f.Type == "text" && len(v.keywordSuggestion(f)) == 0:
+			v.errorf(c.Pos, "", "LIKE on text field %s, which has no keyword subfield", f.Path)
+		case genRangeOp(c.CondOp) != "" && f.Type == "text":
+			v.errorf(c.Pos, v.keywordSuggestion(f), "range comparison on text field %s", f.Path)
+		case f.Path == indexField && (c.CondOp == sql.EQ || c.CondOp == sql.NE):
+			v.validateIndexName(c.Val, c.Pos)
+		default:
+			v.validateValue(f, c.Val, c.Pos)
+		}
+
+	case *sql.CondFunc:
+		v.validateCall(c.Call)
+
+	case *sql.CondIn:
+		if sub, ok := inSubquery(c); ok {
+			v.field(c.Ident, c.Pos)
+			inner := &validator{s: sub.Select, c: v.c, fs: v.fs, subquery: true}
+			inner.validate()
+			v.errs = append(v.errs, inner.errs...)
+		} else if f, ok := v.field(c.Ident, c.Pos); ok {
+			for _, val := range c.Vals {
+				if f.Path == indexField {
+					v.validateIndexName(val, c.Pos)
+				} else {
+					v.validateValue(f, val, c.Pos)
+				}
+			}
+		}
+
+	case *sql.CondExists:
+		v.validateExists(c)
+	}
+}
+
+// validateIndexName checks that an index name compared with the _index
+// pseudo-column is one of those the tables of the statement resolve to.
+func (v *validator) validateIndexName(val sql.Expr, pos sql.Pos) {
+
+	str, ok := val.(*sql.StringExpr)
+	if !ok {
+		return
+	}
+	var names []string
+	for _, index := range v.indices {
+		resolved, _ := v.c.Resolve(index)
+		names = append(names, resolved...)
+	}
+	if name := str.Unquoted(); !containsString(names, name) {
+		v.errorf(pos, didYouMean(name, names), "index %s is not selected by FROM", name)
+	}
+}
+
+// validateExists checks that the table of an EXISTS subquery is a nested
+// field, and validates the conditions of the subquery against its objects.
+func (v *validator) validateExists(e *sql.CondExists) {
+
+	sub, err := v.scope.enter(e.Select)
+	if err != nil {
+		v.errorf(e.Pos, "", "%s", err)
+		return
+	}
+	table := e.Select.TableList[0]
+	f, ok := v.field(sub.path, table.Pos)
+	if !ok {
+		return
+	}
+	if f.Type != "nested" {
+		v.errorf(table.Pos, "", "EXISTS subquery table %s is %s field %s, which is not nested", table.Name, f.Type, f.Path)
+		return
+	}
+
+	if e.Select.WhereCond != nil {
+		cond, err := v.scope.qualifyCond(e.Select.WhereCond, table, sub.path)
+		if err != nil {
+			v.errorf(e.Pos, "", "%s", err)
+			return
+		}
+		outer := v.scope
+		v.scope = sub
+		v.validateCond(cond)
+		v.scope = outer
+	}
+}
+
+// validateValue checks that a value compared with the specified field is of
+// a suitable type.
+func (v *validator) validateValue(f FieldInfo, val sql.Expr, pos sql.Pos) {
+
+	if v.fs.isDateExpr(val) && f.Type != "date" && f.Type != "date_nanos" {
+		v.errorf(pos, "", "date %s compared with %s field %s", sql.FormatExpr(val), f.Type, f.Path)
+		return
+	}
+
+	str, ok := val.(*sql.StringExpr)
+	if !ok || !isNumeric(f) {
+		return
+	}
+	suggestion := ""
+	if _, err := strconv.ParseFloat(str.Unquoted(), 64); err == nil {
+		suggestion = fmt.Sprintf("remove the quotes from %s", str.Val)
+	}
+	v.errorf(pos, suggestion, "string %s compared with %s field %s", str.Val, f.Type, f.Path)
+}
+
+// field returns the field with the specified path in the first index of the
+// statement mapping it, recording an error if no index does.
+func (v *validator) field(path string, pos sql.Pos) (FieldInfo, bool) {
+
+	var paths []string
+	for _, index := range v.indices {
+		if f, ok := v.c.Field(index, path); ok {
+			return f, true
+		}
+		for _, f := range v.c.Fields(index) {
+			paths = append(paths, f.Path)
+		}
+	}
+	v.errorf(pos, didYouMean(path, paths), "unknown column %s", path)
+	return FieldInfo{}, false
+}
+
+// keywordSuggestion proposes the keyword multi-field of a text field, if it
+// has one.
+func (v *validator) keywordSuggestion(f FieldInfo) string {
+
+	path := f.Path + ".keyword"
+	for _, index := range v.indices {
+		if k, ok := v.c.Field(index, path); ok && k.Type == "keyword" {
+			return fmt.Sprintf("use %s instead", path)
+		}
+	}
+	return ""
+}
+
+// isComputedAlias returns true if name is the alias of a selected field
+// computed by a function call, whose arguments are checked with the select
+// list.
+func isComputedAlias(fields sql.Fields, name string) bool {
+	for _, f := range fields {
+		if f.Alias == name {
+			return f.Expr != nil
+		}
+	}
+	return false
+}
+
+// isNumeric returns true for fields holding numbers.
+func isNumeric(f FieldInfo) bool {
+	return containsString(numericTypes, f.Type)
+}
+
+// didYouMean suggests the candidate closest to name, or returns an empty
+// string if none is close enough to be a likely misspelling.
+func didYouMean(name string, candidates []string) string {
+
+	best, bestDist := "", len(name)/2+1
+	for _, c := range candidates {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(c)); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	if len(best) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("did you mean %s?", best)
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,155 @@
+package essyntax
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	"github.com/oldenbur/sql-parser/sql"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+// testValidate parses the specified SQL text, recording positions, and
+// validates it against the offline catalog in testdata.
+func testValidate(q string) error {
+
+	c, err := NewCatalogFromFile("testdata/mapping.json")
+	if err != nil {
+		panic(err)
+	}
+	stmt, err := sql.NewParserMode(strings.NewReader(q), sql.RecordPositions).Parse()
+	if err != nil {
+		panic(err)
+	}
+	return Validate(stmt, c)
+}
+
+// validationMessages returns the text of each error found by Validate.
+func validationMessages(err error) []string {
+
+	var msgs []string
+	for _, e := range err.(ValidationErrors) {
+		msgs = append(msgs, e.Error())
+	}
+	return msgs
+}
+
+func TestValidate(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test valid statements\n", t, func() {
+		So(testValidate(`SELECT * FROM oilers`), ShouldBeNil)
+		So(testValidate(`SELECT name, stats.ppg p FROM oilers WHERE goals > 50 AND pos IN ('C', 'LW') ORDER BY p DESC`), ShouldBeNil)
+		So(testValidate(`SELECT name FROM oilers WHERE name.keyword LIKE 'G%' AND jersey = 99 AND name = 'Gretzky'`), ShouldBeNil)
+		So(testValidate(`SELECT pos, MAX(goals) most FROM oilers WHERE dob > '19610126' GROUP BY pos`), ShouldBeNil)
+		So(testValidate(`SELECT pos p, HISTOGRAM(dob, INTERVAL 1 YEAR) yr, COUNT(*) FROM oilers GROUP BY p, yr`), ShouldBeNil)
+		So(testValidate(`SELECT COUNT(*) FROM oilers, flames`), ShouldBeNil)
+		So(testValidate(`SELECT drafted FROM oilers, flames ORDER BY drafted`), ShouldBeNil)
+
+		mapping, err := ioutil.ReadFile("testdata/mapping.json")
+		So(err, ShouldBeNil)
+		fake := newFakeES(string(mapping))
+		defer fake.Close()
+		stmt, err := sql.NewParserMode(strings.NewReader(`SELECT name FROM oilers WHERE goals > 50`), sql.RecordPositions).Parse()
+		So(err, ShouldBeNil)
+		So(Validate(stmt, NewCatalog(fake.conn())), ShouldBeNil)
+		So(fake.paths, ShouldResemble, []string{"GET /oilers/_mapping"})
+	})
+
+	Convey("Test unknown indices and columns\n", t, func() {
+		err := testValidate(`SELECT name FROM oiler`)
+		So(err, ShouldHaveSameTypeAs, ValidationErrors{})
+		So(err.(ValidationErrors)[0], ShouldResemble, &ValidationError{
+			Pos:        sql.Pos{Offset: 17, Line: 1, Column: 18},
+			Msg:        "unknown index oiler",
+			Suggestion: "did you mean oilers?",
+		})
+		So(err.Error(), ShouldEqual, "1:18: unknown index oiler (did you mean oilers?)")
+
+		err = testValidate(`SELECT nmae, assists FROM kings`)
+		So(validationMessages(err), ShouldResemble, []string{"1:27: unknown index kings"})
+
+		err = testValidate("SELECT nmae, assists\nFROM oilers\nWHERE golas > 50 ORDER BY jersy")
+		So(validationMessages(err), ShouldResemble, []string{
+			"1:8: unknown column nmae (did you mean name?)",
+			"1:14: unknown column assists",
+			"3:7: unknown column golas (did you mean goals?)",
+			"3:27: unknown column jersy (did you mean jersey?)",
+		})
+		So(err.Error(), ShouldEqual, "1:8: unknown column nmae (did you mean name?) (and 3 more errors)")
+
+		err = testValidate(`SELECT MAX(stats.pgp) FROM oilers GROUP BY poss`)
+		So(validationMessages(err), ShouldResemble, []string{
+			"1:12: unknown column stats.pgp (did you mean stats.ppg?)",
+			"1:44: unknown column poss (did you mean pos?)",
+		})
+
+		err = testValidate(`SELECT name FROM oilers WHERE MULTI_MATCH('puck', quote, nmae, boost = 2)`)
+		So(validationMessages(err), ShouldResemble, []string{"1:58: unknown column nmae (did you mean name?)"})
+	})
+
+	Convey("Test UNION errors\n", t, func() {
+		So(testValidate(`SELECT name, goals FROM oilers UNION SELECT * FROM flames`), ShouldBeNil)
+
+		err := testValidate(`SELECT name FROM oilers UNION ALL SELECT name, pos FROM flames UNION SELECT nmae FROM flames`)
+		So(validationMessages(err), ShouldResemble, []string{
+			"1:25: UNION branch 2 has 2 columns, expected 1",
+			"1:77: unknown column nmae (did you mean name?)",
+		})
+	})
+
+	Convey("Test function call errors\n", t, func() {
+		err := testValidate(`SELECT name, FOO(goals) FROM oilers WHERE MATCH(quote) ORDER BY MAX(goals)`)
+		So(validationMessages(err), ShouldResemble, []string{
+			"1:14: unknown function FOO",
+			"1:43: MATCH expects 2 arguments, got 1",
+			"1:65: aggregate MAX is only allowed in the select list",
+		})
+
+		fs := NewFunctionSet()
+		So(fs.RegisterScalar(sql.FuncSignature{Name: "FOO", Args: []sql.ArgType{sql.FieldType}, Returns: sql.NumberType},
+			func(call *sql.FuncCallExpr, value func(sql.Expr) (string, error)) (string, error) {
+				return `{"source": "0"}`, nil
+			}), ShouldBeNil)
+		c, err := NewCatalogFromFile("testdata/mapping.json")
+		So(err, ShouldBeNil)
+		stmt, err := sql.NewParserMode(strings.NewReader(`SELECT name, FOO(goals) FROM oilers WHERE FOO(goals, 1) > 0`), sql.RecordPositions).Parse()
+		So(err, ShouldBeNil)
+		So(validationMessages(ValidateFunctions(stmt, c, fs)), ShouldResemble, []string{"1:43: FOO expects 1 argument, got 2"})
+	})
+
+	Convey("Test type errors\n", t, func() {
+		err := testValidate(`SELECT name FROM oilers WHERE goals = '50' OR jersey IN (99, 'eleven')`)
+		So(validationMessages(err), ShouldResemble, []string{
+			`1:31: string '50' compared with long field goals (remove the quotes from '50')`,
+			`1:47: string 'eleven' compared with long field jersey`,
+		})
+
+		err = testValidate(`SELECT name FROM oilers WHERE GAA LIKE '2%'`)
+		So(validationMessages(err), ShouldResemble, []string{"1:31: LIKE on float field GAA (use a comparison or IN)"})
+
+		err = testValidate(`SELECT name FROM oilers WHERE name LIKE 'G%' AND quote LIKE '%puck%'`)
+		So(validationMessages(err), ShouldResemble, []string{"1:50: LIKE on text field quote, which has no keyword subfield"})
+
+		err = testValidate(`SELECT name FROM oilers WHERE name >= 'M' AND quote < 'Z'`)
+		So(validationMessages(err), ShouldResemble, []string{
+			"1:31: range comparison on text field name (use name.keyword instead)",
+			"1:47: range comparison on text field quote",
+		})
+
+		err = testValidate(`SELECT COUNT(*) FROM oilers GROUP BY HISTOGRAM(quote, 10)`)
+		So(validationMessages(err), ShouldResemble, []string{"1:48: cannot aggregate over text field quote"})
+
+		err = testValidate(`SELECT name, COUNT(quote) FROM oilers GROUP BY name ORDER BY name`)
+		So(validationMessages(err), ShouldResemble, []string{
+			"1:20: cannot aggregate over text field quote",
+			"1:48: cannot group by text field name (use name.keyword instead)",
+			"1:62: cannot sort by text field name (use name.keyword instead)",
+		})
+	})
+}
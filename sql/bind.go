@@ -0,0 +1,183 @@
+package sql
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Params returns the bind parameters of the specified statement in the
+// order in which they appear.
+func Params(s *SelectStatement) []*ParamExpr {
+
+	var params []*ParamExpr
+	Inspect(s, func(n Node) bool {
+		if p, ok := n.(*ParamExpr); ok {
+			params = append(params, p)
+		}
+		return true
+	})
+	return params
+}
+
+// Bind returns a copy of the specified statement in which each positional (?)
+// and numbered ($n) parameter is replaced by the literal for the corresponding
+// element of args. It is an error for a parameter to be left without a value or
+// for an argument to go unused. The original statement is left unmodified.
+func Bind(s *SelectStatement, args ...interface{}) (*SelectStatement, error) {
+
+	b, err := NewBinding(s, args...)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bind(s), nil
+}
+
+// BindNamed returns a copy of the specified statement in which each named
+// (:name) parameter is replaced by the literal for the corresponding element
+// of args. As with Bind, missing and unused arguments are reported as errors.
+func BindNamed(s *SelectStatement, args map[string]interface{}) (*SelectStatement, error) {
+
+	b, err := NewNamedBinding(s, args)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bind(s), nil
+}
+
+// Binding maps parameter keys, as returned by ParamExpr.Key, to the literal
+// expressions that replace them.
+type Binding map[string]Expr
+
+// NewBinding converts args to literals for the positional and numbered
+// parameters of the specified statement, verifying that each parameter has
+// a value and that each value is used.
+func NewBinding(s *SelectStatement, args ...interface{}) (Binding, error) {
+
+	b := make(Binding)
+	for i, a := range args {
+		e, err := LiteralExpr(a)
+		if err != nil {
+			return nil, fmt.Errorf("error binding argument %d: %v", i+1, err)
+		}
+		b[fmt.Sprintf("$%d", i+1)] = e
+	}
+
+	return b, b.check(s)
+}
+
+// NewNamedBinding converts args to literals for the named parameters of the
+// specified statement, verifying that each parameter has a value and that
+// each value is used.
+func NewNamedBinding(s *SelectStatement, args map[string]interface{}) (Binding, error) {
+
+	b := make(Binding)
+	for name, a := range args {
+		e, err := LiteralExpr(a)
+		if err != nil {
+			return nil, fmt.Errorf("error binding argument :%s: %v", name, err)
+		}
+		b[":"+name] = e
+	}
+
+	return b, b.check(s)
+}
+
+// maxExactInt is the magnitude beyond which not every integer is exactly
+// representable by the float64 value of a NumExpr.
+const maxExactInt = 1 << 53
+
+// LiteralExpr returns the literal expression that represents the specified
+// Go value in a statement: strings and times become a StringExpr and numeric
+// types a NumExpr. Integers beyond 2^53 in magnitude, which a NumExpr cannot
+// hold exactly, are rejected. Booleans become the string 'true' or 'false',
+// which elasticsearch accepts for boolean fields.
+func LiteralExpr(v interface{}) (Expr, error) {
+
+	switch v := v.(type) {
+	case nil:
+		return nil, fmt.Errorf("nil value")
+	case *StringExpr:
+		return v, nil
+	case *NumExpr:
+		return v, nil
+	case string:
+		return &StringExpr{Val: QuoteString(v)}, nil
+	case []byte:
+		return &StringExpr{Val: QuoteString(string(v))}, nil
+	case time.Time:
+		return &StringExpr{Val: QuoteString(v.Format(time.RFC3339Nano))}, nil
+	case bool:
+		return &StringExpr{Val: QuoteString(fmt.Sprint(v))}, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := rv.Int(); n > maxExactInt || n < -maxExactInt {
+			return nil, fmt.Errorf("integer %d is too large to be represented exactly", n)
+		}
+		return &NumExpr{Val: float64(rv.Int())}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n := rv.Uint(); n > maxExactInt {
+			return nil, fmt.Errorf("integer %d is too large to be represented exactly", n)
+		}
+		return &NumExpr{Val: float64(rv.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &NumExpr{Val: rv.Float()}, nil
+	case reflect.String:
+		return &StringExpr{Val: QuoteString(rv.String())}, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %T", v)
+	}
+}
+
+// check verifies that every parameter of s has a value in the binding and
+// that every value in the binding is used by s.
+func (b Binding) check(s *SelectStatement) error {
+
+	used := make(map[string]bool)
+	for _, p := range Params(s) {
+		if _, ok := b[p.Key()]; !ok {
+			return fmt.Errorf("missing value for parameter %s", p)
+		}
+		used[p.Key()] = true
+	}
+
+	var unused []string
+	for k := range b {
+		if !used[k] {
+			unused = append(unused, k)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Slice(unused, func(i, j int) bool { return argLess(unused[i], unused[j]) })
+		return fmt.Errorf("unused argument %s", unused[0])
+	}
+
+	return nil
+}
+
+// argLess orders the keys of a binding, positional before named and the
+// positional ones by number.
+func argLess(a, b string) bool {
+	if a[0] == '$' && b[0] == '$' && len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// Bind returns a copy of s with every parameter replaced from the binding.
+// Parameters without a value are left in place.
+func (b Binding) Bind(s *SelectStatement) *SelectStatement {
+
+	return Rewrite(s, func(n Node) Node {
+		if p, ok := n.(*ParamExpr); ok {
+			if v, ok := b[p.Key()]; ok {
+				return v
+			}
+		}
+		return n
+	}).(*SelectStatement)
+}
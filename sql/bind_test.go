@@ -0,0 +1,116 @@
+package sql
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	log "github.com/cihub/seelog"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+func TestBind(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test listing statement parameters\n", t, func() {
+		stmt, err := testParse(`SELECT name FROM tbl WHERE a = ? AND (b >= $2 OR c = F(:c, ?))`)
+		So(err, ShouldBeNil)
+		So(Params(stmt), ShouldResemble, []*ParamExpr{
+			&ParamExpr{Lit: "?", Index: 1},
+			&ParamExpr{Lit: "$2", Index: 2},
+			&ParamExpr{Lit: ":c", Name: "c"},
+			&ParamExpr{Lit: "?", Index: 2},
+		})
+
+		stmt, err = testParse(`SELECT name FROM tbl WHERE a = 1`)
+		So(err, ShouldBeNil)
+		So(Params(stmt), ShouldBeEmpty)
+	})
+
+	Convey("Test binding positional parameters\n", t, func() {
+		stmt, err := testParse(`SELECT name FROM tbl WHERE name = ? AND age >= $2 AND id = F(?)`)
+		So(err, ShouldBeNil)
+
+		bound, err := Bind(stmt, "bucky", 21)
+		So(err, ShouldBeNil)
+		So(bound.WhereCond, ShouldResemble, &CondConj{
+			Left: &CondComp{Ident: "name", CondOp: EQ, Val: &StringExpr{Val: `"bucky"`}}, Op: AND,
+			Right: &CondConj{
+				Left: &CondComp{Ident: "age", CondOp: GE, Val: &NumExpr{Val: 21}}, Op: AND,
+				Right: &CondComp{Ident: "id", CondOp: EQ, Val: &FuncCallExpr{Name: "F", Args: []Expr{&NumExpr{Val: 21}}}}}})
+		log.Debugf("bound: %s", bound)
+
+		So(Params(stmt), ShouldHaveLength, 3)
+
+		bound, err = Bind(stmt, true, int64(-1)<<53)
+		So(err, ShouldBeNil)
+		So(bound.WhereCond.(*CondConj).Left, ShouldResemble, &CondComp{Ident: "name", CondOp: EQ, Val: &StringExpr{Val: `"true"`}})
+		So(bound.WhereCond.(*CondConj).Right.(*CondConj).Left, ShouldResemble, &CondComp{Ident: "age", CondOp: GE, Val: &NumExpr{Val: -1 << 53}})
+
+		stmt, err = testParse(`SELECT name FROM tbl WHERE EXISTS (SELECT * FROM tbl.kids WHERE age > ?) AND name = ?`)
+		So(err, ShouldBeNil)
+		bound, err = Bind(stmt, 12, "bucky")
+		So(err, ShouldBeNil)
+		So(bound.String(), ShouldEqual, `SELECT name FROM tbl WHERE (EXISTS (SELECT * FROM tbl.kids WHERE age GT 12.000000) AND name EQ "bucky")`)
+
+		stmt, err = testParse(`SELECT name FROM tbl WHERE team IN (SELECT code FROM teams WHERE city = ?) AND name = ?`)
+		So(err, ShouldBeNil)
+		bound, err = Bind(stmt, "Edmonton", "bucky")
+		So(err, ShouldBeNil)
+		So(bound.String(), ShouldEqual, `SELECT name FROM tbl WHERE (team IN (SELECT code FROM teams WHERE city EQ "Edmonton") AND name EQ "bucky")`)
+	})
+
+	Convey("Test binding named parameters\n", t, func() {
+		stmt, err := testParse(`SELECT name FROM tbl WHERE name = :name OR born < :born`)
+		So(err, ShouldBeNil)
+
+		born := time.Date(1961, 1, 26, 0, 0, 0, 0, time.UTC)
+		bound, err := BindNamed(stmt, map[string]interface{}{"name": `Wayne "The Great One"`, "born": born})
+		So(err, ShouldBeNil)
+		So(bound.WhereCond, ShouldResemble, &CondConj{
+			Left: &CondComp{Ident: "name", CondOp: EQ, Val: &StringExpr{Val: `"Wayne \"The Great One\""`}}, Op: OR,
+			Right: &CondComp{Ident: "born", CondOp: LT, Val: &StringExpr{Val: `"1961-01-26T00:00:00Z"`}}})
+	})
+
+	Convey("Test binding errors\n", t, func() {
+		stmt, err := testParse(`SELECT name FROM tbl WHERE name = ? AND age >= ?`)
+		So(err, ShouldBeNil)
+
+		_, err = Bind(stmt, "bucky")
+		So(err, ShouldResemble, fmt.Errorf("missing value for parameter ?"))
+
+		_, err = Bind(stmt, "bucky", 21, 99)
+		So(err, ShouldResemble, fmt.Errorf("unused argument $3"))
+
+		_, err = Bind(stmt, "bucky", 21, 3, 4, 5, 6, 7, 8, 9, 10)
+		So(err, ShouldResemble, fmt.Errorf("unused argument $3"))
+
+		_, err = Bind(stmt, "bucky", []int{21})
+		So(err, ShouldResemble, fmt.Errorf("error binding argument 2: unsupported type []int"))
+
+		_, err = Bind(stmt, "bucky", int64(1)<<60)
+		So(err, ShouldResemble, fmt.Errorf("error binding argument 2: integer 1152921504606846976 is too large to be represented exactly"))
+
+		_, err = Bind(stmt, "bucky", uint64(1)<<53+1)
+		So(err, ShouldResemble, fmt.Errorf("error binding argument 2: integer 9007199254740993 is too large to be represented exactly"))
+
+		_, err = Bind(stmt, nil, 21)
+		So(err, ShouldResemble, fmt.Errorf("error binding argument 1: nil value"))
+
+		_, err = BindNamed(stmt, map[string]interface{}{})
+		So(err, ShouldResemble, fmt.Errorf("missing value for parameter ?"))
+
+		stmt, err = testParse(`SELECT name FROM tbl WHERE name = :name`)
+		So(err, ShouldBeNil)
+
+		_, err = BindNamed(stmt, map[string]interface{}{"name": "bucky", "age": 21})
+		So(err, ShouldResemble, fmt.Errorf("unused argument :age"))
+
+		_, err = Bind(stmt, "bucky")
+		So(err, ShouldResemble, fmt.Errorf("missing value for parameter :name"))
+	})
+}
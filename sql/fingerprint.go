@@ -0,0 +1,53 @@
+package sql
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// Fingerprint returns the normalized text of the specified statement along
+// with a stable 64-bit hash of that text, so that statements differing only
+// in their literal values, keyword case or whitespace share a fingerprint.
+// Every literal and parameter is replaced by a ? placeholder and the values
+// of each IN list are sorted with duplicates removed, so that IN lists of
+// literals normalize to IN (?) regardless of their length.
+func Fingerprint(s *SelectStatement) (string, uint64) {
+
+	normalized := Rewrite(s, func(n Node) Node {
+		switch n := n.(type) {
+		case *StringExpr, *NumExpr, *ParamExpr:
+			return &ParamExpr{Lit: "?"}
+		case *CondIn:
+			n.Vals = sortedUniqueExprs(n.Vals)
+		}
+		return n
+	}).(*SelectStatement)
+
+	text := Format(normalized, FormatOptions{})
+
+	h := fnv.New64a()
+	h.Write([]byte(text))
+	return text, h.Sum64()
+}
+
+// sortedUniqueExprs returns the specified expressions ordered by their SQL
+// text, keeping only the first of any with identical text.
+func sortedUniqueExprs(exprs []Expr) []Expr {
+
+	byText := make(map[string]Expr)
+	texts := make([]string, 0, len(exprs))
+	for _, e := range exprs {
+		t := formatExpr(e)
+		if _, ok := byText[t]; !ok {
+			byText[t] = e
+			texts = append(texts, t)
+		}
+	}
+	sort.Strings(texts)
+
+	sorted := make([]Expr, len(texts))
+	for i, t := range texts {
+		sorted[i] = byText[t]
+	}
+	return sorted
+}
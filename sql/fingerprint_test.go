@@ -0,0 +1,72 @@
+package sql
+
+import (
+	"testing"
+
+	log "github.com/cihub/seelog"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+func TestFingerprint(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test normalizing literals, case and whitespace\n", t, func() {
+		text, hash := testFingerprint(`select name from oilers where pos = 'C' and goals >= 50`)
+		So(text, ShouldEqual, `SELECT name FROM oilers WHERE pos = ? AND goals >= ?`)
+
+		text2, hash2 := testFingerprint("SELECT name\n\tFROM oilers\nWHERE pos = :pos AND goals >= $2")
+		So(text2, ShouldEqual, text)
+		So(hash2, ShouldEqual, hash)
+	})
+
+	Convey("Test normalizing IN lists\n", t, func() {
+		text, hash := testFingerprint(`SELECT name FROM oilers WHERE pos IN ('C', 'LW', 'RW') AND team IN (F(1), 'EDM', G('y'))`)
+		So(text, ShouldEqual, `SELECT name FROM oilers WHERE pos IN (?) AND team IN (?, F(?), G(?))`)
+
+		text2, hash2 := testFingerprint(`SELECT name FROM oilers WHERE pos IN ('D') AND team IN (G('z'), F(?), 'NYR', 'STL')`)
+		So(text2, ShouldEqual, text)
+		So(hash2, ShouldEqual, hash)
+	})
+
+	Convey("Test normalizing subqueries\n", t, func() {
+		text, _ := testFingerprint(`select name from oilers where exists (select * from oilers.linemates where name in ('Kurri', 'Coffey'))`)
+		So(text, ShouldEqual, `SELECT name FROM oilers WHERE EXISTS (SELECT * FROM oilers.linemates WHERE name IN (?))`)
+
+		text, _ = testFingerprint(`select name from oilers where team in (select code from teams where conf = 'West')`)
+		So(text, ShouldEqual, `SELECT name FROM oilers WHERE team IN (SELECT code FROM teams WHERE conf = ?)`)
+	})
+
+	Convey("Test distinguishing query shapes\n", t, func() {
+		_, hash1 := testFingerprint(`SELECT name FROM oilers WHERE pos = 'C'`)
+		_, hash2 := testFingerprint(`SELECT name FROM oilers WHERE pos != 'C'`)
+		_, hash3 := testFingerprint(`SELECT name FROM oilers WHERE pos NOT IN ('C')`)
+		_, hash4 := testFingerprint(`SELECT name, goals FROM oilers WHERE pos = 'C'`)
+		So(hash1, ShouldNotEqual, hash2)
+		So(hash1, ShouldNotEqual, hash3)
+		So(hash1, ShouldNotEqual, hash4)
+
+		// the hash is FNV-1a of the normalized text and so stable across runs
+		text, hash := testFingerprint(`SELECT * FROM oilers`)
+		So(text, ShouldEqual, `SELECT * FROM oilers`)
+		So(hash, ShouldEqual, uint64(0x6e3f1e71999740df))
+	})
+
+	Convey("Test leaving the statement unmodified\n", t, func() {
+		stmt, err := testParse(`SELECT name FROM oilers WHERE pos IN ('RW', 'C')`)
+		So(err, ShouldBeNil)
+		Fingerprint(stmt)
+		So(stmt.String(), ShouldEqual, `SELECT name FROM oilers WHERE pos IN ('RW', 'C')`)
+	})
+}
+
+func testFingerprint(q string) (string, uint64) {
+	stmt, err := testParse(q)
+	So(err, ShouldBeNil)
+	text, hash := Fingerprint(stmt)
+	log.Debugf("fingerprint %016x: %s", hash, text)
+	return text, hash
+}
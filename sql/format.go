@@ -0,0 +1,278 @@
+package sql
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// KeywordCase selects the letter case of keywords emitted by Format.
+type KeywordCase int
+
+const (
+	UpperKeywords KeywordCase = iota
+	LowerKeywords
+)
+
+// FormatOptions control the layout of the SQL text produced by Format. The
+// zero value produces a single line with upper case keywords.
+type FormatOptions struct {
+	Keywords KeywordCase
+
+	// Indent, when non-empty, starts each clause on a new line and prefixes
+	// wrapped continuation lines with it.
+	Indent string
+
+	// Width is the line length beyond which select lists and conditions are
+	// wrapped onto continuation lines. Zero disables wrapping.
+	Width int
+}
+
+// FormatExpr returns SQL text for the specified expression, as it appears in
+// the Name of a select list field computed by the expression.
+func FormatExpr(e Expr) string {
+	return formatExpr(e)
+}
+
+// FormatCond returns SQL text for the specified condition on a single line,
+// as it appears in the WHERE clause produced by Format.
+func FormatCond(c Cond) string {
+	f := &formatter{}
+	return strings.Join(f.condChunks(c), " ")
+}
+
+// Format returns SQL text for the specified statement which parses back into
+// an identical statement. Unlike String, it emits operators as symbols,
+// numbers in their shortest form and only the parentheses required to
+// preserve the structure of the WHERE condition.
+func Format(s *SelectStatement, opts FormatOptions) string {
+
+	f := &formatter{opts: opts}
+	f.selectClauses(s)
+	return f.buf.String()
+}
+
+// selectClauses writes the clauses of a statement.
+func (f *formatter) selectClauses(s *SelectStatement) {
+
+	f.clause(SELECT, fieldChunks(s.FieldList))
+	f.clause(FROM, fieldChunks(s.TableList))
+	for _, j := range s.Joins {
+		chunks := append(fieldChunks(Fields{j.Table}), f.keyword(ON))
+		chunks = append(chunks, f.condChunks(j.On)...)
+		if j.Left {
+			f.clause(LEFT, append([]string{f.keyword(JOIN)}, chunks...))
+		} else {
+			f.clause(JOIN, chunks)
+		}
+	}
+	if s.WhereCond != nil {
+		f.clause(WHERE, f.condChunks(s.WhereCond))
+	}
+	if len(s.GroupBy) > 0 {
+		f.clause(GROUP, f.groupChunks(s.GroupBy))
+	}
+	for _, u := range s.Unions {
+		if u.All {
+			f.clause(UNION, []string{f.keyword(ALL)})
+		} else {
+			f.clause(UNION, nil)
+		}
+		f.selectClauses(u.Select)
+	}
+	if len(s.OrderBy) > 0 {
+		f.clause(ORDER, f.orderChunks(s.OrderBy))
+	}
+	if s.Limit != nil {
+		f.clause(LIMIT, []string{formatExpr(s.Limit)})
+	}
+}
+
+// formatter lays out clauses, each made up of chunks of text that may be
+// wrapped onto a new line between one another.
+type formatter struct {
+	opts FormatOptions
+	buf  bytes.Buffer
+	col  int
+}
+
+// clause writes the keyword of a clause followed by its chunks.
+func (f *formatter) clause(kw Token, chunks []string) {
+
+	kwText := f.keyword(kw)
+	if f.buf.Len() > 0 {
+		if len(f.opts.Indent) > 0 {
+			f.newline("")
+		} else if f.opts.Width > 0 && len(chunks) > 0 && f.col+len(kwText)+len(chunks[0])+2 > f.opts.Width {
+			f.newline("")
+		} else {
+			f.write(" ")
+		}
+	}
+	f.write(kwText)
+
+	for i, c := range chunks {
+		if i > 0 && f.opts.Width > 0 && f.col+1+len(c) > f.opts.Width {
+			f.newline(f.opts.Indent)
+		} else {
+			f.write(" ")
+		}
+		f.write(c)
+	}
+}
+
+func (f *formatter) write(s string) {
+	f.buf.WriteString(s)
+	f.col += len(s)
+}
+
+func (f *formatter) newline(indent string) {
+	f.buf.WriteString("\n")
+	f.col = 0
+	f.write(indent)
+}
+
+// keyword returns the text of the specified keyword token in the configured
+// letter case.
+func (f *formatter) keyword(kw Token) string {
+	if f.opts.Keywords == LowerKeywords {
+		return strings.ToLower(kw.Text())
+	}
+	return kw.Text()
+}
+
+// fieldChunks returns the comma-separated elements of a field or table list.
+func fieldChunks(fields Fields) []string {
+
+	chunks := make([]string, len(fields))
+	for i, fld := range fields {
+		chunks[i] = fld.Name
+		if fld.Expr != nil {
+			chunks[i] = formatExpr(fld.Expr)
+		}
+		if len(fld.Alias) > 0 {
+			chunks[i] += " " + fld.Alias
+		}
+		if i < len(fields)-1 {
+			chunks[i] += ","
+		}
+	}
+	return chunks
+}
+
+// groupChunks returns the BY keyword and comma-separated expressions of a
+// GROUP BY clause.
+func (f *formatter) groupChunks(exprs []Expr) []string {
+
+	chunks := make([]string, len(exprs))
+	for i, e := range exprs {
+		chunks[i] = formatExpr(e)
+		if i < len(exprs)-1 {
+			chunks[i] += ","
+		}
+	}
+	chunks[0] = f.keyword(BY) + " " + chunks[0]
+	return chunks
+}
+
+// orderChunks returns the BY keyword and comma-separated elements of an
+// ORDER BY clause.
+func (f *formatter) orderChunks(items []OrderItem) []string {
+
+	chunks := make([]string, len(items))
+	for i, o := range items {
+		chunks[i] = formatExpr(o.Expr)
+		if o.Desc {
+			chunks[i] += " " + f.keyword(DESC)
+		}
+		if i < len(items)-1 {
+			chunks[i] += ","
+		}
+	}
+	chunks[0] = f.keyword(BY) + " " + chunks[0]
+	return chunks
+}
+
+// condChunks returns the text of a condition split before each AND and OR.
+func (f *formatter) condChunks(c Cond) []string {
+
+	switch c := c.(type) {
+	case *CondComp:
+		return []string{c.Ident + " " + f.keyword(c.CondOp) + " " + formatExpr(c.Val)}
+
+	case *CondIn:
+		vals := make([]string, len(c.Vals))
+		for i, v := range c.Vals {
+			if sub, ok := v.(*SubqueryExpr); ok {
+				vals[i] = Format(sub.Select, FormatOptions{Keywords: f.opts.Keywords})
+			} else {
+				vals[i] = formatExpr(v)
+			}
+		}
+		op := f.keyword(IN)
+		if c.Not {
+			op = f.keyword(NOT) + " " + op
+		}
+		return []string{c.Ident + " " + op + " (" + strings.Join(vals, ", ") + ")"}
+
+	case *CondFunc:
+		if c.CondOp == ILLEGAL {
+			return []string{formatExpr(c.Call)}
+		}
+		return []string{formatExpr(c.Call) + " " + f.keyword(c.CondOp) + " " + formatExpr(c.Val)}
+
+	case *CondExists:
+		op := f.keyword(EXISTS)
+		if c.Not {
+			op = f.keyword(NOT) + " " + op
+		}
+		return []string{op + " (" + Format(c.Select, FormatOptions{Keywords: f.opts.Keywords}) + ")"}
+
+	case *CondConj:
+		// Conjunctions associate to the right and AND binds more tightly
+		// than OR, so a left operand with the same operator, or any operand
+		// with a looser one, must be parenthesized.
+		left := f.condChunks(c.Left)
+		if l, ok := c.Left.(*CondConj); ok && (l.Op == c.Op || l.Op == OR) {
+			left = parenthesize(left)
+		}
+		right := f.condChunks(c.Right)
+		if r, ok := c.Right.(*CondConj); ok && r.Op != c.Op && r.Op == OR {
+			right = parenthesize(right)
+		}
+		right[0] = f.keyword(c.Op) + " " + right[0]
+		return append(left, right...)
+	}
+
+	return []string{c.String()}
+}
+
+// parenthesize wraps the text of a chunked condition in parentheses.
+func parenthesize(chunks []string) []string {
+	chunks[0] = "(" + chunks[0]
+	chunks[len(chunks)-1] += ")"
+	return chunks
+}
+
+// formatExpr returns the SQL text of the specified expression.
+func formatExpr(e Expr) string {
+
+	switch e := e.(type) {
+	case *NumExpr:
+		return strconv.FormatFloat(e.Val, 'f', -1, 64)
+	case *FuncCallExpr:
+		args := make([]string, len(e.Args))
+		for i, a := range e.Args {
+			args[i] = formatExpr(a)
+		}
+		return e.Name + "(" + strings.Join(args, ", ") + ")"
+	case *NamedArgExpr:
+		return e.Name + " = " + formatExpr(e.Val)
+	case *BinaryExpr:
+		return formatExpr(e.LHS) + " " + e.Op.Text() + " " + formatExpr(e.RHS)
+	case *SubqueryExpr:
+		return Format(e.Select, FormatOptions{})
+	}
+
+	return e.String()
+}
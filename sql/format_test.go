@@ -0,0 +1,265 @@
+package sql
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+func TestFormat(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test formatting on a single line\n", t, func() {
+		stmt, err := testParse(`select  name n, goals FROM oilers o where pos = 'C' and goals>=50.0 OR jersey != -9.25`)
+		So(err, ShouldBeNil)
+		So(Format(stmt, FormatOptions{}), ShouldEqual,
+			`SELECT name n, goals FROM oilers o WHERE pos = 'C' AND goals >= 50 OR jersey != -9.25`)
+		So(Format(stmt, FormatOptions{Keywords: LowerKeywords}), ShouldEqual,
+			`select name n, goals from oilers o where pos = 'C' and goals >= 50 or jersey != -9.25`)
+	})
+
+	Convey("Test formatting expressions and conditions\n", t, func() {
+		stmt, err := testParse(`SELECT histogram(dob,interval 1 years) FROM t GROUP BY 1.0, HISTOGRAM(goals, 10.50)`)
+		So(err, ShouldBeNil)
+		So(stmt.FieldList[0].Name, ShouldEqual, FormatExpr(stmt.FieldList[0].Expr))
+		So(FormatExpr(stmt.FieldList[0].Expr), ShouldEqual, `histogram(dob, INTERVAL 1 YEAR)`)
+		So(FormatExpr(stmt.GroupBy[0]), ShouldEqual, `1`)
+		So(FormatExpr(stmt.GroupBy[1]), ShouldEqual, `HISTOGRAM(goals, 10.5)`)
+
+		stmt, err = testParse(`SELECT a FROM t WHERE (a = 1.0 OR b IN ('x', ?)) AND NOT EXISTS (SELECT * FROM t.c)`)
+		So(err, ShouldBeNil)
+		So(FormatCond(stmt.WhereCond), ShouldEqual, `(a = 1 OR b IN ('x', ?)) AND NOT EXISTS (SELECT * FROM t.c)`)
+	})
+
+	Convey("Test minimal parentheses\n", t, func() {
+		stmt, err := testParse(`SELECT a FROM t WHERE ((a = 1 AND b = 2) OR c = 3) AND (d = 4 OR e = F(5, "x", ?))`)
+		So(err, ShouldBeNil)
+		So(Format(stmt, FormatOptions{}), ShouldEqual,
+			`SELECT a FROM t WHERE (a = 1 AND b = 2 OR c = 3) AND (d = 4 OR e = F(5, "x", ?))`)
+
+		stmt, err = testParse(`SELECT a FROM t WHERE (a = 1 AND b = 2) AND (c = 3 AND d = 4)`)
+		So(err, ShouldBeNil)
+		So(Format(stmt, FormatOptions{}), ShouldEqual,
+			`SELECT a FROM t WHERE (a = 1 AND b = 2) AND c = 3 AND d = 4`)
+	})
+
+	Convey("Test indenting and wrapping\n", t, func() {
+		stmt, err := testParse(`SELECT name, jersey, pos, goals FROM oilers WHERE pos = 'C' AND (goals > 50 OR jersey = 99)`)
+		So(err, ShouldBeNil)
+		So(Format(stmt, FormatOptions{Indent: "  "}), ShouldEqual, strings.Join([]string{
+			`SELECT name, jersey, pos, goals`,
+			`FROM oilers`,
+			`WHERE pos = 'C' AND (goals > 50 OR jersey = 99)`}, "\n"))
+		So(Format(stmt, FormatOptions{Indent: "    ", Width: 24}), ShouldEqual, strings.Join([]string{
+			`SELECT name, jersey,`,
+			`    pos, goals`,
+			`FROM oilers`,
+			`WHERE pos = 'C'`,
+			`    AND (goals > 50`,
+			`    OR jersey = 99)`}, "\n"))
+		So(Format(stmt, FormatOptions{Width: 40}), ShouldEqual, strings.Join([]string{
+			`SELECT name, jersey, pos, goals`,
+			`FROM oilers WHERE pos = 'C'`,
+			`AND (goals > 50 OR jersey = 99)`}, "\n"))
+	})
+
+	Convey("Test formatting JOINs\n", t, func() {
+		stmt, err := testParse(`select p.name from oilers p left outer join teams t on p.team = t.code where p.goals > 50`)
+		So(err, ShouldBeNil)
+		So(Format(stmt, FormatOptions{Keywords: LowerKeywords}), ShouldEqual,
+			`select p.name from oilers p left join teams t on p.team = t.code where p.goals > 50`)
+		So(Format(stmt, FormatOptions{Indent: "  "}), ShouldEqual, strings.Join([]string{
+			`SELECT p.name`,
+			`FROM oilers p`,
+			`LEFT JOIN teams t ON p.team = t.code`,
+			`WHERE p.goals > 50`}, "\n"))
+	})
+
+	Convey("Test formatting UNIONs\n", t, func() {
+		stmt, err := testParse(`select name from oilers where pos = 'C' union all select name from flames order by name limit 5`)
+		So(err, ShouldBeNil)
+		So(Format(stmt, FormatOptions{Keywords: LowerKeywords}), ShouldEqual,
+			`select name from oilers where pos = 'C' union all select name from flames order by name limit 5`)
+		So(Format(stmt, FormatOptions{Indent: "  "}), ShouldEqual, strings.Join([]string{
+			`SELECT name`,
+			`FROM oilers`,
+			`WHERE pos = 'C'`,
+			`UNION ALL`,
+			`SELECT name`,
+			`FROM flames`,
+			`ORDER BY name`,
+			`LIMIT 5`}, "\n"))
+	})
+
+	Convey("Test formatting ORDER BY and LIMIT\n", t, func() {
+		stmt, err := testParse(`select name from oilers order by goals desc, name limit 10`)
+		So(err, ShouldBeNil)
+		So(Format(stmt, FormatOptions{Keywords: LowerKeywords}), ShouldEqual,
+			`select name from oilers order by goals desc, name limit 10`)
+		So(Format(stmt, FormatOptions{Indent: "  "}), ShouldEqual, strings.Join([]string{
+			`SELECT name`,
+			`FROM oilers`,
+			`ORDER BY goals DESC, name`,
+			`LIMIT 10`}, "\n"))
+	})
+
+	Convey("Test round-tripping statements\n", t, func() {
+		for _, q := range []string{
+			`SELECT * FROM t`,
+			`SELECT a x, b, c y FROM t1 a1, t2 WHERE a = "it\"s"`,
+			`SELECT a FROM t WHERE a = 'x' AND b <= -0.001 OR c > 1000000 AND d = $2 AND e != :e`,
+			`SELECT a FROM t WHERE (A = 1 AND (B = 2 OR C = 3)) OR D = 4`,
+			`SELECT a FROM t WHERE a = F() AND (b = G(?, H(-1.5, 'y')) OR (c < ? OR d >= 12.75))`,
+			`SELECT a FROM t WHERE a IN (1, 'x', ?) OR b NOT IN (F(2))`,
+			`SELECT a FROM t WHERE MATCH(a, 'x y', operator = 'and', boost = 2) AND (QUERY_STRING(?) OR b = 1)`,
+			`SELECT a FROM t WHERE ST_DISTANCE(a, POINT(1, -2.5)) <= ? AND F(b) != 'x' ORDER BY ST_DISTANCE(a, POINT(0, 0))`,
+			`SELECT a FROM t WHERE EXISTS (SELECT * FROM t.b WHERE c = 1 OR NOT EXISTS (SELECT x FROM t.b.d)) AND e = ?`,
+			`SELECT a FROM t WHERE b IN (SELECT c FROM u WHERE d = ? AND e NOT IN (SELECT f FROM v)) OR g = 1`,
+			`SELECT p.a, q.b FROM t p JOIN u q ON p.c = q.c LEFT JOIN v ON v.d = q.d AND v.e > 1 WHERE p.f = ?`,
+			`SELECT a FROM t WHERE a = b ORDER BY a DESC, F(b, 1) LIMIT 5`,
+			`SELECT a FROM t WHERE b = 1 UNION SELECT c FROM u GROUP BY c UNION ALL SELECT d FROM v ORDER BY a LIMIT ?`,
+			`SELECT a FROM t ORDER BY a LIMIT :n`,
+			`SELECT team AS t, COUNT(*), SUM(goals) s FROM t WHERE a > 1 GROUP BY team, b LIMIT 3`,
+			`SELECT DATE_TRUNC('month', a) FROM t WHERE a >= NOW() - INTERVAL 30 YEAR AND b < DATE_ADD(c, INTERVAL 1.5 HOUR)`,
+		} {
+			testRoundTrip(q)
+		}
+	})
+
+	Convey("Test round-tripping random statements\n", t, func() {
+		g := &stmtGen{rand: rand.New(rand.NewSource(1))}
+		for i := 0; i < 500; i++ {
+			stmt := g.stmt()
+			text := Format(stmt, FormatOptions{Indent: " ", Width: 1 + g.rand.Intn(80)})
+			parsed, err := testParse(text)
+			So(err, ShouldBeNil)
+			So(parsed, ShouldResemble, stmt)
+		}
+	})
+}
+
+// testRoundTrip asserts that the formatted form of the specified query parses
+// into the same statement as the query itself.
+func testRoundTrip(q string) {
+
+	stmt, err := testParse(q)
+	So(err, ShouldBeNil)
+
+	text := Format(stmt, FormatOptions{})
+	log.Debugf("formatted: %s", text)
+	parsed, err := testParse(text)
+	So(err, ShouldBeNil)
+	So(parsed, ShouldResemble, stmt)
+	So(Format(parsed, FormatOptions{}), ShouldEqual, text)
+}
+
+// stmtGen generates random statements for round-trip tests.
+type stmtGen struct {
+	rand   *rand.Rand
+	nparam int
+}
+
+func (g *stmtGen) stmt() *SelectStatement {
+
+	g.nparam = 0
+	stmt := &SelectStatement{
+		FieldList: g.fields(),
+		TableList: g.fields(),
+	}
+	if g.rand.Intn(3) == 0 {
+		call := &FuncCallExpr{Name: "MAX", Args: []Expr{&IdentExpr{Name: g.ident()}}}
+		stmt.FieldList = append(stmt.FieldList, Field{Name: formatExpr(call), Expr: call})
+	}
+	if g.rand.Intn(4) > 0 {
+		stmt.WhereCond = g.cond(4)
+	}
+	if g.rand.Intn(3) == 0 {
+		stmt.GroupBy = make([]Expr, 1+g.rand.Intn(2))
+		for i := range stmt.GroupBy {
+			stmt.GroupBy[i] = &IdentExpr{Name: g.ident()}
+		}
+	}
+	if g.rand.Intn(3) == 0 {
+		stmt.OrderBy = make([]OrderItem, 1+g.rand.Intn(2))
+		for i := range stmt.OrderBy {
+			stmt.OrderBy[i] = OrderItem{Expr: g.expr(1), Desc: g.rand.Intn(2) == 0}
+		}
+	}
+	if g.rand.Intn(3) == 0 {
+		stmt.Limit = &NumExpr{Val: float64(g.rand.Intn(100))}
+	}
+	return stmt
+}
+
+func (g *stmtGen) fields() Fields {
+
+	fields := make(Fields, 1+g.rand.Intn(3))
+	for i := range fields {
+		fields[i].Name = g.ident()
+		if g.rand.Intn(2) == 0 {
+			fields[i].Alias = g.ident()
+		}
+	}
+	return fields
+}
+
+func (g *stmtGen) ident() string {
+	idents := []string{"a", "name", "t1.goals", "b_2", "dob"}
+	return idents[g.rand.Intn(len(idents))]
+}
+
+func (g *stmtGen) cond(depth int) Cond {
+
+	if depth > 0 && g.rand.Intn(3) > 0 {
+		op := AND
+		if g.rand.Intn(2) == 0 {
+			op = OR
+		}
+		return &CondConj{Op: op, Left: g.cond(depth - 1), Right: g.cond(depth - 1)}
+	}
+
+	if g.rand.Intn(5) == 0 {
+		vals := make([]Expr, 1+g.rand.Intn(3))
+		for i := range vals {
+			vals[i] = g.expr(1)
+		}
+		return &CondIn{Ident: g.ident(), Not: g.rand.Intn(2) == 0, Vals: vals}
+	}
+
+	ops := []Token{EQ, NE, LT, GT, LE, GE, LIKE}
+	return &CondComp{Ident: g.ident(), CondOp: ops[g.rand.Intn(len(ops))], Val: g.expr(2)}
+}
+
+func (g *stmtGen) expr(depth int) Expr {
+
+	switch g.rand.Intn(6) {
+	case 0:
+		return &NumExpr{Val: float64(g.rand.Intn(2000)-1000) / float64(1+g.rand.Intn(100))}
+	case 1:
+		strs := []string{`"abc"`, `'d e f'`, `"esc \" aped"`, `''`}
+		return &StringExpr{Val: strs[g.rand.Intn(len(strs))]}
+	case 2:
+		if g.rand.Intn(2) == 0 {
+			return &ParamExpr{Lit: ":p", Name: "p"}
+		}
+		g.nparam += 1
+		return &ParamExpr{Lit: "?", Index: g.nparam}
+	case 3:
+		if depth > 0 {
+			args := make([]Expr, g.rand.Intn(3))
+			for i := range args {
+				args[i] = g.expr(depth - 1)
+			}
+			return &FuncCallExpr{Name: fmt.Sprintf("F%d", depth), Args: args}
+		}
+	}
+	return &NumExpr{Val: float64(g.rand.Intn(100))}
+}
@@ -0,0 +1,389 @@
+package sql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ArgType is the type of a function argument or result.
+type ArgType int
+
+const (
+	// AnyType accepts any value: a string, number, interval or parameter.
+	AnyType ArgType = iota
+
+	// FieldType accepts an identifier naming a field.
+	FieldType
+
+	// FieldOrStarType accepts an identifier or *, as in COUNT(*).
+	FieldOrStarType
+
+	StringType
+	NumberType
+	BoolType
+
+	// DateType accepts a date: a field, a string literal, or an expression
+	// computing a date such as NOW() - INTERVAL 1 DAY.
+	DateType
+
+	// IntervalType accepts an interval, e.g. INTERVAL 30 YEAR.
+	IntervalType
+
+	// PointType and BoxType accept the geo values computed by functions
+	// returning them, such as a point built from its longitude and latitude.
+	PointType
+	BoxType
+)
+
+func (t ArgType) String() string {
+	switch t {
+	case FieldType:
+		return "field"
+	case FieldOrStarType:
+		return "field or *"
+	case StringType:
+		return "string"
+	case NumberType:
+		return "number"
+	case BoolType:
+		return "boolean"
+	case DateType:
+		return "date"
+	case IntervalType:
+		return "interval"
+	case PointType:
+		return "point"
+	case BoxType:
+		return "bounding box"
+	}
+	return "value"
+}
+
+// FuncKind tells where calls to a function may appear.
+type FuncKind int
+
+const (
+	// ScalarFunc computes a value for each document, and may appear
+	// anywhere an expression may.
+	ScalarFunc FuncKind = iota
+
+	// AggregateFunc computes a value over a group of documents, and may only
+	// appear in the select list.
+	AggregateFunc
+
+	// PredicateFunc tests each document, and may only appear as a WHERE
+	// condition.
+	PredicateFunc
+)
+
+// FuncSignature describes the arguments, result and kind of a function.
+type FuncSignature struct {
+	Name string
+	Kind FuncKind
+
+	// Args lists the types of the positional arguments. If Variadic is set,
+	// the last type may be repeated any number of times beyond the first.
+	Args     []ArgType
+	Variadic bool
+
+	// Options lists the names of the optional arguments which may follow the
+	// positional ones, passed by name as in MATCH(quote, 'puck', boost = 2).
+	Options []string
+
+	Returns ArgType
+}
+
+// FunctionRegistry records the signatures of the functions which statements
+// may call, keyed by case-insensitive name. It is safe for concurrent use.
+type FunctionRegistry struct {
+	mu    sync.RWMutex
+	funcs map[string]FuncSignature
+}
+
+// NewFunctionRegistry returns a registry holding the standard SQL aggregate
+// functions COUNT, SUM, AVG, MIN and MAX.
+func NewFunctionRegistry() *FunctionRegistry {
+
+	r := &FunctionRegistry{funcs: make(map[string]FuncSignature)}
+	r.Register(FuncSignature{Name: "COUNT", Kind: AggregateFunc, Args: []ArgType{FieldOrStarType}, Returns: NumberType})
+	for _, name := range []string{"SUM", "AVG", "MIN", "MAX"} {
+		r.Register(FuncSignature{Name: name, Kind: AggregateFunc, Args: []ArgType{FieldType}, Returns: NumberType})
+	}
+	return r
+}
+
+// Register adds a function to the registry, replacing any function of the
+// same name.
+func (r *FunctionRegistry) Register(sig FuncSignature) error {
+
+	if len(sig.Name) == 0 {
+		return fmt.Errorf("function signature has no name")
+	}
+	if sig.Variadic && len(sig.Args) == 0 {
+		return fmt.Errorf("variadic function %s has no arguments", sig.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.funcs[strings.ToUpper(sig.Name)] = sig
+	return nil
+}
+
+// Lookup returns the signature of the named function.
+func (r *FunctionRegistry) Lookup(name string) (FuncSignature, bool) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sig, ok := r.funcs[strings.ToUpper(name)]
+	return sig, ok
+}
+
+// Names returns the names of the registered functions, in name order.
+func (r *FunctionRegistry) Names() []string {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var names []string
+	for _, sig := range r.funcs {
+		names = append(names, sig.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CallError describes a function call which does not match the signature of
+// the function, or which appears where the kind of function is not allowed.
+// It also describes an operator applied to operands of the wrong types, in
+// which case Call is nil. Pos is the position of the call or operator.
+type CallError struct {
+	Call *FuncCallExpr
+	Pos  Pos
+	Msg  string
+}
+
+func (e *CallError) Error() string {
+	return e.Msg
+}
+
+// Check verifies each function call of the specified statement against the
+// registry, returning an error for each call to an unknown function, with
+// the wrong arguments, or in a clause where its kind is not allowed, and for
+// each + or - which does not add an interval to or subtract one from a date.
+func (r *FunctionRegistry) Check(s *SelectStatement) []*CallError {
+
+	c := &callChecker{r: r}
+	for _, f := range s.FieldList {
+		c.expr(f.Expr, false)
+	}
+	for _, j := range s.Joins {
+		c.cond(j.On)
+	}
+	if s.WhereCond != nil {
+		c.cond(s.WhereCond)
+	}
+	for _, e := range s.GroupBy {
+		c.expr(e, true)
+	}
+	for _, u := range s.Unions {
+		c.errs = append(c.errs, r.Check(u.Select)...)
+	}
+	for _, o := range s.OrderBy {
+		c.expr(o.Expr, true)
+	}
+	return c.errs
+}
+
+// callChecker accumulates the errors found by FunctionRegistry.Check.
+type callChecker struct {
+	r    *FunctionRegistry
+	errs []*CallError
+}
+
+func (c *callChecker) errorf(call *FuncCallExpr, format string, args ...interface{}) {
+	c.errs = append(c.errs, &CallError{Call: call, Pos: call.Pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (c *callChecker) cond(cond Cond) {
+
+	switch cond := cond.(type) {
+	case *CondConj:
+		if cond.Left != nil {
+			c.cond(cond.Left)
+		}
+		if cond.Right != nil {
+			c.cond(cond.Right)
+		}
+	case *CondComp:
+		c.expr(cond.Val, true)
+	case *CondIn:
+		for _, v := range cond.Vals {
+			c.expr(v, true)
+		}
+	case *CondExists:
+		c.errs = append(c.errs, c.r.Check(cond.Select)...)
+	case *CondFunc:
+		if cond.CondOp != ILLEGAL {
+			c.expr(cond.Call, true)
+			c.expr(cond.Val, true)
+		} else if sig, ok := c.call(cond.Call); ok && sig.Kind != PredicateFunc {
+			c.errorf(cond.Call, "%s is not a predicate", cond.Call.Name)
+		}
+	}
+}
+
+// expr checks the calls of an expression, which may be nil. Aggregates are
+// only allowed at the top level of the select list, and so not if scalar is
+// set.
+func (c *callChecker) expr(e Expr, scalar bool) {
+
+	if b, ok := e.(*BinaryExpr); ok {
+		c.binary(b)
+		return
+	}
+	if sub, ok := e.(*SubqueryExpr); ok {
+		c.errs = append(c.errs, c.r.Check(sub.Select)...)
+		return
+	}
+	call, ok := e.(*FuncCallExpr)
+	if !ok {
+		return
+	}
+	sig, ok := c.call(call)
+	if !ok {
+		return
+	}
+	switch {
+	case sig.Kind == PredicateFunc:
+		c.errorf(call, "predicate %s cannot be used as a value", call.Name)
+	case sig.Kind == AggregateFunc && scalar:
+		c.errorf(call, "aggregate %s is only allowed in the select list", call.Name)
+	}
+}
+
+// binary checks the operands of a + or -, which adds an interval to a date or
+// subtracts one from it.
+func (c *callChecker) binary(b *BinaryExpr) {
+
+	c.expr(b.LHS, true)
+	c.expr(b.RHS, true)
+
+	date, interval := b.LHS, b.RHS
+	if b.Op == PLUS && c.matches(IntervalType, date) {
+		date, interval = interval, date
+	}
+	if !c.matches(DateType, date) || !c.matches(IntervalType, interval) {
+		c.errs = append(c.errs, &CallError{Pos: b.Pos, Msg: fmt.Sprintf("cannot apply %s to %s and %s",
+			b.Op.Text(), formatExpr(b.LHS), formatExpr(b.RHS))})
+	}
+}
+
+// call checks the arguments of a call, returning the signature of the
+// function if it is known.
+func (c *callChecker) call(call *FuncCallExpr) (FuncSignature, bool) {
+
+	sig, ok := c.r.Lookup(call.Name)
+	if !ok {
+		c.errorf(call, "unknown function %s", call.Name)
+		return sig, false
+	}
+
+	var args []Expr
+	var named []*NamedArgExpr
+	for _, a := range call.Args {
+		if n, ok := a.(*NamedArgExpr); ok {
+			named = append(named, n)
+		} else if len(named) > 0 {
+			c.errorf(call, "positional argument %s follows named arguments in %s", a, call.Name)
+			return sig, true
+		} else {
+			args = append(args, a)
+		}
+	}
+
+	switch {
+	case sig.Variadic && len(args) < len(sig.Args):
+		c.errorf(call, "%s expects at least %s, got %d", call.Name, arguments(len(sig.Args)), len(args))
+		return sig, true
+	case !sig.Variadic && len(args) != len(sig.Args):
+		c.errorf(call, "%s expects %s, got %d", call.Name, arguments(len(sig.Args)), len(args))
+		return sig, true
+	}
+
+	for i, a := range args {
+		typ := sig.Args[len(sig.Args)-1]
+		if i < len(sig.Args) {
+			typ = sig.Args[i]
+		}
+		if !c.matches(typ, a) {
+			c.errorf(call, "argument %d of %s must be %s, got %s", i+1, call.Name, article(typ), a)
+		}
+		c.expr(a, true)
+	}
+
+	for _, n := range named {
+		if !containsName(sig.Options, n.Name) {
+			if len(sig.Options) == 0 {
+				c.errorf(call, "unexpected argument %s to %s", n.Name, call.Name)
+			} else {
+				c.errorf(call, "unexpected argument %s to %s, expected one of %s",
+					n.Name, call.Name, strings.Join(sig.Options, ", "))
+			}
+		}
+		c.expr(n.Val, true)
+	}
+
+	return sig, true
+}
+
+// matches returns true if an argument is of the specified type. Parameters
+// match any value type, calls match the result type of their function, and
+// fields and string literals may hold dates.
+func (c *callChecker) matches(typ ArgType, a Expr) bool {
+
+	switch a := a.(type) {
+	case *IdentExpr:
+		return (typ == FieldType || typ == DateType) && a.Name != "*" || typ == FieldOrStarType
+	case *StringExpr:
+		return typ == AnyType || typ == StringType || typ == DateType
+	case *NumExpr:
+		return typ == AnyType || typ == NumberType
+	case *ParamExpr:
+		return typ == AnyType || typ == StringType || typ == NumberType || typ == BoolType || typ == DateType
+	case *FuncCallExpr:
+		sig, ok := c.r.Lookup(a.Name)
+		return !ok || typ == AnyType || sig.Returns == typ
+	case *BinaryExpr:
+		return typ == AnyType || typ == DateType
+	case *IntervalExpr:
+		return typ == AnyType || typ == IntervalType
+	}
+	return false
+}
+
+// article returns the name of a type preceded by the indefinite article.
+func article(typ ArgType) string {
+	if name := typ.String(); strings.IndexByte("aeiou", name[0]) >= 0 {
+		return "an " + name
+	}
+	return "a " + typ.String()
+}
+
+// arguments returns a count of n arguments.
+func arguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package sql
+
+import (
+	"testing"
+
+	log "github.com/cihub/seelog"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+// testCheck parses the specified SQL text and returns the messages of the
+// errors found checking it against the registry.
+func testCheck(r *FunctionRegistry, q string) []string {
+
+	stmt, err := testParse(q)
+	if err != nil {
+		panic(err)
+	}
+	var msgs []string
+	for _, e := range r.Check(stmt) {
+		msgs = append(msgs, e.Error())
+	}
+	return msgs
+}
+
+func TestFunctionRegistry(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test registering functions\n", t, func() {
+		r := NewFunctionRegistry()
+		So(r.Names(), ShouldResemble, []string{"AVG", "COUNT", "MAX", "MIN", "SUM"})
+
+		sig, ok := r.Lookup("count")
+		So(ok, ShouldBeTrue)
+		So(sig, ShouldResemble, FuncSignature{Name: "COUNT", Kind: AggregateFunc, Args: []ArgType{FieldOrStarType}, Returns: NumberType})
+
+		So(r.Register(FuncSignature{Name: "Concat", Args: []ArgType{StringType}, Variadic: true, Returns: StringType}), ShouldBeNil)
+		sig, ok = r.Lookup("CONCAT")
+		So(ok, ShouldBeTrue)
+		So(sig.Name, ShouldEqual, "Concat")
+		So(r.Names(), ShouldResemble, []string{"AVG", "COUNT", "Concat", "MAX", "MIN", "SUM"})
+
+		_, ok = r.Lookup("FOO")
+		So(ok, ShouldBeFalse)
+
+		So(r.Register(FuncSignature{}).Error(), ShouldEqual, "function signature has no name")
+		So(r.Register(FuncSignature{Name: "F", Variadic: true}).Error(), ShouldEqual, "variadic function F has no arguments")
+	})
+
+	Convey("Test checking valid calls\n", t, func() {
+		r := NewFunctionRegistry()
+		r.Register(FuncSignature{Name: "ROUND", Args: []ArgType{NumberType}, Options: []string{"digits"}, Returns: NumberType})
+		r.Register(FuncSignature{Name: "CONTAINS", Kind: PredicateFunc, Args: []ArgType{FieldType, StringType}, Variadic: true, Returns: BoolType})
+
+		So(testCheck(r, `SELECT COUNT(*), SUM(goals) FROM t`), ShouldBeNil)
+		So(testCheck(r, `SELECT a FROM t WHERE b > ROUND(1.5, digits = 2) AND c IN (ROUND(?))`), ShouldBeNil)
+		So(testCheck(r, `SELECT a FROM t WHERE CONTAINS(a, 'x', 'y', ?) ORDER BY ROUND(ROUND(2))`), ShouldBeNil)
+	})
+
+	Convey("Test checking invalid calls\n", t, func() {
+		r := NewFunctionRegistry()
+		r.Register(FuncSignature{Name: "ROUND", Args: []ArgType{NumberType}, Options: []string{"digits"}, Returns: NumberType})
+		r.Register(FuncSignature{Name: "UPPER", Args: []ArgType{StringType}, Returns: StringType})
+		r.Register(FuncSignature{Name: "CONTAINS", Kind: PredicateFunc, Args: []ArgType{FieldType, StringType}, Variadic: true, Returns: BoolType})
+
+		So(testCheck(r, `SELECT FOO(a) FROM t`), ShouldResemble, []string{"unknown function FOO"})
+		So(testCheck(r, `SELECT MAX(a, b), MIN() FROM t`), ShouldResemble, []string{
+			"MAX expects 1 argument, got 2",
+			"MIN expects 1 argument, got 0",
+		})
+		So(testCheck(r, `SELECT SUM(*), ROUND('x'), ROUND(UPPER('x')) FROM t`), ShouldResemble, []string{
+			"argument 1 of SUM must be a field, got *",
+			"argument 1 of ROUND must be a number, got 'x'",
+			"argument 1 of ROUND must be a number, got UPPER('x')",
+		})
+		So(testCheck(r, `SELECT a FROM t WHERE CONTAINS(a)`), ShouldResemble, []string{"CONTAINS expects at least 2 arguments, got 1"})
+		So(testCheck(r, `SELECT a FROM t WHERE CONTAINS(a, 'x', b)`), ShouldResemble, []string{"argument 3 of CONTAINS must be a string, got b"})
+		So(testCheck(r, `SELECT ROUND(1, places = 2), UPPER('x', y = 1) FROM t`), ShouldResemble, []string{
+			"unexpected argument places to ROUND, expected one of digits",
+			"unexpected argument y to UPPER",
+		})
+		So(testCheck(r, `SELECT UPPER(y = 2, 'x') FROM t`), ShouldResemble, []string{"positional argument 'x' follows named arguments in UPPER"})
+		So(testCheck(r, `SELECT a FROM t WHERE b IN (SELECT c FROM u WHERE ROUND('x') > 1)`), ShouldResemble, []string{
+			"argument 1 of ROUND must be a number, got 'x'",
+		})
+	})
+
+	Convey("Test checking date arithmetic\n", t, func() {
+		r := NewFunctionRegistry()
+		r.Register(FuncSignature{Name: "NOW", Returns: DateType})
+		r.Register(FuncSignature{Name: "ROUND", Args: []ArgType{NumberType}, Returns: NumberType})
+		r.Register(FuncSignature{Name: "DATE_ADD", Args: []ArgType{DateType, IntervalType}, Returns: DateType})
+
+		So(testCheck(r, `SELECT a FROM t WHERE a > NOW() - INTERVAL 1 DAY AND b < INTERVAL 2 HOUR + '2020-01-01'`), ShouldBeNil)
+		So(testCheck(r, `SELECT a FROM t WHERE a > DATE_ADD(b, INTERVAL 1 DAY) - INTERVAL 1 WEEK`), ShouldBeNil)
+
+		r.Register(FuncSignature{Name: "BUCKET", Args: []ArgType{FieldType, AnyType}})
+		So(testCheck(r, `SELECT BUCKET(a, INTERVAL 1 DAY), BUCKET(b, 10) FROM t GROUP BY BUCKET(c, 'x')`), ShouldBeNil)
+		So(testCheck(r, `SELECT a FROM t WHERE a > NOW() - 1 OR b < INTERVAL 1 DAY - NOW()`), ShouldResemble, []string{
+			"cannot apply - to NOW() and 1",
+			"cannot apply - to INTERVAL 1 DAY and NOW()",
+		})
+		So(testCheck(r, `SELECT a FROM t WHERE a > ROUND(2) + INTERVAL 1 DAY`), ShouldResemble, []string{
+			"cannot apply + to ROUND(2) and INTERVAL 1 DAY",
+		})
+		So(testCheck(r, `SELECT a FROM t WHERE a > DATE_ADD(b, 1) + INTERVAL 1 DAY + FOO()`), ShouldResemble, []string{
+			"argument 2 of DATE_ADD must be an interval, got 1.000000",
+			"unknown function FOO",
+		})
+	})
+
+	Convey("Test checking the kinds of calls\n", t, func() {
+		r := NewFunctionRegistry()
+		r.Register(FuncSignature{Name: "ROUND", Args: []ArgType{NumberType}, Returns: NumberType})
+		r.Register(FuncSignature{Name: "CONTAINS", Kind: PredicateFunc, Args: []ArgType{FieldType, StringType}, Returns: BoolType})
+
+		So(testCheck(r, `SELECT a FROM t WHERE ROUND(1)`), ShouldResemble, []string{"ROUND is not a predicate"})
+		So(testCheck(r, `SELECT CONTAINS(a, 'x') FROM t`), ShouldResemble, []string{"predicate CONTAINS cannot be used as a value"})
+		So(testCheck(r, `SELECT a FROM t WHERE b = MAX(c)`), ShouldResemble, []string{"aggregate MAX is only allowed in the select list"})
+		So(testCheck(r, `SELECT a FROM t GROUP BY COUNT(a) ORDER BY SUM(b)`), ShouldResemble, []string{
+			"aggregate COUNT is only allowed in the select list",
+			"aggregate SUM is only allowed in the select list",
+		})
+		So(testCheck(r, `SELECT ROUND(MAX(a)) FROM t`), ShouldResemble, []string{"aggregate MAX is only allowed in the select list"})
+	})
+}
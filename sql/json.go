@@ -0,0 +1,709 @@
+package sql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// JSONSchemaVersion is the version of the JSON representation of statements
+// written by MarshalJSON. UnmarshalJSON accepts this and any earlier version.
+const JSONSchemaVersion = 1
+
+// Each node is encoded as a JSON object whose "type" member identifies the
+// node type, so that Cond and Expr values can be decoded into the right type.
+const (
+	jsonSelect   = "select"
+	jsonComp     = "comp"
+	jsonIn       = "in"
+	jsonConj     = "conj"
+	jsonFunc     = "func"
+	jsonExists   = "exists"
+	jsonCall     = "call"
+	jsonString   = "string"
+	jsonNumber   = "number"
+	jsonParam    = "param"
+	jsonIdent    = "ident"
+	jsonNamed    = "named"
+	jsonBinary   = "binary"
+	jsonInterval = "interval"
+	jsonSubquery = "subquery"
+)
+
+func (s SelectStatement) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Type      string      `json:"type"`
+		Version   int         `json:"version"`
+		FieldList Fields      `json:"fields"`
+		TableList Fields      `json:"tables"`
+		Joins     []Join      `json:"joins,omitempty"`
+		WhereCond Cond        `json:"where,omitempty"`
+		GroupBy   []Expr      `json:"group,omitempty"`
+		Unions    []Union     `json:"unions,omitempty"`
+		OrderBy   []OrderItem `json:"order,omitempty"`
+		Limit     Expr        `json:"limit,omitempty"`
+	}{jsonSelect, JSONSchemaVersion, s.FieldList, s.TableList, s.Joins, s.WhereCond, s.GroupBy, s.Unions, s.OrderBy, s.Limit})
+}
+
+func (s *SelectStatement) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Version   int               `json:"version"`
+		FieldList Fields            `json:"fields"`
+		TableList Fields            `json:"tables"`
+		Joins     []Join            `json:"joins"`
+		WhereCond json.RawMessage   `json:"where"`
+		GroupBy   []json.RawMessage `json:"group"`
+		Unions    []Union           `json:"unions"`
+		OrderBy   []OrderItem       `json:"order"`
+		Limit     json.RawMessage   `json:"limit"`
+	}
+	if err := decodeNode(b, jsonSelect, &v); err != nil {
+		return err
+	}
+	if v.Version < 1 || v.Version > JSONSchemaVersion {
+		return fmt.Errorf("unsupported statement schema version %d", v.Version)
+	}
+
+	where, err := unmarshalCond(v.WhereCond)
+	if err != nil {
+		return err
+	}
+	var group []Expr
+	for _, raw := range v.GroupBy {
+		e, err := unmarshalExpr(raw)
+		if err != nil {
+			return err
+		}
+		group = append(group, e)
+	}
+	limit, err := unmarshalExpr(v.Limit)
+	if err != nil {
+		return err
+	}
+
+	*s = SelectStatement{FieldList: v.FieldList, TableList: v.TableList, Joins: v.Joins, WhereCond: where,
+		GroupBy: group, Unions: v.Unions, OrderBy: v.OrderBy, Limit: limit}
+	return nil
+}
+
+// Field is not a node and so has no "type" member.
+func (f *Field) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Name  string          `json:"name"`
+		Alias string          `json:"alias"`
+		Expr  json.RawMessage `json:"expr"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	e, err := unmarshalExpr(v.Expr)
+	if err != nil {
+		return err
+	}
+
+	*f = Field{Name: v.Name, Alias: v.Alias, Expr: e}
+	return nil
+}
+
+// OrderItem is not a node and so has no "type" member.
+func (o OrderItem) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Expr Expr `json:"expr"`
+		Desc bool `json:"desc,omitempty"`
+	}{o.Expr, o.Desc})
+}
+
+func (o *OrderItem) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Expr json.RawMessage `json:"expr"`
+		Desc bool            `json:"desc"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	e, err := unmarshalExpr(v.Expr)
+	if err != nil {
+		return err
+	}
+
+	*o = OrderItem{Expr: e, Desc: v.Desc}
+	return nil
+}
+
+// Join is not a node and so has no "type" member.
+func (j Join) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Left  bool  `json:"left,omitempty"`
+		Table Field `json:"table"`
+		On    Cond  `json:"on"`
+	}{j.Left, j.Table, j.On})
+}
+
+func (j *Join) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Left  bool            `json:"left"`
+		Table Field           `json:"table"`
+		On    json.RawMessage `json:"on"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	on, err := unmarshalCond(v.On)
+	if err != nil {
+		return err
+	}
+	if on == nil {
+		return fmt.Errorf("JOIN %s has no ON condition", v.Table.Name)
+	}
+
+	*j = Join{Left: v.Left, Table: v.Table, On: on}
+	return nil
+}
+
+// Union is not a node and so has no "type" member.
+func (u Union) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		All    bool             `json:"all,omitempty"`
+		Select *SelectStatement `json:"select"`
+	}{u.All, u.Select})
+}
+
+func (u *Union) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		All    bool             `json:"all"`
+		Select *SelectStatement `json:"select"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v.Select == nil {
+		return fmt.Errorf("UNION has no select statement")
+	}
+
+	*u = Union{All: v.All, Select: v.Select}
+	return nil
+}
+
+func (c CondComp) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Type   string `json:"type"`
+		Ident  string `json:"ident"`
+		CondOp string `json:"op"`
+		Val    Expr   `json:"val"`
+	}{jsonComp, c.Ident, c.CondOp.Text(), c.Val})
+}
+
+func (c *CondComp) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Ident  string          `json:"ident"`
+		CondOp string          `json:"op"`
+		Val    json.RawMessage `json:"val"`
+	}
+	if err := decodeNode(b, jsonComp, &v); err != nil {
+		return err
+	}
+
+	op, ok := lookupToken(v.CondOp)
+	if !ok || !isOperator(op) {
+		return fmt.Errorf("unexpected comparison operator %q", v.CondOp)
+	}
+	val, err := unmarshalExpr(v.Val)
+	if err != nil {
+		return err
+	}
+
+	*c = CondComp{Ident: v.Ident, CondOp: op, Val: val}
+	return nil
+}
+
+func (c CondIn) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Type  string `json:"type"`
+		Ident string `json:"ident"`
+		Not   bool   `json:"not,omitempty"`
+		Vals  []Expr `json:"vals"`
+	}{jsonIn, c.Ident, c.Not, c.Vals})
+}
+
+func (c *CondIn) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Ident string            `json:"ident"`
+		Not   bool              `json:"not"`
+		Vals  []json.RawMessage `json:"vals"`
+	}
+	if err := decodeNode(b, jsonIn, &v); err != nil {
+		return err
+	}
+
+	vals := make([]Expr, len(v.Vals))
+	for i, raw := range v.Vals {
+		var err error
+		if vals[i], err = unmarshalExpr(raw); err != nil {
+			return err
+		}
+	}
+
+	*c = CondIn{Ident: v.Ident, Not: v.Not, Vals: vals}
+	return nil
+}
+
+func (c CondFunc) MarshalJSON() ([]byte, error) {
+
+	op := ""
+	if c.CondOp != ILLEGAL {
+		op = c.CondOp.Text()
+	}
+	return marshalNode(struct {
+		Type   string        `json:"type"`
+		Call   *FuncCallExpr `json:"call"`
+		CondOp string        `json:"op,omitempty"`
+		Val    Expr          `json:"val,omitempty"`
+	}{jsonFunc, c.Call, op, c.Val})
+}
+
+func (c *CondFunc) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Call   json.RawMessage `json:"call"`
+		CondOp string          `json:"op"`
+		Val    json.RawMessage `json:"val"`
+	}
+	if err := decodeNode(b, jsonFunc, &v); err != nil {
+		return err
+	}
+
+	call := &FuncCallExpr{}
+	if err := json.Unmarshal(v.Call, call); err != nil {
+		return err
+	}
+	*c = CondFunc{Call: call}
+	if len(v.CondOp) == 0 {
+		return nil
+	}
+
+	op, ok := lookupToken(v.CondOp)
+	if !ok || !isOperator(op) {
+		return fmt.Errorf("unexpected comparison operator %q", v.CondOp)
+	}
+	val, err := unmarshalExpr(v.Val)
+	if err != nil {
+		return err
+	}
+	c.CondOp, c.Val = op, val
+	return nil
+}
+
+func (c CondExists) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Type   string           `json:"type"`
+		Not    bool             `json:"not,omitempty"`
+		Select *SelectStatement `json:"select"`
+	}{jsonExists, c.Not, c.Select})
+}
+
+func (c *CondExists) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Not    bool            `json:"not"`
+		Select json.RawMessage `json:"select"`
+	}
+	if err := decodeNode(b, jsonExists, &v); err != nil {
+		return err
+	}
+
+	stmt := &SelectStatement{}
+	if err := json.Unmarshal(v.Select, stmt); err != nil {
+		return err
+	}
+
+	*c = CondExists{Not: v.Not, Select: stmt}
+	return nil
+}
+
+func (c CondConj) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Type  string `json:"type"`
+		Op    string `json:"op"`
+		Left  Cond   `json:"left"`
+		Right Cond   `json:"right"`
+	}{jsonConj, c.Op.Text(), c.Left, c.Right})
+}
+
+func (c *CondConj) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Op    string          `json:"op"`
+		Left  json.RawMessage `json:"left"`
+		Right json.RawMessage `json:"right"`
+	}
+	if err := decodeNode(b, jsonConj, &v); err != nil {
+		return err
+	}
+
+	op, ok := lookupToken(v.Op)
+	if !ok || (op != AND && op != OR) {
+		return fmt.Errorf("unexpected conjunction operator %q", v.Op)
+	}
+	left, err := unmarshalCond(v.Left)
+	if err != nil {
+		return err
+	}
+	right, err := unmarshalCond(v.Right)
+	if err != nil {
+		return err
+	}
+
+	*c = CondConj{Op: op, Left: left, Right: right}
+	return nil
+}
+
+func (f FuncCallExpr) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+		Args []Expr `json:"args"`
+	}{jsonCall, f.Name, f.Args})
+}
+
+func (f *FuncCallExpr) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Name string            `json:"name"`
+		Args []json.RawMessage `json:"args"`
+	}
+	if err := decodeNode(b, jsonCall, &v); err != nil {
+		return err
+	}
+
+	args := make([]Expr, len(v.Args))
+	for i, a := range v.Args {
+		var err error
+		if args[i], err = unmarshalExpr(a); err != nil {
+			return err
+		}
+	}
+
+	*f = FuncCallExpr{Name: v.Name, Args: args}
+	return nil
+}
+
+func (s StringExpr) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Type string `json:"type"`
+		Val  string `json:"val"`
+	}{jsonString, s.Val})
+}
+
+func (s *StringExpr) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Val string `json:"val"`
+	}
+	if err := decodeNode(b, jsonString, &v); err != nil {
+		return err
+	}
+
+	*s = StringExpr{Val: v.Val}
+	return nil
+}
+
+func (n NumExpr) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Type string  `json:"type"`
+		Val  float64 `json:"val"`
+	}{jsonNumber, n.Val})
+}
+
+func (n *NumExpr) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Val float64 `json:"val"`
+	}
+	if err := decodeNode(b, jsonNumber, &v); err != nil {
+		return err
+	}
+
+	*n = NumExpr{Val: v.Val}
+	return nil
+}
+
+func (p ParamExpr) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Type  string `json:"type"`
+		Lit   string `json:"lit"`
+		Index int    `json:"index,omitempty"`
+		Name  string `json:"name,omitempty"`
+	}{jsonParam, p.Lit, p.Index, p.Name})
+}
+
+func (p *ParamExpr) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Lit   string `json:"lit"`
+		Index int    `json:"index"`
+		Name  string `json:"name"`
+	}
+	if err := decodeNode(b, jsonParam, &v); err != nil {
+		return err
+	}
+
+	*p = ParamExpr{Lit: v.Lit, Index: v.Index, Name: v.Name}
+	return nil
+}
+
+func (i IdentExpr) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	}{jsonIdent, i.Name})
+}
+
+func (i *IdentExpr) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Name string `json:"name"`
+	}
+	if err := decodeNode(b, jsonIdent, &v); err != nil {
+		return err
+	}
+
+	*i = IdentExpr{Name: v.Name}
+	return nil
+}
+
+func (n NamedArgExpr) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+		Val  Expr   `json:"val"`
+	}{jsonNamed, n.Name, n.Val})
+}
+
+func (n *NamedArgExpr) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Name string          `json:"name"`
+		Val  json.RawMessage `json:"val"`
+	}
+	if err := decodeNode(b, jsonNamed, &v); err != nil {
+		return err
+	}
+
+	val, err := unmarshalExpr(v.Val)
+	if err != nil {
+		return err
+	}
+
+	*n = NamedArgExpr{Name: v.Name, Val: val}
+	return nil
+}
+
+func (b BinaryExpr) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Type string `json:"type"`
+		Op   string `json:"op"`
+		LHS  Expr   `json:"lhs"`
+		RHS  Expr   `json:"rhs"`
+	}{jsonBinary, b.Op.Text(), b.LHS, b.RHS})
+}
+
+func (b *BinaryExpr) UnmarshalJSON(data []byte) error {
+
+	var v struct {
+		Op  string          `json:"op"`
+		LHS json.RawMessage `json:"lhs"`
+		RHS json.RawMessage `json:"rhs"`
+	}
+	if err := decodeNode(data, jsonBinary, &v); err != nil {
+		return err
+	}
+
+	op, ok := lookupToken(v.Op)
+	if !ok || (op != PLUS && op != MINUS) {
+		return fmt.Errorf("unexpected binary operator %q", v.Op)
+	}
+	lhs, err := unmarshalExpr(v.LHS)
+	if err != nil {
+		return err
+	}
+	rhs, err := unmarshalExpr(v.RHS)
+	if err != nil {
+		return err
+	}
+
+	*b = BinaryExpr{Op: op, LHS: lhs, RHS: rhs}
+	return nil
+}
+
+func (i IntervalExpr) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Type string  `json:"type"`
+		Val  float64 `json:"val"`
+		Unit string  `json:"unit"`
+	}{jsonInterval, i.Val, i.Unit})
+}
+
+func (i *IntervalExpr) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Val  float64 `json:"val"`
+		Unit string  `json:"unit"`
+	}
+	if err := decodeNode(b, jsonInterval, &v); err != nil {
+		return err
+	}
+
+	if unit, ok := intervalUnits[v.Unit]; !ok || unit != v.Unit {
+		return fmt.Errorf("unexpected interval unit %q", v.Unit)
+	}
+
+	*i = IntervalExpr{Val: v.Val, Unit: v.Unit}
+	return nil
+}
+
+func (s SubqueryExpr) MarshalJSON() ([]byte, error) {
+	return marshalNode(struct {
+		Type   string           `json:"type"`
+		Select *SelectStatement `json:"select"`
+	}{jsonSubquery, s.Select})
+}
+
+func (s *SubqueryExpr) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Select json.RawMessage `json:"select"`
+	}
+	if err := decodeNode(b, jsonSubquery, &v); err != nil {
+		return err
+	}
+
+	stmt := &SelectStatement{}
+	if err := json.Unmarshal(v.Select, stmt); err != nil {
+		return err
+	}
+
+	*s = SubqueryExpr{Select: stmt}
+	return nil
+}
+
+// marshalNode encodes a node without escaping the HTML characters in
+// operators such as "<=". Encoders which escape HTML, including
+// json.Marshal, still escape them in their own output.
+func marshalNode(v interface{}) ([]byte, error) {
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// nodeType returns the "type" member of an encoded node.
+func nodeType(b []byte) (string, error) {
+
+	var hdr struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(b, &hdr); err != nil {
+		return "", err
+	}
+	return hdr.Type, nil
+}
+
+// decodeNode unmarshals an encoded node into v after verifying that its
+// "type" member matches typ.
+func decodeNode(b []byte, typ string, v interface{}) error {
+
+	t, err := nodeType(b)
+	if err != nil {
+		return err
+	}
+	if t != typ {
+		return fmt.Errorf("found node type %q, expected %q", t, typ)
+	}
+	return json.Unmarshal(b, v)
+}
+
+// unmarshalCond decodes a condition of any type, returning nil for an absent
+// or null condition.
+func unmarshalCond(b json.RawMessage) (Cond, error) {
+
+	if len(b) == 0 || string(b) == "null" {
+		return nil, nil
+	}
+
+	t, err := nodeType(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Cond
+	switch t {
+	case jsonComp:
+		c = &CondComp{}
+	case jsonIn:
+		c = &CondIn{}
+	case jsonConj:
+		c = &CondConj{}
+	case jsonFunc:
+		c = &CondFunc{}
+	case jsonExists:
+		c = &CondExists{}
+	default:
+		return nil, fmt.Errorf("unexpected condition type %q", t)
+	}
+
+	return c, json.Unmarshal(b, c)
+}
+
+// unmarshalExpr decodes an expression of any type, returning nil for an
+// absent or null expression.
+func unmarshalExpr(b json.RawMessage) (Expr, error) {
+
+	if len(b) == 0 || string(b) == "null" {
+		return nil, nil
+	}
+
+	t, err := nodeType(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var e Expr
+	switch t {
+	case jsonCall:
+		e = &FuncCallExpr{}
+	case jsonString:
+		e = &StringExpr{}
+	case jsonNumber:
+		e = &NumExpr{}
+	case jsonParam:
+		e = &ParamExpr{}
+	case jsonIdent:
+		e = &IdentExpr{}
+	case jsonNamed:
+		e = &NamedArgExpr{}
+	case jsonBinary:
+		e = &BinaryExpr{}
+	case jsonInterval:
+		e = &IntervalExpr{}
+	case jsonSubquery:
+		e = &SubqueryExpr{}
+	default:
+		return nil, fmt.Errorf("unexpected expression type %q", t)
+	}
+
+	return e, json.Unmarshal(b, e)
+}
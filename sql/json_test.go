@@ -0,0 +1,131 @@
+package sql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+func TestJSON(t *testing.T) {
+
+	defer log.Flush()
+
+	Convey("Test encoding a statement\n", t, func() {
+		stmt, err := testParse(`SELECT name n, goals FROM oilers WHERE pos != 'C' AND jersey <= F(?, "x", :y)`)
+		So(err, ShouldBeNil)
+
+		b, err := testMarshal(stmt)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, `{"type":"select","version":1,`+
+			`"fields":[{"name":"name","alias":"n"},{"name":"goals"}],"tables":[{"name":"oilers"}],`+
+			`"where":{"type":"conj","op":"AND",`+
+			`"left":{"type":"comp","ident":"pos","op":"!=","val":{"type":"string","val":"'C'"}},`+
+			`"right":{"type":"comp","ident":"jersey","op":"<=","val":{"type":"call","name":"F","args":[`+
+			`{"type":"param","lit":"?","index":1},{"type":"string","val":"\"x\""},{"type":"param","lit":":y","name":"y"}]}}}}`)
+		log.Debugf("json: %s", b)
+
+		b, err = json.Marshal(stmt.WhereCond.(*CondConj).Right)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldStartWith, `{"type":"comp","ident":"jersey","op":"\u003c=",`)
+
+		stmt, err = testParse(`SELECT * FROM oilers`)
+		So(err, ShouldBeNil)
+		b, err = json.Marshal(stmt)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, `{"type":"select","version":1,"fields":[{"name":"*"}],"tables":[{"name":"oilers"}]}`)
+	})
+
+	Convey("Test round-tripping statements through JSON\n", t, func() {
+		for _, q := range []string{
+			`SELECT * FROM t`,
+			`SELECT a x, b FROM t1 a1, t2 WHERE a = "it\"s"`,
+			`SELECT a FROM t WHERE a = 'x' AND b <= -0.001 OR c > 1000000 AND d = $2 AND e != :e`,
+			`SELECT a FROM t WHERE a = F() AND (b = G(?, H(-1.5, 'y')) OR (c < ? OR d >= 12.75))`,
+			`SELECT a FROM t WHERE a IN (1, 'x', ?) OR b NOT IN (F(2))`,
+			`SELECT a FROM t WHERE MATCH(a, 'x y', operator = 'and', boost = 2) AND (QUERY_STRING(?) OR b = 1)`,
+			`SELECT a FROM t WHERE ST_DISTANCE(a, POINT(1, -2.5)) <= ? AND F(b) != 'x' ORDER BY ST_DISTANCE(a, POINT(0, 0))`,
+			`SELECT a FROM t WHERE EXISTS (SELECT * FROM t.b WHERE c = 1 OR NOT EXISTS (SELECT x FROM t.b.d)) AND e = ?`,
+			`SELECT a FROM t WHERE b IN (SELECT c FROM u WHERE d = ? AND e NOT IN (SELECT f FROM v)) OR g = 1`,
+			`SELECT p.a, q.b FROM t p JOIN u q ON p.c = q.c LEFT JOIN v ON v.d = q.d AND v.e > 1 WHERE p.f = ?`,
+			`SELECT a FROM t WHERE a = b ORDER BY a DESC, F(b) LIMIT 5`,
+			`SELECT a FROM t WHERE b IN (SELECT c FROM u UNION SELECT d FROM v) UNION ALL SELECT e FROM w ORDER BY a LIMIT 3`,
+			`SELECT team t, COUNT(*) n, AVG(goals) FROM t GROUP BY team`,
+			`SELECT DATE_TRUNC('month', a) m FROM t WHERE a > NOW() - INTERVAL 30 YEAR + INTERVAL 1.5 DAY`,
+		} {
+			stmt, err := testParse(q)
+			So(err, ShouldBeNil)
+
+			b, err := json.Marshal(stmt)
+			So(err, ShouldBeNil)
+
+			var decoded SelectStatement
+			So(json.Unmarshal(b, &decoded), ShouldBeNil)
+			So(&decoded, ShouldResemble, stmt)
+		}
+	})
+
+	Convey("Test decoding individual nodes\n", t, func() {
+		c, err := unmarshalCond([]byte(`{"type":"comp","ident":"a","op":">=","val":{"type":"number","val":1.5}}`))
+		So(err, ShouldBeNil)
+		So(c, ShouldResemble, &CondComp{Ident: "a", CondOp: GE, Val: &NumExpr{Val: 1.5}})
+
+		c, err = unmarshalCond([]byte(`{"type":"func","call":{"type":"call","name":"F","args":[]},"op":"<","val":{"type":"number","val":2}}`))
+		So(err, ShouldBeNil)
+		So(c, ShouldResemble, &CondFunc{Call: &FuncCallExpr{Name: "F", Args: []Expr{}}, CondOp: LT, Val: &NumExpr{Val: 2}})
+
+		c, err = unmarshalCond([]byte(`null`))
+		So(err, ShouldBeNil)
+		So(c, ShouldBeNil)
+	})
+
+	Convey("Test decoding errors\n", t, func() {
+		var stmt SelectStatement
+
+		err := json.Unmarshal([]byte(`{"type":"select","version":2,"fields":[],"tables":[]}`), &stmt)
+		So(err, ShouldResemble, fmt.Errorf("unsupported statement schema version 2"))
+
+		err = json.Unmarshal([]byte(`{"type":"comp","version":1}`), &stmt)
+		So(err, ShouldResemble, fmt.Errorf(`found node type "comp", expected "select"`))
+
+		err = json.Unmarshal([]byte(`{"type":"select","version":1,"where":{"type":"string","val":"'x'"}}`), &stmt)
+		So(err, ShouldResemble, fmt.Errorf(`unexpected condition type "string"`))
+
+		err = json.Unmarshal([]byte(`{"type":"select","version":1,"where":{"type":"comp","ident":"a","op":"AND"}}`), &stmt)
+		So(err, ShouldResemble, fmt.Errorf(`unexpected comparison operator "AND"`))
+
+		err = json.Unmarshal([]byte(`{"type":"select","version":1,"where":{"type":"conj","op":"=","left":null,"right":null}}`), &stmt)
+		So(err, ShouldResemble, fmt.Errorf(`unexpected conjunction operator "="`))
+
+		err = json.Unmarshal([]byte(`{"type":"select","version":1,"where":{"type":"comp","ident":"a","op":"=","val":{"type":"conj"}}}`), &stmt)
+		So(err, ShouldResemble, fmt.Errorf(`unexpected expression type "conj"`))
+
+		err = json.Unmarshal([]byte(`{"type":"select","version":1,"where":{"type":"comp","ident":"a","op":"=","val":{"type":"binary","op":"*"}}}`), &stmt)
+		So(err, ShouldResemble, fmt.Errorf(`unexpected binary operator "*"`))
+
+		err = json.Unmarshal([]byte(`{"type":"select","version":1,"where":{"type":"comp","ident":"a","op":"=","val":{"type":"interval","val":1,"unit":"DAYS"}}}`), &stmt)
+		So(err, ShouldResemble, fmt.Errorf(`unexpected interval unit "DAYS"`))
+
+		err = json.Unmarshal([]byte(`{"type":"select","version":1,"joins":[{"table":{"name":"u"}}]}`), &stmt)
+		So(err, ShouldResemble, fmt.Errorf(`JOIN u has no ON condition`))
+
+		err = json.Unmarshal([]byte(`{"type":"select","version":1,"unions":[{"all":true}]}`), &stmt)
+		So(err, ShouldResemble, fmt.Errorf(`UNION has no select statement`))
+	})
+}
+
+// testMarshal returns the JSON encoding of v without HTML escaping.
+func testMarshal(v interface{}) ([]byte, error) {
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v)
+	return bytes.TrimSpace(buf.Bytes()), err
+}
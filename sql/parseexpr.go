@@ -1,16 +1,59 @@
 package sql
 
 import (
+	"bytes"
 	"fmt"
 	"strconv"
+	"strings"
 )
 
+// Expr is implemented by every expression node, i.e. anything that can
+// appear as a comparison value or a function argument.
 type Expr interface {
-	String() string
+	Node
+	expr()
 }
 
+func (*FuncCallExpr) expr() {}
+func (*StringExpr) expr()   {}
+func (*NumExpr) expr()      {}
+func (*ParamExpr) expr()    {}
+func (*IdentExpr) expr()    {}
+func (*NamedArgExpr) expr() {}
+func (*BinaryExpr) expr()   {}
+func (*IntervalExpr) expr() {}
+func (*SubqueryExpr) expr() {}
+
+// parseExpr parses an operand followed by any number of + or - operators
+// and further operands, which associate to the left, e.g.
+// NOW() - INTERVAL 30 YEAR.
 func (p *Parser) parseExpr() (Expr, error) {
 
+	e, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, _ := p.scanIgnoreWhitespace()
+		if op != PLUS && op != MINUS {
+			p.unscan()
+			return e, nil
+		}
+		pos := p.pos()
+
+		rhs, err := p.parseOperand()
+		if err != nil {
+			return nil, fmt.Errorf(`error parsing operand of %s: %v`, op.Text(), err)
+		}
+		e = &BinaryExpr{Op: op, LHS: e, RHS: rhs, Pos: pos}
+	}
+}
+
+// parseOperand parses a single literal, parameter, identifier, function call
+// or interval.
+func (p *Parser) parseOperand() (Expr, error) {
+
 	tok, arg := p.scanIgnoreWhitespace()
 	switch(tok) {
 	case STRING:
@@ -21,17 +64,153 @@ func (p *Parser) parseExpr() (Expr, error) {
 			return nil, fmt.Errorf("ParseExpr() error in ParseFloat('%s'): %v", arg, err)
 		}
 		return &NumExpr{Val: numVal}, nil
+	case PARAM:
+		return p.parseParam(arg)
 	case IDENT:
+		pos := p.pos()
+		if next, _ := p.scanIgnoreWhitespace(); next == PAREN_L {
+			return p.parseFuncArgs(arg, pos)
+		}
 		p.unscan()
-		return p.parseFuncCall()
+		return &IdentExpr{Name: arg, Pos: pos}, nil
+	case INTERVAL:
+		return p.parseInterval()
 	default:
 		return nil, fmt.Errorf(`parseExpr() expected expression (string, number or function call), got %v`, tok)
 	}
 }
 
+// intervalUnits maps the units of an INTERVAL, singular or plural, to their
+// singular form.
+var intervalUnits = map[string]string{
+	"YEAR": "YEAR", "YEARS": "YEAR",
+	"QUARTER": "QUARTER", "QUARTERS": "QUARTER",
+	"MONTH": "MONTH", "MONTHS": "MONTH",
+	"WEEK": "WEEK", "WEEKS": "WEEK",
+	"DAY": "DAY", "DAYS": "DAY",
+	"HOUR": "HOUR", "HOURS": "HOUR",
+	"MINUTE": "MINUTE", "MINUTES": "MINUTE",
+	"SECOND": "SECOND", "SECONDS": "SECOND",
+}
+
+// parseInterval parses the count and unit of an interval, e.g. 30 YEAR, with
+// the scanner positioned just after INTERVAL. The count may be quoted, as in
+// INTERVAL '30' YEAR.
+func (p *Parser) parseInterval() (Expr, error) {
+
+	tok, lit := p.scanIgnoreWhitespace()
+	count := lit
+	if tok == STRING {
+		count = (&StringExpr{Val: lit}).Unquoted()
+	} else if tok != NUMBER {
+		return nil, fmt.Errorf(`expected INTERVAL count, got "%s"`, lit)
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid INTERVAL count "%s"`, lit)
+	}
+
+	tok, lit = p.scanIgnoreWhitespace()
+	unit, ok := intervalUnits[strings.ToUpper(lit)]
+	if tok != IDENT || !ok {
+		return nil, fmt.Errorf(`expected INTERVAL unit, got "%s"`, lit)
+	}
+
+	return &IntervalExpr{Val: n, Unit: unit}, nil
+}
+
+// IntervalExpr represents a length of time, e.g. INTERVAL 30 YEAR. Unit is
+// one of YEAR, QUARTER, MONTH, WEEK, DAY, HOUR, MINUTE or SECOND.
+type IntervalExpr struct {
+	Val  float64
+	Unit string
+}
+
+func (i IntervalExpr) String() string {
+	return fmt.Sprintf("INTERVAL %s %s", strconv.FormatFloat(i.Val, 'f', -1, 64), i.Unit)
+}
+
+// BinaryExpr represents the addition or subtraction of two expressions, e.g.
+// NOW() - INTERVAL 30 YEAR. Pos is the position of the operator.
+type BinaryExpr struct {
+	Op  Token // PLUS or MINUS
+	LHS Expr
+	RHS Expr
+	Pos Pos
+}
+
+func (b BinaryExpr) String() string {
+	return fmt.Sprintf("%s %s %s", b.LHS, b.Op.Text(), b.RHS)
+}
+
+// IdentExpr represents a reference to a field by name.
+type IdentExpr struct {
+	Name string
+	Pos  Pos
+}
+
+func (i IdentExpr) String() string {
+	return i.Name
+}
+
+// SubqueryExpr represents a SELECT statement whose rows supply the values of
+// an IN list, e.g. team IN (SELECT code FROM teams), in which it is the only
+// value. The parentheses belong to the IN list, and so are not part of its
+// text.
+type SubqueryExpr struct {
+	Select *SelectStatement
+	Pos    Pos
+}
+
+func (s SubqueryExpr) String() string {
+	return s.Select.String()
+}
+
+// ParamExpr represents a bind parameter placeholder: a positional ?, a
+// numbered $n or a named :name. Index is the 1-based argument position of
+// positional and numbered parameters and zero for named ones.
+type ParamExpr struct {
+	Lit   string
+	Index int
+	Name  string
+}
+
+func (p ParamExpr) String() string {
+	return p.Lit
+}
+
+// Key returns the identity under which the parameter is bound, so that ?
+// and $n placeholders referring to the same argument share a value.
+func (p ParamExpr) Key() string {
+	if len(p.Name) > 0 {
+		return ":" + p.Name
+	}
+	return fmt.Sprintf("$%d", p.Index)
+}
+
+// parseParam converts the literal of a PARAM token into a ParamExpr,
+// numbering positional parameters in the order they are encountered.
+func (p *Parser) parseParam(lit string) (Expr, error) {
+
+	switch lit[0] {
+	case '?':
+		p.nparam += 1
+		return &ParamExpr{Lit: lit, Index: p.nparam}, nil
+	case '$':
+		idx, err := strconv.Atoi(lit[1:])
+		if err != nil || idx < 1 {
+			return nil, fmt.Errorf(`invalid parameter number in '%s'`, lit)
+		}
+		return &ParamExpr{Lit: lit, Index: idx}, nil
+	default:
+		return &ParamExpr{Lit: lit, Name: lit[1:]}, nil
+	}
+}
+
 type FuncCallExpr struct {
 	Name string
 	Args []Expr
+	Pos  Pos
 }
 
 func (f FuncCallExpr) String() string {
@@ -48,6 +227,17 @@ func (f FuncCallExpr) String() string {
 	return fmt.Sprintf("%s(%s)", f.Name, argList)
 }
 
+// NamedArgExpr represents an optional function argument passed by name, e.g.
+// operator = 'and' in MATCH(quote, 'puck', operator = 'and').
+type NamedArgExpr struct {
+	Name string
+	Val  Expr
+}
+
+func (n NamedArgExpr) String() string {
+	return fmt.Sprintf("%s = %s", n.Name, n.Val)
+}
+
 type StringExpr struct {
 	Val string
 }
@@ -56,6 +246,59 @@ func (s StringExpr) String() string {
 	return s.Val
 }
 
+// Unquoted returns the string value with its enclosing quotes removed and
+// any backslash escapes resolved.
+func (s StringExpr) Unquoted() string {
+
+	if len(s.Val) < 2 {
+		return s.Val
+	}
+
+	var buf bytes.Buffer
+	inner := []rune(s.Val[1 : len(s.Val)-1])
+	for i := 0; i < len(inner); i++ {
+		ch := inner[i]
+		if ch == '\\' && i+1 < len(inner) {
+			i += 1
+			switch inner[i] {
+			case 'n':
+				ch = '\n'
+			case 't':
+				ch = '\t'
+			default:
+				ch = inner[i]
+			}
+		}
+		buf.WriteRune(ch)
+	}
+
+	return buf.String()
+}
+
+// QuoteString returns s as a double-quoted string literal that the Scanner
+// reads back as a single STRING token.
+func QuoteString(s string) string {
+
+	var buf bytes.Buffer
+	buf.WriteRune('"')
+	for _, ch := range s {
+		switch ch {
+		case '"', '\\':
+			buf.WriteRune('\\')
+			buf.WriteRune(ch)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			buf.WriteRune(ch)
+		}
+	}
+	buf.WriteRune('"')
+
+	return buf.String()
+}
+
 type NumExpr struct {
 	Val float64
 }
@@ -67,27 +310,53 @@ func (n NumExpr) String() string {
 func (p *Parser) parseFuncCall() (Expr, error) {
 
 	var funcName string
-	var args []Expr = make([]Expr, 0)
 
 	tok, ident := p.scanIgnoreWhitespace()
 	if tok != IDENT {
 		return nil, fmt.Errorf(`expected IDENT, got '%s'`, ident)
 	}
 	funcName = ident
+	pos := p.pos()
 
 	tok, arg := p.scanIgnoreWhitespace()
 	if tok != PAREN_L {
 		return nil, fmt.Errorf(`expected '(', got '%s'`, arg)
 	}
 
-	tok, _ = p.scanIgnoreWhitespace()
+	return p.parseFuncArgs(funcName, pos)
+}
+
+// parseFuncArgs parses the arguments of a call to the named function, found
+// at pos, with the scanner positioned just after the opening parenthesis.
+func (p *Parser) parseFuncArgs(funcName string, pos Pos) (Expr, error) {
+
+	var args []Expr = make([]Expr, 0)
+
+	tok, arg := p.scanIgnoreWhitespace()
 	i := 1
 	for tok != EOF && tok != PAREN_R {
 
-		p.unscan()
-		e, err := p.parseExpr()
-		if err != nil {
-			return nil, fmt.Errorf(`error parsing %s argument %d: %v`, funcName, i, err)
+		// A * argument, as in COUNT(*), is represented by an IdentExpr.
+		var e Expr = &IdentExpr{Name: "*", Pos: p.pos()}
+		if tok != ASTERISK {
+			p.unscan()
+			var err error
+			if e, err = p.parseExpr(); err != nil {
+				return nil, fmt.Errorf(`error parsing %s argument %d: %v`, funcName, i, err)
+			}
+		}
+
+		// An identifier followed by = names an optional argument.
+		if ident, ok := e.(*IdentExpr); ok {
+			if tok, _ = p.scanIgnoreWhitespace(); tok == EQ {
+				val, err := p.parseExpr()
+				if err != nil {
+					return nil, fmt.Errorf(`error parsing %s argument %s: %v`, funcName, ident.Name, err)
+				}
+				e = &NamedArgExpr{Name: ident.Name, Val: val}
+			} else {
+				p.unscan()
+			}
 		}
 		args = append(args, e)
 
@@ -105,5 +374,5 @@ func (p *Parser) parseFuncCall() (Expr, error) {
 		return nil, fmt.Errorf(`expected PAREN_R in function %s, got EOF`, funcName)
 	}
 
-	return &FuncCallExpr{Name: funcName, Args: args}, nil
+	return &FuncCallExpr{Name: funcName, Args: args, Pos: pos}, nil
 }
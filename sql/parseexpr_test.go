@@ -46,6 +46,33 @@ func TestFuncCall(t *testing.T) {
 		log.Debugf("NumExpr: %s", f)
 	})
 
+	Convey("Test parsing parameters\n", t, func() {
+		p := NewParser(strings.NewReader(`FuncName(?, $3, :name, ?)`))
+		f, err := p.parseExpr()
+		So(err, ShouldBeNil)
+		So(f, ShouldResemble, &FuncCallExpr{Name: "FuncName", Args: []Expr{
+			&ParamExpr{Lit: "?", Index: 1},
+			&ParamExpr{Lit: "$3", Index: 3},
+			&ParamExpr{Lit: ":name", Name: "name"},
+			&ParamExpr{Lit: "?", Index: 2},
+		}})
+		log.Debugf("params: %s", f)
+
+		p = NewParser(strings.NewReader(`$0`))
+		_, err = p.parseExpr()
+		So(err, ShouldResemble, fmt.Errorf(`invalid parameter number in '$0'`))
+	})
+
+	Convey("Test quoting and unquoting strings\n", t, func() {
+		So(QuoteString(`plain`), ShouldEqual, `"plain"`)
+		So(QuoteString(`say "hi" \ bye`), ShouldEqual, `"say \"hi\" \\ bye"`)
+		So(QuoteString("two\nlines"), ShouldEqual, `"two\nlines"`)
+
+		So(StringExpr{Val: `"plain"`}.Unquoted(), ShouldEqual, `plain`)
+		So(StringExpr{Val: `'it\'s'`}.Unquoted(), ShouldEqual, `it's`)
+		So(StringExpr{Val: QuoteString("a \"b\"\n\\c")}.Unquoted(), ShouldEqual, "a \"b\"\n\\c")
+	})
+
 	Convey("Test parsing function call without args\n", t, func() {
 		p := NewParser(strings.NewReader(`FuncName()`))
 		f, err := p.parseExpr()
@@ -74,12 +101,80 @@ func TestFuncCall(t *testing.T) {
 		log.Debugf("cond: %s", f)
 	})
 
+	Convey("Test parsing function call with named arguments\n", t, func() {
+		p := NewParser(strings.NewReader(`MATCH(quote, 'puck', operator = 'and', boost=2, f = g(?))`))
+		f, err := p.parseFuncCall()
+		So(err, ShouldBeNil)
+		So(f, ShouldResemble, &FuncCallExpr{Name: "MATCH", Args: []Expr{
+			&IdentExpr{Name: "quote"},
+			&StringExpr{Val: `'puck'`},
+			&NamedArgExpr{Name: "operator", Val: &StringExpr{Val: `'and'`}},
+			&NamedArgExpr{Name: "boost", Val: &NumExpr{Val: 2}},
+			&NamedArgExpr{Name: "f", Val: &FuncCallExpr{Name: "g", Args: []Expr{&ParamExpr{Lit: "?", Index: 1}}}},
+		}})
+		So(f.String(), ShouldEqual, `MATCH(quote, 'puck', operator = 'and', boost = 2.000000, f = g(?))`)
+
+		p = NewParser(strings.NewReader(`MATCH(quote, boost = )`))
+		_, err = p.parseFuncCall()
+		So(err, ShouldResemble, fmt.Errorf(`error parsing MATCH argument boost: parseExpr() expected expression (string, number or function call), got PAREN_R`))
+	})
+
+	Convey("Test parsing intervals and date arithmetic\n", t, func() {
+		p := NewParser(strings.NewReader(`NOW() - INTERVAL 30 YEAR + interval '2' days`))
+		e, err := p.parseExpr()
+		So(err, ShouldBeNil)
+		So(e, ShouldResemble, &BinaryExpr{
+			Op: PLUS,
+			LHS: &BinaryExpr{
+				Op:  MINUS,
+				LHS: &FuncCallExpr{Name: "NOW", Args: []Expr{}},
+				RHS: &IntervalExpr{Val: 30, Unit: "YEAR"},
+			},
+			RHS: &IntervalExpr{Val: 2, Unit: "DAY"},
+		})
+		So(e.String(), ShouldEqual, `NOW() - INTERVAL 30 YEAR + INTERVAL 2 DAY`)
+
+		p = NewParser(strings.NewReader(`DATE_SUB(dob, INTERVAL 1.5 Hours)`))
+		e, err = p.parseExpr()
+		So(err, ShouldBeNil)
+		So(e, ShouldResemble, &FuncCallExpr{Name: "DATE_SUB", Args: []Expr{
+			&IdentExpr{Name: "dob"},
+			&IntervalExpr{Val: 1.5, Unit: "HOUR"},
+		}})
+
+		p = NewParser(strings.NewReader(`INTERVAL 3 fortnights`))
+		_, err = p.parseExpr()
+		So(err, ShouldResemble, fmt.Errorf(`expected INTERVAL unit, got "fortnights"`))
+
+		p = NewParser(strings.NewReader(`INTERVAL 'x' DAY`))
+		_, err = p.parseExpr()
+		So(err, ShouldResemble, fmt.Errorf(`invalid INTERVAL count "'x'"`))
+
+		p = NewParser(strings.NewReader(`INTERVAL DAY`))
+		_, err = p.parseExpr()
+		So(err, ShouldResemble, fmt.Errorf(`expected INTERVAL count, got "DAY"`))
+
+		p = NewParser(strings.NewReader(`NOW() - )`))
+		_, err = p.parseExpr()
+		So(err, ShouldResemble, fmt.Errorf(`error parsing operand of -: parseExpr() expected expression (string, number or function call), got PAREN_R`))
+	})
+
 	Convey("Test parsing function call with one string argument\n", t, func() {
 		p := NewParser(strings.NewReader(`FuncName 123`))
-		_, err := p.parseExpr()
+		_, err := p.parseFuncCall()
 		So(err, ShouldResemble, fmt.Errorf(`expected '(', got '123'`))
 	})
 
+	Convey("Test parsing an identifier\n", t, func() {
+		p := NewParser(strings.NewReader(`t1.goals 123`))
+		e, err := p.parseExpr()
+		So(err, ShouldBeNil)
+		So(e, ShouldResemble, &IdentExpr{Name: "t1.goals"})
+		e, err = p.parseExpr()
+		So(err, ShouldBeNil)
+		So(e, ShouldResemble, &NumExpr{Val: 123})
+	})
+
 	Convey("Test parsing function call with one string argument\n", t, func() {
 		p := NewParser(strings.NewReader(`FuncName(123`))
 		_, err := p.parseExpr()
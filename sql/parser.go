@@ -3,13 +3,20 @@ package sql
 import (
 	"fmt"
 	"io"
+	"math"
+	"strconv"
 
 //	log "github.com/cihub/seelog"
 )
 
+// Field is an element of a select or table list. The Expr of a field
+// computed by a function call, such as MAX(goals), holds the call and its
+// Name the SQL text of the call; Expr is nil for other fields.
 type Field struct {
-	Name string
-	Alias string
+	Name string  `json:"name"`
+	Alias string `json:"alias,omitempty"`
+	Expr Expr    `json:"expr,omitempty"`
+	Pos  Pos     `json:"-"`
 }
 
 func (f Field) String() string {
@@ -32,30 +39,142 @@ func (f Fields) String() string {
 	}
 }
 
-// SelectStatement represents a SQL SELECT statement.
+// OrderItem is an element of an ORDER BY clause.
+type OrderItem struct {
+	Expr Expr
+	Desc bool
+}
+
+func (o OrderItem) String() string {
+	if o.Desc {
+		return fmt.Sprintf("%s DESC", o.Expr)
+	}
+	return o.Expr.String()
+}
+
+// Join is a JOIN clause, which joins the rows of the tables before it with
+// those of Table for which the On condition holds. A LEFT [OUTER] JOIN also
+// keeps the rows without a match, which an INNER JOIN drops.
+type Join struct {
+	Left  bool
+	Table Field
+	On    Cond
+	Pos   Pos
+}
+
+func (j Join) String() string {
+	if j.Left {
+		return fmt.Sprintf("LEFT JOIN %s ON %s", j.Table, j.On)
+	}
+	return fmt.Sprintf("JOIN %s ON %s", j.Table, j.On)
+}
+
+// Union is a UNION clause, which adds the rows of Select to those of the
+// statements before it. A UNION drops duplicate rows, which a UNION ALL
+// keeps.
+type Union struct {
+	All    bool
+	Select *SelectStatement
+	Pos    Pos
+}
+
+func (u Union) String() string {
+	if u.All {
+		return fmt.Sprintf("UNION ALL %s", u.Select)
+	}
+	return fmt.Sprintf("UNION %s", u.Select)
+}
+
+// SelectStatement represents a SQL SELECT statement. Limit, when not nil,
+// is a NumExpr holding a non-negative integer or a ParamExpr.
+//
+// The TableList and Joins make up a left-deep join tree: the single table of
+// a statement with joins is joined with the Table of each Join in turn.
+//
+// The Unions likewise combine the rows of the statement with those of the
+// Select of each Union in turn. The OrderBy and Limit of a statement with
+// unions apply to the combined rows, and those of each Select are empty.
 type SelectStatement struct {
 	FieldList Fields
 	TableList Fields
+	Joins     []Join
 	WhereCond Cond
+	GroupBy   []Expr
+	Unions    []Union
+	OrderBy   []OrderItem
+	Limit     Expr
 }
 
 func (s SelectStatement) String() string {
 
+	joins := ""
+	for _, j := range s.Joins {
+		joins = fmt.Sprintf("%s %s", joins, j)
+	}
 	where := ""
 	if s.WhereCond != nil {
 		where = fmt.Sprintf(" WHERE %s", s.WhereCond)
 	}
-	return fmt.Sprintf("SELECT %s FROM %s%s", s.FieldList.String(), s.TableList.String(), where)
+	group := ""
+	for i, e := range s.GroupBy {
+		sep := ", "
+		if i == 0 {
+			sep = " GROUP BY "
+		}
+		group = fmt.Sprintf("%s%s%s", group, sep, e)
+	}
+	unions := ""
+	for _, u := range s.Unions {
+		unions = fmt.Sprintf("%s %s", unions, u)
+	}
+	order := ""
+	for i, o := range s.OrderBy {
+		sep := ", "
+		if i == 0 {
+			sep = " ORDER BY "
+		}
+		order = fmt.Sprintf("%s%s%s", order, sep, o)
+	}
+	limit := ""
+	if s.Limit != nil {
+		limit = fmt.Sprintf(" LIMIT %s", s.Limit)
+	}
+	return fmt.Sprintf("SELECT %s FROM %s%s%s%s%s%s%s", s.FieldList.String(), s.TableList.String(), joins, where, group, unions, order, limit)
+}
+
+// Tables returns the tables of the TableList followed by those of the Joins.
+func (s SelectStatement) Tables() Fields {
+
+	tables := append(Fields{}, s.TableList...)
+	for _, j := range s.Joins {
+		tables = append(tables, j.Table)
+	}
+	return tables
 }
 
+// Mode is a set of flags controlling optional parser behavior.
+type Mode uint
+
+const (
+	// RecordPositions records the source position of each field, table,
+	// comparison, identifier and function call in its Pos field, which is
+	// otherwise left zero.
+	RecordPositions Mode = 1 << iota
+)
+
 // Parser represents a parser.
 type Parser struct {
 	s   *Scanner
 	buf struct {
 		tok Token  // last read token
 		lit string // last read literal
+		pos Pos    // position of the last read token
 		n   int    // buffer size (max=1)
 	}
+	mode   Mode
+	nparam int // number of positional (?) parameters parsed so far
+	depth  int // number of enclosing statements of the subquery being parsed
+	union  bool // whether the statement being parsed is the Select of a Union
 }
 
 // NewParser returns a new instance of Parser.
@@ -63,8 +182,19 @@ func NewParser(r io.Reader) *Parser {
 	return &Parser{s: NewScanner(r)}
 }
 
+// NewParserMode returns a new instance of Parser with the specified mode.
+func NewParserMode(r io.Reader, mode Mode) *Parser {
+	return &Parser{s: NewScanner(r), mode: mode}
+}
+
 // Parse parses a SQL SELECT statement.
 func (p *Parser) Parse() (*SelectStatement, error) {
+	return p.parseSelect()
+}
+
+// parseSelect parses a SELECT statement, which is ended by EOF or, for a
+// subquery, by the PAREN_R closing it, which is left unscanned.
+func (p *Parser) parseSelect() (*SelectStatement, error) {
 	stmt := &SelectStatement{}
 
 	// First token should be a "SELECT" keyword.
@@ -72,7 +202,7 @@ func (p *Parser) Parse() (*SelectStatement, error) {
 		return nil, fmt.Errorf("found %q, expected SELECT", lit)
 	}
 
-	selFields, err := p.parseCommaDelimIdents()
+	selFields, err := p.parseFieldList(true)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing SELECT fields: %v", err)
 	}
@@ -89,26 +219,198 @@ func (p *Parser) Parse() (*SelectStatement, error) {
 	}
 	stmt.TableList = tables
 
-	// Next we should see the "WHERE" keyword.
 	tok, lit := p.scanIgnoreWhitespace()
+	for tok == JOIN || tok == INNER || tok == LEFT {
+		if len(stmt.TableList) > 1 {
+			return nil, fmt.Errorf("found %q, JOIN cannot follow a list of tables", lit)
+		}
+		join, err := p.parseJoin(tok)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing JOIN %d: %v", len(stmt.Joins)+1, err)
+		}
+		stmt.Joins = append(stmt.Joins, join)
+
+		tok, lit = p.scanIgnoreWhitespace()
+		if tok != JOIN && tok != INNER && tok != LEFT && tok != WHERE && !p.endsClause(FROM, tok) {
+			return nil, fmt.Errorf(`expected AND or OR, got "%s"`, lit)
+		}
+	}
+
+	// Next we should see the "WHERE" keyword.
 	if tok == WHERE {
 		stmt.WhereCond, err = p.parseCondTree()
 		if err != nil {
 			return nil, err
 		}
-	} else if tok != EOF {
+		if tok, lit = p.scanIgnoreWhitespace(); !p.endsClause(WHERE, tok) {
+			return nil, fmt.Errorf(`expected AND or OR, got "%s"`, lit)
+		}
+	} else if !p.endsClause(FROM, tok) {
 		return nil, fmt.Errorf("found %q, expected WHERE", lit)
 	}
 
+	if tok == GROUP {
+		stmt.GroupBy, err = p.parseGroupBy()
+		if err != nil {
+			return nil, err
+		}
+		if tok, lit = p.scanIgnoreWhitespace(); !p.endsClause(GROUP, tok) {
+			return nil, fmt.Errorf("found %q, expected ORDER", lit)
+		}
+	}
+
+	for tok == UNION && !p.union {
+		union, err := p.parseUnion()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing UNION %d: %v", len(stmt.Unions)+1, err)
+		}
+		stmt.Unions = append(stmt.Unions, union)
+		tok, lit = p.scanIgnoreWhitespace()
+	}
+	if len(stmt.Unions) > 0 {
+		// The ORDER BY and LIMIT ending the last Select apply to every row.
+		last := stmt.Unions[len(stmt.Unions)-1].Select
+		stmt.OrderBy, stmt.Limit = last.OrderBy, last.Limit
+		last.OrderBy, last.Limit = nil, nil
+	}
+
+	if tok == ORDER {
+		stmt.OrderBy, err = p.parseOrderBy()
+		if err != nil {
+			return nil, err
+		}
+		if tok, lit = p.scanIgnoreWhitespace(); !p.endsClause(ORDER, tok) {
+			return nil, fmt.Errorf("found %q, expected LIMIT", lit)
+		}
+	}
+
+	if tok == LIMIT {
+		stmt.Limit, err = p.parseLimit()
+		if err != nil {
+			return nil, err
+		}
+		if tok, lit = p.scanIgnoreWhitespace(); !p.endsClause(LIMIT, tok) {
+			return nil, fmt.Errorf("found %q, expected EOF", lit)
+		}
+	}
+
 	// Return the successfully parsed statement.
+	p.unscan()
 	return stmt, nil
 }
 
+// parseSubquery parses a parenthesized SELECT statement with the scanner
+// positioned just after the PAREN_L opening it.
+func (p *Parser) parseSubquery() (*SelectStatement, error) {
+
+	if tok, lit := p.scanIgnoreWhitespace(); tok != SELECT {
+		return nil, fmt.Errorf("found %q, expected SELECT in subquery", lit)
+	}
+	p.unscan()
+
+	union := p.union
+	p.depth++
+	p.union = false
+	stmt, err := p.parseSelect()
+	p.depth--
+	p.union = union
+	if err != nil {
+		return nil, err
+	}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != PAREN_R {
+		return nil, fmt.Errorf(`expected PAREN_R closing subquery, got "%s"`, lit)
+	}
+	return stmt, nil
+}
+
+// parseJoin parses a JOIN clause with the scanner positioned just after its
+// first keyword, tok: JOIN, INNER or LEFT.
+func (p *Parser) parseJoin(tok Token) (Join, error) {
+
+	join := Join{Left: tok == LEFT, Pos: p.pos()}
+	if tok != JOIN {
+		next, lit := p.scanIgnoreWhitespace()
+		if tok == LEFT && next == OUTER {
+			next, lit = p.scanIgnoreWhitespace()
+		}
+		if next != JOIN {
+			return join, fmt.Errorf("found %q, expected JOIN", lit)
+		}
+	}
+
+	tables, err := p.parseFieldList(false)
+	if err != nil {
+		return join, err
+	}
+	if len(tables) > 1 {
+		return join, fmt.Errorf("found %q, expected ON", ",")
+	}
+	join.Table = tables[0]
+
+	if tok, lit := p.scanIgnoreWhitespace(); tok != ON {
+		return join, fmt.Errorf("found %q, expected ON", lit)
+	}
+	if join.On, err = p.parseCondTree(); err != nil {
+		return join, err
+	}
+	return join, nil
+}
+
+// parseUnion parses a UNION clause with the scanner positioned just after
+// its UNION keyword. The Select of the union is ended by the next UNION,
+// which is left unscanned, along with the end of the statement.
+func (p *Parser) parseUnion() (Union, error) {
+
+	union := Union{Pos: p.pos()}
+	if tok, _ := p.scanIgnoreWhitespace(); tok == ALL {
+		union.All = true
+	} else {
+		p.unscan()
+	}
+
+	p.union = true
+	stmt, err := p.parseSelect()
+	p.union = false
+	if err != nil {
+		return union, err
+	}
+	union.Select = stmt
+	return union, nil
+}
+
+// clauseOrder lists the optional clauses following FROM in the order in
+// which they must appear.
+var clauseOrder = []Token{WHERE, GROUP, UNION, ORDER, LIMIT}
+
+// endsClause returns true if tok may follow the clause introduced by kw,
+// i.e. it ends the statement or introduces one of the clauses after kw.
+func (p *Parser) endsClause(kw, tok Token) bool {
+
+	if tok == EOF || tok == PAREN_R && p.depth > 0 {
+		return true
+	}
+	after := kw == FROM
+	for _, c := range clauseOrder {
+		if after && tok == c {
+			return true
+		}
+		after = after || c == kw
+	}
+	return false
+}
+
 // parseCommaDelimIdents assumes that the scanner position is at the head
 // of comma-delimited list of fields each possibly followed by an alias.
 // The list is parsed int a Fields and returned along with any error that
 // arises during parsing.
 func (p *Parser) parseCommaDelimIdents() (fields Fields, err error) {
+	return p.parseFieldList(false)
+}
+
+// parseFieldList parses a comma-delimited list of fields, each possibly
+// followed by an alias with or without AS. If funcs is true, the fields may
+// also be function calls such as COUNT(*), whose Name is their SQL text.
+func (p *Parser) parseFieldList(funcs bool) (fields Fields, err error) {
 
 	for {
 
@@ -117,9 +419,22 @@ func (p *Parser) parseCommaDelimIdents() (fields Fields, err error) {
 			return nil, fmt.Errorf("found %q, expected field", lit)
 		}
 
-		f := Field{ Name: lit }
+		f := Field{ Name: lit, Pos: p.pos() }
 
 		tok, lit = p.scanIgnoreWhitespace()
+		if tok == PAREN_L && funcs && f.Name != "*" {
+			if f.Expr, err = p.parseFuncArgs(f.Name, f.Pos); err != nil {
+				return nil, err
+			}
+			f.Name = formatExpr(f.Expr)
+			tok, lit = p.scanIgnoreWhitespace()
+		}
+
+		if tok == AS {
+			if tok, lit = p.scanIgnoreWhitespace(); tok != IDENT {
+				return nil, fmt.Errorf("found %q, expected alias", lit)
+			}
+		}
 		if tok == IDENT {
 			f.Alias = lit
 			tok, lit = p.scanIgnoreWhitespace()
@@ -136,6 +451,83 @@ func (p *Parser) parseCommaDelimIdents() (fields Fields, err error) {
 	return
 }
 
+// parseGroupBy parses the expressions of a GROUP BY clause with the scanner
+// positioned just after GROUP.
+func (p *Parser) parseGroupBy() (exprs []Expr, err error) {
+
+	if tok, lit := p.scanIgnoreWhitespace(); tok != BY {
+		return nil, fmt.Errorf("found %q, expected BY", lit)
+	}
+
+	for {
+
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing GROUP BY item %d: %v", len(exprs)+1, err)
+		}
+		exprs = append(exprs, e)
+
+		if tok, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+
+	return
+}
+
+// parseOrderBy parses the expressions of an ORDER BY clause, each optionally
+// followed by ASC or DESC, with the scanner positioned just after ORDER.
+func (p *Parser) parseOrderBy() (items []OrderItem, err error) {
+
+	if tok, lit := p.scanIgnoreWhitespace(); tok != BY {
+		return nil, fmt.Errorf("found %q, expected BY", lit)
+	}
+
+	for {
+
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing ORDER BY item %d: %v", len(items)+1, err)
+		}
+		item := OrderItem{Expr: e}
+
+		tok, _ := p.scanIgnoreWhitespace()
+		if tok == ASC || tok == DESC {
+			item.Desc = tok == DESC
+			tok, _ = p.scanIgnoreWhitespace()
+		}
+
+		items = append(items, item)
+
+		if tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+
+	return
+}
+
+// parseLimit parses the row count of a LIMIT clause, which is either a
+// non-negative integer or a parameter.
+func (p *Parser) parseLimit() (Expr, error) {
+
+	tok, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case NUMBER:
+		n, err := strconv.ParseFloat(lit, 64)
+		if err != nil || n < 0 || n != math.Trunc(n) {
+			return nil, fmt.Errorf("found %q, expected non-negative integer LIMIT", lit)
+		}
+		return &NumExpr{Val: n}, nil
+	case PARAM:
+		return p.parseParam(lit)
+	}
+
+	return nil, fmt.Errorf("found %q, expected LIMIT count", lit)
+}
+
 // scan returns the next token from the underlying scanner.
 // If a token has been unscanned then read that instead.
 func (p *Parser) scan() (tok Token, lit string) {
@@ -149,7 +541,7 @@ func (p *Parser) scan() (tok Token, lit string) {
 	tok, lit = p.s.Scan()
 
 	// Save it to the buffer in case we unscan later.
-	p.buf.tok, p.buf.lit = tok, lit
+	p.buf.tok, p.buf.lit, p.buf.pos = tok, lit, p.s.Pos()
 
 	return
 }
@@ -163,5 +555,14 @@ func (p *Parser) scanIgnoreWhitespace() (tok Token, lit string) {
 	return
 }
 
+// pos returns the position of the last read token if positions are being
+// recorded, and the zero Pos otherwise.
+func (p *Parser) pos() Pos {
+	if p.mode&RecordPositions == 0 {
+		return Pos{}
+	}
+	return p.buf.pos
+}
+
 // unscan pushes the previously read token back onto the buffer.
 func (p *Parser) unscan() { p.buf.n = 1 }
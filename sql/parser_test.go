@@ -69,6 +69,251 @@ func TestParser_ParseSimpleSelect(t *testing.T) {
 		})
 	})
 
+	Convey("Statement with ORDER BY and LIMIT\n", t, func() {
+		stmt, err := testParse(`SELECT name n, goals FROM oilers WHERE pos = 'C' ORDER BY goals DESC, name ASC, F(dob) LIMIT 10`)
+		So(err, ShouldBeNil)
+		log.Debug("SQL: ", stmt)
+		So(stmt, ShouldResemble, &SelectStatement{
+			FieldList: Fields{Field{Name: "name", Alias: "n"}, Field{Name: "goals"}},
+			TableList: Fields{Field{Name: "oilers"}},
+			WhereCond: &CondComp{Ident: "pos", CondOp: EQ, Val: &StringExpr{Val: `'C'`}},
+			OrderBy: []OrderItem{
+				{Expr: &IdentExpr{Name: "goals"}, Desc: true},
+				{Expr: &IdentExpr{Name: "name"}},
+				{Expr: &FuncCallExpr{Name: "F", Args: []Expr{&IdentExpr{Name: "dob"}}}}},
+			Limit: &NumExpr{Val: 10},
+		})
+		So(stmt.String(), ShouldEqual, `SELECT name n, goals FROM oilers WHERE pos EQ 'C' ORDER BY goals DESC, name, F(dob) LIMIT 10.000000`)
+
+		stmt, err = testParse(`SELECT * FROM oilers LIMIT ?`)
+		So(err, ShouldBeNil)
+		So(stmt.Limit, ShouldResemble, &ParamExpr{Lit: "?", Index: 1})
+
+		stmt, err = testParse(`SELECT * FROM oilers order by jersey`)
+		So(err, ShouldBeNil)
+		So(stmt.OrderBy, ShouldResemble, []OrderItem{{Expr: &IdentExpr{Name: "jersey"}}})
+		So(stmt.Limit, ShouldBeNil)
+	})
+
+	Convey("Statement with aggregates and GROUP BY\n", t, func() {
+		stmt, err := testParse(`SELECT team AS t, COUNT(*) n, MAX( goals ) AS most FROM oilers WHERE pos = 'C' GROUP BY team, pos LIMIT 5`)
+		So(err, ShouldBeNil)
+		log.Debug("SQL: ", stmt)
+		So(stmt, ShouldResemble, &SelectStatement{
+			FieldList: Fields{
+				Field{Name: "team", Alias: "t"},
+				Field{Name: "COUNT(*)", Alias: "n", Expr: &FuncCallExpr{Name: "COUNT", Args: []Expr{&IdentExpr{Name: "*"}}}},
+				Field{Name: "MAX(goals)", Alias: "most", Expr: &FuncCallExpr{Name: "MAX", Args: []Expr{&IdentExpr{Name: "goals"}}}}},
+			TableList: Fields{Field{Name: "oilers"}},
+			WhereCond: &CondComp{Ident: "pos", CondOp: EQ, Val: &StringExpr{Val: `'C'`}},
+			GroupBy:   []Expr{&IdentExpr{Name: "team"}, &IdentExpr{Name: "pos"}},
+			Limit:     &NumExpr{Val: 5},
+		})
+		So(stmt.String(), ShouldEqual, `SELECT team t, COUNT(*) n, MAX(goals) most FROM oilers WHERE pos EQ 'C' GROUP BY team, pos LIMIT 5.000000`)
+
+		_, err = testParse(`SELECT a AS FROM t`)
+		So(errstring(err), ShouldEqual, `error parsing SELECT fields: found "FROM", expected alias`)
+
+		_, err = testParse(`SELECT a FROM F(t)`)
+		So(errstring(err), ShouldEqual, `found "(", expected WHERE`)
+
+		_, err = testParse(`SELECT a FROM t GROUP a`)
+		So(errstring(err), ShouldEqual, `found "a", expected BY`)
+
+		_, err = testParse(`SELECT a FROM t GROUP BY a WHERE a = 1`)
+		So(errstring(err), ShouldEqual, `found "WHERE", expected ORDER`)
+
+		_, err = testParse(`SELECT a FROM t ORDER BY a GROUP BY a`)
+		So(errstring(err), ShouldEqual, `found "GROUP", expected LIMIT`)
+	})
+
+	Convey("Statement with EXISTS subqueries\n", t, func() {
+		stmt, err := testParse(`SELECT name FROM oilers WHERE EXISTS (SELECT * FROM oilers.linemates WHERE name = ? AND NOT EXISTS ` +
+			`(SELECT * FROM oilers.linemates.seasons)) AND pos = ?`)
+		So(err, ShouldBeNil)
+		So(stmt.WhereCond, ShouldResemble, &CondConj{
+			Op: AND,
+			Left: &CondExists{Select: &SelectStatement{
+				FieldList: Fields{Field{Name: "*"}},
+				TableList: Fields{Field{Name: "oilers.linemates"}},
+				WhereCond: &CondConj{
+					Op:   AND,
+					Left: &CondComp{Ident: "name", CondOp: EQ, Val: &ParamExpr{Lit: "?", Index: 1}},
+					Right: &CondExists{Not: true, Select: &SelectStatement{
+						FieldList: Fields{Field{Name: "*"}},
+						TableList: Fields{Field{Name: "oilers.linemates.seasons"}}}}}}},
+			Right: &CondComp{Ident: "pos", CondOp: EQ, Val: &ParamExpr{Lit: "?", Index: 2}}})
+		So(stmt.WhereCond.String(), ShouldEqual, `(EXISTS (SELECT * FROM oilers.linemates WHERE (name EQ ? AND `+
+			`NOT EXISTS (SELECT * FROM oilers.linemates.seasons))) AND pos EQ ?)`)
+
+		for _, c := range []struct{ q, err string }{
+			{`SELECT a FROM t WHERE EXISTS SELECT * FROM u`, `expected PAREN_L after EXISTS, got "SELECT"`},
+			{`SELECT a FROM t WHERE NOT a = 1`, `expected EXISTS, got "a"`},
+			{`SELECT a FROM t WHERE EXISTS (a = 1)`, `error parsing EXISTS subquery: found "a", expected SELECT in subquery`},
+			{`SELECT a FROM t WHERE EXISTS (SELECT * FROM u`, `error parsing EXISTS subquery: expected PAREN_R closing subquery, got "EOF"`},
+			{`SELECT a FROM t WHERE EXISTS (SELECT * FROM u WHERE b = 1 c)`, `error parsing EXISTS subquery: expected AND or OR, got "c"`},
+			{`SELECT a FROM t )`, `found ")", expected WHERE`},
+		} {
+			_, err := testParse(c.q)
+			So(errstring(err), ShouldEqual, c.err)
+		}
+	})
+
+	Convey("Statement with IN subqueries\n", t, func() {
+		stmt, err := testParse(`SELECT name FROM oilers WHERE team IN (SELECT code FROM teams WHERE conf = ? ` +
+			`AND city NOT IN (SELECT city FROM arenas)) AND pos = ?`)
+		So(err, ShouldBeNil)
+		So(stmt.WhereCond, ShouldResemble, &CondConj{
+			Op: AND,
+			Left: &CondIn{Ident: "team", Vals: []Expr{&SubqueryExpr{Select: &SelectStatement{
+				FieldList: Fields{Field{Name: "code"}},
+				TableList: Fields{Field{Name: "teams"}},
+				WhereCond: &CondConj{
+					Op:   AND,
+					Left: &CondComp{Ident: "conf", CondOp: EQ, Val: &ParamExpr{Lit: "?", Index: 1}},
+					Right: &CondIn{Ident: "city", Not: true, Vals: []Expr{&SubqueryExpr{Select: &SelectStatement{
+						FieldList: Fields{Field{Name: "city"}},
+						TableList: Fields{Field{Name: "arenas"}}}}}}}}}}},
+			Right: &CondComp{Ident: "pos", CondOp: EQ, Val: &ParamExpr{Lit: "?", Index: 2}}})
+		So(stmt.WhereCond.String(), ShouldEqual, `(team IN (SELECT code FROM teams WHERE (conf EQ ? AND `+
+			`city NOT IN (SELECT city FROM arenas))) AND pos EQ ?)`)
+
+		for _, c := range []struct{ q, err string }{
+			{`SELECT a FROM t WHERE a IN (SELECT b FROM u`, `error parsing IN subquery of a: expected PAREN_R closing subquery, got "EOF"`},
+			{`SELECT a FROM t WHERE a IN (SELECT b FROM u WHERE c = 1 d)`, `error parsing IN subquery of a: expected AND or OR, got "d"`},
+			{`SELECT a FROM t WHERE a IN (1, SELECT b FROM u)`, `error parsing IN list of a: parseExpr() expected expression (string, number or function call), got SELECT`},
+		} {
+			_, err := testParse(c.q)
+			So(errstring(err), ShouldEqual, c.err)
+		}
+	})
+
+	Convey("Statement with JOINs\n", t, func() {
+		stmt, err := testParse(`SELECT p.name, t.city FROM oilers p JOIN teams t ON p.team = t.code ` +
+			`LEFT OUTER JOIN arenas a ON a.team = t.code AND a.capacity > 10000 WHERE p.goals > 50`)
+		So(err, ShouldBeNil)
+		So(stmt.TableList, ShouldResemble, Fields{Field{Name: "oilers", Alias: "p"}})
+		So(stmt.Joins, ShouldResemble, []Join{
+			{Table: Field{Name: "teams", Alias: "t"},
+				On: &CondComp{Ident: "p.team", CondOp: EQ, Val: &IdentExpr{Name: "t.code"}}},
+			{Left: true, Table: Field{Name: "arenas", Alias: "a"},
+				On: &CondConj{
+					Op:    AND,
+					Left:  &CondComp{Ident: "a.team", CondOp: EQ, Val: &IdentExpr{Name: "t.code"}},
+					Right: &CondComp{Ident: "a.capacity", CondOp: GT, Val: &NumExpr{Val: 10000}}}}})
+		So(stmt.WhereCond, ShouldResemble, &CondComp{Ident: "p.goals", CondOp: GT, Val: &NumExpr{Val: 50}})
+		So(stmt.Tables(), ShouldResemble, Fields{{Name: "oilers", Alias: "p"}, {Name: "teams", Alias: "t"}, {Name: "arenas", Alias: "a"}})
+		So(stmt.String(), ShouldEqual, `SELECT p.name, t.city FROM oilers p JOIN teams t ON p.team EQ t.code `+
+			`LEFT JOIN arenas a ON (a.team EQ t.code AND a.capacity GT 10000.000000) WHERE p.goals GT 50.000000`)
+
+		stmt, err = testParse(`SELECT * FROM oilers INNER JOIN teams ON oilers.team = teams.code ORDER BY oilers.name`)
+		So(err, ShouldBeNil)
+		So(stmt.Joins, ShouldHaveLength, 1)
+		So(stmt.Joins[0].Left, ShouldBeFalse)
+		So(stmt.OrderBy, ShouldHaveLength, 1)
+
+		for _, c := range []struct{ q, err string }{
+			{`SELECT * FROM a, b JOIN c ON a.x = c.x`, `found "JOIN", JOIN cannot follow a list of tables`},
+			{`SELECT * FROM a LEFT c ON a.x = c.x`, `error parsing JOIN 1: found "c", expected JOIN`},
+			{`SELECT * FROM a INNER OUTER JOIN c ON a.x = c.x`, `error parsing JOIN 1: found "OUTER", expected JOIN`},
+			{`SELECT * FROM a JOIN c WHERE a.x = c.x`, `error parsing JOIN 1: found "WHERE", expected ON`},
+			{`SELECT * FROM a JOIN c, d ON a.x = c.x`, `error parsing JOIN 1: found ",", expected ON`},
+			{`SELECT * FROM a JOIN ON a.x = c.x`, `error parsing JOIN 1: found "ON", expected field`},
+			{`SELECT * FROM a JOIN c ON a.x = c.x d`, `expected AND or OR, got "d"`},
+		} {
+			_, err := testParse(c.q)
+			So(errstring(err), ShouldEqual, c.err)
+		}
+	})
+
+	Convey("Statement with UNIONs\n", t, func() {
+		stmt, err := testParse(`SELECT name FROM oilers WHERE pos = 'C' UNION SELECT name FROM flames WHERE pos = 'C' ` +
+			`UNION ALL SELECT name FROM jets ORDER BY name DESC LIMIT 10`)
+		So(err, ShouldBeNil)
+		So(stmt.TableList, ShouldResemble, Fields{Field{Name: "oilers"}})
+		So(stmt.WhereCond, ShouldResemble, &CondComp{Ident: "pos", CondOp: EQ, Val: &StringExpr{Val: "'C'"}})
+		So(stmt.Unions, ShouldResemble, []Union{
+			{Select: &SelectStatement{
+				FieldList: Fields{Field{Name: "name"}},
+				TableList: Fields{Field{Name: "flames"}},
+				WhereCond: &CondComp{Ident: "pos", CondOp: EQ, Val: &StringExpr{Val: "'C'"}}}},
+			{All: true, Select: &SelectStatement{
+				FieldList: Fields{Field{Name: "name"}},
+				TableList: Fields{Field{Name: "jets"}}}}})
+		So(stmt.OrderBy, ShouldResemble, []OrderItem{{Expr: &IdentExpr{Name: "name"}, Desc: true}})
+		So(stmt.Limit, ShouldResemble, &NumExpr{Val: 10})
+		So(stmt.String(), ShouldEqual, `SELECT name FROM oilers WHERE pos EQ 'C' UNION SELECT name FROM flames WHERE pos EQ 'C' `+
+			`UNION ALL SELECT name FROM jets ORDER BY name DESC LIMIT 10.000000`)
+
+		stmt, err = testParse(`SELECT name FROM oilers WHERE team IN (SELECT code FROM teams UNION SELECT code FROM champions) ` +
+			`UNION SELECT team FROM flames GROUP BY team`)
+		So(err, ShouldBeNil)
+		So(stmt.Unions, ShouldHaveLength, 1)
+		So(stmt.Unions[0].Select.GroupBy, ShouldResemble, []Expr{&IdentExpr{Name: "team"}})
+		sub, _ := stmt.WhereCond.(*CondIn).Vals[0].(*SubqueryExpr)
+		So(sub.Select.Unions, ShouldHaveLength, 1)
+		So(sub.Select.Unions[0].Select.TableList, ShouldResemble, Fields{Field{Name: "champions"}})
+
+		for _, c := range []struct{ q, err string }{
+			{`SELECT a FROM t UNION`, `error parsing UNION 1: found "EOF", expected SELECT`},
+			{`SELECT a FROM t UNION ALL a FROM u`, `error parsing UNION 1: found "a", expected SELECT`},
+			{`SELECT a FROM t ORDER BY a UNION SELECT b FROM u`, `found "UNION", expected LIMIT`},
+			{`SELECT a FROM t UNION SELECT b FROM u LIMIT 1 UNION SELECT c FROM v`, `error parsing UNION 1: found "UNION", expected EOF`},
+			{`SELECT a FROM t UNION SELECT b FROM u UNION SELECT c FROM v WHERE c = 1 d`, `error parsing UNION 2: expected AND or OR, got "d"`},
+		} {
+			_, err := testParse(c.q)
+			So(errstring(err), ShouldEqual, c.err)
+		}
+	})
+
+	Convey("Statement with recorded positions\n", t, func() {
+		stmt, err := NewParserMode(strings.NewReader("SELECT name n, MAX(goals)\nFROM oilers\n"+
+			"WHERE pos IN ('C') AND name LIKE 'W%'\nGROUP BY name ORDER BY n"), RecordPositions).Parse()
+		So(err, ShouldBeNil)
+		So(stmt.FieldList[0].Pos, ShouldResemble, Pos{7, 1, 8})
+		So(stmt.FieldList[1].Pos, ShouldResemble, Pos{15, 1, 16})
+		So(stmt.FieldList[1].Expr.(*FuncCallExpr).Pos, ShouldResemble, Pos{15, 1, 16})
+		So(stmt.FieldList[1].Expr.(*FuncCallExpr).Args[0].(*IdentExpr).Pos, ShouldResemble, Pos{19, 1, 20})
+		So(stmt.TableList[0].Pos, ShouldResemble, Pos{31, 2, 6})
+		conj := stmt.WhereCond.(*CondConj)
+		So(conj.Left.(*CondIn).Pos, ShouldResemble, Pos{44, 3, 7})
+		So(conj.Right.(*CondComp).Pos, ShouldResemble, Pos{61, 3, 24})
+		So(stmt.GroupBy[0].(*IdentExpr).Pos, ShouldResemble, Pos{85, 4, 10})
+		So(stmt.OrderBy[0].Expr.(*IdentExpr).Pos, ShouldResemble, Pos{99, 4, 24})
+
+		stmt, err = NewParserMode(strings.NewReader(`SELECT COUNT(*) FROM t`), RecordPositions).Parse()
+		So(err, ShouldBeNil)
+		So(stmt.FieldList[0].Expr.(*FuncCallExpr).Args[0].(*IdentExpr).Pos, ShouldResemble, Pos{13, 1, 14})
+
+		stmt, err = testParse(`SELECT name FROM oilers WHERE pos = 'C'`)
+		So(err, ShouldBeNil)
+		So(stmt.FieldList[0].Pos.IsValid(), ShouldBeFalse)
+		So(stmt.WhereCond.(*CondComp).Pos, ShouldResemble, Pos{})
+	})
+
+	Convey("Invalid ORDER BY and LIMIT clauses\n", t, func() {
+		_, err := testParse(`SELECT * FROM oilers ORDER goals`)
+		So(errstring(err), ShouldEqual, `found "goals", expected BY`)
+
+		_, err = testParse(`SELECT * FROM oilers ORDER BY goals name`)
+		So(errstring(err), ShouldEqual, `found "name", expected LIMIT`)
+
+		_, err = testParse(`SELECT * FROM oilers ORDER BY`)
+		So(errstring(err), ShouldEqual, `error parsing ORDER BY item 1: parseExpr() expected expression (string, number or function call), got EOF`)
+
+		_, err = testParse(`SELECT * FROM oilers LIMIT 2.5`)
+		So(errstring(err), ShouldEqual, `found "2.5", expected non-negative integer LIMIT`)
+
+		_, err = testParse(`SELECT * FROM oilers LIMIT -1`)
+		So(errstring(err), ShouldEqual, `found "-1", expected non-negative integer LIMIT`)
+
+		_, err = testParse(`SELECT * FROM oilers LIMIT name`)
+		So(errstring(err), ShouldEqual, `found "name", expected LIMIT count`)
+
+		_, err = testParse(`SELECT * FROM oilers LIMIT 5 ORDER BY name`)
+		So(errstring(err), ShouldEqual, `found "ORDER", expected EOF`)
+	})
+
 	Convey("Expected SELECT", t, func() {
 		_, err := testParse(`foo`)
 		So(errstring(err), ShouldEqual, `found "foo", expected SELECT`)
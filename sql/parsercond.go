@@ -2,23 +2,90 @@ package sql
 
 import (
 	"fmt"
+	"strings"
 )
 
+// Cond is implemented by every condition node, i.e. anything that can
+// appear in a WHERE clause.
 type Cond interface {
-	String() string
+	Node
+	cond()
 }
 
+func (*CondComp) cond() {}
+func (*CondConj) cond() {}
+func (*CondIn) cond()   {}
+func (*CondFunc) cond() {}
+func (*CondExists) cond() {}
+
 // CondComp represents a single comparison, e.g. f = 'bucky'
 type CondComp struct {
 	Ident string
-	CondOp Token  // e.g. =, <=
+	CondOp Token  // e.g. =, <=, LIKE
 	Val Expr
+	Pos Pos
 }
 
 func (c CondComp) String() string {
 	return fmt.Sprintf("%s %s %s", c.Ident, c.CondOp, c.Val)
 }
 
+// CondIn represents a list membership test, e.g. pos IN ('C', 'LW'), which is
+// negated when Not is set, e.g. pos NOT IN ('G').
+type CondIn struct {
+	Ident string
+	Not bool
+	Vals []Expr
+	Pos Pos
+}
+
+func (c CondIn) String() string {
+
+	vals := make([]string, len(c.Vals))
+	for i, v := range c.Vals {
+		vals[i] = v.String()
+	}
+
+	not := ""
+	if c.Not {
+		not = " NOT"
+	}
+	return fmt.Sprintf("%s%s IN (%s)", c.Ident, not, strings.Join(vals, ", "))
+}
+
+// CondFunc represents a boolean-valued function call used as a condition,
+// e.g. MATCH(quote, 'puck'), or a comparison of the value of a function call,
+// e.g. ST_DISTANCE(location, POINT(-113.49, 53.54)) < '10km', which has a
+// CondOp and Val. CondOp is ILLEGAL for a boolean-valued call.
+type CondFunc struct {
+	Call *FuncCallExpr
+	CondOp Token
+	Val Expr
+}
+
+func (c CondFunc) String() string {
+	if c.CondOp == ILLEGAL {
+		return c.Call.String()
+	}
+	return fmt.Sprintf("%s %s %s", c.Call, c.CondOp, c.Val)
+}
+
+// CondExists represents a subquery test, e.g.
+//   EXISTS (SELECT * FROM oilers.linemates WHERE name = 'Kurri')
+// which is negated when Not is set.
+type CondExists struct {
+	Not bool
+	Select *SelectStatement
+	Pos Pos
+}
+
+func (c CondExists) String() string {
+	if c.Not {
+		return fmt.Sprintf("NOT EXISTS (%s)", c.Select)
+	}
+	return fmt.Sprintf("EXISTS (%s)", c.Select)
+}
+
 // CondConj represents a single level of ANDed or ORed statements,
 // e.g. f1 = "v1" AND myNum >= 12.34 AND (f2 != "v2" OR id = 12)
 // There is an AND node with two Conds and a single Node, which is
@@ -36,65 +103,105 @@ func (c CondConj) String() string {
 // parseCondTree assumes that the scanner is in position to parse a potentially
 // compound boolean expression, e.g.
 //   t1.field1 = "val1" AND (t2.field1 <= -12.34 OR t1.field2 != "val2")
+// AND binds more tightly than OR and both associate to the right, so that
+//   a = 1 AND b = 2 OR c = 3 AND d = 4
+// is parsed as (a = 1 AND b = 2) OR (c = 3 AND d = 4). Parsing stops at the
+// first token that cannot continue the expression, which is left unscanned.
 // If parsing is successful, a populated Cond tree structure representing the
 // parsed expression is returned, otherwise error.
 func (p *Parser) parseCondTree() (Cond, error) {
+	return p.parseCondConj(OR)
+}
+
+// parseCondConj parses a chain of conditions joined by the specified
+// conjunction, each of which binds more tightly than op.
+func (p *Parser) parseCondConj(op Token) (Cond, error) {
 
-	var left, right Cond
+	var left Cond
 	var err error
+	if op == OR {
+		left, err = p.parseCondConj(AND)
+	} else {
+		left, err = p.parseCondPrimary()
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	tok, lit := p.scanIgnoreWhitespace()
-	if tok == PAREN_L {
-		left, err = p.parseCondTree()
-		if err != nil {
-			return nil, err
-		}
-	} else if tok == IDENT {
+	if tok, _ := p.scanIgnoreWhitespace(); tok != op {
 		p.unscan()
-		left, err = p.parseCondComp()
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		return nil, fmt.Errorf(`expeected PAREN_L or IDENT, got "%s"`, lit)
+		return left, nil
 	}
 
-	tok, lit = p.scanIgnoreWhitespace()
-	if tok == AND || tok == OR {
+	right, err := p.parseCondConj(op)
+	if err != nil {
+		return nil, err
+	}
 
-		condConj := &CondConj{Op: tok, Left: left}
-		right, err = p.parseCondTree()
+	return &CondConj{Op: op, Left: left, Right: right}, nil
+}
+
+// parseCondPrimary parses either a parenthesized condition, an EXISTS test
+// or a single comparison.
+func (p *Parser) parseCondPrimary() (Cond, error) {
+
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok == NOT || tok == EXISTS {
+		p.unscan()
+		return p.parseCondExists()
+	} else if tok == PAREN_L {
+		cond, err := p.parseCondTree()
 		if err != nil {
 			return nil, err
 		}
-		condConj.Right = right
-
 		if tok, lit = p.scanIgnoreWhitespace(); tok != PAREN_R {
-			p.unscan()
+			return nil, fmt.Errorf(`expected PAREN_R, got "%s"`, lit)
 		}
-
-		return condConj, nil
-	} else if tok == PAREN_R {
-		return left, nil
-	} else if tok != EOF {
-		return nil, fmt.Errorf(`expected AND or OR, got "%s"`, lit)
+		return cond, nil
+	} else if tok == IDENT {
+		p.unscan()
+		return p.parseCondComp()
 	}
 
-	return left, nil
+	return nil, fmt.Errorf(`expeected PAREN_L or IDENT, got "%s"`, lit)
 }
 
 // parseCondComp assumes that the scanner is in the position to parse a condition
 // expression, e.g. t1.field1 = "stringval". If parsing is successful, a populated
-// CondComp structure is returned, otherwise an error.
-func (p *Parser) parseCondComp() (*CondComp, error) {
+// CondComp structure, a CondIn for an IN list or a CondFunc for a function call
+// or a comparison of one is returned, otherwise an error.
+func (p *Parser) parseCondComp() (Cond, error) {
 
 	tok, ident := p.scanIgnoreWhitespace()
 	if tok != IDENT {
 		return nil, fmt.Errorf(`expected IDENT, got "%s"`, ident)
 	}
+	pos := p.pos()
 
 	op, lit := p.scanIgnoreWhitespace()
-	if !isOperator(op) {
+	if op == PAREN_L {
+		call, err := p.parseFuncArgs(ident, pos)
+		if err != nil {
+			return nil, err
+		}
+		cond := &CondFunc{Call: call.(*FuncCallExpr)}
+		if op, _ := p.scanIgnoreWhitespace(); isOperator(op) {
+			cond.CondOp = op
+			if cond.Val, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		} else {
+			p.unscan()
+		}
+		return cond, nil
+	} else if op == NOT {
+		if op, lit = p.scanIgnoreWhitespace(); op != IN {
+			return nil, fmt.Errorf(`expected IN after NOT, got "%s"`, lit)
+		}
+		return p.parseCondIn(ident, true, pos)
+	} else if op == IN {
+		return p.parseCondIn(ident, false, pos)
+	} else if !isOperator(op) {
 		return nil, fmt.Errorf(`expected operator, got "%s"`, lit)
 	}
 
@@ -103,9 +210,75 @@ func (p *Parser) parseCondComp() (*CondComp, error) {
 		return nil, err
 	}
 
-	return &CondComp{Ident: ident, CondOp: op, Val: expr}, nil
+	return &CondComp{Ident: ident, CondOp: op, Val: expr, Pos: pos}, nil
+}
+
+// parseCondExists parses an EXISTS test of a parenthesized subquery, which
+// may be preceded by NOT.
+func (p *Parser) parseCondExists() (*CondExists, error) {
+
+	cond := &CondExists{}
+	tok, lit := p.scanIgnoreWhitespace()
+	cond.Pos = p.pos()
+	if tok == NOT {
+		cond.Not = true
+		tok, lit = p.scanIgnoreWhitespace()
+	}
+	if tok != EXISTS {
+		return nil, fmt.Errorf(`expected EXISTS, got "%s"`, lit)
+	}
+	if tok, lit = p.scanIgnoreWhitespace(); tok != PAREN_L {
+		return nil, fmt.Errorf(`expected PAREN_L after EXISTS, got "%s"`, lit)
+	}
+
+	var err error
+	if cond.Select, err = p.parseSubquery(); err != nil {
+		return nil, fmt.Errorf("error parsing EXISTS subquery: %v", err)
+	}
+	return cond, nil
+}
+
+// parseCondIn assumes that the scanner is positioned after the IN keyword
+// and parses the parenthesized, comma-delimited list of values that follows,
+// or the subquery supplying them.
+// pos is the position of the tested identifier.
+func (p *Parser) parseCondIn(ident string, not bool, pos Pos) (*CondIn, error) {
+
+	if tok, lit := p.scanIgnoreWhitespace(); tok != PAREN_L {
+		return nil, fmt.Errorf(`expected PAREN_L after IN, got "%s"`, lit)
+	}
+
+	in := &CondIn{Ident: ident, Not: not, Pos: pos}
+	if tok, _ := p.scanIgnoreWhitespace(); tok == SELECT {
+		sub := &SubqueryExpr{Pos: p.pos()}
+		p.unscan()
+		var err error
+		if sub.Select, err = p.parseSubquery(); err != nil {
+			return nil, fmt.Errorf(`error parsing IN subquery of %s: %v`, ident, err)
+		}
+		in.Vals = []Expr{sub}
+		return in, nil
+	}
+	p.unscan()
+
+	for {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, fmt.Errorf(`error parsing IN list of %s: %v`, ident, err)
+		}
+		in.Vals = append(in.Vals, e)
+
+		tok, lit := p.scanIgnoreWhitespace()
+		if tok == PAREN_R {
+			break
+		} else if tok != COMMA {
+			return nil, fmt.Errorf(`expected COMMA or PAREN_R in IN list of %s, got "%s"`, ident, lit)
+		}
+	}
+
+	return in, nil
 }
 
 func isOperator(tok Token) bool {
-	return tok == EQ || tok == NE || tok == LT || tok == GT || tok == LE || tok == GE
+	return tok == EQ || tok == NE || tok == LT || tok == GT || tok == LE || tok == GE || tok == LIKE
 }
\ No newline at end of file
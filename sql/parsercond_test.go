@@ -106,6 +106,115 @@ func TestParserCond(t *testing.T) {
 
 	})
 
+	Convey("Test AND binding more tightly than OR\n", t, func() {
+		p := NewParser(strings.NewReader(`A = 1 AND B = 2 OR C = 3 AND D = 4 OR E = 5`))
+		c, err := p.parseCondTree()
+		So(err, ShouldBeNil)
+		So(c, ShouldResemble, &CondConj{
+			Left: &CondConj{
+				Left: &CondComp{Ident:"A", CondOp: EQ, Val: &NumExpr{Val: 1}},
+				Op: AND,
+				Right: &CondComp{Ident:"B", CondOp: EQ, Val: &NumExpr{Val: 2}}},
+			Op: OR,
+			Right: &CondConj{
+				Left: &CondConj{
+					Left: &CondComp{Ident:"C", CondOp: EQ, Val: &NumExpr{Val: 3}},
+					Op: AND,
+					Right: &CondComp{Ident:"D", CondOp: EQ, Val: &NumExpr{Val: 4}}},
+				Op: OR,
+				Right: &CondComp{Ident:"E", CondOp: EQ, Val: &NumExpr{Val: 5}}}})
+		log.Debugf("cond: %s", c)
+	})
 
+	Convey("Test closing nested parentheses\n", t, func() {
+		p := NewParser(strings.NewReader(`(A = 1 AND (B = 2 OR C = 3)) OR D = 4`))
+		c, err := p.parseCondTree()
+		So(err, ShouldBeNil)
+		So(c, ShouldResemble, &CondConj{
+			Left: &CondConj{
+				Left: &CondComp{Ident:"A", CondOp: EQ, Val: &NumExpr{Val: 1}},
+				Op: AND,
+				Right: &CondConj{
+					Left: &CondComp{Ident:"B", CondOp: EQ, Val: &NumExpr{Val: 2}},
+					Op: OR,
+					Right: &CondComp{Ident:"C", CondOp: EQ, Val: &NumExpr{Val: 3}}}},
+			Op: OR,
+			Right: &CondComp{Ident:"D", CondOp: EQ, Val: &NumExpr{Val: 4}}})
+		log.Debugf("cond: %s", c)
+	})
 
-}
\ No newline at end of file
+	Convey("Test unbalanced parentheses\n", t, func() {
+		p := NewParser(strings.NewReader(`(A = 1 AND B = 2`))
+		_, err := p.parseCondTree()
+		So(errstring(err), ShouldEqual, `expected PAREN_R, got "EOF"`)
+
+		_, err = testParse(`SELECT a FROM t WHERE A = 1)`)
+		So(errstring(err), ShouldEqual, `expected AND or OR, got ")"`)
+	})
+
+	Convey("Test parsing IN lists\n", t, func() {
+		p := NewParser(strings.NewReader(`pos IN ('C', 'LW', ?) AND jersey NOT IN (99)`))
+		c, err := p.parseCondTree()
+		So(err, ShouldBeNil)
+		So(c, ShouldResemble, &CondConj{
+			Left: &CondIn{Ident: "pos", Vals: []Expr{&StringExpr{Val: `'C'`}, &StringExpr{Val: `'LW'`}, &ParamExpr{Lit: "?", Index: 1}}},
+			Op: AND,
+			Right: &CondIn{Ident: "jersey", Not: true, Vals: []Expr{&NumExpr{Val: 99}}}})
+		So(c.String(), ShouldEqual, `(pos IN ('C', 'LW', ?) AND jersey NOT IN (99.000000))`)
+		log.Debugf("cond: %s", c)
+
+		p = NewParser(strings.NewReader(`pos IN 'C'`))
+		_, err = p.parseCondTree()
+		So(errstring(err), ShouldEqual, `expected PAREN_L after IN, got "'C'"`)
+
+		p = NewParser(strings.NewReader(`pos IN ('C' 'D')`))
+		_, err = p.parseCondTree()
+		So(errstring(err), ShouldEqual, `expected COMMA or PAREN_R in IN list of pos, got "'D'"`)
+
+		p = NewParser(strings.NewReader(`pos IN ()`))
+		_, err = p.parseCondTree()
+		So(errstring(err), ShouldEqual, `error parsing IN list of pos: parseExpr() expected expression (string, number or function call), got PAREN_R`)
+
+		p = NewParser(strings.NewReader(`name LIKE 'Gret%' OR pos like ?`))
+		c, err = p.parseCondTree()
+		So(err, ShouldBeNil)
+		So(c, ShouldResemble, &CondConj{
+			Left: &CondComp{Ident: "name", CondOp: LIKE, Val: &StringExpr{Val: `'Gret%'`}},
+			Op: OR,
+			Right: &CondComp{Ident: "pos", CondOp: LIKE, Val: &ParamExpr{Lit: "?", Index: 1}}})
+		So(c.String(), ShouldEqual, `(name LIKE 'Gret%' OR pos LIKE ?)`)
+
+		p = NewParser(strings.NewReader(`MATCH(quote, 'puck', fuzziness = 'AUTO') AND NOT_ANALYZED(pos)`))
+		c, err = p.parseCondTree()
+		So(err, ShouldBeNil)
+		So(c, ShouldResemble, &CondConj{
+			Left: &CondFunc{Call: &FuncCallExpr{Name: "MATCH", Args: []Expr{&IdentExpr{Name: "quote"}, &StringExpr{Val: `'puck'`},
+				&NamedArgExpr{Name: "fuzziness", Val: &StringExpr{Val: `'AUTO'`}}}}},
+			Op: AND,
+			Right: &CondFunc{Call: &FuncCallExpr{Name: "NOT_ANALYZED", Args: []Expr{&IdentExpr{Name: "pos"}}}}})
+		So(c.String(), ShouldEqual, `(MATCH(quote, 'puck', fuzziness = 'AUTO') AND NOT_ANALYZED(pos))`)
+
+		p = NewParser(strings.NewReader(`ST_DISTANCE(loc, POINT(-113.49, 53.54)) < '10km' OR F(a)`))
+		c, err = p.parseCondTree()
+		So(err, ShouldBeNil)
+		So(c, ShouldResemble, &CondConj{
+			Left: &CondFunc{Call: &FuncCallExpr{Name: "ST_DISTANCE", Args: []Expr{&IdentExpr{Name: "loc"},
+				&FuncCallExpr{Name: "POINT", Args: []Expr{&NumExpr{Val: -113.49}, &NumExpr{Val: 53.54}}}}},
+				CondOp: LT, Val: &StringExpr{Val: `'10km'`}},
+			Op: OR,
+			Right: &CondFunc{Call: &FuncCallExpr{Name: "F", Args: []Expr{&IdentExpr{Name: "a"}}}}})
+		So(c.String(), ShouldEqual, `(ST_DISTANCE(loc, POINT(-113.490000, 53.540000)) LT '10km' OR F(a))`)
+
+		p = NewParser(strings.NewReader(`F(a) >= `))
+		_, err = p.parseCondTree()
+		So(err, ShouldNotBeNil)
+
+		p = NewParser(strings.NewReader(`MATCH(quote 'puck')`))
+		_, err = p.parseCondTree()
+		So(errstring(err), ShouldEqual, `expected COMMA or PAREN_R after MATCH arg 1, got 'puck'`)
+
+		p = NewParser(strings.NewReader(`pos NOT = 'C'`))
+		_, err = p.parseCondTree()
+		So(errstring(err), ShouldEqual, `expected IN after NOT, got "="`)
+	})
+}
@@ -3,32 +3,62 @@ package sql
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
 	"strings"
 )
 
+// Pos is a position in SQL text: the byte offset along with the 1-based line
+// and column, counted in runes.
+type Pos struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// IsValid returns true if the position has been recorded, i.e. it is not
+// the zero Pos.
+func (p Pos) IsValid() bool { return p.Line > 0 }
+
+func (p Pos) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
 // Scanner represents a lexical scanner.
 type Scanner struct {
-	r *bufio.Reader
+	r      *bufio.Reader
+	pos    Pos // position of the next rune
+	prev   Pos // position before the last read, restored by unread
+	tokPos Pos // position of the last scanned token
 }
 
 // NewScanner returns a new instance of Scanner.
 func NewScanner(r io.Reader) *Scanner {
-	return &Scanner{r: bufio.NewReader(r)}
+	return &Scanner{r: bufio.NewReader(r), pos: Pos{Line: 1, Column: 1}}
 }
 
+// Pos returns the position of the first character of the token last
+// returned by Scan.
+func (s *Scanner) Pos() Pos { return s.tokPos }
+
 // Scan returns the next token and literal value.
 func (s *Scanner) Scan() (tok Token, lit string) {
+	s.tokPos = s.pos
+
 	// Read the next rune.
 	ch := s.read()
 
 	// If we see whitespace then consume all contiguous whitespace.
-	// If we see a letter then consume as an ident or reserved word.
+	// If we see a letter or underscore then consume as an ident or reserved
+	// word, so that metadata fields such as _index are idents.
 	// If we see a digit then consume as a number.
 	if isWhitespace(ch) {
 		s.unread()
 		return s.scanWhitespace()
-	} else if isLetter(ch) {
+	} else if isLetter(ch) || ch == '_' {
 		s.unread()
 		return s.scanIdent()
 	} else if isDigit(ch) || ch == '-' {
@@ -43,6 +73,9 @@ func (s *Scanner) Scan() (tok Token, lit string) {
 	} else if ch == '"' {
 		s.unread()
 		return s.scanStrDbl()
+	} else if isParamChar(ch) {
+		s.unread()
+		return s.scanParam()
 	}
 
 	// Otherwise read the individual character.
@@ -57,6 +90,8 @@ func (s *Scanner) Scan() (tok Token, lit string) {
 		return PAREN_L, string(ch)
 	case ')':
 		return PAREN_R, string(ch)
+	case '+':
+		return PLUS, string(ch)
 	}
 
 	return ILLEGAL, string(ch)
@@ -115,17 +150,60 @@ func (s *Scanner) scanIdent() (tok Token, lit string) {
 		return AND, buf.String()
 	case "OR":
 		return OR, buf.String()
+	case "NOT":
+		return NOT, buf.String()
+	case "IN":
+		return IN, buf.String()
+	case "ORDER":
+		return ORDER, buf.String()
+	case "BY":
+		return BY, buf.String()
+	case "ASC":
+		return ASC, buf.String()
+	case "DESC":
+		return DESC, buf.String()
+	case "LIMIT":
+		return LIMIT, buf.String()
+	case "AS":
+		return AS, buf.String()
+	case "GROUP":
+		return GROUP, buf.String()
+	case "LIKE":
+		return LIKE, buf.String()
+	case "INTERVAL":
+		return INTERVAL, buf.String()
+	case "EXISTS":
+		return EXISTS, buf.String()
+	case "JOIN":
+		return JOIN, buf.String()
+	case "INNER":
+		return INNER, buf.String()
+	case "LEFT":
+		return LEFT, buf.String()
+	case "OUTER":
+		return OUTER, buf.String()
+	case "ON":
+		return ON, buf.String()
+	case "UNION":
+		return UNION, buf.String()
+	case "ALL":
+		return ALL, buf.String()
 	}
 
 	// Otherwise return as a regular identifier.
 	return IDENT, buf.String()
 }
 
-// scanNumber consumes the current rune and all contiguous number runes.
+// scanNumber consumes the current rune and all contiguous number runes. A -
+// which is not followed by a digit or decimal point is the MINUS operator.
 func (s *Scanner) scanNumber() (tok Token, lit string) {
 	// Create a buffer and read the current character into it.
 	var buf bytes.Buffer
-	buf.WriteRune(s.read())
+	ch := s.read()
+	if next := s.peek(); ch == '-' && !isDigit(next) && next != '.' {
+		return MINUS, string(ch)
+	}
+	buf.WriteRune(ch)
 
 	// Read every subsequent ident character into the buffer.
 	// Non-ident characters and EOF will cause the loop to exit.
@@ -186,6 +264,35 @@ func (s *Scanner) scanOp() (tok Token, lit string) {
 	return ILLEGAL, buf.String()
 }
 
+// scanParam consumes a bind parameter placeholder: a lone ?, a $ followed
+// by digits or a : followed by identifier runes.
+func (s *Scanner) scanParam() (tok Token, lit string) {
+	var buf bytes.Buffer
+	lead := s.read()
+	buf.WriteRune(lead)
+
+	if lead == '?' {
+		return PARAM, buf.String()
+	}
+
+	// Read the parameter number or name into the buffer.
+	for {
+		if ch := s.read(); ch == eof {
+			break
+		} else if isDigit(ch) || (lead == ':' && (isLetter(ch) || ch == '_')) {
+			buf.WriteRune(ch)
+		} else {
+			s.unread()
+			break
+		}
+	}
+
+	if buf.Len() < 2 {
+		return ILLEGAL, buf.String()
+	}
+	return PARAM, buf.String()
+}
+
 // scanStr consumes the current rune, which is assumed to be a quote,
 // and continues to consume until either a newline or an unescaped
 // closing quote is encountered.
@@ -224,10 +331,18 @@ func (s *Scanner) scanStr(term rune) (tok Token, lit string) {
 // read reads the next rune from the buffered reader.
 // Returns the rune(0) if an error occurs (or io.EOF is returned).
 func (s *Scanner) read() rune {
-	ch, _, err := s.r.ReadRune()
+	s.prev = s.pos
+	ch, size, err := s.r.ReadRune()
 	if err != nil {
 		return eof
 	}
+	s.pos.Offset += size
+	if ch == '\n' {
+		s.pos.Line += 1
+		s.pos.Column = 1
+	} else {
+		s.pos.Column += 1
+	}
 	return ch
 }
 
@@ -238,7 +353,10 @@ func (s *Scanner) peek() rune {
 }
 
 // unread places the previously read rune back on the reader.
-func (s *Scanner) unread() { _ = s.r.UnreadRune() }
+func (s *Scanner) unread() {
+	_ = s.r.UnreadRune()
+	s.pos = s.prev
+}
 
 // isWhitespace returns true if the rune is a space, tab, or newline.
 func isWhitespace(ch rune) bool { return ch == ' ' || ch == '\t' || ch == '\n' }
@@ -254,6 +372,9 @@ func isLetter(ch rune) bool { return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && c
 // isDigit returns true if the rune is a digit.
 func isDigit(ch rune) bool { return (ch >= '0' && ch <= '9') }
 
+// isParamChar returns true if the rune starts a bind parameter placeholder.
+func isParamChar(ch rune) bool { return ch == '?' || ch == '$' || ch == ':' }
+
 // isOpChar returns true if the run is an operator character.
 func isOpChar(ch rune) bool { return ch == '=' || ch == '!' || ch == '<' || ch == '>' }
 
@@ -27,11 +27,15 @@ func TestScanner(t *testing.T) {
 		testScanString(`,`, COMMA, `,`)
 		testScanString(`(`, PAREN_L, `(`)
 		testScanString(`)`, PAREN_R, `)`)
+		testScanString(`+`, PLUS, `+`)
+		testScanString(`- 1`, MINUS, `-`)
+		testScanString(`-INTERVAL`, MINUS, `-`)
 	})
 
 	Convey("Identifiers\n", t, func() {
 		testScanString(`foo`, IDENT, `foo`)
 		testScanString(`Zx12_3U_-*`, IDENT, `Zx12_3U_-*`)
+		testScanString(`_index`, IDENT, `_index`)
 	})
 
 	Convey("Numbers\n", t, func() {
@@ -39,6 +43,7 @@ func TestScanner(t *testing.T) {
 		testScanString(`12.34`, NUMBER, `12.34`)
 		testScanString(`-46`, NUMBER, `-46`)
 		testScanString(`-98.765`, NUMBER, `-98.765`)
+		testScanString(`-.5`, NUMBER, `-.5`)
 	})
 
 	Convey("Keywords\n", t, func() {
@@ -49,6 +54,18 @@ func TestScanner(t *testing.T) {
 		testScanString(`WHERE`, WHERE, `WHERE`)
 		testScanString(`AND`, AND, `AND`)
 		testScanString(`OR`, OR, `OR`)
+		testScanString(`not`, NOT, `not`)
+		testScanString(`In`, IN, `In`)
+		testScanString(`like`, LIKE, `like`)
+		testScanString(`Interval`, INTERVAL, `Interval`)
+		testScanString(`exists`, EXISTS, `exists`)
+		testScanString(`Join`, JOIN, `Join`)
+		testScanString(`INNER`, INNER, `INNER`)
+		testScanString(`left`, LEFT, `left`)
+		testScanString(`outer`, OUTER, `outer`)
+		testScanString(`Union`, UNION, `Union`)
+		testScanString(`all`, ALL, `all`)
+		testScanString(`ON`, ON, `ON`)
 	})
 
 	Convey("Operators\n", t, func() {
@@ -70,6 +87,36 @@ func TestScanner(t *testing.T) {
 		testScanString(`'illegal2`, ILLEGAL, `'illegal2`)
 	})
 
+	Convey("Parameters\n", t, func() {
+		testScanString(`?`, PARAM, `?`)
+		testScanString(`$1`, PARAM, `$1`)
+		testScanString(`$23)`, PARAM, `$23`)
+		testScanString(`:name`, PARAM, `:name`)
+		testScanString(`:first_name,`, PARAM, `:first_name`)
+		testScanString(`$`, ILLEGAL, `$`)
+		testScanString(`: name`, ILLEGAL, `:`)
+	})
+
+	Convey("Token positions\n", t, func() {
+		s := NewScanner(strings.NewReader("SELECT a,\n  b FROM t\n"))
+		var pos []Pos
+		for tok, _ := s.Scan(); tok != EOF; tok, _ = s.Scan() {
+			if tok != WS {
+				pos = append(pos, s.Pos())
+			}
+		}
+		So(pos, ShouldResemble, []Pos{{0, 1, 1}, {7, 1, 8}, {8, 1, 9}, {12, 2, 3}, {14, 2, 5}, {19, 2, 10}})
+		So(s.Pos(), ShouldResemble, Pos{21, 3, 1})
+		So(s.Pos().String(), ShouldEqual, "3:1")
+		So(Pos{}.String(), ShouldEqual, "-")
+
+		s = NewScanner(strings.NewReader("a<b"))
+		testScanRmWs(s, IDENT, "a")
+		testScanRmWs(s, LT, "<")
+		testScanRmWs(s, IDENT, "b")
+		So(s.Pos(), ShouldResemble, Pos{2, 1, 3})
+	})
+
 	Convey("Real statement - somewhat complicated\n", t, func() {
 		str := `SELECT t1.field1, t2.* FROM table1 t1
 				wHeRe t1.joinA = t2.joinA AND (t2.fieldN <= -123.456 OR t2.fieldS = 'howdy ho')`
@@ -13,12 +13,15 @@ const (
 	IDENT  // main
 	NUMBER // 1, 12.34, -46, -98.765
     STRING // 'abc', "DEF 123 &*$"
+	PARAM  // ?, $1, :name
 
 	// Misc characters
 	ASTERISK   // *
 	COMMA      // ,
 	PAREN_L    // (
 	PAREN_R    // )
+	PLUS       // +
+	MINUS      // -
 
 	// Operators
 	EQ // =
@@ -34,6 +37,28 @@ const (
 	WHERE
 	AND
 	OR
+	NOT
+	IN
+	ORDER
+	BY
+	ASC
+	DESC
+	LIMIT
+	AS
+	GROUP
+	LIKE
+	INTERVAL
+	EXISTS
+	JOIN
+	INNER
+	LEFT
+	OUTER
+	ON
+	UNION
+	ALL
+
+	// tokenEnd marks the end of the token list and is not itself a token.
+	tokenEnd
 )
 
 func (t Token) String() string {
@@ -50,6 +75,8 @@ func (t Token) String() string {
 		return "NUMBER"
 	case STRING:
 		return "STRING"
+	case PARAM:
+		return "PARAM"
 	case ASTERISK:
 		return "ASTERISK"
 	case COMMA:
@@ -58,6 +85,10 @@ func (t Token) String() string {
 		return "PAREN_L"
 	case PAREN_R:
 		return "PAREN_R"
+	case PLUS:
+		return "PLUS"
+	case MINUS:
+		return "MINUS"
 	case EQ:
 		return "EQ"
 	case NE:
@@ -80,6 +111,87 @@ func (t Token) String() string {
 		return "AND"
 	case OR:
 		return "OR"
+	case NOT:
+		return "NOT"
+	case IN:
+		return "IN"
+	case ORDER:
+		return "ORDER"
+	case BY:
+		return "BY"
+	case ASC:
+		return "ASC"
+	case DESC:
+		return "DESC"
+	case LIMIT:
+		return "LIMIT"
+	case AS:
+		return "AS"
+	case GROUP:
+		return "GROUP"
+	case LIKE:
+		return "LIKE"
+	case INTERVAL:
+		return "INTERVAL"
+	case EXISTS:
+		return "EXISTS"
+	case JOIN:
+		return "JOIN"
+	case INNER:
+		return "INNER"
+	case LEFT:
+		return "LEFT"
+	case OUTER:
+		return "OUTER"
+	case ON:
+		return "ON"
+	case UNION:
+		return "UNION"
+	case ALL:
+		return "ALL"
 	}
 	return "UNKNOWN"
-}
\ No newline at end of file
+}
+
+// Text returns the SQL text of an operator, punctuation or keyword token,
+// e.g. ">=" for GE and "SELECT" for SELECT, and the token name otherwise.
+func (t Token) Text() string {
+	switch t {
+	case ASTERISK:
+		return "*"
+	case COMMA:
+		return ","
+	case PAREN_L:
+		return "("
+	case PAREN_R:
+		return ")"
+	case PLUS:
+		return "+"
+	case MINUS:
+		return "-"
+	case EQ:
+		return "="
+	case NE:
+		return "!="
+	case GT:
+		return ">"
+	case LT:
+		return "<"
+	case GE:
+		return ">="
+	case LE:
+		return "<="
+	}
+	return t.String()
+}
+
+// lookupToken returns the operator or keyword token whose SQL text, as
+// returned by Text, matches the specified string.
+func lookupToken(text string) (Token, bool) {
+	for t := ASTERISK; t < tokenEnd; t++ {
+		if t.Text() == text {
+			return t, true
+		}
+	}
+	return ILLEGAL, false
+}
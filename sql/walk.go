@@ -0,0 +1,327 @@
+package sql
+
+import (
+	"fmt"
+)
+
+// Node is implemented by every statement, condition and expression type. The
+// unexported methods make each new node type declare how it is traversed, so
+// that Walk and Rewrite cannot silently skip it.
+type Node interface {
+	String() string
+
+	// walk calls Walk(v, child) for each non-nil child of the node in order.
+	walk(v Visitor)
+
+	// rewrite returns a copy of the node whose children have been replaced by
+	// the result of Rewrite(child, fn).
+	rewrite(fn func(Node) Node) Node
+}
+
+// A Visitor's Visit method is invoked for each node encountered by Walk. If
+// the result visitor w is not nil, Walk visits each of the children of node
+// with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses a statement, condition or expression tree in depth-first
+// order: it starts by calling v.Visit(node); node must not be nil.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	node.walk(v)
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses a tree in depth-first order, calling f(node) for each node
+// and then f(nil) after its children. The children of node are skipped if
+// f(node) returns false.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Rewrite returns a copy of the specified tree in which every node has been
+// replaced by the result of fn, applied bottom-up so that fn sees a node only
+// after its children have been rewritten. The original tree is left
+// unmodified. fn must return a node that is valid in the position of the one
+// it replaces, i.e. a Cond for a Cond and an Expr for an Expr; Rewrite panics
+// otherwise.
+func Rewrite(node Node, fn func(Node) Node) Node {
+	return fn(node.rewrite(fn))
+}
+
+// rewriteCond returns the rewritten form of the specified condition, which
+// may be nil.
+func rewriteCond(c Cond, fn func(Node) Node) Cond {
+	if c == nil {
+		return nil
+	}
+	n := Rewrite(c, fn)
+	cond, ok := n.(Cond)
+	if !ok {
+		panic(fmt.Sprintf("Rewrite: %T returned in place of condition %s", n, c))
+	}
+	return cond
+}
+
+// rewriteExpr returns the rewritten form of the specified expression, which
+// may be nil.
+func rewriteExpr(e Expr, fn func(Node) Node) Expr {
+	if e == nil {
+		return nil
+	}
+	n := Rewrite(e, fn)
+	expr, ok := n.(Expr)
+	if !ok {
+		panic(fmt.Sprintf("Rewrite: %T returned in place of expression %s", n, e))
+	}
+	return expr
+}
+
+func (s *SelectStatement) walk(v Visitor) {
+	for _, f := range s.FieldList {
+		if f.Expr != nil {
+			Walk(v, f.Expr)
+		}
+	}
+	for _, j := range s.Joins {
+		Walk(v, j.On)
+	}
+	if s.WhereCond != nil {
+		Walk(v, s.WhereCond)
+	}
+	for _, e := range s.GroupBy {
+		Walk(v, e)
+	}
+	for _, u := range s.Unions {
+		Walk(v, u.Select)
+	}
+	for _, o := range s.OrderBy {
+		Walk(v, o.Expr)
+	}
+	if s.Limit != nil {
+		Walk(v, s.Limit)
+	}
+}
+
+func (s *SelectStatement) rewrite(fn func(Node) Node) Node {
+	stmt := *s
+	if s.FieldList != nil {
+		stmt.FieldList = make(Fields, len(s.FieldList))
+		for i, f := range s.FieldList {
+			f.Expr = rewriteExpr(f.Expr, fn)
+			stmt.FieldList[i] = f
+		}
+	}
+	if s.Joins != nil {
+		stmt.Joins = make([]Join, len(s.Joins))
+		for i, j := range s.Joins {
+			j.On = rewriteCond(j.On, fn)
+			stmt.Joins[i] = j
+		}
+	}
+	stmt.WhereCond = rewriteCond(s.WhereCond, fn)
+	if s.GroupBy != nil {
+		stmt.GroupBy = make([]Expr, len(s.GroupBy))
+		for i, e := range s.GroupBy {
+			stmt.GroupBy[i] = rewriteExpr(e, fn)
+		}
+	}
+	if s.Unions != nil {
+		stmt.Unions = make([]Union, len(s.Unions))
+		for i, u := range s.Unions {
+			n := Rewrite(u.Select, fn)
+			sel, ok := n.(*SelectStatement)
+			if !ok {
+				panic(fmt.Sprintf("Rewrite: %T returned in place of union %s", n, u.Select))
+			}
+			u.Select = sel
+			stmt.Unions[i] = u
+		}
+	}
+	if s.OrderBy != nil {
+		stmt.OrderBy = make([]OrderItem, len(s.OrderBy))
+		for i, o := range s.OrderBy {
+			stmt.OrderBy[i] = OrderItem{Expr: rewriteExpr(o.Expr, fn), Desc: o.Desc}
+		}
+	}
+	stmt.Limit = rewriteExpr(s.Limit, fn)
+	return &stmt
+}
+
+func (c *CondComp) walk(v Visitor) {
+	if c.Val != nil {
+		Walk(v, c.Val)
+	}
+}
+
+func (c *CondComp) rewrite(fn func(Node) Node) Node {
+	comp := *c
+	comp.Val = rewriteExpr(c.Val, fn)
+	return &comp
+}
+
+func (c *CondIn) walk(v Visitor) {
+	for _, e := range c.Vals {
+		Walk(v, e)
+	}
+}
+
+func (c *CondIn) rewrite(fn func(Node) Node) Node {
+	in := *c
+	in.Vals = make([]Expr, len(c.Vals))
+	for i, e := range c.Vals {
+		in.Vals[i] = rewriteExpr(e, fn)
+	}
+	return &in
+}
+
+func (c *CondFunc) walk(v Visitor) {
+	Walk(v, c.Call)
+	if c.Val != nil {
+		Walk(v, c.Val)
+	}
+}
+
+func (c *CondFunc) rewrite(fn func(Node) Node) Node {
+	n := Rewrite(c.Call, fn)
+	call, ok := n.(*FuncCallExpr)
+	if !ok {
+		panic(fmt.Sprintf("Rewrite: %T returned in place of function call %s", n, c.Call))
+	}
+	f := *c
+	f.Call = call
+	if c.Val != nil {
+		f.Val = rewriteExpr(c.Val, fn)
+	}
+	return &f
+}
+
+func (c *CondExists) walk(v Visitor) {
+	Walk(v, c.Select)
+}
+
+func (c *CondExists) rewrite(fn func(Node) Node) Node {
+	n := Rewrite(c.Select, fn)
+	stmt, ok := n.(*SelectStatement)
+	if !ok {
+		panic(fmt.Sprintf("Rewrite: %T returned in place of subquery %s", n, c.Select))
+	}
+	e := *c
+	e.Select = stmt
+	return &e
+}
+
+func (c *CondConj) walk(v Visitor) {
+	if c.Left != nil {
+		Walk(v, c.Left)
+	}
+	if c.Right != nil {
+		Walk(v, c.Right)
+	}
+}
+
+func (c *CondConj) rewrite(fn func(Node) Node) Node {
+	conj := *c
+	conj.Left = rewriteCond(c.Left, fn)
+	conj.Right = rewriteCond(c.Right, fn)
+	return &conj
+}
+
+func (f *FuncCallExpr) walk(v Visitor) {
+	for _, a := range f.Args {
+		Walk(v, a)
+	}
+}
+
+func (f *FuncCallExpr) rewrite(fn func(Node) Node) Node {
+	call := *f
+	call.Args = make([]Expr, len(f.Args))
+	for i, a := range f.Args {
+		call.Args[i] = rewriteExpr(a, fn)
+	}
+	return &call
+}
+
+func (n *NamedArgExpr) walk(v Visitor) {
+	Walk(v, n.Val)
+}
+
+func (n *NamedArgExpr) rewrite(fn func(Node) Node) Node {
+	arg := *n
+	arg.Val = rewriteExpr(n.Val, fn)
+	return &arg
+}
+
+func (b *BinaryExpr) walk(v Visitor) {
+	Walk(v, b.LHS)
+	Walk(v, b.RHS)
+}
+
+func (b *BinaryExpr) rewrite(fn func(Node) Node) Node {
+	bin := *b
+	bin.LHS = rewriteExpr(b.LHS, fn)
+	bin.RHS = rewriteExpr(b.RHS, fn)
+	return &bin
+}
+
+func (i *IntervalExpr) walk(v Visitor) {}
+
+func (i *IntervalExpr) rewrite(fn func(Node) Node) Node {
+	interval := *i
+	return &interval
+}
+
+func (s *StringExpr) walk(v Visitor) {}
+
+func (s *StringExpr) rewrite(fn func(Node) Node) Node {
+	str := *s
+	return &str
+}
+
+func (n *NumExpr) walk(v Visitor) {}
+
+func (n *NumExpr) rewrite(fn func(Node) Node) Node {
+	num := *n
+	return &num
+}
+
+func (i *IdentExpr) walk(v Visitor) {}
+
+func (i *IdentExpr) rewrite(fn func(Node) Node) Node {
+	ident := *i
+	return &ident
+}
+
+func (s *SubqueryExpr) walk(v Visitor) {
+	Walk(v, s.Select)
+}
+
+func (s *SubqueryExpr) rewrite(fn func(Node) Node) Node {
+	n := Rewrite(s.Select, fn)
+	stmt, ok := n.(*SelectStatement)
+	if !ok {
+		panic(fmt.Sprintf("Rewrite: %T returned in place of subquery %s", n, s.Select))
+	}
+	e := *s
+	e.Select = stmt
+	return &e
+}
+
+func (p *ParamExpr) walk(v Visitor) {}
+
+func (p *ParamExpr) rewrite(fn func(Node) Node) Node {
+	param := *p
+	return &param
+}
@@ -0,0 +1,137 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	T "github.com/oldenbur/sql-parser/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() { T.ConfigureTestLogger() }
+
+// recorder is a Visitor that records the type of each visited node, and nil
+// for the end of each node's children.
+type recorder struct {
+	visited []string
+}
+
+func (r *recorder) Visit(n Node) Visitor {
+	if n == nil {
+		r.visited = append(r.visited, "end")
+	} else {
+		r.visited = append(r.visited, fmt.Sprintf("%T", n))
+	}
+	return r
+}
+
+func TestWalk(t *testing.T) {
+
+	defer log.Flush()
+
+	stmt, err := testParse(`SELECT a FROM t WHERE a = "x" AND (b >= F(1, ?) OR c != 2)`)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	Convey("Test walking a statement\n", t, func() {
+		r := &recorder{}
+		Walk(r, stmt)
+		So(strings.Join(r.visited, " "), ShouldEqual, strings.Join([]string{
+			"*sql.SelectStatement",
+			"*sql.CondConj",
+			"*sql.CondComp", "*sql.StringExpr", "end", "end",
+			"*sql.CondConj",
+			"*sql.CondComp", "*sql.FuncCallExpr", "*sql.NumExpr", "end", "*sql.ParamExpr", "end", "end", "end",
+			"*sql.CondComp", "*sql.NumExpr", "end", "end",
+			"end", "end", "end"}, " "))
+	})
+
+	Convey("Test inspecting a statement\n", t, func() {
+		var idents []string
+		Inspect(stmt, func(n Node) bool {
+			if c, ok := n.(*CondComp); ok {
+				idents = append(idents, c.Ident)
+			}
+			_, isCall := n.(*FuncCallExpr)
+			return !isCall
+		})
+		So(idents, ShouldResemble, []string{"a", "b", "c"})
+
+		nums := 0
+		Inspect(stmt, func(n Node) bool {
+			if _, ok := n.(*NumExpr); ok {
+				nums += 1
+			}
+			_, isCall := n.(*FuncCallExpr)
+			return !isCall
+		})
+		So(nums, ShouldEqual, 1)
+	})
+
+	Convey("Test rewriting a statement\n", t, func() {
+		orig := stmt.String()
+
+		rewritten := Rewrite(stmt, func(n Node) Node {
+			switch n := n.(type) {
+			case *NumExpr:
+				n.Val *= 10
+			case *CondComp:
+				n.Ident = strings.ToUpper(n.Ident)
+			case *CondConj:
+				if n.Op == OR {
+					return n.Left
+				}
+			}
+			return n
+		})
+		So(rewritten.String(), ShouldEqual, `SELECT a FROM t WHERE (A EQ "x" AND B GE F(10.000000, ?))`)
+		So(stmt.String(), ShouldEqual, orig)
+	})
+
+	Convey("Test rewriting with an invalid replacement\n", t, func() {
+		So(func() {
+			Rewrite(stmt, func(n Node) Node {
+				if _, ok := n.(*StringExpr); ok {
+					return &CondComp{Ident: "x", CondOp: EQ, Val: &NumExpr{Val: 1}}
+				}
+				return n
+			})
+		}, ShouldPanicWith, `Rewrite: *sql.CondComp returned in place of expression "x"`)
+
+		sub, err := testParse(`SELECT a FROM t WHERE EXISTS (SELECT * FROM t.b)`)
+		So(err, ShouldBeNil)
+		So(func() {
+			Rewrite(sub, func(n Node) Node {
+				if s, ok := n.(*SelectStatement); ok && s.WhereCond == nil {
+					return &IdentExpr{Name: "x"}
+				}
+				return n
+			})
+		}, ShouldPanicWith, `Rewrite: *sql.IdentExpr returned in place of subquery SELECT * FROM t.b`)
+
+		in, err := testParse(`SELECT a FROM t WHERE b IN (SELECT c FROM u)`)
+		So(err, ShouldBeNil)
+		So(func() {
+			Rewrite(in, func(n Node) Node {
+				if s, ok := n.(*SelectStatement); ok && s.WhereCond == nil {
+					return &IdentExpr{Name: "x"}
+				}
+				return n
+			})
+		}, ShouldPanicWith, `Rewrite: *sql.IdentExpr returned in place of subquery SELECT c FROM u`)
+
+		union, err := testParse(`SELECT a FROM t UNION SELECT c FROM u`)
+		So(err, ShouldBeNil)
+		So(func() {
+			Rewrite(union, func(n Node) Node {
+				if s, ok := n.(*SelectStatement); ok && len(s.Unions) == 0 {
+					return &IdentExpr{Name: "x"}
+				}
+				return n
+			})
+		}, ShouldPanicWith, `Rewrite: *sql.IdentExpr returned in place of union SELECT c FROM u`)
+	})
+}